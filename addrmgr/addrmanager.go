@@ -27,6 +27,7 @@ import (
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/wire"
 	"github.com/zeusyf/btcd/wire/common"
+	"golang.org/x/crypto/sha3"
 )
 
 // AddrManager provides a concurrency safe address manager for caching potential
@@ -162,7 +163,22 @@ const (
 	getAddrPercent = 23
 
 	// serialisationVersion is the current version of the on-disk format.
-	serialisationVersion = 1
+	// Version 2 added Tor v3, I2P and CJDNS addresses, which version 1 files
+	// can't contain, so version 1 files are still loaded.
+	serialisationVersion = 2
+
+	// torV3Version is the version byte of Tor v3 onion addresses.
+	torV3Version = 0x03
+)
+
+var (
+	// onionBase32 is the encoding of Tor v3 onion addresses and I2P b32
+	// addresses, which are not padded.  Both are written in lowercase.
+	onionBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	// torV3ChecksumPrefix is the prefix of the data hashed for the checksum
+	// of Tor v3 onion addresses.
+	torV3ChecksumPrefix = []byte(".onion checksum")
 )
 
 // updateAddress is a helper function to either update an address already known
@@ -462,7 +478,7 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		return fmt.Errorf("error reading %s: %v", filePath, err)
 	}
 
-	if sam.Version != serialisationVersion {
+	if sam.Version != serialisationVersion && sam.Version != 1 {
 		return fmt.Errorf("unknown version %v in serialized "+
 			"addrmanager", sam.Version)
 	}
@@ -693,11 +709,72 @@ func (a *AddrManager) reset() {
 	}
 }
 
+// torV3Checksum returns the checksum of the Tor v3 onion address of the
+// passed public key.
+func torV3Checksum(pubKey []byte) []byte {
+	data := make([]byte, 0, len(torV3ChecksumPrefix)+len(pubKey)+1)
+	data = append(data, torV3ChecksumPrefix...)
+	data = append(data, pubKey...)
+	data = append(data, torV3Version)
+	sum := sha3.Sum256(data)
+	return sum[:2]
+}
+
+// decodeTorV3 returns the public key of a Tor v3 onion address given the 56
+// characters preceding ".onion".
+func decodeTorV3(onion string) ([]byte, error) {
+	data, err := onionBase32.DecodeString(strings.ToUpper(onion))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 35 || data[34] != torV3Version {
+		return nil, fmt.Errorf("invalid Tor v3 address %s.onion", onion)
+	}
+	pubKey := data[:32]
+	checksum := torV3Checksum(pubKey)
+	if data[32] != checksum[0] || data[33] != checksum[1] {
+		return nil, fmt.Errorf("bad checksum in Tor v3 address "+
+			"%s.onion", onion)
+	}
+	return pubKey, nil
+}
+
+// encodeTorV3 returns the Tor v3 onion address of the passed public key.
+func encodeTorV3(pubKey []byte) string {
+	data := make([]byte, 0, 35)
+	data = append(data, pubKey...)
+	data = append(data, torV3Checksum(pubKey)...)
+	data = append(data, torV3Version)
+	return strings.ToLower(onionBase32.EncodeToString(data)) + ".onion"
+}
+
 // HostToNetAddress returns a netaddress given a host address.  If the address
-// is a Tor .onion address this will be taken care of.  Else if the host is
-// not an IP address it will be resolved (via Tor if required).
+// is a Tor .onion address or an I2P .b32.i2p address this will be taken care
+// of, and addresses in fc00::/8 are taken to be CJDNS addresses.  Else if the
+// host is not an IP address it will be resolved (via Tor if required).
 func (a *AddrManager) HostToNetAddress(host string, port uint16, services common.ServiceFlag) (*wire.NetAddress, error) {
-	// Tor address is 16 char base32 + ".onion"
+	// Tor v3 address is 56 char base32 + ".onion"
+	if len(host) == 62 && host[56:] == ".onion" {
+		pubKey, err := decodeTorV3(host[:56])
+		if err != nil {
+			return nil, err
+		}
+		return wire.NewNetAddressV2(wire.NetTorV3, pubKey, port,
+			services), nil
+	}
+
+	// I2P address is 52 char base32 + ".b32.i2p"
+	if len(host) == 60 && host[52:] == ".b32.i2p" {
+		data, err := onionBase32.DecodeString(strings.ToUpper(host[:52]))
+		if err != nil {
+			return nil, err
+		}
+		return wire.NewNetAddressV2(wire.NetI2P, data, port,
+			services), nil
+	}
+
+	// Legacy Tor v2 address is 16 char base32 + ".onion".  These are only
+	// kept for the onioncat addresses still relayed in addr messages.
 	var ip net.IP
 	if len(host) == 22 && host[16:] == ".onion" {
 		// go base32 encoding uses capitals (as does the rfc
@@ -721,13 +798,25 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, services common
 		ip = ips[0]
 	}
 
+	if cjdnsNet.Contains(ip) {
+		return wire.NewNetAddressV2(wire.NetCJDNS, ip, port, services), nil
+	}
 	return wire.NewNetAddressIPPort(ip, port, services), nil
 }
 
 // ipString returns a string for the ip from the provided NetAddress. If the
 // ip is in the range used for Tor addresses then it will be transformed into
-// the relevant .onion address.
+// the relevant .onion address.  Tor v3 and I2P addresses are returned as
+// .onion and .b32.i2p addresses.
 func ipString(na *wire.NetAddress) string {
+	switch na.Network() {
+	case wire.NetTorV3:
+		return encodeTorV3(na.Addr)
+	case wire.NetI2P:
+		return strings.ToLower(onionBase32.EncodeToString(na.Addr)) +
+			".b32.i2p"
+	}
+
 	if IsOnionCatTor(na) {
 		// We know now that na.IP is long enough.
 		base32 := base32.StdEncoding.EncodeToString(na.IP[6:])
@@ -747,7 +836,7 @@ func NetAddressKey(na *wire.NetAddress) string {
 
 func (a *AddrManager) isMyself(na *wire.NetAddress) bool {
 	for _, p := range a.localAddresses {
-		if p.origin == ManualPrio && p.na.IP.Equal(na.IP) && p.na.Port == na.Port {
+		if p.origin == ManualPrio && NetAddressKey(p.na) == NetAddressKey(na) {
 			return true
 		}
 	}
@@ -992,7 +1081,8 @@ func (a *AddrManager) SetServices(addr *wire.NetAddress, services common.Service
 // with the given priority.
 func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPriority) (AddressPriority, error) {
 	if !IsRoutable(na) {
-		return InterfacePrio, fmt.Errorf("address %s is not routable", na.IP)
+		return InterfacePrio, fmt.Errorf("address %s is not routable",
+			ipString(na))
 	}
 
 	a.lamtx.Lock()
@@ -1056,8 +1146,16 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Unreachable
 	}
 
-	if IsOnionCatTor(remoteAddr) {
-		if IsOnionCatTor(localAddr) {
+	if IsI2P(remoteAddr) || IsCJDNS(remoteAddr) {
+		// These networks only reach their own addresses.
+		if localAddr.Network() == remoteAddr.Network() {
+			return Private
+		}
+		return Unreachable
+	}
+
+	if IsOnionCatTor(remoteAddr) || IsTorV3(remoteAddr) {
+		if IsOnionCatTor(localAddr) || IsTorV3(localAddr) {
 			return Private
 		}
 
@@ -1068,6 +1166,10 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Default
 	}
 
+	if !localAddr.IsAddrV1() {
+		return Unreachable
+	}
+
 	if IsRFC4380(remoteAddr) {
 		if !IsRoutable(localAddr) {
 			return Default
@@ -1137,15 +1239,15 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 		}
 	}
 	if bestAddress != nil {
-		log.Debugf("Suggesting address %s:%d for %s:%d", bestAddress.IP,
-			bestAddress.Port, remoteAddr.IP, remoteAddr.Port)
+		log.Debugf("Suggesting address %s for %s",
+			NetAddressKey(bestAddress), NetAddressKey(remoteAddr))
 	} else {
-		log.Debugf("No worthy address for %s:%d", remoteAddr.IP,
-			remoteAddr.Port)
+		log.Debugf("No worthy address for %s", NetAddressKey(remoteAddr))
 
 		// Send something unroutable if nothing suitable.
 		var ip net.IP
-		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) {
+		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) &&
+			!IsTorV3(remoteAddr) {
 			ip = net.IPv6zero
 		} else {
 			ip = net.IPv4zero
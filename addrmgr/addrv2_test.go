@@ -0,0 +1,143 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeusyf/btcd/addrmgr"
+	"github.com/zeusyf/btcd/wire"
+)
+
+// TestHostToNetAddressV2 ensures Tor v3, I2P and CJDNS hosts are converted to
+// addresses of their network and back to the same key.
+func TestHostToNetAddressV2(t *testing.T) {
+	tests := []struct {
+		host    string
+		network wire.NetworkID
+	}{
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", wire.NetTorV3},
+		{"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p", wire.NetI2P},
+		{"fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa", wire.NetCJDNS},
+		{"8.8.8.8", wire.NetIPv4},
+	}
+
+	amgr := addrmgr.New("testhosttonetaddressv2", nil, nil)
+	for i, test := range tests {
+		na, err := amgr.HostToNetAddress(test.host, 8333, 0)
+		if err != nil {
+			t.Errorf("#%d: HostToNetAddress(%s): %v", i, test.host, err)
+			continue
+		}
+		if na.Network() != test.network {
+			t.Errorf("#%d: got network %v, want %v", i, na.Network(),
+				test.network)
+		}
+		if !addrmgr.IsRoutable(na) {
+			t.Errorf("#%d: %s is not routable", i, test.host)
+		}
+		want := net.JoinHostPort(test.host, "8333")
+		if key := addrmgr.NetAddressKey(na); key != want {
+			t.Errorf("#%d: got key %s, want %s", i, key, want)
+		}
+	}
+
+	// A bad checksum must be rejected.
+	_, err := amgr.HostToNetAddress(
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrya.onion",
+		8333, 0)
+	if err == nil {
+		t.Errorf("HostToNetAddress: bad Tor v3 checksum accepted")
+	}
+}
+
+// TestGetBestLocalAddressV2 ensures Tor v3 and I2P local addresses are only
+// advertised to peers which can reach them.
+func TestGetBestLocalAddressV2(t *testing.T) {
+	amgr := addrmgr.New("testgetbestlocaladdressv2", nil, nil)
+	onion, _ := amgr.HostToNetAddress(
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+		8333, 0)
+	i2p, _ := amgr.HostToNetAddress(
+		"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+		0, 0)
+	ipv4 := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.100"), 8333, 0)
+	for _, na := range []*wire.NetAddress{onion, i2p, ipv4} {
+		if _, err := amgr.AddLocalAddress(na, addrmgr.BoundPrio); err != nil {
+			t.Fatalf("AddLocalAddress: %v", err)
+		}
+	}
+
+	remoteOnion := wire.NewNetAddressV2(wire.NetTorV3, make([]byte, 32), 8333, 0)
+	remoteI2P := wire.NewNetAddressV2(wire.NetI2P, make([]byte, 32), 0, 0)
+	remoteIPv4 := wire.NewNetAddressIPPort(net.ParseIP("8.8.8.8"), 8333, 0)
+	tests := []struct {
+		remote *wire.NetAddress
+		want   *wire.NetAddress
+	}{
+		{remoteOnion, onion},
+		{remoteI2P, i2p},
+		{remoteIPv4, ipv4},
+	}
+	for i, test := range tests {
+		got := amgr.GetBestLocalAddress(test.remote)
+		if addrmgr.NetAddressKey(got) != addrmgr.NetAddressKey(test.want) {
+			t.Errorf("#%d: got %s, want %s", i, addrmgr.NetAddressKey(got),
+				addrmgr.NetAddressKey(test.want))
+		}
+	}
+}
+
+// TestSavePeersV2 ensures addresses of the new networks survive a round trip
+// through the peers file.
+func TestSavePeersV2(t *testing.T) {
+	dir, err := os.MkdirTemp("", "addrmgr")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	amgr := addrmgr.New(dir, nil, nil)
+	src := wire.NewNetAddressIPPort(net.ParseIP("173.194.115.66"), 8333, 0)
+	hosts := []string{
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+		"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+		"fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa",
+	}
+	for _, host := range hosts {
+		na, err := amgr.HostToNetAddress(host, 8333, 0)
+		if err != nil {
+			t.Fatalf("HostToNetAddress(%s): %v", host, err)
+		}
+		amgr.AddAddress(na, src)
+	}
+	amgr.Start()
+	amgr.Stop()
+
+	if _, err := os.Stat(filepath.Join(dir, "peers.json")); err != nil {
+		t.Fatalf("peers file not written: %v", err)
+	}
+	loaded := addrmgr.New(dir, nil, nil)
+	loaded.Start()
+	defer loaded.Stop()
+	if n := loaded.NumAddresses(); n != len(hosts) {
+		t.Fatalf("loaded %d addresses, want %d", n, len(hosts))
+	}
+	ka := loaded.GetAddress()
+	if ka == nil {
+		t.Fatalf("GetAddress: no address")
+	}
+	key := addrmgr.NetAddressKey(ka.NetAddress())
+	found := false
+	for _, host := range hosts {
+		found = found || key == net.JoinHostPort(host, "8333")
+	}
+	if !found {
+		t.Errorf("GetAddress: unexpected address %s", key)
+	}
+}
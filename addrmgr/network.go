@@ -84,6 +84,9 @@ var (
 	// { magic 6 bytes, 10 bytes base32 decode of key hash }
 	onionCatNet = ipNet("fd87:d87e:eb43::", 48, 128)
 
+	// cjdnsNet defines the IPv6 address block used by CJDNS.
+	cjdnsNet = ipNet("fc00::", 8, 128)
+
 	// zero4Net defines the IPv4 address block for address staring with 0
 	// (0.0.0.0/8).
 	zero4Net = ipNet("0.0.0.0", 8, 32)
@@ -117,6 +120,22 @@ func IsOnionCatTor(na *wire.NetAddress) bool {
 	return onionCatNet.Contains(na.IP)
 }
 
+// IsTorV3 returns whether or not the passed address is a Tor v3 hidden
+// service.
+func IsTorV3(na *wire.NetAddress) bool {
+	return na.Network() == wire.NetTorV3
+}
+
+// IsI2P returns whether or not the passed address is an I2P destination.
+func IsI2P(na *wire.NetAddress) bool {
+	return na.Network() == wire.NetI2P
+}
+
+// IsCJDNS returns whether or not the passed address is a CJDNS address.
+func IsCJDNS(na *wire.NetAddress) bool {
+	return na.Network() == wire.NetCJDNS
+}
+
 // IsRFC1918 returns whether or not the passed address is part of the IPv4
 // private network address space as defined by RFC1918 (10.0.0.0/8,
 // 172.16.0.0/12, or 192.168.0.0/16).
@@ -212,7 +231,16 @@ func IsRFC6598(na *wire.NetAddress) bool {
 // considered invalid under the following circumstances:
 // IPv4: It is either a zero or all bits set address.
 // IPv6: It is either a zero or RFC3849 documentation address.
+// Tor v3 and I2P: The address is not 32 bytes long.
+// CJDNS: The address is not in fc00::/8.
 func IsValid(na *wire.NetAddress) bool {
+	switch na.Network() {
+	case wire.NetTorV3, wire.NetI2P:
+		return len(na.Addr) == 32
+	case wire.NetCJDNS:
+		return cjdnsNet.Contains(na.IP)
+	}
+
 	// IsUnspecified returns if address is 0, so only all bits set, and
 	// RFC3849 need to be explicitly checked.
 	return na.IP != nil && !(na.IP.IsUnspecified() ||
@@ -221,8 +249,12 @@ func IsValid(na *wire.NetAddress) bool {
 
 // IsRoutable returns whether or not the passed address is routable over
 // the public internet.  This is true as long as the address is valid and is not
-// in any reserved ranges.
+// in any reserved ranges.  Valid Tor v3, I2P and CJDNS addresses are always
+// routable over their network.
 func IsRoutable(na *wire.NetAddress) bool {
+	if !na.IsAddrV1() {
+		return IsValid(na)
+	}
 	return IsValid(na) && !(IsRFC1918(na) || IsRFC2544(na) ||
 		IsRFC3927(na) || IsRFC4862(na) || IsRFC3849(na) ||
 		IsRFC4843(na) || IsRFC5737(na) || IsRFC6598(na) ||
@@ -232,8 +264,9 @@ func IsRoutable(na *wire.NetAddress) bool {
 // GroupKey returns a string representing the network group an address is part
 // of.  This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address, the string "tor:key" where key is the /4 of the
-// onion address for Tor address, and the string "unroutable" for an unroutable
-// address.
+// onion address for Tor address, the network name and the /4 of the address
+// for Tor v3, I2P and CJDNS addresses, and the string "unroutable" for an
+// unroutable address.
 func GroupKey(na *wire.NetAddress) string {
 	if IsLocal(na) {
 		return "local"
@@ -241,6 +274,12 @@ func GroupKey(na *wire.NetAddress) string {
 	if !IsRoutable(na) {
 		return "unroutable"
 	}
+	switch na.Network() {
+	case wire.NetTorV3, wire.NetI2P:
+		return fmt.Sprintf("%v:%d", na.Network(), na.Addr[0]&((1<<4)-1))
+	case wire.NetCJDNS:
+		return fmt.Sprintf("%v:%d", na.Network(), na.IP[1]&((1<<4)-1))
+	}
 	if IsIPv4(na) {
 		return na.IP.Mask(net.CIDRMask(16, 32)).String()
 	}
@@ -42,7 +42,7 @@ func (b *BlockChain) maybeAcceptBlock(block *btcutil.Block, flags BehaviorFlags)
 		return true, nil, -1
 	}
 
-	if block.MsgBlock().Header.Nonce < 0 && len(block.MsgBlock().Transactions[0].SignatureScripts) <= wire.CommitteeSigs {
+	if block.MsgBlock().Header.Nonce < 0 && wire.NumBlockSigners(block.MsgBlock().Transactions[0]) < wire.CommitteeSigs {
 		return false, fmt.Errorf("insifficient signatures"), -1
 	}
 	if block.MsgBlock().Header.Nonce < 0 && len(block.MsgBlock().Transactions[0].SignatureScripts[1]) < 33 {
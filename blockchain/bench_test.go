@@ -7,7 +7,10 @@ package blockchain
 import (
 	"testing"
 
+	"github.com/zeusyf/btcd/chaincfg"
 	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/ovm"
+	"github.com/zeusyf/omega/viewpoint"
 )
 
 // BenchmarkIsCoinBase performs a simple benchmark against the IsCoinBase
@@ -29,3 +32,58 @@ func BenchmarkIsCoinBaseTx(b *testing.B) {
 		IsCoinBaseTx(tx)
 	}
 }
+
+// sigBenchTxs is the number of transactions in the block of the signature
+// verification benchmarks, about as many as in the large blocks generated by
+// fullblocktests.
+const sigBenchTxs = 2000
+
+// sigBenchSetup returns the signed transactions of a block for the signature
+// verification benchmarks along with the view holding the outputs they spend.
+func sigBenchSetup(b *testing.B) (*BlockChain, []*btcutil.Tx, *viewpoint.ViewPointSet, func()) {
+	chain, teardownFunc, err := chainSetup("sigbench",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		b.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	txs, views := signedTestTxs(b, chain, sigBenchTxs)
+	return chain, txs, views, teardownFunc
+}
+
+// BenchmarkVerifySigsSerial benchmarks verifying the signatures of the
+// transactions of a block with the OVM one after another, as checkConnectBlock
+// does for the transactions depending on other transactions of the block.
+func BenchmarkVerifySigsSerial(b *testing.B) {
+	chain, txs, views, teardownFunc := sigBenchSetup(b)
+	defer teardownFunc()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, tx := range txs {
+			err := ovm.VerifySigs(tx, chain.ChainParams, 0, views)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkVerifySigsParallel benchmarks verifying the signatures of the
+// independent transactions of a block with verifyBlockSigs, as
+// checkConnectBlock does.
+func BenchmarkVerifySigsParallel(b *testing.B) {
+	chain, txs, views, teardownFunc := sigBenchSetup(b)
+	defer teardownFunc()
+
+	independent := make([]bool, len(txs))
+	for i := range independent {
+		independent[i] = true
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := chain.verifyBlockSigs(txs, independent, views); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+// aggregateSigActive returns whether the block after the passed node may be
+// signed with versioned block signatures, which is once the aggregate
+// signature deployment is active.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) aggregateSigActive(prevNode *chainutil.BlockNode) (bool, error) {
+	state, err := b.deploymentState(prevNode, chaincfg.DeploymentAggregateSig)
+	if err != nil {
+		return false, err
+	}
+	return state == ThresholdActive, nil
+}
+
+// verifyAggregateBlockSig checks a versioned block signature of a block whose
+// signature hash is passed.  committee holds the miner blocks of the members
+// of the committee in the order used by the signer bitmap, nil for unknown
+// members.  It returns the pubkey hashes of the signers.
+func verifyAggregateBlockSig(script []byte, hash []byte, committee []*wire.MinerBlock) ([][20]byte, error) {
+	agg, err := wire.ParseAggregateBlockSig(script)
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([][20]byte, 0, len(agg.PubKeys))
+	keys := make([]*btcec.PublicKey, 0, len(agg.PubKeys))
+	k := 0
+	for i := 0; i < len(agg.Signers)*8; i++ {
+		if !agg.HasSigner(i) {
+			continue
+		}
+		if i >= len(committee) || committee[i] == nil {
+			return nil, fmt.Errorf("aggregate block signature has "+
+				"signer %d out of the committee", i)
+		}
+
+		var pkh [20]byte
+		copy(pkh[:], btcutil.Hash160(agg.PubKeys[k]))
+		if !bytes.Equal(pkh[:], committee[i].MsgBlock().Miner[:]) {
+			return nil, fmt.Errorf("aggregate block signature key %d "+
+				"does not match committee member %d", k, i)
+		}
+
+		key, err := btcec.ParsePubKey(agg.PubKeys[k], btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		signers = append(signers, pkh)
+		k++
+	}
+
+	aggKey, err := btcec.AggregatePubKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := btcec.ParseSchnorrSignature(agg.Signature[:])
+	if err != nil {
+		return nil, err
+	}
+	if !sig.Verify(hash, aggKey.Q) {
+		return nil, fmt.Errorf("aggregate block signature is invalid")
+	}
+
+	return signers, nil
+}
@@ -58,6 +58,7 @@ type BestState struct {
 	// for every POW block, it increase by CommitteeSize
 	// to phase out the last committee EVEN it means to pass the
 	// end of Miner chain (for consistency among nodes
+	UtxoCommitment chainhash.Hash // Hash of the MuHash of the utxo set.
 
 	// values below are not store in DB
 	BlockSize uint64    // The size of the best block.
@@ -116,6 +117,9 @@ type MinerChain interface {
 	DSReport(*wire.Violations)
 	FastReorganizeChain(attachNodes *list.List) error
 	TPSreportFromDB([20]byte, uint32) []TPSrv
+	InvalidateBlock(hash *chainhash.Hash) error
+	ReconsiderBlock(hash *chainhash.Hash) error
+	PreciousBlock(hash *chainhash.Hash) error
 }
 
 // BlockChain provides functions for working with the bitcoin block chain.
@@ -132,6 +136,8 @@ type BlockChain struct {
 	ChainParams         *chaincfg.Params
 	timeSource          chainutil.MedianTimeSource
 	indexManager        IndexManager
+	pruneDepth          int32
+	finalitySigners     int
 
 	Miners MinerChain // The Miner chain to provide the next Miner
 
@@ -182,6 +188,37 @@ type BlockChain struct {
 	StateLock     sync.RWMutex
 	stateSnapshot *BestState
 
+	// utxoCommitment is the MuHash of the utxo set at the best block.  It
+	// is protected by the chain lock.
+	utxoCommitment *MuHash
+
+	// utxoCache caches the changes to the utxo set until they are flushed
+	// to the database.
+	utxoCache *utxoCache
+
+	// These fields are related to finality.  finalNode is the last final
+	// block of the main chain and finalSigs the committee members known to
+	// have signed the tx blocks above it.  They are protected by the chain
+	// lock.
+	finalNode *chainutil.BlockNode
+	finalSigs map[chainhash.Hash]*finalitySigs
+
+	// These fields are related to the version bits deployments.  They are
+	// protected by the chain lock.
+	//
+	// warningCaches caches the current deployment threshold state for blocks
+	// in each of the **possible** deployments.  This is used in order to
+	// detect when new unrecognized rule changes are being voted on and/or
+	// have been activated such as will be the case when older versions of
+	// the software are being used
+	//
+	// deploymentCaches caches the current deployment threshold state for
+	// blocks in each of the actively defined deployments.
+	warningCaches         []thresholdStateCache
+	deploymentCaches      []thresholdStateCache
+	unknownRulesWarned    bool
+	unknownVersionsWarned bool
+
 	// The notifications field stores a slice of callbacks to be executed on
 	// certain blockchain events.
 	notificationsLock sync.RWMutex
@@ -625,7 +662,7 @@ func (s * BlockChain) GetRollbackList(h int32) * list.List {
 func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Block,
 	view *viewpoint.ViewPointSet, stxos []viewpoint.SpentTxOut, vm *ovm.OVM) error {
 
-	if block.MsgBlock().Header.Nonce < 0 && len(block.MsgBlock().Transactions[0].SignatureScripts) <= wire.CommitteeSigs {
+	if block.MsgBlock().Header.Nonce < 0 && wire.NumBlockSigners(block.MsgBlock().Transactions[0]) < wire.CommitteeSigs {
 		return fmt.Errorf("insifficient signatures")
 	}
 	if block.MsgBlock().Header.Nonce < 0 && len(block.MsgBlock().Transactions[0].SignatureScripts[1]) < btcec.PubKeyBytesLenCompressed {
@@ -674,10 +711,47 @@ func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Bloc
 		log.Infof("Update LastRotation to %d", state.LastRotation)
 	}
 
+	// Update the utxo set commitment with the outputs spent and created
+	// by the block.
+	commitment, err := connectUtxoCommitment(b.utxoCommitment, block,
+		node.Height, stxos)
+	if err != nil {
+		return err
+	}
+	state.UtxoCommitment = commitment.Hash()
+
+	// Update the threshold states of the deployments for the blocks after
+	// this one, and warn about unknown versions and rules once current.
+	for id := 0; id < len(b.ChainParams.Deployments); id++ {
+		if _, err := b.deploymentState(node, uint32(id)); err != nil {
+			return err
+		}
+	}
+	if b.isCurrent() {
+		if err := b.warnUnknownVersions(node); err != nil {
+			return err
+		}
+		if err := b.warnUnknownRuleActivations(node); err != nil {
+			return err
+		}
+	}
+
+	// Keep the changes to the utxo set in the utxo cache, and flush them
+	// along with the block once the cache is full.  They are undone when
+	// the block can not be stored.
+	undo := b.utxoCache.commit(view.Utxo, node.Height, true)
+	flush := b.utxoCache.needsFlush()
+
 	// Atomically insert info into the database.
 	err = b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
-		err := dbPutBestState(dbTx, state)
+		err := dbPutBestState(dbTx, state, commitment)
+		if err != nil {
+			return err
+		}
+
+		// Store the threshold states of the deployments.
+		err = dbPutThresholdCaches(dbTx, b.deploymentCaches, b.ChainParams)
 		if err != nil {
 			return err
 		}
@@ -689,10 +763,16 @@ func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Bloc
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails removing all of the utxos spent and adding the new
-		// ones created by the block.
-		err = viewpoint.DbPutViews(dbTx, view)
+		// Update the utxo set from the utxo cache when flushing it, and
+		// the other views using their state.  The utxos spent and added
+		// by the block are in the utxo cache.
+		if flush {
+			err = b.utxoCache.flush(dbTx, &node.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		err = dbPutViewsExceptUtxos(dbTx, view)
 		if err != nil {
 			return err
 		}
@@ -717,12 +797,17 @@ func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Bloc
 		return nil
 	})
 	if err != nil {
+		b.utxoCache.rollback(undo)
 		return err
 	}
 
+	var locked, unlocked []wire.OutPoint
 	for i := 0; i < m; i++ {
 		c := b.collaterals[0]
 		delete(b.LockedCollaterals, c)
+		if c != (wire.OutPoint{}) {
+			unlocked = append(unlocked, c)
+		}
 
 		b.collaterals = b.collaterals[1:]
 
@@ -734,12 +819,20 @@ func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Bloc
 		c = *mb.MsgBlock().Utxos
 		b.collaterals = append(b.collaterals, c)
 		b.LockedCollaterals[c] = struct{}{}
+		locked = append(locked, c)
 	}
 
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.Commit()
 	vm.Commit()
+	b.utxoCommitment = commitment
+	if flush {
+		b.utxoCache.markFlushed(node.Height)
+	}
+	for i := range b.deploymentCaches {
+		b.deploymentCaches[i].MarkFlushed()
+	}
 
 	// update blocklist
 	//	b.Blacklist.Update(uint32(node.Height))
@@ -760,11 +853,21 @@ func (b *BlockChain) connectBlock(node *chainutil.BlockNode, block *btcutil.Bloc
 
 	//	fmt.Printf("connectBlock: stateSnapshot updated to %d\n", state.Height)
 
+	// Delete the blocks which are now deep enough when pruning.
+	b.maybePruneBlocks(node.Height)
+
+	// Count the committee signatures of the block towards its finality,
+	// and drop those of the blocks which can no longer become final.
+	b.addFinalitySigs(node, block.MsgBlock().Transactions[0].SignatureScripts)
+	b.pruneFinalitySigs(node.Height)
+
 	// Notify the caller that the block was connected to the main chain.
 	// The caller would typically want to react with actions such as
 	// updating wallets.
 	b.ChainLock.Unlock()
 	b.SendNotification(NTBlockConnected, block)
+	b.sendBlockEvents(node, block, true, rot, state.LastRotation,
+		locked, unlocked)
 	b.ChainLock.Lock()
 
 	return nil
@@ -850,9 +953,28 @@ func (b *BlockChain) disconnectBlock(node *chainutil.BlockNode, block *btcutil.B
 		newTotalTxns, prevNode.CalcPastMedianTime(), // bits,
 		rotation) // prevNode.bits, b.BestSnapshot().LastRotation)
 
+	undo := b.utxoCache.commit(view.Utxo, node.Height, false)
+
+	var commitment *MuHash
 	err = b.db.Update(func(dbTx database.Tx) error {
+		// Before we delete the spend journal entry for this back,
+		// we'll fetch it as is so the utxo set commitment and the
+		// indexers can utilize if needed.
+		stxos, err := dbFetchSpendJournalEntry(dbTx, block)
+		if err != nil {
+			return err
+		}
+
+		// Restore the utxo set commitment to the previous block.
+		commitment, err = disconnectUtxoCommitment(b.utxoCommitment,
+			block, node.Height, stxos)
+		if err != nil {
+			return err
+		}
+		state.UtxoCommitment = commitment.Hash()
+
 		// Update best block state.
-		err := dbPutBestState(dbTx, state)
+		err = dbPutBestState(dbTx, state, commitment)
 		if err != nil {
 			return err
 		}
@@ -866,15 +988,13 @@ func (b *BlockChain) disconnectBlock(node *chainutil.BlockNode, block *btcutil.B
 
 		// Update the utxo set using the state of the utxo view.  This
 		// entails restoring all of the utxos spent and removing the new
-		// ones created by the block.
-		err = viewpoint.DbPutViews(dbTx, view)
+		// ones created by the block.  The utxo cache is always flushed
+		// so the flush marker stays in the main chain.
+		err = b.utxoCache.flush(dbTx, &prevNode.Hash)
 		if err != nil {
 			return err
 		}
-
-		// Before we delete the spend journal entry for this back,
-		// we'll fetch it as is so the indexers can utilize if needed.
-		stxos, err := dbFetchSpendJournalEntry(dbTx, block)
+		err = dbPutViewsExceptUtxos(dbTx, view)
 		if err != nil {
 			return err
 		}
@@ -899,12 +1019,18 @@ func (b *BlockChain) disconnectBlock(node *chainutil.BlockNode, block *btcutil.B
 		return nil
 	})
 	if err != nil {
+		b.utxoCache.rollback(undo)
 		return err
 	}
+	b.utxoCache.markFlushed(prevNode.Height)
 
+	var locked, unlocked []wire.OutPoint
 	for i := 0; i < m; i++ {
 		c := b.collaterals[b.ChainParams.ViolationReportDeadline-1]
 		delete(b.LockedCollaterals, c)
+		if c != (wire.OutPoint{}) {
+			unlocked = append(unlocked, c)
+		}
 		b.collaterals = b.collaterals[:b.ChainParams.ViolationReportDeadline-1]
 
 		mb, _ := b.Miners.BlockByHeight(int32(rot) - b.ChainParams.ViolationReportDeadline - int32(i))
@@ -915,11 +1041,13 @@ func (b *BlockChain) disconnectBlock(node *chainutil.BlockNode, block *btcutil.B
 		c = *mb.MsgBlock().Utxos
 		b.collaterals = append([]wire.OutPoint{c}, b.collaterals...)
 		b.LockedCollaterals[c] = struct{}{}
+		locked = append(locked, c)
 	}
 
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.Commit()
+	b.utxoCommitment = commitment
 	/*
 		if b.Blacklist != nil {
 			b.Blacklist.Rollback(uint32(node.Height))
@@ -946,6 +1074,8 @@ func (b *BlockChain) disconnectBlock(node *chainutil.BlockNode, block *btcutil.B
 	// updating wallets.
 	b.ChainLock.Unlock()
 	b.SendNotification(NTBlockDisconnected, block)
+	b.sendBlockEvents(node, block, false, rot, state.LastRotation,
+		locked, unlocked)
 	b.ChainLock.Lock()
 
 	return nil
@@ -997,8 +1127,8 @@ func (b *BlockChain) SignedBy(x *list.Element, miners []*[20]byte) bool {
 // is outside committee. commiteee does not include those collateral are spent,
 // thus if they sign, the check will fail
 func (b *BlockChain) signedBy(block *btcutil.Block, miners []*[20]byte) bool {
-	for _, sign := range block.MsgBlock().Transactions[0].SignatureScripts[1:] {
-		k, _ := btcec.ParsePubKey(sign[:btcec.PubKeyBytesLenCompressed], btcec.S256())
+	for _, sign := range wire.BlockSignerKeys(block.MsgBlock().Transactions[0]) {
+		k, _ := btcec.ParsePubKey(sign, btcec.S256())
 		pk, _ := btcutil.NewAddressPubKeyPubKey(*k, b.ChainParams)
 		// is the signer in committee?
 		signer := *pk.AddressPubKeyHash().Hash160()
@@ -1089,6 +1219,11 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 		}
 	}
 
+	// Refuse to disconnect final blocks.
+	if err := b.checkFinality(detachNodes, attachNodes); err != nil {
+		return 0, 0, err
+	}
+
 	// Track the old and new best chains heads.
 	oldBest := tip
 	newBest := tip
@@ -1138,7 +1273,7 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err = views.FetchInputUtxos(block)
+		err = b.fetchInputUtxos(views, block)
 		if err != nil {
 			return 0, 0, err
 		}
@@ -1325,6 +1460,20 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 	views, Vm = b.Canvas(nil)
 	views.SetBestHash(&b.BestChain.Tip().Hash)
 
+	// Notify the caller the reorganization starts before the first block
+	// is disconnected.  The chain lock is released for the notification,
+	// as it is for those of the blocks disconnected and connected below.
+	reorganize := detachNodes.Len() != 0 && attachNodes.Len() != 0
+	if reorganize {
+		newTip := attachNodes.Back().Value.(*chainutil.BlockNode)
+		started := newReorganization(oldBest, newTip, detachNodes,
+			attachNodes)
+
+		b.ChainLock.Unlock()
+		b.SendNotification(NTReorganizeStarted, started)
+		b.ChainLock.Lock()
+	}
+
 	// Disconnect blocks from the main chain.
 	for i, e := 0, detachNodes.Front(); e != nil; i, e = i+1, e.Next() {
 		n := e.Value.(*chainutil.BlockNode)
@@ -1338,7 +1487,7 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := views.FetchInputUtxos(block)
+		err := b.fetchInputUtxos(views, block)
 		if err != nil {
 			return 0, 0, err
 		}
@@ -1354,13 +1503,15 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 			return 0, 0, err
 		}
 
+		version := b.contractVersion(n.Parent,
+			block.MsgBlock().Header.Version)
 		Vm.BlockNumber = func() uint64 {
 			return uint64(block.Height())
 		}
 		Vm.BlockTime = func() uint32 {
 			return uint32(block.MsgBlock().Header.Timestamp.Unix())
 		}
-		Vm.BlockVersion = func() uint32 { return block.MsgBlock().Header.Version }
+		Vm.BlockVersion = func() uint32 { return version }
 
 		if err = Vm.Rollback(); err != nil { // roll back contract state in DB
 			return 0, 0, err
@@ -1385,7 +1536,7 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := views.FetchInputUtxos(block)
+		err := b.fetchInputUtxos(views, block)
 		if err != nil {
 			log.Infof("FetchInputUtxos error: " + err.Error())
 			return detachable, attachable, err // should panic. this should never happend and would potentially corrupt the database
@@ -1410,13 +1561,15 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 				op := wire.OutPoint{*coinBaseHash, uint32(len(coinBase.MsgTx().TxOut) - 1)}
 				return op
 			})
+		version := b.contractVersion(n.Parent,
+			block.MsgBlock().Header.Version)
 		Vm.BlockNumber = func() uint64 {
 			return uint64(block.Height())
 		}
 		Vm.BlockTime = func() uint32 {
 			return uint32(block.MsgBlock().Header.Timestamp.Unix())
 		}
-		Vm.BlockVersion = func() uint32 { return block.MsgBlock().Header.Version }
+		Vm.BlockVersion = func() uint32 { return version }
 
 		Vm.StepLimit = block.MsgBlock().Header.ContractExec
 		Vm.GetCoinBase = func() *btcutil.Tx { return coinBase }
@@ -1483,6 +1636,17 @@ func (b *BlockChain) doReorganizeChain(detachNodes, attachNodes *list.List, chec
 	log.Infof("REORGANIZE: New best chain head is %v (height %v)",
 		newBest.Hash, newBest.Height)
 
+	// Notify the caller the reorganization is done.
+	if reorganize {
+		done := newReorganization(oldBest, newBest, detachNodes,
+			attachNodes)
+		done.Attach = done.Attach[:attachable]
+
+		b.ChainLock.Unlock()
+		b.SendNotification(NTReorganizeDone, done)
+		b.ChainLock.Lock()
+	}
+
 	return detachable, attachable, nil
 }
 
@@ -1505,7 +1669,7 @@ func (b *BlockChain) CheckCollateral(block *wire.MinerBlock, latest *chainhash.H
 
 	utxos := viewpoint.NewUtxoViewpoint()
 
-	err := utxos.FetchUtxosMain(b.db, map[wire.OutPoint]struct{}{*block.MsgBlock().Utxos: struct{}{}})
+	err := b.fetchUtxosMain(utxos, map[wire.OutPoint]struct{}{*block.MsgBlock().Utxos: struct{}{}})
 
 	e := utxos.LookupEntry(*block.MsgBlock().Utxos)
 	if err != nil || e == nil {
@@ -1571,13 +1735,16 @@ func (b *BlockChain) Canvas(block *btcutil.Block) (*viewpoint.ViewPointSet, *ovm
 	Vm.SetViewPoint(views)
 
 	if block != nil {
+		version := b.contractVersion(
+			b.index.LookupNode(&block.MsgBlock().Header.PrevBlock),
+			block.MsgBlock().Header.Version)
 		Vm.BlockNumber = func() uint64 {
 			return uint64(block.Height())
 		}
 		Vm.BlockTime = func() uint32 {
 			return uint32(block.MsgBlock().Header.Timestamp.Unix())
 		}
-		Vm.BlockVersion = func() uint32 { return block.MsgBlock().Header.Version }
+		Vm.BlockVersion = func() uint32 { return version }
 		//		Vm.Block = func() *btcutil.Block { return block }
 		Vm.SetCoinBaseOp(
 			func(txo wire.TxOut) wire.OutPoint {
@@ -1636,13 +1803,7 @@ func (b *BlockChain) connectBestChain(node *chainutil.BlockNode, block *btcutil.
 		// Skip checks if node has already been fully validated.
 		fastAdd = fastAdd || b.index.NodeStatus(node).KnownValid()
 
-		best := b.BestSnapshot()
-		mb := b.Miners.NodeByHeight(int32(best.LastRotation))
-
-		cnl := mb.Data.GetContractExec()
-		if cnl == 0 {
-			cnl = b.ChainParams.ContractExecLimit
-		}
+		cnl := b.ContractExecLimit()
 		if block.MsgBlock().Header.ContractExec > cnl && b.ChainParams.Net == common.MainNet {
 			// contract execution must not exceed block limit
 			str := fmt.Sprintf("Contract execution steps exceeds block limit in %v", *block.Hash())
@@ -1678,11 +1839,7 @@ func (b *BlockChain) connectBestChain(node *chainutil.BlockNode, block *btcutil.
 		// utxos, spend them, and add the new utxos being created by
 		// this block.
 		if fastAdd {
-			err := views.FetchInputUtxos(block)
-			if err != nil {
-				return false, err
-			}
-			err = views.ConnectTransactions(block, &stxos)
+			err := b.connectBlockUtxos(views, block, &stxos)
 			if err != nil {
 				return false, err
 			}
@@ -2066,6 +2223,20 @@ func (b *BlockChain) BlockHashByHeight(blockHeight int32) (*chainhash.Hash, erro
 	return &node.Hash, nil
 }
 
+// ContractExecLimit returns the maximum number of contract execution steps of
+// a block extending the best chain.  It is set by the miner block of the
+// current rotation, or is the default limit of the chain parameters.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ContractExecLimit() int64 {
+	best := b.BestSnapshot()
+	mb := b.Miners.NodeByHeight(int32(best.LastRotation))
+	if cnl := mb.Data.GetContractExec(); cnl != 0 {
+		return cnl
+	}
+	return b.ChainParams.ContractExecLimit
+}
+
 func (b *BlockChain) NodeByHeight(blockHeight int32) *chainutil.BlockNode {
 	if blockHeight < 0 {
 		return nil
@@ -2622,6 +2793,29 @@ type Config struct {
 	// index manager.
 	IndexManager IndexManager
 
+	// PruneDepth enables pruning of the stored blocks.  Blocks deeper than
+	// PruneDepth below the best block are deleted as new blocks are
+	// connected.  It is raised to MinPruneDepth when lower.
+	//
+	// This field can be zero if the caller does not wish to prune blocks.
+	PruneDepth int32
+
+	// FinalityFraction enables finality.  A tx block signed by at least
+	// this fraction of the committee is final once in the main chain, and
+	// reorganizations disconnecting a final block are refused.
+	//
+	// This field can be zero if the caller does not wish to enforce
+	// finality.
+	FinalityFraction float64
+
+	// UtxoCacheMaxSize is the maximum size in bytes of the utxo cache,
+	// which holds the changes to the utxo set until they are written to
+	// the database in a batch.
+	//
+	// This field can be zero if the caller wishes to write the changes of
+	// every block to the database.
+	UtxoCacheMaxSize uint64
+
 	Miner   []btcutil.Address
 	PrivKey []*btcec.PrivateKey
 
@@ -2674,6 +2868,11 @@ func New(config *Config) (*BlockChain, error) {
 	}
 
 	params := config.ChainParams
+	pruneDepth := config.PruneDepth
+	if pruneDepth > 0 && pruneDepth < MinPruneDepth(params) {
+		pruneDepth = MinPruneDepth(params)
+	}
+
 	targetTimespan := int64(params.TargetTimespan / time.Second)
 	targetTimePerBlock := int64(params.TargetTimePerBlock / time.Second)
 	adjustmentFactor := params.RetargetAdjustmentFactor
@@ -2684,6 +2883,8 @@ func New(config *Config) (*BlockChain, error) {
 		ChainParams:         params,
 		timeSource:          config.TimeSource,
 		indexManager:        config.IndexManager,
+		pruneDepth:          pruneDepth,
+		finalitySigners:     finalityThreshold(config.FinalityFraction),
 		minRetargetTimespan: targetTimespan / adjustmentFactor,
 		maxRetargetTimespan: targetTimespan * adjustmentFactor,
 		blocksPerRetarget:   int32(targetTimespan / targetTimePerBlock),
@@ -2700,6 +2901,10 @@ func New(config *Config) (*BlockChain, error) {
 		LockedCollaterals: make(map[wire.OutPoint]struct{}),
 		IsPacking:         false,
 		BTfile:            f,
+		finalSigs:         make(map[chainhash.Hash]*finalitySigs),
+		warningCaches:     newThresholdCaches(vbNumBits),
+		deploymentCaches:  newThresholdCaches(chaincfg.DefinedDeployments),
+		utxoCache:         newUtxoCache(config.UtxoCacheMaxSize),
 	}
 
 	// Initialize the chain state from the passed database.  When the db
@@ -2708,6 +2913,20 @@ func New(config *Config) (*BlockChain, error) {
 	if err := b.initChainState(); err != nil {
 		return nil, err
 	}
+	if b.finalitySigners > 0 {
+		b.finalNode = b.findFinalNode()
+	}
+
+	// Replay the blocks whose changes to the utxo set were not flushed to
+	// the database.
+	if err := b.initUtxoCache(); err != nil {
+		return nil, err
+	}
+
+	// Initialize the threshold state caches of the deployments.
+	if err := b.initThresholdCaches(); err != nil {
+		return nil, err
+	}
 
 	// Perform any upgrades to the various chain-specific buckets as needed.
 	//	if err := b.maybeUpgradeDbBuckets(config.Interrupt); err != nil {
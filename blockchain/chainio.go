@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/zeusyf/btcd/blockchain/bccompress"
+	"github.com/zeusyf/btcd/chaincfg"
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/database"
 	"github.com/zeusyf/btcd/wire"
@@ -113,6 +114,16 @@ var (
 	// miner's TPS record.
 	minerTPSBucketName = []byte("tpsrecord")
 
+	// thresholdStateBucketName is the name of the db bucket used to house
+	// the threshold states of the deployments, in a nested bucket for each
+	// deployment.
+	thresholdStateBucketName = []byte("thresholdstate")
+
+	// utxoFlushMarkerKeyName is the name of the db key used to store the
+	// hash of the block the utxo set was last flushed at from the utxo
+	// cache.
+	utxoFlushMarkerKeyName = []byte("utxoflushmarker")
+
 	// byteOrder is the preferred byte order used for serializing numeric
 	// fields for storage in the database.
 	byteOrder = binary.LittleEndian
@@ -132,6 +143,20 @@ func IsDbBucketNotFoundErr(err error) bool {
 	return ok && dbErr.ErrorCode == database.ErrBucketNotFound
 }
 
+// IsDbBlockPrunedErr returns whether or not the passed error is a
+// database.Error with an error code of database.ErrBlockPruned.
+func IsDbBlockPrunedErr(err error) bool {
+	dbErr, ok := err.(database.Error)
+	return ok && dbErr.ErrorCode == database.ErrBlockPruned
+}
+
+// isDbBlockNotFoundErr returns whether or not the passed error is a
+// database.Error with an error code of database.ErrBlockNotFound.
+func isDbBlockNotFoundErr(err error) bool {
+	dbErr, ok := err.(database.Error)
+	return ok && dbErr.ErrorCode == database.ErrBlockNotFound
+}
+
 // DbFetchVersion fetches an individual version with the given key from the
 // metadata bucket.  It is primarily used to track versions on entities such as
 // buckets.  It returns zero if the provided key does not exist.
@@ -189,6 +214,10 @@ func (b *BlockChain) FetchUtxoEntry(outpoint wire.OutPoint) (*viewpoint.UtxoEntr
 	b.ChainLock.RLock()
 	defer b.ChainLock.RUnlock()
 
+	if entry, ok := b.utxoCache.lookupEntry(outpoint); ok {
+		return entry, nil
+	}
+
 	var entry *viewpoint.UtxoEntry
 	err := b.db.View(func(dbTx database.Tx) error {
 		var err error
@@ -663,6 +692,7 @@ func DbFetchHashByHeight(dbTx database.Tx, height int32) (*chainhash.Hash, error
 // The serialized format is:
 //
 //   <block hash><block height><total txns><work sum length><work sum>
+//   <utxo commitment>
 //
 //   Field             Type             Size
 //   block hash        chainhash.Hash   chainhash.HashSize
@@ -670,6 +700,7 @@ func DbFetchHashByHeight(dbTx database.Tx, height int32) (*chainhash.Hash, error
 //   total txns        uint64           8 bytes
 //   work sum length   uint32           4 bytes
 //   work sum          big.Int          work sum length
+//   utxo commitment   MuHash           MuHashSize, absent in older versions
 // -----------------------------------------------------------------------------
 
 // bestChainState represents the data to be stored the database for the current
@@ -680,6 +711,10 @@ type bestChainState struct {
 	height    uint32
 	totalTxns uint64
 	rotation  uint32
+
+	// utxoCommitment is the serialized MuHash of the utxo set, nil when
+	// it was not stored.
+	utxoCommitment []byte
 }
 
 // serializeBestChainState returns the serialization of the passed block best
@@ -689,6 +724,7 @@ func serializeBestChainState(state bestChainState) []byte {
 	//	workSumBytes := state.workSum.Bytes()
 	//	workSumBytesLen := uint32(len(workSumBytes))
 	serializedLen := chainhash.HashSize + 4 + 8 + 4 + 4 + 4 // + workSumBytesLen
+	serializedLen += len(state.utxoCommitment)
 
 	// Serialize the chain state.
 	serializedData := make([]byte, serializedLen)
@@ -701,6 +737,8 @@ func serializeBestChainState(state bestChainState) []byte {
 	byteOrder.PutUint32(serializedData[offset:], state.rotation)
 	offset += 4
 	byteOrder.PutUint64(serializedData[offset:], state.totalTxns)
+	offset += 12
+	copy(serializedData[offset:], state.utxoCommitment)
 
 	return serializedData[:]
 }
@@ -729,26 +767,259 @@ func deserializeBestChainState(serializedData []byte) (bestChainState, error) {
 	state.rotation = byteOrder.Uint32(serializedData[offset : offset+4])
 	offset += 4
 	state.totalTxns = byteOrder.Uint64(serializedData[offset : offset+8])
+	offset += 12
+
+	if len(serializedData) >= int(offset)+MuHashSize {
+		state.utxoCommitment = make([]byte, MuHashSize)
+		copy(state.utxoCommitment, serializedData[offset:])
+	}
 
 	return state, nil
 }
 
 // dbPutBestState uses an existing database transaction to update the best chain
 // state with the given parameters.
-func dbPutBestState(dbTx database.Tx, snapshot *BestState) error {
+func dbPutBestState(dbTx database.Tx, snapshot *BestState, utxoCommitment *MuHash) error {
 	// Serialize the current best chain state.
 	serializedData := serializeBestChainState(bestChainState{
-		hash:      snapshot.Hash,
-		bits:      snapshot.Bits,
-		rotation:  snapshot.LastRotation,
-		height:    uint32(snapshot.Height),
-		totalTxns: snapshot.TotalTxns,
+		hash:           snapshot.Hash,
+		bits:           snapshot.Bits,
+		rotation:       snapshot.LastRotation,
+		height:         uint32(snapshot.Height),
+		totalTxns:      snapshot.TotalTxns,
+		utxoCommitment: utxoCommitment.Serialize(),
 	})
 
 	// Store the current best chain state into the database.
 	return dbTx.Metadata().Put(chainStateKeyName, serializedData)
 }
 
+// dbPutUtxoFlushMarker uses an existing database transaction to record that
+// the utxo set in the database is the utxo set after the passed block.
+func dbPutUtxoFlushMarker(dbTx database.Tx, hash *chainhash.Hash) error {
+	return dbTx.Metadata().Put(utxoFlushMarkerKeyName, hash[:])
+}
+
+// dbFetchUtxoFlushMarker uses an existing database transaction to load the
+// hash of the block the utxo set in the database was last flushed at.  It
+// returns nil when no flush marker is stored.
+func dbFetchUtxoFlushMarker(dbTx database.Tx) *chainhash.Hash {
+	serialized := dbTx.Metadata().Get(utxoFlushMarkerKeyName)
+	if len(serialized) != chainhash.HashSize {
+		return nil
+	}
+	var hash chainhash.Hash
+	copy(hash[:], serialized)
+	return &hash
+}
+
+// -----------------------------------------------------------------------------
+// The threshold states of the deployments are stored in a nested bucket of the
+// threshold state bucket for each deployment.  The key of the nested bucket
+// is the definition of the deployment, so the states are recalculated when it
+// changes:
+//
+//   <deployment id><prev version><feature mask><start time><expire time>
+//   <activation threshold><confirmation window>
+//
+//   Field                   Type      Size
+//   deployment id           uint32    4
+//   prev version            uint32    4
+//   feature mask            uint32    4
+//   start time              uint64    8
+//   expire time             uint64    8
+//   activation threshold    uint32    4
+//   confirmation window     uint32    4
+//
+// The nested bucket maps the hash of the last block of a confirmation window
+// to the threshold state of the following blocks, serialized as one byte.
+// -----------------------------------------------------------------------------
+
+// thresholdCacheKey returns the key of the nested bucket of the threshold
+// states of the passed deployment.
+func thresholdCacheKey(id uint32, params *chaincfg.Params) []byte {
+	d := &params.Deployments[id]
+	key := make([]byte, 36)
+	byteOrder.PutUint32(key[0:4], id)
+	byteOrder.PutUint32(key[4:8], d.PrevVersion)
+	byteOrder.PutUint32(key[8:12], d.FeatureMask)
+	byteOrder.PutUint64(key[12:20], d.StartTime)
+	byteOrder.PutUint64(key[20:28], d.ExpireTime)
+	byteOrder.PutUint32(key[28:32], params.RuleChangeActivationThreshold)
+	byteOrder.PutUint32(key[32:36], params.MinerConfirmationWindow)
+	return key
+}
+
+// dbFetchThresholdCaches loads the threshold states of the deployments stored
+// in the database into the passed caches.
+func dbFetchThresholdCaches(dbTx database.Tx, caches []thresholdStateCache, params *chaincfg.Params) error {
+	bucket := dbTx.Metadata().Bucket(thresholdStateBucketName)
+	if bucket == nil {
+		return nil
+	}
+	for id := range caches {
+		cacheBucket := bucket.Bucket(thresholdCacheKey(uint32(id), params))
+		if cacheBucket == nil {
+			continue
+		}
+		err := cacheBucket.ForEach(func(k, v []byte) error {
+			if len(k) != chainhash.HashSize || len(v) != 1 ||
+				ThresholdState(v[0]) >= numThresholdsStates {
+
+				return database.Error{
+					ErrorCode:   database.ErrCorruption,
+					Description: "corrupt threshold state entry",
+				}
+			}
+			var hash chainhash.Hash
+			copy(hash[:], k)
+			caches[id].entries[hash] = ThresholdState(v[0])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbPutThresholdCaches stores the modified threshold states of the passed
+// caches, and removes the states of deployments which are no longer defined
+// as they were.
+func dbPutThresholdCaches(dbTx database.Tx, caches []thresholdStateCache, params *chaincfg.Params) error {
+	bucket, err := dbTx.Metadata().CreateBucketIfNotExists(thresholdStateBucketName)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]struct{}, len(caches))
+	for id := range caches {
+		key := thresholdCacheKey(uint32(id), params)
+		current[string(key)] = struct{}{}
+		if len(caches[id].modified) == 0 {
+			continue
+		}
+		cacheBucket, err := bucket.CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+		for hash, state := range caches[id].modified {
+			hash := hash
+			if err := cacheBucket.Put(hash[:], []byte{byte(state)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	var stale [][]byte
+	err = bucket.ForEachBucket(func(k []byte) error {
+		if _, ok := current[string(k)]; !ok {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := bucket.DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbCreateChainBuckets creates the buckets which house the block index and
+// the chain state, and stores the versions of the utxo set and the spend
+// journal.
+func dbCreateChainBuckets(dbTx database.Tx) error {
+	meta := dbTx.Metadata()
+
+	var err error
+	// Create the bucket that houses map from tokentype to contract.
+	if _, err = meta.CreateBucket(IssuedTokenTypes); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the block index data.
+	if _, err = meta.CreateBucket(blockIndexBucketName); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the chain block hash to height
+	// index.
+	if _, err = meta.CreateBucket(hashIndexBucketName); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the chain block height to hash
+	// index.
+	if _, err = meta.CreateBucket(heightIndexBucketName); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses my coins.
+	//		if _, err = meta.CreateBucket(MycoinsBucketName); err != nil {
+	//			return err
+	//		}
+
+	// Create the bucket that houses the spend journal data and
+	// store its version.
+	if _, err = meta.CreateBucket(spendJournalBucketName); err != nil {
+		return err
+	}
+	if err = DbPutVersion(dbTx, utxoSetVersionKeyName, latestUtxoSetBucketVersion); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the utxo set and store its
+	// version.  Note that the genesis block coinbase transaction is
+	// intentionally not inserted here since it is not spendable by
+	// consensus rules.
+	if _, err = meta.CreateBucket(UtxoSetBucketName); err != nil {
+		return err
+	}
+	if err = DbPutVersion(dbTx, spendJournalVersionKeyName, latestSpendJournalBucketVersion); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the vertex hash to definition
+	//		if _, err = meta.CreateBucket(vertexSetBucketName); err != nil {
+	//			return err
+	//		}
+
+	// Create the bucket that houses the border hash to definition
+	if _, err = meta.CreateBucket(borderSetBucketName); err != nil {
+		return err
+	}
+	if _, err = meta.CreateBucket(borderBoxSetBucketName); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the polygon hash to definition
+	_, err = meta.CreateBucket(polygonSetBucketName)
+	if err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the right hash to definition
+	if _, err = meta.CreateBucket(rightSetBucketName); err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the miner tps records
+	if _, err = meta.CreateBucket(minerTPSBucketName); err != nil {
+		return err
+	}
+
+	// Create the compendatedBucketName bucket
+	if _, err = meta.CreateBucket(compendatedBucketName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // createChainState initializes both the database and the chain state to the
 // genesis block.  This includes creating the necessary buckets and inserting
 // the genesis block, so it must only be called on an uninitialized database.
@@ -778,90 +1049,12 @@ func (b *BlockChain) createChainState() error {
 	// Create the initial the database chain state including creating the
 	// necessary index buckets and inserting the genesis block.
 	err := b.db.Update(func(dbTx database.Tx) error {
-		meta := dbTx.Metadata()
-
-		var err error
-		// Create the bucket that houses map from tokentype to contract.
-		if _, err = meta.CreateBucket(IssuedTokenTypes); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the block index data.
-		if _, err = meta.CreateBucket(blockIndexBucketName); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the chain block hash to height
-		// index.
-		if _, err = meta.CreateBucket(hashIndexBucketName); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the chain block height to hash
-		// index.
-		if _, err = meta.CreateBucket(heightIndexBucketName); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses my coins.
-		//		if _, err = meta.CreateBucket(MycoinsBucketName); err != nil {
-		//			return err
-		//		}
-
-		// Create the bucket that houses the spend journal data and
-		// store its version.
-		if _, err = meta.CreateBucket(spendJournalBucketName); err != nil {
-			return err
-		}
-		if err = DbPutVersion(dbTx, utxoSetVersionKeyName, latestUtxoSetBucketVersion); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the utxo set and store its
-		// version.  Note that the genesis block coinbase transaction is
-		// intentionally not inserted here since it is not spendable by
-		// consensus rules.
-		if _, err = meta.CreateBucket(UtxoSetBucketName); err != nil {
-			return err
-		}
-		if err = DbPutVersion(dbTx, spendJournalVersionKeyName, latestSpendJournalBucketVersion); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the vertex hash to definition
-		//		if _, err = meta.CreateBucket(vertexSetBucketName); err != nil {
-		//			return err
-		//		}
-
-		// Create the bucket that houses the border hash to definition
-		if _, err = meta.CreateBucket(borderSetBucketName); err != nil {
-			return err
-		}
-		if _, err = meta.CreateBucket(borderBoxSetBucketName); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the polygon hash to definition
-		_, err = meta.CreateBucket(polygonSetBucketName)
+		// Create the buckets of the chain state.
+		err := dbCreateChainBuckets(dbTx)
 		if err != nil {
 			return err
 		}
 
-		// Create the bucket that houses the right hash to definition
-		if _, err = meta.CreateBucket(rightSetBucketName); err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the miner tps records
-		if _, err = meta.CreateBucket(minerTPSBucketName); err != nil {
-			return err
-		}
-
-		// Create the compendatedBucketName bucket
-		if _, err = meta.CreateBucket(compendatedBucketName); err != nil {
-			return err
-		}
-
 		// Save the genesis block to the block index database.
 		if err = dbStoreBlockNode(dbTx, node); err != nil {
 			return err
@@ -874,11 +1067,6 @@ func (b *BlockChain) createChainState() error {
 			return err
 		}
 
-		// Store the current best chain state into the database.
-		if err = dbPutBestState(dbTx, b.stateSnapshot); err != nil {
-			return err
-		}
-
 		// Store the initial Tx, bur not the coin base Tx.
 		txs := genesisBlock.Transactions()
 		views := b.NewViewPointSet()
@@ -892,6 +1080,18 @@ func (b *BlockChain) createChainState() error {
 			return err
 		}
 
+		// Commit to the utxo set made of the initial Tx.
+		b.utxoCommitment, err = dbComputeUtxoCommitment(dbTx)
+		if err != nil {
+			return err
+		}
+		b.stateSnapshot.UtxoCommitment = b.utxoCommitment.Hash()
+
+		// Store the current best chain state into the database.
+		if err = dbPutBestState(dbTx, b.stateSnapshot, b.utxoCommitment); err != nil {
+			return err
+		}
+
 		// Store the genesis block into the database.
 		return dbStoreBlock(dbTx, genesisBlock)
 	})
@@ -1104,7 +1304,7 @@ func (b *BlockChain) initChainState() error {
 	buffertop := 0
 
 	// Attempt to load the chain state from the database.
-
+	var storeCommitment bool
 	exec := func(dbTx database.Tx) error {
 		// Fetch the stored chain state from the database metadata.
 		// When it doesn't exist, it means the database hasn't been
@@ -1292,6 +1492,24 @@ func (b *BlockChain) initChainState() error {
 			numTxns, state.totalTxns, tip.CalcPastMedianTime(), // state.bits,
 			state.rotation)
 
+		// Load the utxo set commitment.  A database created by an
+		// older version does not have it, so it is computed from the
+		// utxo set and stored below.
+		if state.utxoCommitment != nil {
+			b.utxoCommitment, err = DeserializeMuHash(state.utxoCommitment)
+			if err != nil {
+				return err
+			}
+		} else {
+			log.Infof("Computing the utxo set commitment...")
+			b.utxoCommitment, err = dbComputeUtxoCommitment(dbTx)
+			if err != nil {
+				return err
+			}
+			storeCommitment = true
+		}
+		b.stateSnapshot.UtxoCommitment = b.utxoCommitment.Hash()
+
 		return nil
 	}
 
@@ -1301,6 +1519,15 @@ func (b *BlockChain) initChainState() error {
 		return err
 	}
 
+	if storeCommitment {
+		err = b.db.Update(func(dbTx database.Tx) error {
+			return dbPutBestState(dbTx, b.stateSnapshot, b.utxoCommitment)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// As we might have updated the index after it was loaded, we'll
 	// attempt to flush the index to the DB. This will only result in a
 	// write if the elements are dirty, so it'll usually be a noop.
@@ -1536,9 +1763,13 @@ func deserializeBlockRow(blockRow []byte) (*wire.BlockHeader, chainutil.BlockSta
 }
 
 // DbFetchHeaderByHash uses an existing database transaction to retrieve the
-// block header for the provided hash.
+// block header for the provided hash.  The header of a pruned block is read
+// from the block index.
 func DbFetchHeaderByHash(dbTx database.Tx, hash *chainhash.Hash) (*wire.BlockHeader, error) {
 	headerBytes, err := dbTx.FetchBlockHeader(hash)
+	if IsDbBlockPrunedErr(err) || isDbBlockNotFoundErr(err) {
+		return dbFetchPrunedHeader(dbTx, hash, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1570,7 +1801,7 @@ func dbFetchBlockByNode(dbTx database.Tx, node *chainutil.BlockNode) (*btcutil.B
 	// Load the raw block bytes from the database.
 	blockBytes, err := dbTx.FetchBlock(&node.Hash)
 	if err != nil {
-		return nil, err
+		return nil, dbPrunedBlockErr(dbTx, node, err)
 	}
 
 	// Create the encapsulated block and set the height appropriately.
@@ -1921,7 +2152,7 @@ func (b *BlockChain) FetchUtxoView(tx *btcutil.Tx) (*viewpoint.ViewPointSet, err
 	// chain.
 	view := viewpoint.NewViewPointSet(b.db)
 	b.ChainLock.RLock()
-	err := view.Utxo.FetchUtxosMain(b.db, neededSet)
+	err := b.fetchUtxosMain(view.Utxo, neededSet)
 	b.ChainLock.RUnlock()
 	return view, err
 }
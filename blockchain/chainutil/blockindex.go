@@ -31,6 +31,14 @@ const (
 	// has failed validation, thus the block is also invalid.
 	StatusInvalidAncestor
 
+	// StatusPruned indicates that the block's payload has been removed from
+	// disk by pruning.  Only the header is kept.
+	StatusPruned
+
+	// StatusFinal indicates that the block, or a descendant of it, has been
+	// signed by enough of the committee that it can not be disconnected.
+	StatusFinal
+
 	// StatusNone indicates that the block has no validation state flags set.
 	//
 	// NOTE: This must be defined last in order to avoid influencing iota.
@@ -52,6 +60,18 @@ func (status BlockStatus) HaveData() bool {
 	return status&StatusDataStored != 0
 }
 
+// Pruned returns whether the full block data has been removed from the
+// database by pruning.
+func (status BlockStatus) Pruned() bool {
+	return status&StatusPruned != 0
+}
+
+// Final returns whether the block is final, so the chain may not be
+// reorganized to disconnect it.
+func (status BlockStatus) Final() bool {
+	return status&StatusFinal != 0
+}
+
 // KnownValid returns whether the block is known to be valid. This will return
 // false for a valid block that has not been fully validated yet.
 func (status BlockStatus) KnownValid() bool {
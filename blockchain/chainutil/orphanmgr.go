@@ -294,6 +294,36 @@ func (b *Orphans) OnNewMinerNode(handler func(*chainhash.Hash, *chainhash.Hash,
 	return added
 }
 
+// RemoveOrphansOf removes the orphans descending from the block with the
+// passed hash from the orphan pool, such as when the block is found invalid.
+// It returns the number of orphans removed.
+//
+// This function is safe for concurrent access.
+func (b *Orphans) RemoveOrphansOf(hash *chainhash.Hash) int {
+	removed := 0
+	hashes := []chainhash.Hash{*hash}
+	for len(hashes) > 0 {
+		prevHash := hashes[0]
+		hashes = hashes[1:]
+
+		b.orphanLock.RLock()
+		orphans := append([]*orphanBlock(nil), b.prevOrphans[prevHash]...)
+		b.orphanLock.RUnlock()
+
+		for _, orphan := range orphans {
+			hashes = append(hashes, *orphan.block.Hash())
+			b.orphanLock.Lock()
+			if orphan == b.oldestOrphan {
+				b.oldestOrphan = nil
+			}
+			b.orphanLock.Unlock()
+			b.removeOrphanBlock(orphan)
+			removed++
+		}
+	}
+	return removed
+}
+
 func (b *Orphans) CheckOrphan(blockHash * chainhash.Hash, block Orphaned) bool {
 	// The block must not already exist as an orphan.
 	if p, exists := b.orphans[*blockHash]; exists {
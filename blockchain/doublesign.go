@@ -0,0 +1,314 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+// signedBlock is a tx block signed by a committee member along with the
+// signature of the member, which together prove the member signed the block
+// even when the block itself is not kept by the chain.
+type signedBlock struct {
+	hash      chainhash.Hash
+	header    wire.BlockHeader
+	signature []byte
+}
+
+// doubleSign is the evidence collected against a committee member at a tx
+// block height: the miner block which put it in the committee and the
+// different tx blocks it signed at that height.
+type doubleSign struct {
+	mrBlock  chainhash.Hash
+	mrHeight int32
+	blocks   []signedBlock
+}
+
+// DoubleSignWatcher looks for committee members signing two different tx
+// blocks at the same height.  It is fed with the blocks and signatures
+// received from peers and keeps the proofs until they can no longer be
+// reported, that is, until ViolationReportDeadline miner blocks after the
+// miner block of the violator.  The proofs are handed out as Violations
+// ready to be put in the ViolationReport of a miner block.
+type DoubleSignWatcher struct {
+	chain *BlockChain
+
+	mtx sync.Mutex
+
+	// signed holds, by tx block height and signer, the blocks signed by
+	// each committee member.
+	signed map[int32]map[[20]byte]*doubleSign
+
+	// pruned is the miner chain height of the last pruning.
+	pruned int32
+}
+
+// NewDoubleSignWatcher returns a new double sign watcher for the passed chain.
+func NewDoubleSignWatcher(chain *BlockChain) *DoubleSignWatcher {
+	return &DoubleSignWatcher{
+		chain:  chain,
+		signed: make(map[int32]map[[20]byte]*doubleSign),
+	}
+}
+
+// AddBlock records the committee signatures of a tx block.  Blocks whose
+// parent is unknown are ignored.  The signatures are checked, so the block
+// should have passed the checks of the chain first.
+//
+// This function is safe for concurrent access.
+func (w *DoubleSignWatcher) AddBlock(block *btcutil.Block) {
+	msgBlock := block.MsgBlock()
+	if msgBlock.Header.Nonce > 0 || len(msgBlock.Transactions) == 0 {
+		return
+	}
+	parent := w.chain.NodeByHash(&msgBlock.Header.PrevBlock)
+	if parent == nil {
+		return
+	}
+	w.record(*block.Hash(), &msgBlock.Header, parent,
+		msgBlock.Transactions[0].SignatureScripts)
+}
+
+// AddSignatures records the committee signatures of the tx block with the
+// passed hash, as relayed in a MsgSignatures.  Signatures of unknown blocks
+// are ignored.
+//
+// This function is safe for concurrent access.
+func (w *DoubleSignWatcher) AddSignatures(msg *wire.MsgSignatures) {
+	node := w.chain.NodeByHash(&msg.Hash)
+	if node == nil || node.Data.GetNonce() > 0 {
+		return
+	}
+	parent := w.chain.ParentNode(node)
+	if parent == nil {
+		return
+	}
+	header := w.chain.NodetoHeader(node)
+	w.record(msg.Hash, &header, parent, msg.Signatures)
+}
+
+// committeeSigners checks the signatures of the tx block with the passed hash
+// and parent and returns the committee members who signed it and the
+// signature script of each of them, along with the committee.  The first
+// signature script is the witness commitment of the coinbase and is skipped.
+// Signers outside the committee are not returned.
+func (b *BlockChain) committeeSigners(hash chainhash.Hash, parent *chainutil.BlockNode, sigs [][]byte) ([][20]byte, [][]byte, [wire.CommitteeSize]*wire.MinerBlock, bool) {
+	var mbs [wire.CommitteeSize]*wire.MinerBlock
+	if len(sigs) < 2 {
+		return nil, nil, mbs, false
+	}
+	rotate, ok := b.rotationAt(parent)
+	if !ok {
+		return nil, nil, mbs, false
+	}
+
+	for i := range mbs {
+		mb, _ := b.Miners.BlockByHeight(int32(rotate) - wire.CommitteeSize + 1 + int32(i))
+		mbs[i] = mb
+	}
+
+	height := parent.Height + 1
+	sigHash := MakeMinerSigHash(height, hash)
+
+	var signers [][20]byte
+	var signatures [][]byte
+	for _, sign := range sigs[1:] {
+		if wire.IsVersionedBlockSig(sign) {
+			pkhs, err := verifyAggregateBlockSig(sign, sigHash, mbs[:])
+			if err != nil {
+				continue
+			}
+			for _, pkh := range pkhs {
+				signers = append(signers, pkh)
+				signatures = append(signatures, sign)
+			}
+			continue
+		}
+		signer, err := btcutil.VerifySigScript(sign, sigHash, b.ChainParams)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, *signer.Hash160())
+		signatures = append(signatures, sign)
+	}
+
+	var members [][20]byte
+	var memberSigs [][]byte
+	for i, pkh := range signers {
+		for _, m := range mbs {
+			if m != nil && m.MsgBlock().Miner == pkh {
+				members = append(members, pkh)
+				memberSigs = append(memberSigs, signatures[i])
+				break
+			}
+		}
+	}
+	return members, memberSigs, mbs, true
+}
+
+// record checks the signatures of the block with the passed hash, header and
+// parent and records the committee members who signed it.
+func (w *DoubleSignWatcher) record(hash chainhash.Hash, header *wire.BlockHeader, parent *chainutil.BlockNode, sigs [][]byte) {
+	signers, signatures, mbs, ok := w.chain.committeeSigners(hash, parent,
+		sigs)
+	if !ok {
+		return
+	}
+	height := parent.Height + 1
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.prune(w.chain.Miners.BestSnapshot().Height)
+
+	for i, pkh := range signers {
+		var mb *wire.MinerBlock
+		for _, m := range mbs {
+			if m != nil && m.MsgBlock().Miner == pkh {
+				mb = m
+				break
+			}
+		}
+		if mb == nil {
+			continue
+		}
+
+		bySigner, ok := w.signed[height]
+		if !ok {
+			bySigner = make(map[[20]byte]*doubleSign)
+			w.signed[height] = bySigner
+		}
+		ds, ok := bySigner[pkh]
+		if !ok {
+			ds = &doubleSign{
+				mrBlock:  *mb.Hash(),
+				mrHeight: mb.Height(),
+			}
+			bySigner[pkh] = ds
+		}
+		known := false
+		for _, sb := range ds.blocks {
+			if sb.hash == hash {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		ds.blocks = append(ds.blocks, signedBlock{
+			hash:      hash,
+			header:    *header,
+			signature: signatures[i],
+		})
+		if len(ds.blocks) == 2 {
+			log.Infof("Committee member %x double signed blocks %s "+
+				"and %s at height %d", pkh, ds.blocks[0].hash,
+				ds.blocks[1].hash, height)
+		}
+	}
+}
+
+// Violations returns the proofs of double signing which can still be reported
+// in a miner block at the passed height, in tx block height order.  Proofs
+// which can no longer be reported are discarded.
+//
+// This function is safe for concurrent access.
+func (w *DoubleSignWatcher) Violations(minerHeight int32) []*wire.Violations {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.prune(minerHeight)
+
+	var violations []*wire.Violations
+	for height, bySigner := range w.signed {
+		for _, ds := range bySigner {
+			if len(ds.blocks) < 2 {
+				continue
+			}
+			blocks := make([]chainhash.Hash, 0, len(ds.blocks))
+			for _, sb := range ds.blocks {
+				blocks = append(blocks, sb.hash)
+			}
+			violations = append(violations, &wire.Violations{
+				Height:  height,
+				MRBlock: ds.mrBlock,
+				Blocks:  blocks,
+			})
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Height != violations[j].Height {
+			return violations[i].Height < violations[j].Height
+		}
+		return violations[i].MRBlock.String() < violations[j].MRBlock.String()
+	})
+	return violations
+}
+
+// Proof returns the headers of the blocks of the passed violation and the
+// signature of the violator on each of them, in the order of the blocks of the
+// violation.  It returns false when the watcher does not hold the proof of
+// the violation, such as when it has been discarded past its report deadline.
+//
+// This function is safe for concurrent access.
+func (w *DoubleSignWatcher) Proof(v *wire.Violations) ([]wire.BlockHeader, [][]byte, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, ds := range w.signed[v.Height] {
+		if ds.mrBlock != v.MRBlock {
+			continue
+		}
+		headers := make([]wire.BlockHeader, 0, len(v.Blocks))
+		signatures := make([][]byte, 0, len(v.Blocks))
+		for _, hash := range v.Blocks {
+			found := false
+			for _, sb := range ds.blocks {
+				if sb.hash == hash {
+					headers = append(headers, sb.header)
+					signatures = append(signatures, sb.signature)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, nil, false
+			}
+		}
+		return headers, signatures, true
+	}
+	return nil, nil, false
+}
+
+// prune discards the signatures which can no longer be reported in a miner
+// block at the passed height.
+//
+// This function MUST be called with the watcher lock held.
+func (w *DoubleSignWatcher) prune(minerHeight int32) {
+	if minerHeight <= w.pruned {
+		return
+	}
+	w.pruned = minerHeight
+
+	deadline := w.chain.ChainParams.ViolationReportDeadline
+	for height, bySigner := range w.signed {
+		for pkh, ds := range bySigner {
+			if ds.mrHeight+deadline < minerHeight {
+				delete(bySigner, pkh)
+			}
+		}
+		if len(bySigner) == 0 {
+			delete(w.signed, height)
+		}
+	}
+}
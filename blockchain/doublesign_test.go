@@ -0,0 +1,241 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+// TestDoubleSignViolations ensures only committee members who signed several
+// blocks at a height are reported, and only until the report deadline.
+func TestDoubleSignViolations(t *testing.T) {
+	params := chaincfg.MainNetParams
+	w := NewDoubleSignWatcher(&BlockChain{ChainParams: &params})
+
+	mr1 := chainhash.Hash{0x01}
+	mr2 := chainhash.Hash{0x02}
+	a, b, c := chainhash.Hash{0xa}, chainhash.Hash{0xb}, chainhash.Hash{0xc}
+	signed := func(hashes ...chainhash.Hash) []signedBlock {
+		blocks := make([]signedBlock, 0, len(hashes))
+		for _, hash := range hashes {
+			blocks = append(blocks, signedBlock{
+				hash:      hash,
+				header:    wire.BlockHeader{Nonce: -int32(hash[0])},
+				signature: []byte{hash[0]},
+			})
+		}
+		return blocks
+	}
+	w.signed[20] = map[[20]byte]*doubleSign{
+		{0x01}: {mrBlock: mr1, mrHeight: 10, blocks: signed(a, b)},
+		{0x02}: {mrBlock: mr2, mrHeight: 50, blocks: signed(a)},
+	}
+	w.signed[30] = map[[20]byte]*doubleSign{
+		{0x02}: {mrBlock: mr2, mrHeight: 50, blocks: signed(c, a)},
+	}
+
+	want := []*wire.Violations{
+		{Height: 20, MRBlock: mr1, Blocks: []chainhash.Hash{a, b}},
+		{Height: 30, MRBlock: mr2, Blocks: []chainhash.Hash{c, a}},
+	}
+	got := w.Violations(10 + params.ViolationReportDeadline)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Violations: got %v, want %v", got, want)
+	}
+
+	// The proof of a violation holds the header of each block and the
+	// signature of the violator on it.
+	headers, sigs, ok := w.Proof(want[1])
+	if !ok {
+		t.Fatalf("Proof: no proof of %v", want[1])
+	}
+	wantHeaders := []wire.BlockHeader{{Nonce: -0xc}, {Nonce: -0xa}}
+	wantSigs := [][]byte{{0xc}, {0xa}}
+	if !reflect.DeepEqual(headers, wantHeaders) ||
+		!reflect.DeepEqual(sigs, wantSigs) {
+		t.Errorf("Proof: got %v %x, want %v %x", headers, sigs,
+			wantHeaders, wantSigs)
+	}
+	unknown := &wire.Violations{Height: 30, MRBlock: mr1, Blocks: want[1].Blocks}
+	if _, _, ok := w.Proof(unknown); ok {
+		t.Errorf("Proof: got a proof of an unknown violation")
+	}
+
+	// Past the deadline of the first violator.
+	got = w.Violations(11 + params.ViolationReportDeadline)
+	if !reflect.DeepEqual(got, want[1:]) {
+		t.Fatalf("Violations: got %v, want %v", got, want[1:])
+	}
+	if len(w.signed[20]) != 1 {
+		t.Errorf("Violations: expired proofs not discarded")
+	}
+
+	got = w.Violations(51 + params.ViolationReportDeadline)
+	if len(got) != 0 || len(w.signed) != 0 {
+		t.Errorf("Violations: got %v after all deadlines", got)
+	}
+}
+
+// committeeMinerChain is a miner chain serving the miner blocks of a single
+// committee, the last of which is at the rotation height.
+type committeeMinerChain struct {
+	MinerChain
+	rotation int32
+	blocks   []*wire.MinerBlock
+}
+
+// newCommitteeMinerChain returns a miner chain whose committee at the passed
+// rotation height is made of the passed members.
+func newCommitteeMinerChain(rotation int32, members [][20]byte) *committeeMinerChain {
+	m := &committeeMinerChain{rotation: rotation}
+	for i, pkh := range members {
+		mb := wire.NewMinerBlock(&wire.MingingRightBlock{
+			Version:   wire.Version2,
+			PrevBlock: chainhash.Hash{byte(i)},
+			Miner:     pkh,
+		})
+		mb.SetHeight(rotation - int32(len(members)) + 1 + int32(i))
+		m.blocks = append(m.blocks, mb)
+	}
+	return m
+}
+
+// BestSnapshot returns the state of the miner chain at the rotation height.
+func (m *committeeMinerChain) BestSnapshot() *BestState {
+	return &BestState{Height: m.rotation}
+}
+
+// BlockByHeight returns the committee member at the passed height.
+func (m *committeeMinerChain) BlockByHeight(height int32) (*wire.MinerBlock, error) {
+	i := height - m.rotation + int32(len(m.blocks)) - 1
+	if i < 0 || int(i) >= len(m.blocks) {
+		return nil, AssertError("miner block not found")
+	}
+	return m.blocks[i], nil
+}
+
+// signTestBlock appends the signature of the passed key on the tx block at
+// the passed height to its coinbase, in the format miners sign blocks.
+func signTestBlock(tb testing.TB, block *btcutil.Block, height int32, key *btcec.PrivateKey) {
+	sigHash := MakeMinerSigHash(height, *block.Hash())
+	sig, err := key.Sign(sigHash)
+	if err != nil {
+		tb.Fatalf("Sign: %v", err)
+	}
+	coinbase := block.MsgBlock().Transactions[0]
+	if len(coinbase.SignatureScripts) == 0 {
+		coinbase.SignatureScripts = [][]byte{{}}
+	}
+	script := append(key.PubKey().SerializeCompressed(), sig.Serialize()...)
+	coinbase.SignatureScripts = append(coinbase.SignatureScripts, script)
+}
+
+// TestDoubleSignDetection ensures a committee member signing two different tx
+// blocks at the same height is detected from the blocks fed to the watcher,
+// while signers outside the committee and signatures of unknown blocks are
+// ignored.
+func TestDoubleSignDetection(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("doublesign",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	keys := make([]*btcec.PrivateKey, wire.CommitteeSize+1)
+	members := make([][20]byte, wire.CommitteeSize)
+	for i := range keys {
+		keys[i], err = btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: %v", err)
+		}
+		if i < len(members) {
+			copy(members[i][:], btcutil.Hash160(
+				keys[i].PubKey().SerializeCompressed()))
+		}
+	}
+	outsider := keys[wire.CommitteeSize]
+
+	rotation := int32(chain.BestSnapshot().LastRotation)
+	miners := newCommitteeMinerChain(rotation, members)
+	chain.Miners = miners
+	w := NewDoubleSignWatcher(chain)
+
+	// Two different blocks on the genesis block, both signed by the first
+	// member and the outsider, and one of them by the second member.
+	genesis := chain.BestSnapshot().Hash
+	blocks := make([]*btcutil.Block, 2)
+	for i := range blocks {
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+			PrevBlock: genesis,
+			Timestamp: time.Unix(int64(0x5f000000+i), 0),
+			Nonce:     -1,
+		})
+		coinbase := wire.NewMsgTx(wire.TxVersion)
+		coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex), 0))
+		msgBlock.AddTransaction(coinbase)
+		blocks[i] = btcutil.NewBlock(msgBlock)
+
+		signTestBlock(t, blocks[i], 1, keys[0])
+		signTestBlock(t, blocks[i], 1, outsider)
+		if i == 0 {
+			signTestBlock(t, blocks[i], 1, keys[1])
+		}
+	}
+
+	w.AddBlock(blocks[0])
+	if got := w.Violations(rotation); len(got) != 0 {
+		t.Fatalf("Violations: got %v after a single block", got)
+	}
+	w.AddBlock(blocks[1])
+	w.AddBlock(blocks[1])
+
+	want := []*wire.Violations{{
+		Height:  1,
+		MRBlock: *miners.blocks[0].Hash(),
+		Blocks:  []chainhash.Hash{*blocks[0].Hash(), *blocks[1].Hash()},
+	}}
+	got := w.Violations(rotation)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Violations: got %v, want %v", got, want)
+	}
+
+	// The proof holds the signature of the violator on each block.
+	headers, sigs, ok := w.Proof(want[0])
+	if !ok {
+		t.Fatalf("Proof: no proof of %v", want[0])
+	}
+	for i, block := range blocks {
+		if headers[i] != block.MsgBlock().Header {
+			t.Errorf("Proof: header %d is not the header of the "+
+				"signed block", i)
+		}
+		signer, err := btcutil.VerifySigScript(sigs[i],
+			MakeMinerSigHash(1, *block.Hash()), chain.ChainParams)
+		if err != nil || *signer.Hash160() != members[0] {
+			t.Errorf("Proof: signature %d is not the signature of "+
+				"the violator", i)
+		}
+	}
+
+	// Signatures of a block the chain doesn't know are ignored.
+	w.AddSignatures(&wire.MsgSignatures{
+		Hash:       chainhash.Hash{0x01},
+		Signatures: blocks[0].MsgBlock().Transactions[0].SignatureScripts,
+	})
+	if got := w.Violations(rotation); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Violations: got %v after signatures of an unknown "+
+			"block, want %v", got, want)
+	}
+}
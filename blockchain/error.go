@@ -226,6 +226,14 @@ const (
 	ErrBlockTimeOutOfOrder
 
 	ErrExpiredTx
+
+	// ErrFinalityReorg indicates that a reorganization would disconnect a
+	// block which has been signed by enough of the committee to be final.
+	ErrFinalityReorg
+
+	// ErrUnexpectedBlockSig indicates that a block is signed with a kind of
+	// block signature which is not valid for the block.
+	ErrUnexpectedBlockSig
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -274,6 +282,8 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrInvalidAncestorBlock:      "ErrInvalidAncestorBlock",
 	ErrPrevBlockNotBest:          "ErrPrevBlockNotBest",
 	ErrExcessContractExec:		  "ErrExcessContractExec",
+	ErrFinalityReorg:             "ErrFinalityReorg",
+	ErrUnexpectedBlockSig:        "ErrUnexpectedBlockSig",
 }
 
 // String returns the ErrorCode as a human-readable name.
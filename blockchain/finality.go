@@ -0,0 +1,198 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+)
+
+// ReorgRefused is the data of a NTReorgRefused notification.  Final is the
+// final block the reorganization would have disconnected and Tip the tip of
+// the side chain.
+type ReorgRefused struct {
+	Final       chainhash.Hash
+	FinalHeight int32
+	Tip         chainhash.Hash
+	TipHeight   int32
+}
+
+// maxFinalitySigsDepth is the depth below the best block past which the
+// signatures of blocks which are not final yet are dropped.  Such blocks are
+// either on a side chain or no longer signed by the committee.
+const maxFinalitySigsDepth = 100
+
+// finalitySigs are the committee members known to have signed a tx block
+// which is not final yet.
+type finalitySigs struct {
+	height  int32
+	signers map[[20]byte]struct{}
+}
+
+// finalityThreshold returns the number of committee signatures making a block
+// final for the passed fraction of the committee, or 0 when finality is
+// disabled.
+func finalityThreshold(fraction float64) int {
+	if fraction <= 0 {
+		return 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return int(math.Ceil(fraction * wire.CommitteeSize))
+}
+
+// findFinalNode returns the last final block of the main chain, or nil.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) findFinalNode() *chainutil.BlockNode {
+	for n := b.BestChain.Tip(); n != nil; n = n.Parent {
+		if b.index.NodeStatus(n).Final() {
+			return n
+		}
+	}
+	return nil
+}
+
+// setFinal marks the passed main chain block, and its ancestors, final.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) setFinal(node *chainutil.BlockNode) {
+	for n := node; n != nil && !b.index.NodeStatus(n).Final(); n = n.Parent {
+		b.index.SetStatusFlags(n, chainutil.StatusFinal)
+	}
+	b.finalNode = node
+	b.pruneFinalitySigs(b.BestChain.Height())
+
+	log.Infof("Block %v at height %d is final", node.Hash, node.Height)
+}
+
+// pruneFinalitySigs drops the signatures of the blocks at or below the final
+// block, and of those deeper than maxFinalitySigsDepth below the passed best
+// height.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pruneFinalitySigs(bestHeight int32) {
+	minHeight := bestHeight - maxFinalitySigsDepth
+	if b.finalNode != nil && b.finalNode.Height >= minHeight {
+		minHeight = b.finalNode.Height + 1
+	}
+	for hash, sigs := range b.finalSigs {
+		if sigs.height < minHeight {
+			delete(b.finalSigs, hash)
+		}
+	}
+}
+
+// addFinalitySigs records the committee members who signed the passed tx
+// block with the passed signature scripts, and marks the block final once
+// enough of them did and it is in the main chain.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) addFinalitySigs(node *chainutil.BlockNode, sigs [][]byte) {
+	if b.finalitySigners == 0 || node.Data.GetNonce() > 0 {
+		return
+	}
+	if b.finalNode != nil && node.Height <= b.finalNode.Height {
+		return
+	}
+	parent := b.ParentNode(node)
+	if parent == nil {
+		return
+	}
+
+	signers, _, _, ok := b.committeeSigners(node.Hash, parent, sigs)
+	if !ok {
+		return
+	}
+	known, ok := b.finalSigs[node.Hash]
+	if !ok {
+		known = &finalitySigs{
+			height:  node.Height,
+			signers: make(map[[20]byte]struct{}),
+		}
+		b.finalSigs[node.Hash] = known
+	}
+	for _, pkh := range signers {
+		known.signers[pkh] = struct{}{}
+	}
+
+	if len(known.signers) >= b.finalitySigners && b.BestChain.Contains(node) {
+		b.setFinal(node)
+	}
+}
+
+// checkFinality returns a rule error, and sends a NTReorgRefused
+// notification, when one of the nodes to detach from the main chain is final.
+// attachNodes are the nodes which would be attached.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkFinality(detachNodes, attachNodes *list.List) error {
+	if b.finalitySigners == 0 || b.finalNode == nil {
+		return nil
+	}
+
+	for e := detachNodes.Front(); e != nil; e = e.Next() {
+		n := e.Value.(*chainutil.BlockNode)
+		if !b.index.NodeStatus(n).Final() {
+			continue
+		}
+
+		refused := &ReorgRefused{
+			Final:       n.Hash,
+			FinalHeight: n.Height,
+		}
+		if e := attachNodes.Back(); e != nil {
+			tip := e.Value.(*chainutil.BlockNode)
+			refused.Tip = tip.Hash
+			refused.TipHeight = tip.Height
+		}
+		log.Warnf("Refused reorganization to %v at height %d which "+
+			"disconnects final block %v at height %d", refused.Tip,
+			refused.TipHeight, n.Hash, n.Height)
+
+		b.ChainLock.Unlock()
+		b.SendNotification(NTReorgRefused, refused)
+		b.ChainLock.Lock()
+
+		str := fmt.Sprintf("reorganization disconnects final block %v "+
+			"at height %d", n.Hash, n.Height)
+		return ruleError(ErrFinalityReorg, str)
+	}
+	return nil
+}
+
+// AddBlockSignatures records the committee signatures of a tx block relayed
+// in a MsgSignatures, which may make the block final.  Signatures of unknown
+// blocks are ignored.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) AddBlockSignatures(msg *wire.MsgSignatures) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	if node := b.index.LookupNode(&msg.Hash); node != nil {
+		b.addFinalitySigs(node, msg.Signatures)
+	}
+}
+
+// FinalBlock returns the hash and height of the last final block of the main
+// chain.  It returns false when finality is disabled or no block is final.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FinalBlock() (chainhash.Hash, int32, bool) {
+	b.ChainLock.RLock()
+	defer b.ChainLock.RUnlock()
+
+	if b.finalitySigners == 0 || b.finalNode == nil {
+		return chainhash.Hash{}, 0, false
+	}
+	return b.finalNode.Hash, b.finalNode.Height, true
+}
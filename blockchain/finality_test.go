@@ -0,0 +1,50 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// TestPruneFinalitySigs ensures the signatures of the blocks at or below the
+// final block, and of those too deep below the best block, are dropped.
+func TestPruneFinalitySigs(t *testing.T) {
+	b := &BlockChain{finalSigs: make(map[chainhash.Hash]*finalitySigs)}
+	for _, height := range []int32{10, 11, 150, 200, 250} {
+		b.finalSigs[chainhash.Hash{byte(height)}] = &finalitySigs{
+			height:  height,
+			signers: make(map[[20]byte]struct{}),
+		}
+	}
+	remaining := func() map[int32]bool {
+		heights := make(map[int32]bool)
+		for _, sigs := range b.finalSigs {
+			heights[sigs.height] = true
+		}
+		return heights
+	}
+
+	// Nothing is final yet, so only the depth bounds the signatures.
+	b.pruneFinalitySigs(110)
+	if got := remaining(); len(got) != 5 {
+		t.Fatalf("pruneFinalitySigs: got heights %v, want all 5", got)
+	}
+	b.pruneFinalitySigs(111)
+	if got := remaining(); len(got) != 4 || got[10] {
+		t.Fatalf("pruneFinalitySigs: got heights %v, want 10 dropped",
+			got)
+	}
+
+	// The final block bounds them when it is above the depth.
+	b.finalNode = &chainutil.BlockNode{Height: 150}
+	b.pruneFinalitySigs(200)
+	if got := remaining(); len(got) != 2 || !got[200] || !got[250] {
+		t.Fatalf("pruneFinalitySigs: got heights %v, want [200 250]",
+			got)
+	}
+}
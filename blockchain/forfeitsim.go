@@ -0,0 +1,188 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/omega/ovm"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// ForfeitureVictim is a transaction of a double signed block which is to be
+// compensated from the collateral of the violator.
+type ForfeitureVictim struct {
+	Tx chainhash.Hash
+
+	// Claim and Secured are the amounts written in the claim record of
+	// the transaction: the fee to be compensated, and the part of it
+	// guaranteed by the collateral.
+	Claim   int64
+	Secured int64
+
+	// Payees are the addresses, with their net ID, of the outputs which
+	// will be paid.
+	Payees [][21]byte
+}
+
+// ForfeitureAward is the award given to a reporter of the violation.
+type ForfeitureAward struct {
+	Reporter [20]byte
+	Amount   int64
+}
+
+// ViolatorForfeiture is the breakdown of the forfeiture of the collateral of
+// one violator.
+type ViolatorForfeiture struct {
+	MinerBlock chainhash.Hash
+	Height     int32
+	Collateral int64
+
+	// Contract is the amount paid to the forfeiture contract for the
+	// victims.
+	Contract int64
+
+	Awards  []ForfeitureAward
+	Burned  int64
+	Victims []ForfeitureVictim
+}
+
+// ForfeitureReport is the result of a forfeiture simulation.  Txs are the
+// compensation transactions CompTxs would generate.
+type ForfeitureReport struct {
+	Height     int32
+	MinerBlock chainhash.Hash
+	Violators  []*ViolatorForfeiture
+	Txs        []*wire.MsgTx
+}
+
+// isBurn returns whether the passed output destroys its value.
+func isBurn(txo *wire.TxOut) bool {
+	return len(txo.PkScript) > 0 && txo.PkScript[len(txo.PkScript)-1] == ovm.OP_PAY2NONE
+}
+
+// outValue returns the value of a numeric output, 0 for other outputs.
+func outValue(txo *wire.TxOut) int64 {
+	if v, ok := txo.Value.(*token.NumToken); ok {
+		return v.Val
+	}
+	return 0
+}
+
+// addViolator adds the breakdown of the forfeiture of the violator blk.  stx is
+// the transaction spending its collateral, ctx the claim filing transaction if
+// any and x the victims.
+func (r *ForfeitureReport) addViolator(g *BlockChain, blk *wire.MinerBlock, stx *wire.MsgTx, ctx *wire.MsgTx, x map[chainhash.Hash]*txfee) {
+	v := &ViolatorForfeiture{
+		MinerBlock: *blk.Hash(),
+		Height:     blk.Height(),
+	}
+	if op := blk.MsgBlock().Utxos; op != nil {
+		if tk := g.MainChainTx(op.Hash); tk != nil && int(op.Index) < len(tk.TxOut) {
+			v.Collateral = outValue(tk.TxOut[op.Index])
+		}
+	}
+
+	for i, txo := range stx.TxOut {
+		switch {
+		case isBurn(txo):
+			v.Burned += outValue(txo)
+
+		case i == 0:
+			v.Contract = outValue(txo)
+
+		default:
+			var reporter [20]byte
+			copy(reporter[:], txo.PkScript[1:21])
+			v.Awards = append(v.Awards, ForfeitureAward{
+				Reporter: reporter,
+				Amount:   outValue(txo),
+			})
+		}
+	}
+	if ctx != nil {
+		for _, txo := range ctx.TxOut {
+			if isBurn(txo) {
+				v.Burned += outValue(txo)
+			}
+		}
+	}
+
+	for _, tx := range x {
+		if tx == nil {
+			continue
+		}
+		v.Victims = append(v.Victims, ForfeitureVictim{
+			Tx:      *tx.tx.Hash(),
+			Claim:   tx.fee,
+			Secured: tx.sure,
+			Payees:  tx.payees,
+		})
+	}
+
+	r.Violators = append(r.Violators, v)
+}
+
+// SimulateForfeiture runs the forfeiture of the collateral of the miner block
+// at the passed height, as it would be done by CompTxs once its reporting
+// period is over, with the reports made so far.  The collateral and the
+// claims are looked up in views, or in the main chain when views is nil.  No
+// state is changed, so it may be used to audit forfeitures in advance.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SimulateForfeiture(height int32, views *viewpoint.ViewPointSet) (*ForfeitureReport, error) {
+	b.ChainLock.RLock()
+	defer b.ChainLock.RUnlock()
+
+	mb, err := b.Miners.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ForfeitureReport{
+		Height:     height,
+		MinerBlock: *mb.Hash(),
+		Txs:        make([]*wire.MsgTx, 0),
+	}
+	if mb.MsgBlock().Utxos == nil {
+		// No collateral, nothing to forfeit.
+		return report, nil
+	}
+
+	if views == nil {
+		views = b.NewViewPointSet()
+		need := map[wire.OutPoint]struct{}{*mb.MsgBlock().Utxos: {}}
+		if err := b.fetchUtxosMain(views.Utxo, need); err != nil {
+			return nil, err
+		}
+	}
+
+	// The violation may be reported up to the miner block before the
+	// one whose rotation triggers the forfeiture.
+	deadline := b.ChainParams.ViolationReportDeadline
+	last := height + deadline - 1
+	if best := b.Miners.BestSnapshot().Height; last > best {
+		last = best
+	}
+	prevminer := b.Miners.NodeByHeight(last)
+
+	mrblks := make([]wire.MingingRightBlock, deadline+wire.POWRotate)
+	for i := 0; i < int(deadline+wire.POWRotate) && prevminer != nil; i++ {
+		mrblks[int(deadline+wire.POWRotate)-i-1] = b.Miners.NodetoHeader(prevminer)
+		prevminer = prevminer.Parent
+	}
+
+	reportee := map[int32]*wire.MinerBlock{height: mb}
+	report.Txs, err = b.compTxs(b.BestChain.Tip(), reportee, mrblks, views, report)
+	if err != nil {
+		return nil, err
+	}
+	if report.Txs == nil {
+		report.Txs = make([]*wire.MsgTx, 0)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/ovm"
+	"github.com/zeusyf/omega/token"
+)
+
+// TestForfeitureBreakdown ensures the breakdown of a forfeiture is taken from
+// the compensation transactions generated for the violator.
+func TestForfeitureBreakdown(t *testing.T) {
+	g := &BlockChain{ChainParams: &chaincfg.MainNetParams}
+	blk := wire.NewMinerBlock(&wire.MingingRightBlock{Version: 1})
+	blk.SetHeight(300)
+
+	burn := []byte{g.ChainParams.PubKeyHashAddrID, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, ovm.OP_PAY2NONE}
+	reporter := [20]byte{0x0a}
+	award := make([]byte, 22)
+	award[0] = g.ChainParams.PubKeyHashAddrID
+	copy(award[1:], reporter[:])
+	award[21] = ovm.OP_PAY2PKH
+
+	stx := &wire.MsgTx{}
+	stx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 600}}, PkScript: make([]byte, 29)})
+	stx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 100}}, PkScript: award})
+	ctx := &wire.MsgTx{}
+	ctx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 50}}, PkScript: burn})
+
+	victim := btcutil.NewTx(&wire.MsgTx{Version: 1})
+	payee := [21]byte{g.ChainParams.PubKeyHashAddrID, 0x0b}
+	x := map[chainhash.Hash]*txfee{
+		*victim.Hash(): {tx: victim, fee: 550, sure: 550,
+			payees: [][21]byte{payee}},
+		{0x01}: nil,
+	}
+
+	var report ForfeitureReport
+	report.addViolator(g, blk, stx, ctx, x)
+
+	want := &ViolatorForfeiture{
+		MinerBlock: *blk.Hash(),
+		Height:     300,
+		Contract:   600,
+		Awards:     []ForfeitureAward{{Reporter: reporter, Amount: 100}},
+		Burned:     50,
+		Victims: []ForfeitureVictim{{Tx: *victim.Hash(), Claim: 550,
+			Secured: 550, Payees: [][21]byte{payee}}},
+	}
+	if len(report.Violators) != 1 || !reflect.DeepEqual(report.Violators[0], want) {
+		t.Fatalf("addViolator: got %+v, want %+v", report.Violators, want)
+	}
+
+	// Without claims the contract output is burned.
+	stx.TxOut[0].PkScript = burn
+	report.addViolator(g, blk, stx, nil, nil)
+	got := report.Violators[1]
+	if got.Contract != 0 || got.Burned != 600 || len(got.Victims) != 0 {
+		t.Errorf("addViolator: got %+v without claims", got)
+	}
+}
@@ -90,6 +90,13 @@ func (g *BlockChain) CompTxs(prevNode *chainutil.BlockNode, views *viewpoint.Vie
 		prevminer = prevminer.Parent
 	}
 
+	return g.compTxs(prevNode, reportee, mrblks, views, nil)
+}
+
+// compTxs generates the compensation transactions for the violators in
+// reportee, whose reports are in mrblks.  When report is not nil, the
+// breakdown of the forfeiture of each violator is added to it.
+func (g *BlockChain) compTxs(prevNode *chainutil.BlockNode, reportee map[int32]*wire.MinerBlock, mrblks []wire.MingingRightBlock, views *viewpoint.ViewPointSet, report *ForfeitureReport) ([]*wire.MsgTx, error) {
 	avgtx := -1
 
 	// usage score by addresses
@@ -117,6 +124,9 @@ func (g *BlockChain) CompTxs(prevNode *chainutil.BlockNode, views *viewpoint.Vie
 		if len(x) == 0 {
 			// no claim. no need to open, destroy the entire bal.
 			stx.TxOut[0].PkScript = []byte{g.ChainParams.PubKeyHashAddrID, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, ovm.OP_PAY2NONE}
+			if report != nil {
+				report.addViolator(g, blk, stx, nil, x)
+			}
 			continue
 		}
 
@@ -176,6 +186,9 @@ func (g *BlockChain) CompTxs(prevNode *chainutil.BlockNode, views *viewpoint.Vie
 			ctx.AddTxOut(cto)
 
 			ctransactions = append(ctransactions, ctx)
+			if report != nil {
+				report.addViolator(g, blk, stx, ctx, x)
+			}
 			continue
 		}
 
@@ -288,6 +301,9 @@ func (g *BlockChain) CompTxs(prevNode *chainutil.BlockNode, views *viewpoint.Vie
 		ctx.AddTxOut(cto)
 
 		ctransactions = append(ctransactions, ctx)
+		if report != nil {
+			report.addViolator(g, blk, stx, ctx, x)
+		}
 	}
 
 	return ctransactions, nil
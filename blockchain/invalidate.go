@@ -0,0 +1,264 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sort"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// descendants returns the nodes in the block index which descend from the
+// passed node.  Every descendant is an ancestor of a tip of the block index,
+// so they are found by walking back from the tips.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) descendants(node *chainutil.BlockNode) []*chainutil.BlockNode {
+	seen := make(map[*chainutil.BlockNode]struct{})
+	var nodes []*chainutil.BlockNode
+
+	tips := make([]*chainutil.BlockNode, 0, len(b.index.Tips)+1)
+	tips = append(tips, b.BestChain.Tip())
+	for _, t := range b.index.Tips {
+		tips = append(tips, t)
+	}
+
+	for _, t := range tips {
+		if t.Height <= node.Height {
+			continue
+		}
+		var path []*chainutil.BlockNode
+		n := t
+		for ; n != nil && n.Height > node.Height; n = n.Parent {
+			if _, ok := seen[n]; ok {
+				break
+			}
+			path = append(path, n)
+		}
+
+		// The path descends from the node when it leads to the node or
+		// to a descendant already found.
+		if _, ok := seen[n]; n != node && !ok {
+			continue
+		}
+		for _, p := range path {
+			seen[p] = struct{}{}
+			nodes = append(nodes, p)
+		}
+	}
+
+	return nodes
+}
+
+// bestTipCandidates returns the best valid blocks of the side chains which
+// are higher than the end of the main chain, from the highest down.  A side
+// chain block is valid when neither it nor any of its ancestors in the side
+// chain are known to be invalid and their data is available.  Candidates at
+// the same height are ordered by their hashes, so the order does not depend
+// on the order of the tips in the block index.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) bestTipCandidates() []*chainutil.BlockNode {
+	tip := b.BestChain.Tip()
+	seen := make(map[*chainutil.BlockNode]struct{})
+	var candidates []*chainutil.BlockNode
+	for _, t := range b.index.Tips {
+		candidate := t
+		for n := t; n != nil && !b.BestChain.Contains(n); n = n.Parent {
+			status := b.index.NodeStatus(n)
+			if status.KnownInvalid() || !status.HaveData() {
+				candidate = n.Parent
+			}
+		}
+		if candidate == nil || candidate.Height <= tip.Height {
+			continue
+		}
+		if _, ok := seen[candidate]; ok {
+			continue
+		}
+		seen[candidate] = struct{}{}
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Height != candidates[j].Height {
+			return candidates[i].Height > candidates[j].Height
+		}
+		return bytes.Compare(candidates[i].Hash[:],
+			candidates[j].Hash[:]) < 0
+	})
+	return candidates
+}
+
+// reorganizeToBestTip reorganizes the chain to the best valid block of the
+// side chains.  As in connectBestChain, a side chain only replaces the main
+// chain when it is higher, and the reorganization is still subject to the
+// rules of ReorganizeChain, which refuses to disconnect final blocks or the
+// block referenced by the best miner block.  So the candidates returned by
+// bestTipCandidates are tried in turn until one is accepted.
+//
+// This function may modify node statuses in the block index without flushing.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) reorganizeToBestTip() error {
+	tip := b.BestChain.Tip()
+	var err error
+	for _, candidate := range b.bestTipCandidates() {
+		detachNodes, attachNodes := b.getReorganizeNodes(candidate)
+		if attachNodes.Len() == 0 {
+			continue
+		}
+		err = b.ReorganizeChain(detachNodes, attachNodes)
+		if err == nil || b.BestChain.Tip() != tip {
+			return err
+		}
+		log.Infof("Not reorganizing to block %v (height %d): %v",
+			candidate.Hash, candidate.Height, err)
+	}
+	return err
+}
+
+// InvalidateBlock marks the block with the passed hash as invalid, and all of
+// its descendants as having an invalid ancestor.  When the block is in the
+// main chain, it is disconnected along with its descendants, and the chain is
+// reorganized to the best remaining valid side chain.  The orphans descending
+// from the invalidated blocks are dropped.
+//
+// The statuses are stored in the block index, so they are kept across
+// restarts until the block is reconsidered with ReconsiderBlock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) InvalidateBlock(hash *chainhash.Hash) error {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %v is not known", hash)
+	}
+	if node.Parent == nil {
+		return fmt.Errorf("block %v at height %d can not be invalidated",
+			hash, node.Height)
+	}
+	if b.index.NodeStatus(node).Final() {
+		str := fmt.Sprintf("block %v at height %d is final", hash,
+			node.Height)
+		return ruleError(ErrFinalityReorg, str)
+	}
+
+	// Disconnect the block and its descendants from the main chain.
+	if b.BestChain.Contains(node) {
+		detachNodes := list.New()
+		for n := b.BestChain.Tip(); n != node.Parent; n = n.Parent {
+			detachNodes.PushBack(n)
+		}
+		err := b.ReorganizeChain(detachNodes, list.New())
+		if writeErr := b.index.FlushToDB(dbStoreBlockNode); writeErr != nil {
+			log.Warnf("Error flushing block index changes to disk: %v",
+				writeErr)
+		}
+		if err != nil {
+			return err
+		}
+		if b.BestChain.Contains(node) {
+			return fmt.Errorf("unable to disconnect block %v", hash)
+		}
+	}
+
+	b.index.SetStatusFlags(node, chainutil.StatusValidateFailed)
+	b.index.UnsetStatusFlags(node, chainutil.StatusValid)
+	b.Orphans.RemoveOrphansOf(&node.Hash)
+	for _, n := range b.descendants(node) {
+		b.index.SetStatusFlags(n, chainutil.StatusInvalidAncestor)
+		b.Orphans.RemoveOrphansOf(&n.Hash)
+	}
+
+	log.Infof("Invalidated block %v (height %d)", hash, node.Height)
+
+	err := b.reorganizeToBestTip()
+	if writeErr := b.index.FlushToDB(dbStoreBlockNode); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
+
+// ReconsiderBlock removes the invalid status of the block with the passed
+// hash, of its ancestors in side chains and of its descendants, so they are
+// validated again, and reorganizes the chain to the best valid chain.  It
+// undoes InvalidateBlock, and also allows blocks which failed validation to be
+// retried.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReconsiderBlock(hash *chainhash.Hash) error {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %v is not known", hash)
+	}
+
+	const invalid = chainutil.StatusValidateFailed |
+		chainutil.StatusInvalidAncestor
+	for n := node; n != nil && !b.BestChain.Contains(n); n = n.Parent {
+		b.index.UnsetStatusFlags(n, invalid)
+	}
+	for _, n := range b.descendants(node) {
+		b.index.UnsetStatusFlags(n, invalid)
+	}
+
+	log.Infof("Reconsidered block %v (height %d)", hash, node.Height)
+
+	err := b.reorganizeToBestTip()
+	if writeErr := b.index.FlushToDB(dbStoreBlockNode); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
+
+// PreciousBlock treats the block with the passed hash as if it was received
+// before the other blocks at the same height.  When it is a valid side chain
+// block at least as high as the end of the main chain, the chain is
+// reorganized so it becomes the end of the main chain.  Since the best chain
+// state is stored, the choice is kept across restarts.  A lower block is left
+// alone since it would not be the best chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PreciousBlock(hash *chainhash.Hash) error {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %v is not known", hash)
+	}
+	if b.BestChain.Contains(node) {
+		return nil
+	}
+	if b.index.NodeStatus(node).KnownInvalid() {
+		return fmt.Errorf("block %v is invalid", hash)
+	}
+	if node.Height < b.BestChain.Tip().Height {
+		return nil
+	}
+
+	detachNodes, attachNodes := b.getReorganizeNodes(node)
+	if attachNodes.Len() == 0 || attachNodes.Back().Value != node {
+		return fmt.Errorf("unable to reorganize the chain to block %v",
+			hash)
+	}
+
+	err := b.ReorganizeChain(detachNodes, attachNodes)
+	if writeErr := b.index.FlushToDB(dbStoreBlockNode); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
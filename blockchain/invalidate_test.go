@@ -0,0 +1,227 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+)
+
+// TestDescendants ensures the descendants of a node are found in the main
+// chain and in all the side chains forking from it.
+func TestDescendants(t *testing.T) {
+	index := chainutil.NewBlockIndex(nil, &chaincfg.SimNetParams)
+	var id byte
+	newNode := func(parent *chainutil.BlockNode) *chainutil.BlockNode {
+		id++
+		node := &chainutil.BlockNode{Parent: parent, Hash: chainhash.Hash{id}}
+		if parent != nil {
+			node.Height = parent.Height + 1
+		}
+		index.AddNodeUL(node)
+		return node
+	}
+
+	// genesis -> a1 -> a2 -> a3 is the main chain, b2 -> b3 -> b4 forks
+	// from a1 and c3 from a2.
+	genesis := newNode(nil)
+	a1 := newNode(genesis)
+	a2 := newNode(a1)
+	a3 := newNode(a2)
+	b2 := newNode(a1)
+	b3 := newNode(b2)
+	b4 := newNode(b3)
+	c3 := newNode(a2)
+
+	b := &BlockChain{
+		index:     index,
+		BestChain: chainutil.NewChainView(a3),
+	}
+
+	tests := []struct {
+		node *chainutil.BlockNode
+		want []*chainutil.BlockNode
+	}{
+		{a1, []*chainutil.BlockNode{a2, a3, b2, b3, b4, c3}},
+		{a2, []*chainutil.BlockNode{a3, c3}},
+		{b3, []*chainutil.BlockNode{b4}},
+		{a3, nil},
+	}
+	for i, test := range tests {
+		got := b.descendants(test.node)
+		found := make(map[*chainutil.BlockNode]int)
+		for _, n := range got {
+			found[n]++
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("descendants #%d: got %d nodes, want %d", i,
+				len(got), len(test.want))
+			continue
+		}
+		for _, n := range test.want {
+			if found[n] != 1 {
+				t.Errorf("descendants #%d: node at height %d "+
+					"found %d times", i, n.Height, found[n])
+			}
+		}
+	}
+}
+
+// TestBestTipCandidates ensures the chain is reorganized to the best valid
+// side chain block, skipping the invalidated blocks and their descendants and
+// the blocks whose data is missing, and to the reconsidered blocks again.
+func TestBestTipCandidates(t *testing.T) {
+	index := chainutil.NewBlockIndex(nil, &chaincfg.SimNetParams)
+	var id byte
+	newNode := func(parent *chainutil.BlockNode, status chainutil.BlockStatus) *chainutil.BlockNode {
+		id++
+		node := &chainutil.BlockNode{Parent: parent, Hash: chainhash.Hash{id},
+			Status: status}
+		if parent != nil {
+			node.Height = parent.Height + 1
+		}
+		index.AddNodeUL(node)
+		return node
+	}
+	stored := chainutil.StatusDataStored
+
+	// genesis -> a1 -> a2 is the main chain, b2 -> b3 -> b4 forks from
+	// a1, c1 -> ... -> c5 from genesis, and d3, whose data is missing,
+	// from a2.
+	genesis := newNode(nil, stored)
+	a1 := newNode(genesis, stored)
+	a2 := newNode(a1, stored)
+	b2 := newNode(a1, stored)
+	b3 := newNode(b2, stored)
+	b4 := newNode(b3, stored)
+	c1 := newNode(genesis, stored)
+	c2 := newNode(c1, stored)
+	c3 := newNode(c2, stored)
+	c4 := newNode(c3, stored)
+	c5 := newNode(c4, stored)
+	newNode(a2, chainutil.StatusNone)
+
+	b := &BlockChain{
+		index:     index,
+		BestChain: chainutil.NewChainView(a2),
+	}
+	check := func(desc string, want ...*chainutil.BlockNode) {
+		t.Helper()
+		got := b.bestTipCandidates()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d candidates, want %d", desc,
+				len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: candidate %d is at height %d, want "+
+					"%d", desc, i, got[i].Height, want[i].Height)
+			}
+		}
+	}
+	check("valid side chains", c5, b4)
+
+	// Invalidating c3 leaves c2, which is not higher than the main chain.
+	index.SetStatusFlags(c3, chainutil.StatusValidateFailed)
+	for _, n := range b.descendants(c3) {
+		index.SetStatusFlags(n, chainutil.StatusInvalidAncestor)
+	}
+	check("c3 invalidated", b4)
+
+	// Invalidating b3 leaves b2, which is not higher either.
+	index.SetStatusFlags(b3, chainutil.StatusValidateFailed)
+	check("b3 invalidated")
+
+	const invalid = chainutil.StatusValidateFailed |
+		chainutil.StatusInvalidAncestor
+	for _, n := range []*chainutil.BlockNode{c3, c4, c5} {
+		index.UnsetStatusFlags(n, invalid)
+	}
+	check("c3 reconsidered", c5)
+}
+
+// TestInvalidateBlockRestart ensures the statuses set by InvalidateBlock and
+// cleared by ReconsiderBlock are kept across restarts.
+func TestInvalidateBlockRestart(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("invalidateblock",
+		&chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	restart := func() *BlockChain {
+		t.Helper()
+		c, err := New(&Config{
+			DB:          chain.db,
+			ChainParams: chain.ChainParams,
+			TimeSource:  chainutil.NewMedianTime(),
+		})
+		if err != nil {
+			t.Fatalf("New: unexpected error %v", err)
+		}
+		return c
+	}
+
+	// Add a side chain s1 -> s2 whose data is not stored, so invalidating
+	// and reconsidering it doesn't reorganize the chain.
+	genesis := chain.BestChain.Tip()
+	var side []*chainutil.BlockNode
+	parent := genesis
+	for i := 0; i < 2; i++ {
+		header := wire.BlockHeader{
+			Version:   1,
+			PrevBlock: parent.Hash,
+			Timestamp: time.Unix(genesis.Data.TimeStamp()+int64(i+1), 0),
+			Nonce:     -1,
+		}
+		node := NewBlockNode(&header, parent)
+		chain.index.AddNode(node)
+		side = append(side, node)
+		parent = node
+	}
+	if err := chain.index.FlushToDB(dbStoreBlockNode); err != nil {
+		t.Fatalf("FlushToDB: unexpected error %v", err)
+	}
+
+	status := func(c *BlockChain, node *chainutil.BlockNode) chainutil.BlockStatus {
+		t.Helper()
+		n := c.index.LookupNode(&node.Hash)
+		if n == nil {
+			t.Fatalf("block at height %d not loaded", node.Height)
+		}
+		return c.index.NodeStatus(n)
+	}
+
+	if err := chain.InvalidateBlock(&side[0].Hash); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error %v", err)
+	}
+	chain = restart()
+	if status(chain, side[0])&chainutil.StatusValidateFailed == 0 {
+		t.Fatalf("InvalidateBlock: block not invalid after restart")
+	}
+	if status(chain, side[1])&chainutil.StatusInvalidAncestor == 0 {
+		t.Fatalf("InvalidateBlock: descendant not invalid after restart")
+	}
+	if chain.BestChain.Tip().Hash != genesis.Hash {
+		t.Fatalf("InvalidateBlock: main chain changed")
+	}
+
+	if err := chain.ReconsiderBlock(&side[1].Hash); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error %v", err)
+	}
+	chain = restart()
+	for _, node := range side {
+		if status(chain, node).KnownInvalid() {
+			t.Fatalf("ReconsiderBlock: block at height %d still "+
+				"invalid after restart", node.Height)
+		}
+	}
+}
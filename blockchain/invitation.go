@@ -0,0 +1,207 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/btcutil"
+)
+
+var (
+	// ErrInvitationExpired is returned by InvitationFilter.Accept for an
+	// invitation which is expired or expires too far in the future.
+	ErrInvitationExpired = errors.New("invitation expired")
+
+	// ErrInvitationReplayed is returned by InvitationFilter.Accept for an
+	// invitation which does not expire later than the last invitation
+	// accepted from the same sender to the same recipient.
+	ErrInvitationReplayed = errors.New("invitation replayed")
+)
+
+// NewInvitationMsg signs inv with priv and encrypts it with ECIES to toKey, the
+// miner key of the recipient whose pubkey hash is to.  inv.Pubkey is set to the
+// public key of priv.  The signature is encrypted along with the invitation,
+// so only the recipient learns who is inviting it.
+func NewInvitationMsg(inv *wire.Invitation, expire uint32, to [20]byte, toKey *btcec.PublicKey, priv *btcec.PrivateKey) (*wire.MsgInvitation, error) {
+	copy(inv.Pubkey[:], priv.PubKey().SerializeCompressed())
+
+	msg := wire.NewMsgInvitation()
+	msg.Expire = expire
+	msg.To = to
+	msg.Version = wire.InvitationECIES
+	msg.Encrypt = true
+
+	sig, err := priv.Sign(msg.SignatureHash(inv))
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	if err := inv.Serialize(&w); err != nil {
+		return nil, err
+	}
+	if err := common.WriteVarBytes(&w, 0, sig.Serialize()); err != nil {
+		return nil, err
+	}
+
+	msg.Msg, err = btcec.Encrypt(toKey, w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	msg.Sig = []byte{}
+
+	return msg, nil
+}
+
+// OpenInvitation decrypts the invitation in msg with priv, the miner key of the
+// recipient, and authenticates its sender with the signature made with the
+// key in Invitation.Pubkey.  Invitations encrypted with RSA are still accepted
+// during migration, they are decrypted with rsaDecrypt.  A nil rsaDecrypt
+// rejects them.
+func OpenInvitation(msg *wire.MsgInvitation, priv *btcec.PrivateKey, rsaDecrypt func([]byte) ([]byte, error)) (*wire.Invitation, error) {
+	var to [20]byte
+	copy(to[:], btcutil.Hash160(priv.PubKey().SerializeCompressed()))
+	if to != msg.To {
+		return nil, fmt.Errorf("invitation is not for us")
+	}
+
+	inv := &wire.Invitation{}
+	var sig []byte
+
+	switch msg.Version {
+	case wire.InvitationECIES:
+		plain, err := btcec.Decrypt(priv, msg.Msg)
+		if err != nil {
+			return nil, err
+		}
+		r := bytes.NewReader(plain)
+		if err := inv.Deserialize(r); err != nil {
+			return nil, err
+		}
+		sig, err = common.ReadVarBytes(r, 0, 80, "Sig")
+		if err != nil {
+			return nil, err
+		}
+
+	case wire.InvitationRSA:
+		if rsaDecrypt == nil {
+			return nil, fmt.Errorf("RSA invitation not accepted")
+		}
+		plain, err := rsaDecrypt(msg.Msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := inv.Deserialize(bytes.NewReader(plain)); err != nil {
+			return nil, err
+		}
+		sig = msg.Sig
+
+	case wire.InvitationPlain:
+		if err := inv.Deserialize(bytes.NewReader(msg.Msg)); err != nil {
+			return nil, err
+		}
+		sig = msg.Sig
+
+	default:
+		return nil, fmt.Errorf("unknown invitation format %d", msg.Version)
+	}
+
+	pubKey, err := btcec.ParsePubKey(inv.Pubkey[:], btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := btcec.ParseDERSignature(sig, btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	if !signature.Verify(msg.SignatureHash(inv), pubKey) {
+		return nil, fmt.Errorf("invalid invitation signature")
+	}
+
+	return inv, nil
+}
+
+// invitationKey identifies the invitations of a sender to a recipient.
+type invitationKey struct {
+	from [33]byte
+	to   [20]byte
+}
+
+// InvitationFilter protects against replayed invitations.  It remembers the
+// expiration of the last invitation accepted from each sender to each
+// recipient and only accepts invitations expiring later, so a captured
+// invitation can't be sent again.
+type InvitationFilter struct {
+	mtx  sync.Mutex
+	last map[invitationKey]uint32
+}
+
+// NewInvitationFilter returns a new empty invitation filter.
+func NewInvitationFilter() *InvitationFilter {
+	return &InvitationFilter{
+		last: make(map[invitationKey]uint32),
+	}
+}
+
+// Accept checks an opened invitation against the miner chain height and the
+// invitations accepted before, and records it when it is accepted.  An
+// invitation is valid from height up to wire.CommitteeSize blocks ahead.
+//
+// This function is safe for concurrent access.
+func (f *InvitationFilter) Accept(msg *wire.MsgInvitation, inv *wire.Invitation, height int32) error {
+	if int64(msg.Expire) < int64(height) ||
+		int64(msg.Expire) > int64(height)+wire.CommitteeSize {
+		return ErrInvitationExpired
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	// Forget the invitations which can't be replayed anymore.
+	for k, expire := range f.last {
+		if int64(expire) < int64(height) {
+			delete(f.last, k)
+		}
+	}
+
+	k := invitationKey{from: inv.Pubkey, to: msg.To}
+	if expire, ok := f.last[k]; ok && msg.Expire <= expire {
+		return ErrInvitationReplayed
+	}
+	f.last[k] = msg.Expire
+
+	return nil
+}
+
+// MinerPubKey returns the miner key of the miner with the passed pubkey hash,
+// as found in the signatures of the last depth blocks of the main chain.  It
+// returns nil when the miner has not signed any of them.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) MinerPubKey(pkh [20]byte, depth int32) *btcec.PublicKey {
+	best := b.BestSnapshot().Height
+	for h := best; h > best-depth && h > 0; h-- {
+		block, err := b.BlockByHeight(h)
+		if err != nil {
+			return nil
+		}
+		for _, key := range wire.BlockSignerKeys(block.MsgBlock().Transactions[0]) {
+			if !bytes.Equal(btcutil.Hash160(key), pkh[:]) {
+				continue
+			}
+			if pubKey, err := btcec.ParsePubKey(key, btcec.S256()); err == nil {
+				return pubKey
+			}
+		}
+	}
+	return nil
+}
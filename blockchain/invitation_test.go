@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+// TestInvitation ensures ECIES invitations open with the key of the recipient
+// only, authenticate their sender and are not accepted twice.
+func TestInvitation(t *testing.T) {
+	sender, _ := btcec.NewPrivateKey(btcec.S256())
+	recipient, _ := btcec.NewPrivateKey(btcec.S256())
+	other, _ := btcec.NewPrivateKey(btcec.S256())
+
+	var to [20]byte
+	copy(to[:], btcutil.Hash160(recipient.PubKey().SerializeCompressed()))
+
+	inv := &wire.Invitation{Height: 5, IP: []byte("10.0.0.1:8383")}
+	msg, err := NewInvitationMsg(inv, 10, to, recipient.PubKey(), sender)
+	if err != nil {
+		t.Fatalf("NewInvitationMsg: unexpected error %v", err)
+	}
+
+	got, err := OpenInvitation(msg, recipient, nil)
+	if err != nil {
+		t.Fatalf("OpenInvitation: unexpected error %v", err)
+	}
+	if got.Height != inv.Height || got.Pubkey != inv.Pubkey ||
+		!bytes.Equal(got.IP, inv.IP) {
+		t.Errorf("OpenInvitation: got %v, want %v", got, inv)
+	}
+	if _, err := OpenInvitation(msg, other, nil); err == nil {
+		t.Errorf("OpenInvitation: opened with the key of another miner")
+	}
+
+	// The signature commits to the expiration.
+	msg.Expire++
+	if _, err := OpenInvitation(msg, recipient, nil); err == nil {
+		t.Errorf("OpenInvitation: accepted invitation with altered " +
+			"expiration")
+	}
+	msg.Expire--
+
+	// RSA invitations are only accepted with a decrypter.
+	rsa := *msg
+	rsa.Version = wire.InvitationRSA
+	if _, err := OpenInvitation(&rsa, recipient, nil); err == nil {
+		t.Errorf("OpenInvitation: accepted RSA invitation without " +
+			"decrypter")
+	}
+
+	filter := NewInvitationFilter()
+	if err := filter.Accept(msg, got, 8); err != nil {
+		t.Errorf("Accept: unexpected error %v", err)
+	}
+	if err := filter.Accept(msg, got, 8); err != ErrInvitationReplayed {
+		t.Errorf("Accept: got %v, want %v", err, ErrInvitationReplayed)
+	}
+	if err := filter.Accept(msg, got, 11); err != ErrInvitationExpired {
+		t.Errorf("Accept: got %v, want %v", err, ErrInvitationExpired)
+	}
+	if err := filter.Accept(msg, got, 10-wire.CommitteeSize-1); err != ErrInvitationExpired {
+		t.Errorf("Accept: got %v, want %v", err, ErrInvitationExpired)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// MuHashSize is the size of the serialized state of a MuHash.
+const MuHashSize = 384
+
+// muHashPrime is the modulus of the MuHash group, the largest 3072 bit safe
+// prime.
+var muHashPrime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), MuHashSize*8)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+// MuHash is a rolling hash of a set of byte strings.  Each element is mapped
+// to a number modulo a 3072 bit prime and the set hashes to the product of
+// its elements, so elements may be added and removed in any order and two
+// sets are equal when their hashes are equal.  Removals are accumulated
+// separately and only inverted when the state is serialized.
+//
+// A MuHash is NOT safe for concurrent access.
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// NewMuHash returns the MuHash of the empty set.
+func NewMuHash() *MuHash {
+	return &MuHash{
+		numerator:   big.NewInt(1),
+		denominator: big.NewInt(1),
+	}
+}
+
+// muHashElement maps the passed data to a number modulo muHashPrime by
+// expanding its sha256 hash to MuHashSize bytes.
+func muHashElement(data []byte) *big.Int {
+	var seed [sha256.Size + 4]byte
+	h := sha256.Sum256(data)
+	copy(seed[:], h[:])
+
+	var expanded [MuHashSize]byte
+	for i := 0; i < MuHashSize/sha256.Size; i++ {
+		binary.LittleEndian.PutUint32(seed[sha256.Size:], uint32(i))
+		h := sha256.Sum256(seed[:])
+		copy(expanded[i*sha256.Size:], h[:])
+	}
+
+	e := new(big.Int).SetBytes(expanded[:])
+	return e.Mod(e, muHashPrime)
+}
+
+// Add adds the passed data to the set.
+func (m *MuHash) Add(data []byte) {
+	m.numerator.Mul(m.numerator, muHashElement(data))
+	m.numerator.Mod(m.numerator, muHashPrime)
+}
+
+// Remove removes the passed data, which must have been added, from the set.
+func (m *MuHash) Remove(data []byte) {
+	m.denominator.Mul(m.denominator, muHashElement(data))
+	m.denominator.Mod(m.denominator, muHashPrime)
+}
+
+// Clone returns a copy of the MuHash.
+func (m *MuHash) Clone() *MuHash {
+	return &MuHash{
+		numerator:   new(big.Int).Set(m.numerator),
+		denominator: new(big.Int).Set(m.denominator),
+	}
+}
+
+// normalize divides the numerator by the denominator.
+func (m *MuHash) normalize() {
+	if m.denominator.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+	inv := new(big.Int).ModInverse(m.denominator, muHashPrime)
+	m.numerator.Mul(m.numerator, inv)
+	m.numerator.Mod(m.numerator, muHashPrime)
+	m.denominator.SetInt64(1)
+}
+
+// Serialize returns the MuHashSize bytes serialized state of the MuHash.
+func (m *MuHash) Serialize() []byte {
+	m.normalize()
+	return m.numerator.FillBytes(make([]byte, MuHashSize))
+}
+
+// Hash returns the hash of the set, which is the sha256 hash of its
+// serialized state.
+func (m *MuHash) Hash() chainhash.Hash {
+	return chainhash.HashH(m.Serialize())
+}
+
+// DeserializeMuHash returns the MuHash with the passed serialized state.
+func DeserializeMuHash(serialized []byte) (*MuHash, error) {
+	if len(serialized) != MuHashSize {
+		return nil, AssertError("invalid serialized MuHash length")
+	}
+	n := new(big.Int).SetBytes(serialized)
+	if n.Sign() == 0 || n.Cmp(muHashPrime) >= 0 {
+		return nil, AssertError("invalid serialized MuHash")
+	}
+	return &MuHash{numerator: n, denominator: big.NewInt(1)}, nil
+}
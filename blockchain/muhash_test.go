@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// TestMuHash ensures the MuHash of a set does not depend on the order its
+// elements are added and removed, and survives serialization.
+func TestMuHash(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	m1 := NewMuHash()
+	m1.Add(a)
+	m1.Add(b)
+
+	m2 := NewMuHash()
+	m2.Add(c)
+	m2.Add(b)
+	m2.Remove(c)
+	m2.Add(a)
+	if m1.Hash() != m2.Hash() {
+		t.Fatalf("MuHash depends on the order of the operations")
+	}
+
+	m3 := m1.Clone()
+	m3.Add(c)
+	if m1.Hash() == m3.Hash() {
+		t.Fatalf("MuHash does not change when an element is added")
+	}
+	m3.Remove(c)
+	if m1.Hash() != m3.Hash() {
+		t.Fatalf("MuHash does not return to the same set")
+	}
+
+	if NewMuHash().Hash() == m1.Hash() {
+		t.Fatalf("MuHash of a set equals the one of the empty set")
+	}
+
+	serialized := m2.Serialize()
+	if len(serialized) != MuHashSize {
+		t.Fatalf("Serialize: got %d bytes, want %d", len(serialized),
+			MuHashSize)
+	}
+	m4, err := DeserializeMuHash(serialized)
+	if err != nil {
+		t.Fatalf("DeserializeMuHash: %v", err)
+	}
+	if !bytes.Equal(m4.Serialize(), serialized) || m4.Hash() != m1.Hash() {
+		t.Fatalf("DeserializeMuHash: state not restored")
+	}
+	if _, err := DeserializeMuHash(serialized[1:]); err == nil {
+		t.Fatalf("DeserializeMuHash: no error for a short state")
+	}
+}
+
+// newCommitTestBlock returns a block at the passed height on the passed parent
+// made of a coinbase paying the passed value and of the passed transactions.
+func newCommitTestBlock(parent *chainhash.Hash, height int32, value int64, txs ...*wire.MsgTx) *btcutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{PrevBlock: *parent})
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+		wire.MaxPrevOutIndex), 0))
+	for i := int64(1); i <= 2; i++ {
+		coinbase.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+			Value: &token.NumToken{Val: value * i}},
+			PkScript: make([]byte, 25)})
+	}
+	msgBlock.AddTransaction(coinbase)
+	for _, tx := range txs {
+		msgBlock.AddTransaction(tx)
+	}
+	block := btcutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	return block
+}
+
+// TestUtxoCommitmentConnectDisconnect ensures the utxo set commitment updated
+// as blocks are connected and disconnected equals the one recomputed from
+// scratch from the utxo set in the database.
+func TestUtxoCommitmentConnectDisconnect(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxocommitment",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	chain.ChainLock.Lock()
+	defer chain.ChainLock.Unlock()
+
+	dbCommitment := func() chainhash.Hash {
+		if err := chain.flushUtxoCache(); err != nil {
+			t.Fatalf("flushUtxoCache: %v", err)
+		}
+		var m *MuHash
+		err := chain.db.View(func(dbTx database.Tx) error {
+			var err error
+			m, err = dbComputeUtxoCommitment(dbTx)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("dbComputeUtxoCommitment: %v", err)
+		}
+		return m.Hash()
+	}
+
+	// The two ways of applying a block, as connectBlock and disconnectBlock
+	// do.
+	connect := func(m *MuHash, block *btcutil.Block) (*MuHash, []viewpoint.SpentTxOut) {
+		views := chain.NewViewPointSet()
+		if err := chain.fetchInputUtxos(views, block); err != nil {
+			t.Fatalf("fetchInputUtxos: %v", err)
+		}
+		stxos := make([]viewpoint.SpentTxOut, 0, block.CountSpentOutputs())
+		if err := views.ConnectTransactions(block, &stxos); err != nil {
+			t.Fatalf("ConnectTransactions: %v", err)
+		}
+		chain.utxoCache.commit(views.Utxo, block.Height(), true)
+		m, err := connectUtxoCommitment(m, block, block.Height(), stxos)
+		if err != nil {
+			t.Fatalf("connectUtxoCommitment: %v", err)
+		}
+		return m, stxos
+	}
+	disconnect := func(m *MuHash, block *btcutil.Block, stxos []viewpoint.SpentTxOut) *MuHash {
+		views := chain.NewViewPointSet()
+		if err := chain.fetchInputUtxos(views, block); err != nil {
+			t.Fatalf("fetchInputUtxos: %v", err)
+		}
+		err := views.DisconnectTransactions(chain.db, block, stxos)
+		if err != nil {
+			t.Fatalf("DisconnectTransactions: %v", err)
+		}
+		chain.utxoCache.commit(views.Utxo, block.Height(), false)
+		m, err = disconnectUtxoCommitment(m, block, block.Height(), stxos)
+		if err != nil {
+			t.Fatalf("disconnectUtxoCommitment: %v", err)
+		}
+		return m
+	}
+
+	genesis := chain.utxoCommitment
+	if genesis.Hash() != dbCommitment() {
+		t.Fatalf("utxo commitment of the genesis block does not match " +
+			"the utxo set")
+	}
+
+	// A block creating outputs, then one spending one of them.
+	block1 := newCommitTestBlock(&chain.BestSnapshot().Hash, 1, 1000)
+	spend := wire.NewMsgTx(wire.TxVersion)
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(
+		block1.Transactions()[0].Hash(), 0), 0))
+	spend.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 900}}, PkScript: make([]byte, 25)})
+	block2 := newCommitTestBlock(block1.Hash(), 2, 3000, spend)
+
+	m1, stxos1 := connect(genesis, block1)
+	if m1.Hash() != dbCommitment() {
+		t.Fatalf("connectUtxoCommitment: commitment after block 1 does " +
+			"not match the utxo set")
+	}
+	m2, stxos2 := connect(m1, block2)
+	if len(stxos2) != 1 {
+		t.Fatalf("ConnectTransactions: got %d spent outputs, want 1",
+			len(stxos2))
+	}
+	if m2.Hash() != dbCommitment() {
+		t.Fatalf("connectUtxoCommitment: commitment after block 2 does " +
+			"not match the utxo set")
+	}
+
+	m := disconnect(m2, block2, stxos2)
+	if m.Hash() != dbCommitment() || m.Hash() != m1.Hash() {
+		t.Fatalf("disconnectUtxoCommitment: commitment after block 2 " +
+			"is disconnected does not match the utxo set")
+	}
+	m = disconnect(m, block1, stxos1)
+	if m.Hash() != dbCommitment() || m.Hash() != genesis.Hash() {
+		t.Fatalf("disconnectUtxoCommitment: commitment after block 1 " +
+			"is disconnected does not match the utxo set")
+	}
+}
@@ -6,7 +6,13 @@
 package blockchain
 
 import (
+	"container/list"
 	"fmt"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
 )
 
 // NotificationType represents the type of a notification message.
@@ -33,15 +39,60 @@ const (
 
 	// NTBlockRejected indicates the associated block was rejected.
 	NTBlockRejected
+
+	// NTReorgRefused indicates a reorganization to a side chain was
+	// refused because it would disconnect a final block.
+	NTReorgRefused
+
+	// NTReorganizeStarted indicates the main chain is about to be
+	// reorganized to a side chain.  It is sent once the side chain passed
+	// the checks, before the first block is disconnected.
+	NTReorganizeStarted
+
+	// NTReorganizeDone indicates the main chain has been reorganized to a
+	// side chain.
+	NTReorganizeDone
+
+	// NTMinerBlockConnected indicates the associated block was connected
+	// to the main miner chain.
+	NTMinerBlockConnected
+
+	// NTMinerBlockDisconnected indicates the associated block was
+	// disconnected from the main miner chain.
+	NTMinerBlockDisconnected
+
+	// NTCommitteeRotated indicates the committee rotated as a block was
+	// connected to or disconnected from the main chain.
+	NTCommitteeRotated
+
+	// NTCollateralLocked indicates collaterals of miners became locked.
+	NTCollateralLocked
+
+	// NTCollateralUnlocked indicates collaterals of miners became
+	// unlocked.
+	NTCollateralUnlocked
+
+	// NTCompensation indicates a block with compensation transactions for
+	// the victims of violators was connected to the main chain.
+	NTCompensation
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
 // names for pretty printing.
 var notificationTypeStrings = map[NotificationType]string{
-	NTBlockAccepted:     "NTBlockAccepted",
-	NTBlockConnected:    "NTBlockConnected",
-	NTBlockDisconnected: "NTBlockDisconnected",
-	NTBlockRejected: "NTBlockRejected",
+	NTBlockAccepted:          "NTBlockAccepted",
+	NTBlockConnected:         "NTBlockConnected",
+	NTBlockDisconnected:      "NTBlockDisconnected",
+	NTBlockRejected:          "NTBlockRejected",
+	NTReorgRefused:           "NTReorgRefused",
+	NTReorganizeStarted:      "NTReorganizeStarted",
+	NTReorganizeDone:         "NTReorganizeDone",
+	NTMinerBlockConnected:    "NTMinerBlockConnected",
+	NTMinerBlockDisconnected: "NTMinerBlockDisconnected",
+	NTCommitteeRotated:       "NTCommitteeRotated",
+	NTCollateralLocked:       "NTCollateralLocked",
+	NTCollateralUnlocked:     "NTCollateralUnlocked",
+	NTCompensation:           "NTCompensation",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -55,14 +106,160 @@ func (n NotificationType) String() string {
 // Notification defines notification that is sent to the caller via the callback
 // function provided during the call to New and consists of a notification type
 // as well as associated data that depends on the type as follows:
-// 	- NTBlockAccepted:     *btcutil.Block
-// 	- NTBlockConnected:    *btcutil.Block
-// 	- NTBlockDisconnected: *btcutil.Block
+//   - NTBlockAccepted:          *btcutil.Block
+//   - NTBlockConnected:         *btcutil.Block
+//   - NTBlockDisconnected:      *btcutil.Block
+//   - NTReorgRefused:           *ReorgRefused
+//   - NTReorganizeStarted:      *Reorganization
+//   - NTReorganizeDone:         *Reorganization
+//   - NTMinerBlockConnected:    *wire.MinerBlock
+//   - NTMinerBlockDisconnected: *wire.MinerBlock
+//   - NTCommitteeRotated:       *CommitteeRotation
+//   - NTCollateralLocked:       *Collaterals
+//   - NTCollateralUnlocked:     *Collaterals
+//   - NTCompensation:           *Compensation
 type Notification struct {
 	Type NotificationType
 	Data interface{}
 }
 
+// Reorganization is the data of the NTReorganizeStarted and NTReorganizeDone
+// notifications.  Detach are the hashes of the blocks disconnected from the
+// main chain, starting with the old tip, and Attach those connected, ending
+// with the new tip.  For NTReorganizeStarted, NewTip is the tip of the side
+// chain, and for NTReorganizeDone the tip actually reached.
+type Reorganization struct {
+	OldTip    chainhash.Hash
+	OldHeight int32
+	NewTip    chainhash.Hash
+	NewHeight int32
+	Detach    []chainhash.Hash
+	Attach    []chainhash.Hash
+}
+
+// newReorganization returns the Reorganization from the old tip to the new
+// tip over the passed nodes to detach and attach.
+func newReorganization(oldTip, newTip *chainutil.BlockNode, detachNodes, attachNodes *list.List) *Reorganization {
+	r := &Reorganization{
+		OldTip:    oldTip.Hash,
+		OldHeight: oldTip.Height,
+		NewTip:    newTip.Hash,
+		NewHeight: newTip.Height,
+		Detach:    make([]chainhash.Hash, 0, detachNodes.Len()),
+		Attach:    make([]chainhash.Hash, 0, attachNodes.Len()),
+	}
+	for e := detachNodes.Front(); e != nil; e = e.Next() {
+		r.Detach = append(r.Detach, e.Value.(*chainutil.BlockNode).Hash)
+	}
+	for e := attachNodes.Front(); e != nil; e = e.Next() {
+		r.Attach = append(r.Attach, e.Value.(*chainutil.BlockNode).Hash)
+	}
+	return r
+}
+
+// CommitteeRotation is the data of a NTCommitteeRotated notification.  Block
+// is the block connected or disconnected, and Rotation the last rotation of
+// the committee after it, Previous the one before it.
+type CommitteeRotation struct {
+	Block        chainhash.Hash
+	Height       int32
+	Connected    bool
+	Previous     uint32
+	LastRotation uint32
+}
+
+// Collaterals is the data of the NTCollateralLocked and NTCollateralUnlocked
+// notifications.  OutPoints are the collaterals locked or unlocked as the
+// block was connected to or disconnected from the main chain.
+type Collaterals struct {
+	Block     chainhash.Hash
+	Height    int32
+	OutPoints []wire.OutPoint
+}
+
+// Compensation is the data of a NTCompensation notification.  Txs are the
+// forfeiture transactions, as generated by CompTxs, of the block connected to
+// the main chain.
+type Compensation struct {
+	Block  chainhash.Hash
+	Height int32
+	Txs    []*wire.MsgTx
+}
+
+// sendBlockEvents sends the notifications about the committee, collaterals
+// and compensations following the connection or disconnection of the passed
+// block.  prevRotation and rotation are the last rotations of the committee
+// before and after it, and locked and unlocked the collaterals it changed.
+//
+// This function MUST NOT be called with the chain state lock held.
+func (b *BlockChain) sendBlockEvents(node *chainutil.BlockNode, block *btcutil.Block, connected bool, prevRotation, rotation uint32, locked, unlocked []wire.OutPoint) {
+	if prevRotation != rotation {
+		b.SendNotification(NTCommitteeRotated, &CommitteeRotation{
+			Block:        node.Hash,
+			Height:       node.Height,
+			Connected:    connected,
+			Previous:     prevRotation,
+			LastRotation: rotation,
+		})
+	}
+	if len(unlocked) > 0 {
+		b.SendNotification(NTCollateralUnlocked, &Collaterals{
+			Block:     node.Hash,
+			Height:    node.Height,
+			OutPoints: unlocked,
+		})
+	}
+	if len(locked) > 0 {
+		b.SendNotification(NTCollateralLocked, &Collaterals{
+			Block:     node.Hash,
+			Height:    node.Height,
+			OutPoints: locked,
+		})
+	}
+
+	if !connected {
+		return
+	}
+	var txs []*wire.MsgTx
+	for _, tx := range block.MsgBlock().Transactions[1:] {
+		if !tx.IsForfeit() {
+			break
+		}
+		txs = append(txs, tx)
+	}
+	if len(txs) > 0 {
+		b.SendNotification(NTCompensation, &Compensation{
+			Block:  node.Hash,
+			Height: node.Height,
+			Txs:    txs,
+		})
+	}
+}
+
+// MinerNotice is the notification callback subscribed to the miner chain by
+// SetMinerChain.  It sends the connection and disconnection of miner blocks
+// as the NTMinerBlockConnected and NTMinerBlockDisconnected notifications of
+// the chain.
+func (b *BlockChain) MinerNotice(notification *Notification) {
+	block, ok := notification.Data.(*wire.MinerBlock)
+	if !ok {
+		return
+	}
+	switch notification.Type {
+	case NTBlockConnected:
+		b.SendNotification(NTMinerBlockConnected, block)
+	case NTBlockDisconnected:
+		b.SendNotification(NTMinerBlockDisconnected, block)
+	}
+}
+
+// SetMinerChain sets the miner chain providing the miners of the chain, and
+// subscribes MinerNotice to it.  It must be called before the chain is used.
+func (b *BlockChain) SetMinerChain(miners MinerChain) {
+	b.Miners = miners
+	miners.Subscribe(b.MinerNotice)
+}
+
 // Subscribe to block chain notifications. Registers a callback to be executed
 // when various events take place. See the documentation on Notification and
 // NotificationType for details on the types and contents of notifications.
@@ -103,7 +300,7 @@ func (b *BlockChain) CancelNotification(index int) {
 	b.notificationsLock.RLock()
 	defer b.notificationsLock.RUnlock()
 
-	if index == len(b.notifications) - 1 {
+	if index == len(b.notifications)-1 {
 		for {
 			b.notifications = b.notifications[:index]
 			index--
@@ -111,7 +308,7 @@ func (b *BlockChain) CancelNotification(index int) {
 				return
 			}
 		}
-	} else if index < len(b.notifications) - 1 {
+	} else if index < len(b.notifications)-1 {
 		b.notifications[index] = nil
 	}
 }
@@ -5,9 +5,12 @@
 package blockchain
 
 import (
+	"container/list"
 	"testing"
 
+	"github.com/zeusyf/btcd/blockchain/chainutil"
 	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
 )
 
 // TestNotifications ensures that notification callbacks are fired on events.
@@ -49,3 +52,50 @@ func TestNotifications(t *testing.T) {
 			"times, found %d", numSubscribers, notificationCount)
 	}
 }
+
+// TestNewReorganization ensures the data of the reorganization notifications
+// lists the detached and attached blocks in order.
+func TestNewReorganization(t *testing.T) {
+	fork := &chainutil.BlockNode{Hash: chainhash.Hash{1}, Height: 1}
+	detach, attach := list.New(), list.New()
+	parent := fork
+	for i := byte(0); i < 2; i++ {
+		n := &chainutil.BlockNode{Parent: parent, Hash: chainhash.Hash{2, i},
+			Height: parent.Height + 1}
+		detach.PushFront(n)
+		parent = n
+	}
+	oldTip := parent
+	parent = fork
+	for i := byte(0); i < 3; i++ {
+		n := &chainutil.BlockNode{Parent: parent, Hash: chainhash.Hash{3, i},
+			Height: parent.Height + 1}
+		attach.PushBack(n)
+		parent = n
+	}
+
+	r := newReorganization(oldTip, parent, detach, attach)
+	if r.OldTip != oldTip.Hash || r.OldHeight != 3 ||
+		r.NewTip != parent.Hash || r.NewHeight != 4 {
+		t.Fatalf("newReorganization: wrong tips %+v", r)
+	}
+	wantDetach := []chainhash.Hash{{2, 1}, {2, 0}}
+	wantAttach := []chainhash.Hash{{3, 0}, {3, 1}, {3, 2}}
+	if len(r.Detach) != len(wantDetach) || len(r.Attach) != len(wantAttach) {
+		t.Fatalf("newReorganization: got %d detached and %d attached, "+
+			"want %d and %d", len(r.Detach), len(r.Attach),
+			len(wantDetach), len(wantAttach))
+	}
+	for i := range wantDetach {
+		if r.Detach[i] != wantDetach[i] {
+			t.Fatalf("newReorganization: detached #%d is %v, want %v",
+				i, r.Detach[i], wantDetach[i])
+		}
+	}
+	for i := range wantAttach {
+		if r.Attach[i] != wantAttach[i] {
+			t.Fatalf("newReorganization: attached #%d is %v, want %v",
+				i, r.Attach[i], wantAttach[i])
+		}
+	}
+}
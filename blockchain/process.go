@@ -221,9 +221,9 @@ func (b *orphanBlock) Removable(ob chainutil.Orphaned) bool {
 func (b *orphanBlock) NeedUpdate(ob chainutil.Orphaned) bool {
 	block := b.MsgBlock().(*wire.MsgBlock)
 	if block.Header.Nonce < 0 {
-		nl := len(block.Transactions[0].SignatureScripts)
+		nl := wire.NumBlockSigners(block.Transactions[0])
 		oblock := ob.MsgBlock().(*wire.MsgBlock)
-		ol := len(oblock.Transactions[0].SignatureScripts)
+		ol := wire.NumBlockSigners(oblock.Transactions[0])
 		if nl > ol {
 			return true
 		} else if nl == ol {
@@ -446,12 +446,12 @@ func (b *BlockChain) ProcessBlock(block *btcutil.Block, flags BehaviorFlags) (bo
 			// examine signatures. must not have double signs
 			signers := make(map[[20]byte]struct{})
 			var name [20]byte
-			for _, sig := range block.MsgBlock().Transactions[0].SignatureScripts[1:] {
-				copy(name[:], btcutil.Hash160(sig[:btcec.PubKeyBytesLenCompressed]))
+			for _, sig := range wire.BlockSignerKeys(block.MsgBlock().Transactions[0]) {
+				copy(name[:], btcutil.Hash160(sig))
 				signers[name] = struct{}{}
 			}
-			for _, sig := range mblk.MsgBlock().Transactions[0].SignatureScripts[1:] {
-				copy(name[:], btcutil.Hash160(sig[:btcec.PubKeyBytesLenCompressed]))
+			for _, sig := range wire.BlockSignerKeys(mblk.MsgBlock().Transactions[0]) {
+				copy(name[:], btcutil.Hash160(sig))
 				rt := rotate
 				if _, ok := signers[name]; ok {
 					// double signer
@@ -543,8 +543,8 @@ func (b *BlockChain) consistent(block *btcutil.Block, parent *chainutil.BlockNod
 		miners[blk.MsgBlock().Miner] = struct{}{}
 	}
 
-	for _, sign := range block.MsgBlock().Transactions[0].SignatureScripts[1:] {
-		k, _ := btcec.ParsePubKey(sign[:btcec.PubKeyBytesLenCompressed], btcec.S256())
+	for _, sign := range wire.BlockSignerKeys(block.MsgBlock().Transactions[0]) {
+		k, _ := btcec.ParsePubKey(sign, btcec.S256())
 		pk, _ := btcutil.NewAddressPubKeyPubKey(*k, b.ChainParams)
 		pk.SetFormat(btcutil.PKFCompressed)
 		ppk := pk.AddressPubKeyHash()
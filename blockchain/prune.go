@@ -0,0 +1,208 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+)
+
+const (
+	// pruneReorgDepth is the number of blocks below the best block which are
+	// always kept, along with their spend journal entries, so the chain can
+	// reorganize.
+	pruneReorgDepth = 288
+
+	// pruneInterval is the number of blocks connected between two automatic
+	// prunings.
+	pruneInterval = 100
+)
+
+// MinPruneDepth returns the minimum number of blocks below the best block
+// which must be kept when pruning.  Besides the blocks needed to reorganize
+// the chain, the blocks which may still be looked up to forfeit the
+// collateral of a miner reported for a violation are kept.
+func MinPruneDepth(params *chaincfg.Params) int32 {
+	return params.ViolationReportDeadline*wire.MINER_RORATE_FREQ + pruneReorgDepth
+}
+
+// dbFetchPrunedHeader reads the header of a pruned block from the block index.
+// pruneErr is returned when the block is not in the index.
+func dbFetchPrunedHeader(dbTx database.Tx, hash *chainhash.Hash, pruneErr error) (*wire.BlockHeader, error) {
+	height, err := DbFetchHeightByHash(dbTx, hash)
+	if err != nil {
+		return nil, pruneErr
+	}
+
+	key := BlockIndexKey(hash, uint32(height))
+	row := dbTx.Metadata().Bucket(blockIndexBucketName).Get(key)
+	if row == nil {
+		return nil, pruneErr
+	}
+
+	header, _, err := deserializeBlockRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// dbPrunedBlockErr returns a database.Error with an error code of
+// database.ErrBlockPruned in place of the passed error fetching the data of
+// the block of the passed node when the block is marked pruned in the block
+// index.  The blocks before the ones of an imported chain state snapshot are
+// marked pruned without ever having been stored, so the database does not
+// know about them.
+func dbPrunedBlockErr(dbTx database.Tx, node *chainutil.BlockNode, err error) error {
+	dbErr, ok := err.(database.Error)
+	if !ok || dbErr.ErrorCode != database.ErrBlockNotFound {
+		return err
+	}
+	key := BlockIndexKey(&node.Hash, uint32(node.Height))
+	row := dbTx.Metadata().Bucket(blockIndexBucketName).Get(key)
+	if row == nil || !chainutil.BlockStatus(row[len(row)-1]).Pruned() {
+		return err
+	}
+	return database.Error{
+		ErrorCode:   database.ErrBlockPruned,
+		Description: fmt.Sprintf("block %s has been pruned", node.Hash),
+	}
+}
+
+// storedBlockHeight returns the height of the passed stored block, whether or
+// not it is in the main chain.  The block index holds every block stored,
+// though the main chain is looked up as well in case the index does not know
+// about the block.  False is returned when the height of the block is unknown.
+func (b *BlockChain) storedBlockHeight(dbTx database.Tx, hash *chainhash.Hash) (int32, bool) {
+	if node := b.index.LookupNode(hash); node != nil {
+		return node.Height, true
+	}
+	height, err := DbFetchHeightByHash(dbTx, hash)
+	return height, err == nil
+}
+
+// pruneBlocks deletes the block files holding only blocks below the passed
+// height, along with the spend journal entries of those blocks.  The height is
+// lowered as needed to keep MinPruneDepth blocks.  The pruned blocks are
+// flagged in the block index and the number of them is returned.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pruneBlocks(height int32) (int, error) {
+	tip := b.BestChain.Tip()
+	if limit := tip.Height - MinPruneDepth(b.ChainParams); height > limit {
+		height = limit
+	}
+	if height <= 0 {
+		return 0, nil
+	}
+
+	// The blocks after the last flush of the utxo cache are replayed
+	// after a crash, so flush the cache before pruning them.
+	flush := b.utxoCache.flushedHeight < height
+
+	var pruned []chainhash.Hash
+	err := b.db.Update(func(dbTx database.Tx) error {
+		if flush {
+			err := b.utxoCache.flush(dbTx, &tip.Hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		// Side chain blocks are pruned by height too.  A stored block
+		// of unknown height can't be part of any chain, so it is
+		// pruned as well.
+		pruned, err = dbTx.PruneBlocks(func(hash *chainhash.Hash) bool {
+			h, ok := b.storedBlockHeight(dbTx, hash)
+			return !ok || h < height
+		})
+		if err != nil {
+			return err
+		}
+
+		blockIndex := dbTx.Metadata().Bucket(blockIndexBucketName)
+		for i := range pruned {
+			hash := &pruned[i]
+			if err := dbRemoveSpendJournalEntry(dbTx, hash); err != nil {
+				return err
+			}
+
+			// Flag the block in its index row.  The row of a block
+			// not loaded in memory would otherwise never be updated.
+			h, ok := b.storedBlockHeight(dbTx, hash)
+			if !ok {
+				continue
+			}
+			key := BlockIndexKey(hash, uint32(h))
+			row := blockIndex.Get(key)
+			if row == nil {
+				continue
+			}
+			status := chainutil.BlockStatus(row[len(row)-1])
+			status = status&^chainutil.StatusDataStored | chainutil.StatusPruned
+			updated := make([]byte, len(row))
+			copy(updated, row)
+			updated[len(updated)-1] = byte(status)
+			if err := blockIndex.Put(key, updated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if flush {
+		b.utxoCache.markFlushed(tip.Height)
+	}
+
+	for i := range pruned {
+		node := b.index.LookupNode(&pruned[i])
+		if node == nil {
+			continue
+		}
+		b.index.UnsetStatusFlags(node, chainutil.StatusDataStored)
+		b.index.SetStatusFlags(node, chainutil.StatusPruned)
+	}
+
+	if len(pruned) > 0 {
+		log.Infof("Pruned %d blocks below height %d", len(pruned), height)
+	}
+	return len(pruned), nil
+}
+
+// maybePruneBlocks prunes the blocks deeper than the configured prune depth
+// every pruneInterval blocks.  Failures are logged since they do not affect
+// the chain state.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) maybePruneBlocks(height int32) {
+	if b.pruneDepth == 0 || height%pruneInterval != 0 {
+		return
+	}
+	if _, err := b.pruneBlocks(height - b.pruneDepth); err != nil {
+		log.Warnf("Unable to prune blocks: %v", err)
+	}
+}
+
+// PruneBlocks deletes the stored blocks below the passed height, keeping at
+// least MinPruneDepth blocks below the best block.  Blocks are deleted by
+// whole block files, so some blocks below the height may be kept.  Pruned
+// blocks can no longer be fetched, though their headers remain available.
+// It returns the number of blocks deleted.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PruneBlocks(height int32) (int, error) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	return b.pruneBlocks(height)
+}
@@ -4,39 +4,35 @@
 // license that can be found in the LICENSE file.
 
 package blockchain
-/*
-import (
-	"fmt"
-	"runtime"
 
-	"github.com/zeusyf/btcd/wire"
+import (
+	"github.com/zeusyf/btcd/chaincfg"
 	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/ovm"
 	"github.com/zeusyf/omega/viewpoint"
-	"github.com/zeusyf/omega/Vm"
 )
 
-// txValidateItem holds a transaction along with which input to validate.
+// txValidateItem holds a transaction whose signatures are to be validated.
+// The OVM verifies all the signatures of a transaction at once, so unlike
+// scripts they are not validated per input.
 type txValidateItem struct {
-	txInIndex int
-	txIn      *wire.TxIn
-	tx        *btcutil.Tx
-//	sigHashes *TxSigHashes
+	tx *btcutil.Tx
 }
 
-// txValidator provides a type which asynchronously validates transaction
-// inputs.  It provides several channels for communication and a processing
-// function that is intended to be in run multiple goroutines.
+// txValidator provides a type which asynchronously validates the signatures
+// of transactions.  It provides several channels for communication and a
+// processing function that is intended to be in run multiple goroutines.
 type txValidator struct {
 	validateChan chan *txValidateItem
 	quitChan     chan struct{}
 	resultChan   chan error
-	view 	     *viewpoint.ViewPointSet
-//	flags        ScriptFlags
-//	sigCache     *SigCache
-//	hashCache    *HashCache
+
+	// verify validates the signatures of a transaction.  It is called
+	// concurrently, so it must not modify any shared state.
+	verify func(tx *btcutil.Tx) error
 }
-/*
-// sendResult sends the result of a script pair validation on the internal
+
+// sendResult sends the result of a signature validation on the internal
 // result channel while respecting the quit channel.  This allows orderly
 // shutdown when the validation process is aborted early due to a validation
 // error in one of the other goroutines.
@@ -46,7 +42,7 @@ func (v *txValidator) sendResult(result error) {
 	case <-v.quitChan:
 	}
 }
-/*
+
 // validateHandler consumes items to validate from the internal validate channel
 // and returns the result of the validation on the internal result channel. It
 // must be run as a goroutine.
@@ -55,76 +51,24 @@ out:
 	for {
 		select {
 		case txVI := <-v.validateChan:
-			// Ensure the referenced input utxo is available.
-			txIn := txVI.txIn
-			utxo := v.view.Utxo.LookupEntry(txIn.PreviousOutPoint)
-			if utxo == nil {
-				str := fmt.Sprintf("unable to find unspent "+
-					"output %v referenced from "+
-					"transaction %s:%d",
-					txIn.PreviousOutPoint, txVI.tx.Hash(),
-					txVI.txInIndex)
-				err := ruleError(ErrMissingTxOut, str)
-				v.sendResult(err)
-				break out
-			}
-			// Create a new script engine for the script pair.
-			sigScript := txVI.tx.MsgTx().SignatureScripts[txIn.SignatureIndex]
-//			witness := txIn.Witness
-			pkScript := utxo.PkScript()
-			vm := Vm.NewOVM(Vm.Context{}, Config{}, Vm.Config{}, v.view.Db)
-/*
-			inputAmount := utxo.Amount
-			vm, err := NewEngine(pkScript, txVI.tx.MsgTx(),
-				txVI.txInIndex, v.flags, v.sigCache, txVI.sigHashes,
-				inputAmount)
-			if err != nil {
-				str := fmt.Sprintf("failed to parse input "+
-					"%s:%d which references output %v - "+
-					"%v (input witness %x, input script "+
-					"bytes %x, prev output script bytes %x)",
-					txVI.tx.Hash(), txVI.txInIndex,
-					txIn.PreviousOutPoint, err, witness,
-					sigScript, pkScript)
-				err := ruleError(ErrScriptMalformed, str)
-				v.sendResult(err)
-				break out
-			}
+			v.sendResult(v.verify(txVI.tx))
 
-			// Execute the script pair.
-			if err := vm.Execute(); err != nil {
-				str := fmt.Sprintf("failed to validate input "+
-					"%s:%d which references output %v - "+
-					"%v (input witness %x, input script "+
-					"bytes %x, prev output script bytes %x)",
-					txVI.tx.Hash(), txVI.txInIndex,
-					txIn.PreviousOutPoint, err, witness,
-					sigScript, pkScript)
-				err := ruleError(ErrScriptValidation, str)
-				v.sendResult(err)
-				break out
-			}
-
-			// Validation succeeded.
-			v.sendResult(nil)
-* /
 		case <-v.quitChan:
 			break out
 		}
 	}
 }
-/*
-// Validate validates the scripts for all of the passed transaction inputs using
-// multiple goroutines.
-func (v *txValidator) Validate(items []*txValidateItem) error {
+
+// Validate validates the signatures of all of the passed transactions using
+// up to the passed number of goroutines.  It returns the first error found.
+func (v *txValidator) Validate(items []*txValidateItem, maxGoRoutines int) error {
 	if len(items) == 0 {
 		return nil
 	}
 
-	// Limit the number of goroutines to do script validation based on the
-	// number of processor cores.  This helps ensure the system stays
-	// reasonably responsive under heavy load.
-	maxGoRoutines := runtime.NumCPU() * 3
+	// Limit the number of goroutines to do signature validation.  This
+	// helps ensure the system stays reasonably responsive under heavy
+	// load.
 	if maxGoRoutines <= 0 {
 		maxGoRoutines = 1
 	}
@@ -133,24 +77,24 @@ func (v *txValidator) Validate(items []*txValidateItem) error {
 	}
 
 	// Start up validation handlers that are used to asynchronously
-	// validate each transaction input.
+	// validate each transaction.
 	for i := 0; i < maxGoRoutines; i++ {
 		go v.validateHandler()
 	}
 
-	// Validate each of the inputs.  The quit channel is closed when any
-	// errors occur so all processing goroutines exit regardless of which
-	// input had the validation error.
-	numInputs := len(items)
+	// Validate each of the transactions.  The quit channel is closed when
+	// any errors occur so all processing goroutines exit regardless of
+	// which transaction had the validation error.
+	numItems := len(items)
 	currentItem := 0
 	processedItems := 0
-	for processedItems < numInputs {
+	for processedItems < numItems {
 		// Only send items while there are still items that need to
 		// be processed.  The select statement will never select a nil
 		// channel.
 		var validateChan chan *txValidateItem
 		var item *txValidateItem
-		if currentItem < numInputs {
+		if currentItem < numItems {
 			validateChan = v.validateChan
 			item = items[currentItem]
 		}
@@ -171,22 +115,23 @@ func (v *txValidator) Validate(items []*txValidateItem) error {
 	close(v.quitChan)
 	return nil
 }
-/*
+
 // newTxValidator returns a new instance of txValidator to be used for
-// validating transaction scripts asynchronously.
-func newTxValidator(utxoView *viewpoint.ViewPointSet) *txValidator {
-	//, flags ScriptFlags,	sigCache *SigCache, hashCache *HashCache) *txValidator {
+// validating the signatures of transactions spending the outputs in the
+// passed view asynchronously.  The view is only read, so it must hold all the
+// utxos and rights the signatures are validated against.
+func newTxValidator(view *viewpoint.ViewPointSet, chainParams *chaincfg.Params) *txValidator {
 	return &txValidator{
 		validateChan: make(chan *txValidateItem),
 		quitChan:     make(chan struct{}),
 		resultChan:   make(chan error),
-		view:     utxoView,
-//		sigCache:     sigCache,
-//		hashCache:    hashCache,
-//		flags:        flags,
+		verify: func(tx *btcutil.Tx) error {
+			return ovm.VerifySigs(tx, chainParams, 0, view)
+		},
 	}
 }
 
+/*
 // checkBlockScripts executes and validates the scripts for all transactions in
 // the passed block using multiple goroutines.
 func checkBlockScripts(block *btcutil.Block, utxoView *viewpoint.UtxoViewpoint) error {
@@ -0,0 +1,117 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"runtime"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// sigVerifyWorkers returns the number of goroutines verifying the signatures
+// of the transactions of a block, which is set by the SigVeriConcurrency chain
+// parameter and defaults to the number of processor cores.
+func (b *BlockChain) sigVerifyWorkers() int {
+	if b.ChainParams.SigVeriConcurrency > 0 {
+		return b.ChainParams.SigVeriConcurrency
+	}
+	return runtime.NumCPU()
+}
+
+// prefetchBlockInputs loads all of the utxos referenced by the inputs of the
+// transactions in the block at the passed height into the view in one pass,
+// along with the rights of those utxos and the rights referenced by the
+// definitions and outputs of the transactions, except for those defined in
+// the block itself.  It returns, for each transaction, whether all of its
+// inputs are outputs of earlier blocks, so its signatures can be verified
+// before the contracts of the block are executed.  A transaction referencing
+// a right which could not be loaded is not independent either, since looking
+// it up again would fill the view while the signatures are being verified
+// concurrently.
+func (b *BlockChain) prefetchBlockInputs(height int32, block *btcutil.Block, views *viewpoint.ViewPointSet) ([]bool, error) {
+	if err := b.fetchInputUtxos(views, block); err != nil {
+		return nil, err
+	}
+
+	// The rights defined in the block are added to the view as the
+	// transactions defining them are connected.
+	transactions := block.Transactions()
+	defined := make(map[chainhash.Hash]struct{})
+	for _, tx := range transactions[1:] {
+		for _, def := range tx.MsgTx().TxDef {
+			if !def.IsSeparator() {
+				defined[def.Hash()] = struct{}{}
+			}
+		}
+	}
+	fetchRight := func(hash *chainhash.Hash) bool {
+		if _, ok := defined[*hash]; ok || hash.IsEqual(&zerohash) {
+			return true
+		}
+		return views.Rights.GetRight(views.Db, *hash) != nil
+	}
+
+	independent := make([]bool, len(transactions))
+	for i, tx := range transactions[1:] {
+		independent[i+1] = true
+		for _, txIn := range tx.MsgTx().TxIn {
+			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			entry := views.Utxo.LookupEntry(txIn.PreviousOutPoint)
+			if entry == nil || entry.BlockHeight() >= height {
+				independent[i+1] = false
+				continue
+			}
+			if entry.TokenType&2 == 2 && entry.Rights != nil &&
+				!fetchRight(entry.Rights) {
+
+				independent[i+1] = false
+			}
+		}
+
+		for _, def := range tx.MsgTx().TxDef {
+			switch def := def.(type) {
+			case *token.RightDef:
+				if !fetchRight(&def.Father) {
+					independent[i+1] = false
+				}
+			case *token.RightSetDef:
+				for j := range def.Rights {
+					if !fetchRight(&def.Rights[j]) {
+						independent[i+1] = false
+					}
+				}
+			}
+		}
+		for _, txOut := range tx.MsgTx().TxOut {
+			if !txOut.IsSeparator() && txOut.HasRight() &&
+				!fetchRight(txOut.Rights) {
+
+				independent[i+1] = false
+			}
+		}
+	}
+
+	return independent, nil
+}
+
+// verifyBlockSigs verifies in parallel the signatures of the transactions of
+// the block which are independent of the other transactions in the block.
+// The view must hold all the utxos they spend, and it is not modified.
+func (b *BlockChain) verifyBlockSigs(transactions []*btcutil.Tx, independent []bool, views *viewpoint.ViewPointSet) error {
+	items := make([]*txValidateItem, 0, len(transactions))
+	for i, tx := range transactions {
+		if independent[i] {
+			items = append(items, &txValidateItem{tx: tx})
+		}
+	}
+
+	validator := newTxValidator(views, b.ChainParams)
+	return validator.Validate(items, b.sigVerifyWorkers())
+}
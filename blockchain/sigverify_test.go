@@ -0,0 +1,203 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/txscript"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// signedTestTxs returns numTxs transactions, each spending with a valid
+// signature one output of an earlier block added to the returned view, as
+// the independent transactions of a block.
+func signedTestTxs(tb testing.TB, chain *BlockChain, numTxs int) ([]*btcutil.Tx, *viewpoint.ViewPointSet) {
+	params := chain.ChainParams
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		tb.Fatalf("NewPrivateKey: %v", err)
+	}
+	pubKeyHash := btcutil.Hash160(privKey.PubKey().SerializeCompressed())
+	addr, err := btcutil.NewAddressPubKeyHash(pubKeyHash, params)
+	if err != nil {
+		tb.Fatalf("NewAddressPubKeyHash: %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		tb.Fatalf("PayToAddrScript: %v", err)
+	}
+
+	fund := wire.NewMsgTx(wire.TxVersion)
+	fund.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x01}, 0), 0))
+	for i := 0; i < numTxs; i++ {
+		fund.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+			Value: &token.NumToken{Val: 100000}}, PkScript: pkScript})
+	}
+	fundHash := fund.TxHash()
+	views := chain.NewViewPointSet()
+	views.Utxo.AddTxOuts(btcutil.NewTx(fund), 1)
+
+	txs := make([]*btcutil.Tx, 0, numTxs)
+	for i := 0; i < numTxs; i++ {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		prevOut := wire.NewOutPoint(&fundHash, uint32(i))
+		msgTx.AddTxIn(wire.NewTxIn(prevOut, 0))
+		msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+			Value: &token.NumToken{Val: 90000}}, PkScript: pkScript})
+		sigScript, err := txscript.SignatureScript(msgTx, 0, pkScript,
+			privKey, true, params, txscript.SigHashAll)
+		if err != nil {
+			tb.Fatalf("SignatureScript: %v", err)
+		}
+		msgTx.SignatureScripts = [][]byte{sigScript}
+		txs = append(txs, btcutil.NewTx(msgTx))
+	}
+	return txs, views
+}
+
+// TestTxValidator ensures the txValidator validates every transaction and
+// returns the error of a failed validation.
+func TestTxValidator(t *testing.T) {
+	items := make([]*txValidateItem, 50)
+	for i := range items {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.LockTime = uint32(i)
+		items[i] = &txValidateItem{tx: btcutil.NewTx(msgTx)}
+	}
+
+	var mtx sync.Mutex
+	validated := make(map[*btcutil.Tx]struct{})
+	validator := newTxValidator(nil, nil)
+	validator.verify = func(tx *btcutil.Tx) error {
+		mtx.Lock()
+		validated[tx] = struct{}{}
+		mtx.Unlock()
+		return nil
+	}
+	if err := validator.Validate(items, 4); err != nil {
+		t.Fatalf("Validate: unexpected error %v", err)
+	}
+	if len(validated) != len(items) {
+		t.Fatalf("Validate: validated %d transactions, want %d",
+			len(validated), len(items))
+	}
+
+	errBad := errors.New("bad signature")
+	validator = newTxValidator(nil, nil)
+	validator.verify = func(tx *btcutil.Tx) error {
+		if tx == items[30].tx {
+			return errBad
+		}
+		return nil
+	}
+	if err := validator.Validate(items, 4); err != errBad {
+		t.Fatalf("Validate: got error %v, want %v", err, errBad)
+	}
+
+	if err := newTxValidator(nil, nil).Validate(nil, 4); err != nil {
+		t.Fatalf("Validate: unexpected error %v for no transactions", err)
+	}
+}
+
+// TestVerifyBlockSigsConcurrent ensures the signatures of the independent
+// transactions of a block are verified concurrently against a shared view
+// without modifying it.  Run with -race to detect unsynchronized accesses to
+// the view.
+func TestVerifyBlockSigsConcurrent(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("verifyblocksigs",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	txs, views := signedTestTxs(t, chain, 200)
+	entries := make(map[wire.OutPoint]*viewpoint.UtxoEntry)
+	for outpoint, entry := range views.Utxo.Entries() {
+		entries[outpoint] = entry
+	}
+
+	independent := make([]bool, len(txs))
+	for i := range independent {
+		independent[i] = true
+	}
+	if err := chain.verifyBlockSigs(txs, independent, views); err != nil {
+		t.Fatalf("verifyBlockSigs: unexpected error %v", err)
+	}
+
+	// The spent outputs are left untouched.
+	if len(views.Utxo.Entries()) != len(entries) {
+		t.Fatalf("verifyBlockSigs: view has %d entries, want %d",
+			len(views.Utxo.Entries()), len(entries))
+	}
+	for outpoint, entry := range entries {
+		got := views.Utxo.LookupEntry(outpoint)
+		if got != entry || got.IsSpent() {
+			t.Fatalf("verifyBlockSigs: entry of %v modified", outpoint)
+		}
+	}
+
+	// A bad signature is reported.
+	bad := txs[len(txs)/2].MsgTx().Copy()
+	bad.TxOut[0].Value = &token.NumToken{Val: 100000}
+	txs[len(txs)/2] = btcutil.NewTx(bad)
+	if err := chain.verifyBlockSigs(txs, independent, views); err == nil {
+		t.Fatalf("verifyBlockSigs: accepted a transaction with a bad " +
+			"signature")
+	}
+}
+
+// TestPrefetchBlockInputs ensures only the transactions whose inputs and
+// rights were all loaded from earlier blocks are verified concurrently.
+func TestPrefetchBlockInputs(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("prefetchblockinputs",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	txs, views := signedTestTxs(t, chain, 3)
+
+	// The second transaction pays to an unknown right, and the third one
+	// spends an output of the first one.
+	unknown := chainhash.Hash{0x77}
+	txs[1].MsgTx().TxOut[0].Token = token.Token{TokenType: 2,
+		Value: &token.NumToken{Val: 90000}, Rights: &unknown}
+	txs[2].MsgTx().TxIn[0].PreviousOutPoint = *wire.NewOutPoint(
+		txs[0].Hash(), 0)
+
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+		wire.MaxPrevOutIndex), 0))
+	msgBlock.AddTransaction(coinbase)
+	for _, tx := range txs {
+		msgBlock.AddTransaction(tx.MsgTx())
+	}
+
+	independent, err := chain.prefetchBlockInputs(2,
+		btcutil.NewBlock(msgBlock), views)
+	if err != nil {
+		t.Fatalf("prefetchBlockInputs: unexpected error %v", err)
+	}
+	want := []bool{false, true, false, false}
+	for i := range want {
+		if independent[i] != want[i] {
+			t.Errorf("prefetchBlockInputs: transaction %d "+
+				"independent %v, want %v", i, independent[i],
+				want[i])
+		}
+	}
+}
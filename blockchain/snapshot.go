@@ -0,0 +1,797 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/btcutil"
+)
+
+// -----------------------------------------------------------------------------
+// A chain state snapshot holds what a node needs to start at a given block
+// without validating the blocks before it: the main chain block index, the
+// blocks a pruned node keeps below the snapshot block and the content of the
+// chain state buckets.  The blocks before those are only known by their
+// header, as on a pruned node.
+//
+// The serialized format is:
+//
+//   <magic><version><net><height><hash><num rows><rows><num blocks><blocks>
+//   <best state><state><state hash><checksum>
+//
+//   Field        Type             Size
+//   magic        [4]byte          4 bytes
+//   version      uint32           4 bytes
+//   net          uint32           4 bytes
+//   height       uint32           4 bytes
+//   hash         chainhash.Hash   chainhash.HashSize
+//   num rows     VLQ              variable
+//   rows         []varbytes       block index rows from genesis to height
+//   num blocks   VLQ              variable
+//   blocks       []varbytes       the blocks up to height which a pruned
+//                                 node keeps, see snapshotFirstBlock
+//   best state   varbytes         the serialized best chain state
+//   state        []bucket         the chain state buckets
+//   state hash   chainhash.Hash   double sha256 of the state
+//   checksum     [32]byte         sha256 of everything before it
+//
+// Each bucket of the state is its name as varbytes followed by its records
+// and an end record.  A record starts with its type: a key/value pair is the
+// key and the value as varbytes, a nested bucket is its key as varbytes
+// followed by its own records and end record.  Records are in key order, so
+// the state hash only depends on the chain state.
+// -----------------------------------------------------------------------------
+
+const (
+	// snapshotVersion is the current version of the snapshot format.
+	snapshotVersion = 1
+
+	// snapshotBatchSize is the number of records written in each database
+	// transaction while importing a snapshot.
+	snapshotBatchSize = 50000
+
+	// snapshotBlockBatchSize is the number of blocks stored in each
+	// database transaction while importing a snapshot.
+	snapshotBlockBatchSize = 500
+
+	// snapshotEnd, snapshotKV and snapshotBucket are the record types of
+	// the state of a snapshot.
+	snapshotEnd    = 0
+	snapshotKV     = 1
+	snapshotBucket = 2
+)
+
+var (
+	// snapshotMagic identifies a snapshot file.
+	snapshotMagic = [4]byte{'o', 'm', 's', 'n'}
+
+	// snapshotStateKeyName is the name of the db key used to record that
+	// the chain state was imported from a snapshot, and whether the
+	// snapshot has been verified since.
+	snapshotStateKeyName = []byte("snapshotstate")
+
+	// snapshotBuckets are the buckets making up the chain state.
+	snapshotBuckets = [][]byte{
+		IssuedTokenTypes,
+		UtxoSetBucketName,
+		borderSetBucketName,
+		borderBoxSetBucketName,
+		polygonSetBucketName,
+		rightSetBucketName,
+		minerTPSBucketName,
+		compendatedBucketName,
+	}
+
+	// ErrSnapshotChecksum indicates a snapshot file is corrupted.
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+	// ErrSnapshotNotPinned indicates a snapshot is not taken at a block
+	// pinned in the chain parameters.
+	ErrSnapshotNotPinned = errors.New("snapshot block is not pinned in " +
+		"the chain parameters")
+
+	// ErrSnapshotMismatch indicates the chain state of a snapshot differs
+	// from the one pinned in the chain parameters, or from the one obtained
+	// by validating the chain.
+	ErrSnapshotMismatch = errors.New("snapshot chain state mismatch")
+)
+
+// SnapshotInfo describes a chain state snapshot.  Verified tells whether the
+// chain state has been verified by validating the chain from genesis.
+type SnapshotInfo struct {
+	Height    int32
+	Hash      chainhash.Hash
+	StateHash chainhash.Hash
+	Verified  bool
+}
+
+// serialize returns the serialization of the snapshot info stored under the
+// snapshot state key.
+func (s *SnapshotInfo) serialize() []byte {
+	serialized := make([]byte, 4+2*chainhash.HashSize+1)
+	byteOrder.PutUint32(serialized, uint32(s.Height))
+	copy(serialized[4:], s.Hash[:])
+	copy(serialized[4+chainhash.HashSize:], s.StateHash[:])
+	if s.Verified {
+		serialized[4+2*chainhash.HashSize] = 1
+	}
+	return serialized
+}
+
+// deserializeSnapshotInfo deserializes the value of the snapshot state key.
+func deserializeSnapshotInfo(serialized []byte) (*SnapshotInfo, error) {
+	if len(serialized) < 4+2*chainhash.HashSize+1 {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt snapshot state",
+		}
+	}
+	s := &SnapshotInfo{Height: int32(byteOrder.Uint32(serialized))}
+	copy(s.Hash[:], serialized[4:])
+	copy(s.StateHash[:], serialized[4+chainhash.HashSize:])
+	s.Verified = serialized[4+2*chainhash.HashSize] != 0
+	return s, nil
+}
+
+// snapshotHasher passes the data written to or read from a snapshot to the
+// checksum, and to the state hash while the state is being processed.
+type snapshotHasher struct {
+	w     io.Writer
+	r     io.Reader
+	sum   hash.Hash
+	state hash.Hash
+}
+
+// Write writes p to the underlying writer and hashes it.
+func (h *snapshotHasher) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash(p[:n])
+	return n, err
+}
+
+// Read reads from the underlying reader and hashes what was read.
+func (h *snapshotHasher) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash(p[:n])
+	return n, err
+}
+
+func (h *snapshotHasher) hash(p []byte) {
+	h.sum.Write(p)
+	if h.state != nil {
+		h.state.Write(p)
+	}
+}
+
+// stateHash returns the double sha256 of the state and stops hashing it.
+func (h *snapshotHasher) stateHash() chainhash.Hash {
+	hash := chainhash.HashH(h.state.Sum(nil))
+	h.state = nil
+	return hash
+}
+
+// writeSnapshotBucket writes the records of bucket followed by an end record.
+func writeSnapshotBucket(w io.Writer, bucket database.Bucket) error {
+	cursor := bucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		value := cursor.Value()
+		if value == nil {
+			if nested := bucket.Bucket(key); nested != nil {
+				if _, err := w.Write([]byte{snapshotBucket}); err != nil {
+					return err
+				}
+				if err := common.WriteVarBytes(w, 0, key); err != nil {
+					return err
+				}
+				if err := writeSnapshotBucket(w, nested); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if _, err := w.Write([]byte{snapshotKV}); err != nil {
+			return err
+		}
+		if err := common.WriteVarBytes(w, 0, key); err != nil {
+			return err
+		}
+		if err := common.WriteVarBytes(w, 0, value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{snapshotEnd})
+	return err
+}
+
+// writeSnapshotState writes the chain state buckets to w and returns the hash
+// of the written state.
+func writeSnapshotState(w io.Writer, dbTx database.Tx) (chainhash.Hash, error) {
+	h := &snapshotHasher{w: w, sum: sha256.New(), state: sha256.New()}
+	meta := dbTx.Metadata()
+	for _, name := range snapshotBuckets {
+		bucket := meta.Bucket(name)
+		if bucket == nil {
+			return chainhash.Hash{}, fmt.Errorf("chain state bucket "+
+				"%s does not exist", name)
+		}
+		if err := common.WriteVarBytes(h, 0, name); err != nil {
+			return chainhash.Hash{}, err
+		}
+		if err := writeSnapshotBucket(h, bucket); err != nil {
+			return chainhash.Hash{}, err
+		}
+	}
+	return h.stateHash(), nil
+}
+
+// beginSnapshotView flushes the utxo cache and returns a read-only database
+// transaction on the chain state at the best block, along with the info of a
+// snapshot at that block.  The chain lock is only held until the transaction
+// is open, as the transaction keeps seeing the flushed state while blocks are
+// processed.  The caller must roll back the transaction when done.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) beginSnapshotView() (database.Tx, *SnapshotInfo, error) {
+	// The lock is held for writes as the utxo cache is flushed, and so
+	// the flushed utxo set stays at the best block until the transaction
+	// is open.
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	// The utxo set in the database must be complete.
+	if err := b.flushUtxoCache(); err != nil {
+		return nil, nil, err
+	}
+
+	dbTx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tip := b.BestChain.Tip()
+	info := &SnapshotInfo{
+		Height:   tip.Height,
+		Hash:     tip.Hash,
+		Verified: true,
+	}
+	return dbTx, info, nil
+}
+
+// ExportSnapshot writes a snapshot of the chain state at the current best
+// block to w.  A chain state imported from a snapshot may only be exported
+// once the snapshot has been verified.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ExportSnapshot(w io.Writer) (*SnapshotInfo, error) {
+	if s := b.Snapshot(); s != nil && !s.Verified {
+		return nil, fmt.Errorf("chain state is from snapshot at height "+
+			"%d which is not verified yet", s.Height)
+	}
+
+	dbTx, info, err := b.beginSnapshotView()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+
+	bw := bufio.NewWriter(w)
+	h := &snapshotHasher{w: bw, sum: sha256.New()}
+
+	err = func() error {
+		err := common.WriteElements(h, snapshotMagic, uint32(snapshotVersion),
+			uint32(b.ChainParams.Net), uint32(info.Height), &info.Hash)
+		if err != nil {
+			return err
+		}
+
+		// Main chain block index.
+		if err := common.WriteVarInt(h, 0, uint64(info.Height)+1); err != nil {
+			return err
+		}
+		blockIndex := dbTx.Metadata().Bucket(blockIndexBucketName)
+		for height := int32(0); height <= info.Height; height++ {
+			hash, err := DbFetchHashByHeight(dbTx, height)
+			if err != nil {
+				return err
+			}
+			row := blockIndex.Get(BlockIndexKey(hash, uint32(height)))
+			if row == nil {
+				return AssertError(fmt.Sprintf("ExportSnapshot: no "+
+					"block index entry for block %s", hash))
+			}
+			if err := common.WriteVarBytes(h, 0, row); err != nil {
+				return err
+			}
+		}
+
+		// The blocks kept by a pruned node.
+		first := snapshotFirstBlock(b.ChainParams, info.Height)
+		err = common.WriteVarInt(h, 0, uint64(info.Height-first)+1)
+		if err != nil {
+			return err
+		}
+		for height := first; height <= info.Height; height++ {
+			hash, err := DbFetchHashByHeight(dbTx, height)
+			if err != nil {
+				return err
+			}
+			block, err := dbTx.FetchBlock(hash)
+			if err != nil {
+				return err
+			}
+			if err := common.WriteVarBytes(h, 0, block); err != nil {
+				return err
+			}
+		}
+
+		state := dbTx.Metadata().Get(chainStateKeyName)
+		if err := common.WriteVarBytes(h, 0, state); err != nil {
+			return err
+		}
+
+		info.StateHash, err = writeSnapshotState(h, dbTx)
+		if err != nil {
+			return err
+		}
+		_, err = h.Write(info.StateHash[:])
+		return err
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := bw.Write(h.sum.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Exported chain state snapshot at height %d (%v), state "+
+		"hash %v", info.Height, info.Hash, info.StateHash)
+	return info, nil
+}
+
+// snapshotLoader writes the state of a snapshot to the database, committing
+// every snapshotBatchSize records so the size of a transaction is bounded.
+type snapshotLoader struct {
+	db    database.DB
+	dbTx  database.Tx
+	path  [][]byte
+	count int
+}
+
+// bucket returns the bucket records are currently written to.
+func (l *snapshotLoader) bucket() database.Bucket {
+	bucket := l.dbTx.Metadata()
+	for _, name := range l.path {
+		bucket = bucket.Bucket(name)
+	}
+	return bucket
+}
+
+// written counts a written record and starts a new transaction when the
+// current one is full.
+func (l *snapshotLoader) written() error {
+	l.count++
+	if l.count%snapshotBatchSize != 0 {
+		return nil
+	}
+	return l.restart()
+}
+
+// restart commits the current transaction and starts a new one.
+func (l *snapshotLoader) restart() error {
+	if err := l.dbTx.Commit(); err != nil {
+		return err
+	}
+	var err error
+	l.dbTx, err = l.db.Begin(true)
+	return err
+}
+
+// readBucket reads the records of the current bucket up to its end record.
+func (l *snapshotLoader) readBucket(r io.Reader) error {
+	for {
+		var recType [1]byte
+		if _, err := io.ReadFull(r, recType[:]); err != nil {
+			return err
+		}
+
+		switch recType[0] {
+		case snapshotEnd:
+			return nil
+
+		case snapshotKV:
+			key, err := common.ReadVarBytes(r, 0, wire.MaxBlockPayload, "key")
+			if err != nil {
+				return err
+			}
+			value, err := common.ReadVarBytes(r, 0, wire.MaxBlockPayload, "value")
+			if err != nil {
+				return err
+			}
+			if err := l.bucket().Put(key, value); err != nil {
+				return err
+			}
+
+		case snapshotBucket:
+			key, err := common.ReadVarBytes(r, 0, wire.MaxBlockPayload, "key")
+			if err != nil {
+				return err
+			}
+			if _, err := l.bucket().CreateBucket(key); err != nil {
+				return err
+			}
+			l.path = append(l.path, key)
+			if err := l.readBucket(r); err != nil {
+				return err
+			}
+			l.path = l.path[:len(l.path)-1]
+
+		default:
+			return fmt.Errorf("unknown snapshot record type %d",
+				recType[0])
+		}
+
+		if err := l.written(); err != nil {
+			return err
+		}
+	}
+}
+
+// snapshotFirstBlock returns the height of the first block of a snapshot taken
+// at the passed height.  The blocks from that height up are kept, like a
+// pruned node keeps them, so the blocks which may still be looked up, such as
+// to forfeit the collateral of a reported miner, are available.
+func snapshotFirstBlock(params *chaincfg.Params, height int32) int32 {
+	first := height - MinPruneDepth(params)
+	if first < 0 {
+		first = 0
+	}
+	return first
+}
+
+// snapshotPin returns the snapshot pinned in the chain parameters at the
+// passed block, if any.
+func snapshotPin(params *chaincfg.Params, height int32, hash *chainhash.Hash) *chaincfg.AssumeUtxo {
+	for i := range params.AssumeUtxo {
+		pin := &params.AssumeUtxo[i]
+		if pin.Height == height && pin.BlockHash.IsEqual(hash) {
+			return pin
+		}
+	}
+	return nil
+}
+
+// ImportSnapshot initializes an empty database with the chain state in the
+// snapshot read from r.  The snapshot must be taken at a block pinned in the
+// chain parameters and hold the chain state pinned there.  A chain created on
+// the database starts at the snapshot block, with the blocks before the ones
+// in the snapshot marked pruned in the block index, until VerifySnapshot
+// validates it.
+//
+// The database is written as the snapshot is read, so it must be discarded
+// when an error is returned.
+func ImportSnapshot(db database.DB, params *chaincfg.Params, r io.Reader) (*SnapshotInfo, error) {
+	h := &snapshotHasher{r: bufio.NewReader(r), sum: sha256.New()}
+
+	var magic [4]byte
+	var version, net, height uint32
+	info := &SnapshotInfo{}
+	err := common.ReadElements(h, &magic, &version, &net, &height, &info.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if magic != snapshotMagic {
+		return nil, errors.New("not a chain state snapshot")
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if common.OmegaNet(net) != params.Net {
+		return nil, fmt.Errorf("snapshot is for network %v, not %v",
+			common.OmegaNet(net), params.Net)
+	}
+	info.Height = int32(height)
+	pin := snapshotPin(params, info.Height, &info.Hash)
+	if pin == nil {
+		return nil, ErrSnapshotNotPinned
+	}
+
+	err = db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if meta.Get(chainStateKeyName) != nil ||
+			meta.Bucket(blockIndexBucketName) != nil {
+			return errors.New("database is already initialized")
+		}
+		if err := dbCreateChainBuckets(dbTx); err != nil {
+			return err
+		}
+
+		// The chain is started without optional indexes, which can not
+		// be built without the blocks.
+		_, err := meta.CreateBucket([]byte("usebyaddridx"))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the block index of the main chain.  The rows must link the
+	// genesis block to the pinned block.
+	rows, err := common.ReadVarInt(h, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rows != uint64(info.Height)+1 {
+		return nil, fmt.Errorf("snapshot has %d block index entries "+
+			"for height %d", rows, info.Height)
+	}
+	loader := &snapshotLoader{db: db}
+	loader.dbTx, err = db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if loader.dbTx != nil {
+			loader.dbTx.Rollback()
+		}
+	}()
+
+	first := snapshotFirstBlock(params, info.Height)
+	hashes := make([]chainhash.Hash, 0, info.Height-first+1)
+	prevHash := *params.GenesisHash
+	for height := int32(0); height <= info.Height; height++ {
+		row, err := common.ReadVarBytes(h, 0, wire.MaxBlockHeaderPayload+1, "row")
+		if err != nil {
+			return nil, err
+		}
+		header, _, err := deserializeBlockRow(row)
+		if err != nil {
+			return nil, err
+		}
+		hash := header.BlockHash()
+		if height == 0 && !hash.IsEqual(params.GenesisHash) ||
+			height > 0 && !header.PrevBlock.IsEqual(&prevHash) {
+			return nil, fmt.Errorf("snapshot block index is broken "+
+				"at height %d", height)
+		}
+		prevHash = hash
+
+		// Only the blocks in the snapshot are stored, the ones before
+		// them are known by their header, as if they had been pruned.
+		status := chainutil.StatusValid | chainutil.StatusPruned
+		if height >= first {
+			status = chainutil.StatusValid | chainutil.StatusDataStored
+			hashes = append(hashes, hash)
+		}
+		row[len(row)-1] = byte(status)
+
+		blockIndex := loader.dbTx.Metadata().Bucket(blockIndexBucketName)
+		if err := blockIndex.Put(BlockIndexKey(&hash, uint32(height)), row); err != nil {
+			return nil, err
+		}
+		if err := DbPutBlockIndex(loader.dbTx, &hash, height); err != nil {
+			return nil, err
+		}
+		if err := loader.written(); err != nil {
+			return nil, err
+		}
+	}
+	if !prevHash.IsEqual(&info.Hash) {
+		return nil, ErrSnapshotNotPinned
+	}
+
+	// Load the blocks, which must be the ones of the block index.  They are
+	// written right away, so the transactions stay small.
+	numBlocks, err := common.ReadVarInt(h, 0)
+	if err != nil {
+		return nil, err
+	}
+	if numBlocks != uint64(len(hashes)) {
+		return nil, fmt.Errorf("snapshot has %d blocks, want %d",
+			numBlocks, len(hashes))
+	}
+	if err := loader.restart(); err != nil {
+		return nil, err
+	}
+	for i := range hashes {
+		serializedBlock, err := common.ReadVarBytes(h, 0,
+			wire.MaxBlockPayload, "block")
+		if err != nil {
+			return nil, err
+		}
+		block, err := btcutil.NewBlockFromBytes(serializedBlock)
+		if err != nil {
+			return nil, err
+		}
+		if !block.Hash().IsEqual(&hashes[i]) {
+			return nil, fmt.Errorf("snapshot block %s is not the "+
+				"block at height %d", block.Hash(), first+int32(i))
+		}
+		block.SetHeight(first + int32(i))
+		if err := dbStoreBlock(loader.dbTx, block); err != nil {
+			return nil, err
+		}
+		if (i+1)%snapshotBlockBatchSize == 0 {
+			if err := loader.restart(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	serializedState, err := common.ReadVarBytes(h, 0, wire.MaxBlockPayload, "best state")
+	if err != nil {
+		return nil, err
+	}
+	state, err := deserializeBestChainState(serializedState)
+	if err != nil {
+		return nil, err
+	}
+	if state.hash != info.Hash || state.height != uint32(info.Height) {
+		return nil, fmt.Errorf("snapshot best state is at block %s",
+			state.hash)
+	}
+
+	// Load the chain state.
+	h.state = sha256.New()
+	for range snapshotBuckets {
+		name, err := common.ReadVarBytes(h, 0, wire.MaxBlockPayload, "bucket")
+		if err != nil {
+			return nil, err
+		}
+		known := false
+		for _, n := range snapshotBuckets {
+			known = known || bytes.Equal(n, name)
+		}
+		if !known {
+			return nil, fmt.Errorf("snapshot bucket %s is not a chain "+
+				"state bucket", name)
+		}
+		loader.path = [][]byte{name}
+		if err := loader.readBucket(h); err != nil {
+			return nil, err
+		}
+	}
+	info.StateHash = h.stateHash()
+
+	var stateHash chainhash.Hash
+	if _, err := io.ReadFull(h, stateHash[:]); err != nil {
+		return nil, err
+	}
+	if stateHash != info.StateHash || !pin.StateHash.IsEqual(&info.StateHash) {
+		return nil, ErrSnapshotMismatch
+	}
+
+	sum := h.sum.Sum(nil)
+	var checksum [sha256.Size]byte
+	if _, err := io.ReadFull(h.r, checksum[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, checksum[:]) {
+		return nil, ErrSnapshotChecksum
+	}
+
+	// The chain state is complete, make the database usable.
+	meta := loader.dbTx.Metadata()
+	if err := meta.Put(chainStateKeyName, serializedState); err != nil {
+		return nil, err
+	}
+	if err := meta.Put(snapshotStateKeyName, info.serialize()); err != nil {
+		return nil, err
+	}
+	err = loader.dbTx.Commit()
+	loader.dbTx = nil
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Imported chain state snapshot at height %d (%v), %d "+
+		"records", info.Height, info.Hash, loader.count)
+	return info, nil
+}
+
+// Snapshot returns the snapshot the chain state was imported from, or nil if
+// the chain was validated from genesis.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) Snapshot() *SnapshotInfo {
+	var info *SnapshotInfo
+	b.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Get(snapshotStateKeyName)
+		if serialized == nil {
+			return nil
+		}
+		var err error
+		info, err = deserializeSnapshotInfo(serialized)
+		return err
+	})
+	return info
+}
+
+// VerifySnapshot validates the snapshot the chain state was imported from.
+// The blocks up to the snapshot height, returned by fetch, are processed by
+// chain, which must be a chain on its own database sharing the miner chain
+// of b, and the resulting chain state is compared with the snapshot.  The
+// snapshot is marked as verified on success.  It is meant to be run in the
+// background and may be resumed with the same chain after quit is closed.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerifySnapshot(chain *BlockChain, fetch func(height int32) (*btcutil.Block, error), quit <-chan struct{}) error {
+	info := b.Snapshot()
+	if info == nil {
+		return errors.New("chain state is not from a snapshot")
+	}
+	if info.Verified {
+		return nil
+	}
+
+	for height := chain.BestSnapshot().Height + 1; height <= info.Height; height++ {
+		if interruptRequested(quit) {
+			return errInterruptRequested
+		}
+		block, err := fetch(height)
+		if err != nil {
+			return err
+		}
+		_, isOrphan, err, _, _ := chain.ProcessBlock(block, BFNone)
+		if err != nil {
+			return err
+		}
+		if isOrphan {
+			return fmt.Errorf("block %s at height %d is an orphan",
+				block.Hash(), height)
+		}
+		if height%10000 == 0 {
+			log.Infof("Verifying snapshot: validated block %d of %d",
+				height, info.Height)
+		}
+	}
+
+	// The utxo set in the database lags the utxo cache, so it is flushed
+	// before hashing the chain state.
+	dbTx, best, err := chain.beginSnapshotView()
+	if err != nil {
+		return err
+	}
+	stateHash, err := writeSnapshotState(io.Discard, dbTx)
+	dbTx.Rollback()
+	if err != nil {
+		return err
+	}
+	if best.Hash != info.Hash || stateHash != info.StateHash {
+		log.Errorf("Snapshot at height %d (%v) does not match the "+
+			"validated chain at %v, state hash %v", info.Height,
+			info.Hash, best.Hash, stateHash)
+		return ErrSnapshotMismatch
+	}
+
+	info.Verified = true
+	err = b.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Put(snapshotStateKeyName, info.serialize())
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Verified chain state snapshot at height %d (%v)",
+		info.Height, info.Hash)
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// TestSnapshotHeader ensures the snapshot state round trips and snapshots at
+// blocks not pinned in the chain parameters are rejected before anything is
+// written to the database.
+func TestSnapshotHeader(t *testing.T) {
+	info := &SnapshotInfo{
+		Height:    1000,
+		Hash:      chainhash.Hash{0x01},
+		StateHash: chainhash.Hash{0x02},
+		Verified:  true,
+	}
+	got, err := deserializeSnapshotInfo(info.serialize())
+	if err != nil {
+		t.Fatalf("deserializeSnapshotInfo: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("deserializeSnapshotInfo: got %+v, want %+v", got, info)
+	}
+	if _, err := deserializeSnapshotInfo(info.serialize()[1:]); err == nil {
+		t.Errorf("deserializeSnapshotInfo: accepted truncated state")
+	}
+
+	params := chaincfg.MainNetParams
+	params.AssumeUtxo = []chaincfg.AssumeUtxo{{
+		Height:    1000,
+		BlockHash: &chainhash.Hash{0x03},
+		StateHash: &info.StateHash,
+	}}
+	var buf bytes.Buffer
+	common.WriteElements(&buf, snapshotMagic, uint32(snapshotVersion),
+		uint32(params.Net), uint32(info.Height), &info.Hash)
+	if _, err := ImportSnapshot(nil, &params, &buf); err != ErrSnapshotNotPinned {
+		t.Errorf("ImportSnapshot: got %v, want %v", err,
+			ErrSnapshotNotPinned)
+	}
+}
@@ -0,0 +1,506 @@
+// Copyright (c) 2016-2017 The btcsuite developers
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+)
+
+// ThresholdState define the various threshold states used when voting on
+// consensus changes.
+type ThresholdState byte
+
+// These constants are used to identify specific threshold states.
+const (
+	// ThresholdDefined is the first state for each deployment and is the
+	// state for the genesis block has by definition for all deployments.
+	ThresholdDefined ThresholdState = iota
+
+	// ThresholdStarted is the state for a deployment once its start time
+	// has been reached.
+	ThresholdStarted
+
+	// ThresholdLockedIn is the state for a deployment during the retarget
+	// period which is after the ThresholdStarted state period and the
+	// number of blocks that have voted for the deployment equal or exceed
+	// the required number of votes for the deployment.
+	ThresholdLockedIn
+
+	// ThresholdActive is the state for a deployment for all blocks after a
+	// retarget period in which the deployment was in the ThresholdLockedIn
+	// state.
+	ThresholdActive
+
+	// ThresholdFailed is the state for a deployment once its expiration
+	// time has been reached and it did not reach the ThresholdLockedIn
+	// state.
+	ThresholdFailed
+
+	// numThresholdsStates is the maximum number of threshold states used in
+	// tests.
+	numThresholdsStates
+)
+
+// thresholdStateStrings is a map of ThresholdState values back to their
+// constant names for pretty printing.
+var thresholdStateStrings = map[ThresholdState]string{
+	ThresholdDefined:  "ThresholdDefined",
+	ThresholdStarted:  "ThresholdStarted",
+	ThresholdLockedIn: "ThresholdLockedIn",
+	ThresholdActive:   "ThresholdActive",
+	ThresholdFailed:   "ThresholdFailed",
+}
+
+// String returns the ThresholdState as a human-readable name.
+func (t ThresholdState) String() string {
+	if s := thresholdStateStrings[t]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ThresholdState (%d)", int(t))
+}
+
+// thresholdConditionChecker provides a generic interface that is invoked to
+// determine when a consensus rule change threshold should be changed.
+type thresholdConditionChecker interface {
+	// BeginTime returns the unix timestamp for the median block time after
+	// which voting on a rule change starts (at the next window).
+	BeginTime() uint64
+
+	// EndTime returns the unix timestamp for the median block time after
+	// which an attempted rule change fails if it has not already been
+	// locked in or activated.
+	EndTime() uint64
+
+	// RuleChangeActivationThreshold is the number of blocks for which the
+	// condition must be true in order to lock in a rule change.
+	RuleChangeActivationThreshold() uint32
+
+	// MinerConfirmationWindow is the number of blocks in each threshold
+	// state retarget window.
+	MinerConfirmationWindow() uint32
+
+	// Condition returns whether or not the rule change activation condition
+	// has been met.  This typically involves checking whether or not the
+	// bit associated with the condition is set, but can be more complex as
+	// needed.
+	Condition(*chainutil.BlockNode) (bool, error)
+}
+
+// thresholdStateCache provides a type to cache the threshold states of each
+// threshold window for a set of IDs.  The entries not yet stored in the
+// database are tracked in modified.
+type thresholdStateCache struct {
+	entries  map[chainhash.Hash]ThresholdState
+	modified map[chainhash.Hash]ThresholdState
+}
+
+// Lookup returns the threshold state associated with the given hash along with
+// a boolean that indicates whether or not it is valid.
+func (c *thresholdStateCache) Lookup(hash *chainhash.Hash) (ThresholdState, bool) {
+	state, ok := c.entries[*hash]
+	return state, ok
+}
+
+// Update updates the cache to contain the provided hash to threshold state
+// mapping.
+func (c *thresholdStateCache) Update(hash *chainhash.Hash, state ThresholdState) {
+	c.entries[*hash] = state
+	c.modified[*hash] = state
+}
+
+// MarkFlushed marks all of the current updates as flushed to the database.
+// This is useful so the caller can ensure the needed database updates are not
+// lost until they have successfully been written to the database.
+func (c *thresholdStateCache) MarkFlushed() {
+	for hash := range c.modified {
+		delete(c.modified, hash)
+	}
+}
+
+// newThresholdCaches returns a new array of caches to be used when calculating
+// threshold states.
+func newThresholdCaches(numCaches uint32) []thresholdStateCache {
+	caches := make([]thresholdStateCache, numCaches)
+	for i := 0; i < len(caches); i++ {
+		caches[i] = thresholdStateCache{
+			entries:  make(map[chainhash.Hash]ThresholdState),
+			modified: make(map[chainhash.Hash]ThresholdState),
+		}
+	}
+	return caches
+}
+
+// ancestorNode returns the ancestor of the passed node at the passed height,
+// loading the main chain nodes below the cutoff of the block index as needed.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) ancestorNode(node *chainutil.BlockNode, height int32) *chainutil.BlockNode {
+	if height < 0 || height > node.Height {
+		return nil
+	}
+	for node != nil && node.Height != height {
+		node = b.ParentNode(node)
+	}
+	return node
+}
+
+// pastMedianTime returns the median time of the previous few blocks prior to,
+// and including, the passed node.  Unlike CalcPastMedianTime, it also follows
+// the main chain below the cutoff of the block index, so the result does not
+// depend on which nodes happen to be in memory.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) pastMedianTime(node *chainutil.BlockNode) time.Time {
+	timestamps := make([]int64, 0, chainutil.MedianTimeBlocks)
+	for n := node; n != nil && len(timestamps) < chainutil.MedianTimeBlocks; n = b.ParentNode(n) {
+		timestamps = append(timestamps, n.Data.TimeStamp())
+	}
+	sort.Sort(chainutil.TimeSorter(timestamps))
+	return time.Unix(timestamps[len(timestamps)/2], 0)
+}
+
+// thresholdState returns the current rule change threshold state for the block
+// AFTER the given node and deployment ID.  The cache is used to ensure the
+// threshold states for previous windows are only calculated once.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) thresholdState(prevNode *chainutil.BlockNode, checker thresholdConditionChecker, cache *thresholdStateCache) (ThresholdState, error) {
+	// The threshold state for the window that contains the genesis block is
+	// defined by definition.
+	confirmationWindow := int32(checker.MinerConfirmationWindow())
+	if prevNode == nil || (prevNode.Height+1) < confirmationWindow {
+		return ThresholdDefined, nil
+	}
+
+	// Get the ancestor that is the last block of the previous confirmation
+	// window in order to get its threshold state.  This can be done because
+	// the state is the same for all blocks within a given window.
+	prevNode = b.ancestorNode(prevNode, prevNode.Height-
+		(prevNode.Height+1)%confirmationWindow)
+
+	// Iterate backwards through each of the previous confirmation windows
+	// to find the most recently cached threshold state.
+	var neededStates []*chainutil.BlockNode
+	for prevNode != nil {
+		// Nothing more to do if the state of the block is already
+		// cached.
+		if _, ok := cache.Lookup(&prevNode.Hash); ok {
+			break
+		}
+
+		// The start and expiration times are based on the median block
+		// time, so calculate it now.
+		medianTime := b.pastMedianTime(prevNode)
+
+		// The state is simply defined if the start time hasn't been
+		// been reached yet.
+		if uint64(medianTime.Unix()) < checker.BeginTime() {
+			cache.Update(&prevNode.Hash, ThresholdDefined)
+			break
+		}
+
+		// Add this node to the list of nodes that need the state
+		// calculated and cached.
+		neededStates = append(neededStates, prevNode)
+
+		// Get the ancestor that is the last block of the previous
+		// confirmation window.
+		prevNode = b.ancestorNode(prevNode, prevNode.Height-confirmationWindow)
+	}
+
+	// Start with the threshold state for the most recent confirmation
+	// window that has a cached state.
+	state := ThresholdDefined
+	if prevNode != nil {
+		var ok bool
+		state, ok = cache.Lookup(&prevNode.Hash)
+		if !ok {
+			return ThresholdFailed, AssertError(fmt.Sprintf(
+				"thresholdState: cache lookup failed for %v",
+				prevNode.Hash))
+		}
+	}
+
+	// Since each threshold state depends on the state of the previous
+	// window, iterate starting from the oldest unknown window.
+	for neededNum := len(neededStates) - 1; neededNum >= 0; neededNum-- {
+		prevNode := neededStates[neededNum]
+
+		switch state {
+		case ThresholdDefined:
+			// The deployment of the rule change fails if it expires
+			// before it is accepted and locked in.
+			medianTime := b.pastMedianTime(prevNode)
+			medianTimeUnix := uint64(medianTime.Unix())
+			if medianTimeUnix >= checker.EndTime() {
+				state = ThresholdFailed
+				break
+			}
+
+			// The state for the rule moves to the started state
+			// once its start time has been reached (and it hasn't
+			// already expired per the above).
+			if medianTimeUnix >= checker.BeginTime() {
+				state = ThresholdStarted
+			}
+
+		case ThresholdStarted:
+			// The deployment of the rule change fails if it expires
+			// before it is accepted and locked in.
+			medianTime := b.pastMedianTime(prevNode)
+			if uint64(medianTime.Unix()) >= checker.EndTime() {
+				state = ThresholdFailed
+				break
+			}
+
+			// At this point, the rule change is still being voted
+			// on by the miners, so iterate backwards through the
+			// confirmation window to count all of the votes in it.
+			var count uint32
+			countNode := prevNode
+			for i := int32(0); i < confirmationWindow && countNode != nil; i++ {
+				condition, err := checker.Condition(countNode)
+				if err != nil {
+					return ThresholdFailed, err
+				}
+				if condition {
+					count++
+				}
+
+				// Get the previous block node.
+				countNode = b.ParentNode(countNode)
+			}
+
+			// The state is locked in if the number of blocks in the
+			// period that voted for the rule change meets the
+			// activation threshold.
+			if count >= checker.RuleChangeActivationThreshold() {
+				state = ThresholdLockedIn
+			}
+
+		case ThresholdLockedIn:
+			// The new rule becomes active when its previous state
+			// was locked in.
+			state = ThresholdActive
+
+		// Nothing to do if the previous state is active or failed since
+		// they are both terminal states.
+		case ThresholdActive:
+		case ThresholdFailed:
+		}
+
+		// Update the cache to avoid recalculating the state in the
+		// future.
+		cache.Update(&prevNode.Hash, state)
+	}
+
+	return state, nil
+}
+
+// deploymentState returns the current rule change threshold for a given
+// deploymentID.  The threshold is evaluated from the point of view of the block
+// node passed in as the first argument to this method.
+//
+// It is important to note that, as the variable name indicates, this function
+// expects the block node prior to the block for which the deployment state is
+// desired.  In other words, the returned deployment state is for the block
+// AFTER the passed node.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) deploymentState(prevNode *chainutil.BlockNode, deploymentID uint32) (ThresholdState, error) {
+	if deploymentID >= uint32(len(b.ChainParams.Deployments)) {
+		return ThresholdFailed, DeploymentError(deploymentID)
+	}
+
+	deployment := &b.ChainParams.Deployments[deploymentID]
+	checker := deploymentChecker{deployment: deployment, chain: b}
+	cache := &b.deploymentCaches[deploymentID]
+
+	return b.thresholdState(prevNode, checker, cache)
+}
+
+// ThresholdState returns the current rule change threshold state of the given
+// deployment ID for the block AFTER the end of the current best chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ThresholdState(deploymentID uint32) (ThresholdState, error) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	return b.deploymentState(b.BestChain.Tip(), deploymentID)
+}
+
+// IsDeploymentActive returns true if the target deploymentID is active, and
+// false otherwise.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsDeploymentActive(deploymentID uint32) (bool, error) {
+	state, err := b.ThresholdState(deploymentID)
+	if err != nil {
+		return false, err
+	}
+
+	return state == ThresholdActive, nil
+}
+
+// DeploymentInfo describes the state of a deployment for the block after a
+// given block.  Since is the height of the first block of the confirmation
+// window the state started at, and Count the number of blocks signalling for
+// the deployment in the current window when it is started.
+type DeploymentInfo struct {
+	ID         uint32
+	Deployment *chaincfg.ConsensusDeployment
+	State      ThresholdState
+	Since      int32
+	Count      uint32
+	Elapsed    uint32
+	Threshold  uint32
+	Window     uint32
+}
+
+// DeploymentInfos returns the state of all the deployments for the block after
+// the block with the passed hash, or after the best block when hash is nil.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DeploymentInfos(hash *chainhash.Hash) ([]DeploymentInfo, error) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	node := b.BestChain.Tip()
+	if hash != nil {
+		node = b.index.LookupNode(hash)
+		if node == nil {
+			return nil, fmt.Errorf("block %s is not known", hash)
+		}
+	}
+
+	window := int32(b.ChainParams.MinerConfirmationWindow)
+	infos := make([]DeploymentInfo, 0, len(b.ChainParams.Deployments))
+	for id := range b.ChainParams.Deployments {
+		deployment := &b.ChainParams.Deployments[id]
+		state, err := b.deploymentState(node, uint32(id))
+		if err != nil {
+			return nil, err
+		}
+		info := DeploymentInfo{
+			ID:         uint32(id),
+			Deployment: deployment,
+			State:      state,
+			Threshold:  b.ChainParams.RuleChangeActivationThreshold,
+			Window:     b.ChainParams.MinerConfirmationWindow,
+		}
+
+		// Find the first window with the current state.
+		since := node.Height + 1 - (node.Height+1)%window
+		for since >= window {
+			prev := b.ancestorNode(node, since-window-1)
+			if prev == nil {
+				break
+			}
+			s, err := b.deploymentState(prev, uint32(id))
+			if err != nil {
+				return nil, err
+			}
+			if s != state {
+				break
+			}
+			since -= window
+		}
+		info.Since = since
+
+		// Count the signalling blocks of the current window.
+		if state == ThresholdStarted {
+			checker := deploymentChecker{deployment: deployment, chain: b}
+			start := node.Height + 1 - (node.Height+1)%window
+			for n := node; n != nil && n.Height >= start; n = b.ParentNode(n) {
+				condition, err := checker.Condition(n)
+				if err != nil {
+					return nil, err
+				}
+				if condition {
+					info.Count++
+				}
+				info.Elapsed++
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// initThresholdCaches loads the threshold state caches of the deployments from
+// the database, then warms them up along with the warning caches for the
+// current best chain, so the threshold states of the following blocks can be
+// found quickly.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initThresholdCaches() error {
+	err := b.db.View(func(dbTx database.Tx) error {
+		return dbFetchThresholdCaches(dbTx, b.deploymentCaches, b.ChainParams)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Initialize the warning and deployment caches by calculating the
+	// threshold state for each of them.  This will ensure the caches are
+	// populated and any states that needed to be recalculated due to
+	// definition changes is done now.
+	prevNode := b.BestChain.Tip().Parent
+	for bit := uint32(0); bit < vbNumBits; bit++ {
+		checker := bitConditionChecker{bit: bit, chain: b}
+		cache := &b.warningCaches[bit]
+		_, err := b.thresholdState(prevNode, checker, cache)
+		if err != nil {
+			return err
+		}
+	}
+	for id := 0; id < len(b.ChainParams.Deployments); id++ {
+		_, err := b.deploymentState(prevNode, uint32(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	// No warnings about unknown rules or versions until the chain is
+	// current.
+	if b.isCurrent() {
+		// Warn if a high enough percentage of the last blocks have
+		// unexpected versions.
+		bestNode := b.BestChain.Tip()
+		if err := b.warnUnknownVersions(bestNode); err != nil {
+			return err
+		}
+
+		// Warn if any unknown new rules are either about to activate or
+		// have already been activated.
+		if err := b.warnUnknownRuleActivations(bestNode); err != nil {
+			return err
+		}
+	}
+
+	// Store the states of the deployments computed above.
+	err = b.db.Update(func(dbTx database.Tx) error {
+		return dbPutThresholdCaches(dbTx, b.deploymentCaches, b.ChainParams)
+	})
+	if err != nil {
+		return err
+	}
+	for i := range b.deploymentCaches {
+		b.deploymentCaches[i].MarkFlushed()
+	}
+
+	return nil
+}
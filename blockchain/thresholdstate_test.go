@@ -0,0 +1,296 @@
+// Copyright (c) 2016-2017 The btcsuite developers
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// TestThresholdStateStringer tests the stringized output for the
+// ThresholdState type.
+func TestThresholdStateStringer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   ThresholdState
+		want string
+	}{
+		{ThresholdDefined, "ThresholdDefined"},
+		{ThresholdStarted, "ThresholdStarted"},
+		{ThresholdLockedIn, "ThresholdLockedIn"},
+		{ThresholdActive, "ThresholdActive"},
+		{ThresholdFailed, "ThresholdFailed"},
+		{0xff, "Unknown ThresholdState (255)"},
+	}
+
+	// Detect additional threshold states that don't have the stringer added.
+	if len(tests)-1 != int(numThresholdsStates) {
+		t.Errorf("It appears a threshold state was added without " +
+			"adding an associated stringer test")
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestThresholdStateCache ensure the threshold state cache works as intended
+// including tracking the modified entries.
+func TestThresholdStateCache(t *testing.T) {
+	t.Parallel()
+
+	caches := newThresholdCaches(2)
+	cache := &caches[1]
+	hash := chainhash.Hash{1}
+	if _, ok := cache.Lookup(&hash); ok {
+		t.Fatalf("Lookup: found an entry in an empty cache")
+	}
+
+	cache.Update(&hash, ThresholdLockedIn)
+	if state, ok := cache.Lookup(&hash); !ok || state != ThresholdLockedIn {
+		t.Fatalf("Lookup: got %v, %v, want %v", state, ok,
+			ThresholdLockedIn)
+	}
+	if len(cache.modified) != 1 || len(caches[0].modified) != 0 {
+		t.Fatalf("Update: entry not tracked as modified")
+	}
+
+	cache.MarkFlushed()
+	if len(cache.modified) != 0 {
+		t.Fatalf("MarkFlushed: %d modified entries left",
+			len(cache.modified))
+	}
+	if _, ok := cache.Lookup(&hash); !ok {
+		t.Fatalf("MarkFlushed: entry removed from the cache")
+	}
+}
+
+// TestSignalsDeployment ensures a block version votes for a deployment only
+// with the version the deployment upgrades from and all of its feature bits.
+func TestSignalsDeployment(t *testing.T) {
+	t.Parallel()
+
+	deployment := &chaincfg.ConsensusDeployment{
+		PrevVersion: 0x20000,
+		FeatureMask: 0x5,
+	}
+	tests := []struct {
+		version uint32
+		want    bool
+	}{
+		{0x20005, true},
+		{0x20007, true},
+		{0x20004, false},
+		{0x20000, false},
+		{0x30005, false},
+		{0x10005, false},
+	}
+	for i, test := range tests {
+		if got := signalsDeployment(test.version, deployment); got != test.want {
+			t.Errorf("signalsDeployment #%d (%x): got %v, want %v",
+				i, test.version, got, test.want)
+		}
+	}
+
+	if signalsDeployment(0x2ffff, &chaincfg.ConsensusDeployment{
+		PrevVersion: 0x20000,
+	}) {
+		t.Errorf("signalsDeployment: a deployment without feature bits " +
+			"is voted for")
+	}
+}
+
+// testNodeData is the data of the block nodes built by the threshold state
+// tests.  A version of 1 votes for the tested rule change.
+type testNodeData struct {
+	timestamp int64
+	version   uint32
+}
+
+func (d *testNodeData) TimeStamp() int64       { return d.timestamp }
+func (d *testNodeData) GetNonce() int32        { return 0 }
+func (d *testNodeData) GetBits() uint32        { return 0 }
+func (d *testNodeData) SetBits(uint32)         {}
+func (d *testNodeData) GetVersion() uint32     { return d.version }
+func (d *testNodeData) GetContractExec() int64 { return 0 }
+
+// testConditionChecker is a thresholdConditionChecker counting the votes of
+// the block nodes built by the threshold state tests.
+type testConditionChecker struct {
+	beginTime uint64
+	endTime   uint64
+}
+
+func (c testConditionChecker) BeginTime() uint64                     { return c.beginTime }
+func (c testConditionChecker) EndTime() uint64                       { return c.endTime }
+func (c testConditionChecker) RuleChangeActivationThreshold() uint32 { return 8 }
+func (c testConditionChecker) MinerConfirmationWindow() uint32       { return 10 }
+
+func (c testConditionChecker) Condition(node *chainutil.BlockNode) (bool, error) {
+	return node.Data.GetVersion() == 1, nil
+}
+
+// TestThresholdStateTransitions ensures the threshold state of a rule change
+// moves through its states at the boundaries of the confirmation windows
+// according to the median time of the blocks and their votes.
+func TestThresholdStateTransitions(t *testing.T) {
+	t.Parallel()
+
+	// The blocks are 10 seconds apart, so the median time of the block
+	// at height h is the time of the block at height h-5.
+	blockTime := func(height int32) int64 {
+		return 1000 + int64(height)*10
+	}
+
+	tests := []struct {
+		name    string
+		checker testConditionChecker
+
+		// votes returns the number of votes cast in the window ending
+		// at the passed height.
+		votes func(height int32) int
+
+		// want are the states of the blocks after the last block of
+		// each window.
+		want []ThresholdState
+	}{{
+		name:    "activated",
+		checker: testConditionChecker{uint64(blockTime(15)), uint64(blockTime(1000))},
+		votes: func(height int32) int {
+			if height == 39 {
+				return 10
+			}
+			return 0
+		},
+		want: []ThresholdState{ThresholdDefined, ThresholdDefined,
+			ThresholdStarted, ThresholdLockedIn, ThresholdActive,
+			ThresholdActive},
+	}, {
+		name:    "locked in at the threshold",
+		checker: testConditionChecker{uint64(blockTime(15)), uint64(blockTime(1000))},
+		votes: func(height int32) int {
+			if height == 49 {
+				return 8
+			}
+			return 7
+		},
+		want: []ThresholdState{ThresholdDefined, ThresholdDefined,
+			ThresholdStarted, ThresholdStarted, ThresholdLockedIn,
+			ThresholdActive},
+	}, {
+		name:    "failed",
+		checker: testConditionChecker{uint64(blockTime(15)), uint64(blockTime(45))},
+		votes: func(height int32) int {
+			if height >= 59 {
+				return 10
+			}
+			return 7
+		},
+		want: []ThresholdState{ThresholdDefined, ThresholdDefined,
+			ThresholdStarted, ThresholdStarted, ThresholdStarted,
+			ThresholdFailed, ThresholdFailed},
+	}, {
+		name:    "expired when started",
+		checker: testConditionChecker{uint64(blockTime(15)), uint64(blockTime(20))},
+		votes: func(height int32) int {
+			return 10
+		},
+		want: []ThresholdState{ThresholdDefined, ThresholdDefined,
+			ThresholdFailed, ThresholdFailed},
+	}}
+
+	b := &BlockChain{}
+	for _, test := range tests {
+		// Build the chain window by window, voting with the last
+		// blocks of each window.
+		var tip *chainutil.BlockNode
+		cache := &newThresholdCaches(1)[0]
+		for i, want := range test.want {
+			last := int32(i*10 + 9)
+			votes := test.votes(last)
+			for height := last - 9; height <= last; height++ {
+				node := &chainutil.BlockNode{
+					Parent: tip,
+					Hash:   chainhash.Hash{byte(height), byte(height >> 8), 1},
+					Height: height,
+					Data:   &testNodeData{timestamp: blockTime(height)},
+				}
+				if last-height < int32(votes) {
+					node.Data.(*testNodeData).version = 1
+				}
+				tip = node
+			}
+
+			state, err := b.thresholdState(tip, test.checker, cache)
+			if err != nil {
+				t.Fatalf("%s: thresholdState at height %d: "+
+					"unexpected error %v", test.name, last, err)
+			}
+			if state != want {
+				t.Errorf("%s: thresholdState at height %d: got "+
+					"%v, want %v", test.name, last, state, want)
+			}
+		}
+	}
+}
+
+// TestContractVersion ensures contracts see the raw block version until the
+// aggregate signature deployment is active, and the version without its votes
+// afterwards.
+func TestContractVersion(t *testing.T) {
+	t.Parallel()
+
+	params := chaincfg.MainNetParams
+	params.RuleChangeActivationThreshold = 8
+	params.MinerConfirmationWindow = 10
+	params.Deployments[chaincfg.DeploymentAggregateSig].StartTime = 1150
+	params.Deployments[chaincfg.DeploymentAggregateSig].ExpireTime = 10000
+	b := &BlockChain{
+		ChainParams:      &params,
+		deploymentCaches: newThresholdCaches(chaincfg.DefinedDeployments),
+	}
+
+	// The blocks of the window ending at height 39 vote for the
+	// deployment, which is active from height 50.
+	nodes := make([]*chainutil.BlockNode, 0, 50)
+	var tip *chainutil.BlockNode
+	for height := int32(0); height < 50; height++ {
+		version := uint32(0x60000)
+		if height >= 30 && height < 40 {
+			version |= 0x1
+		}
+		tip = &chainutil.BlockNode{
+			Parent: tip,
+			Hash:   chainhash.Hash{byte(height), 2},
+			Height: height,
+			Data: &testNodeData{
+				timestamp: 1000 + int64(height)*10,
+				version:   version,
+			},
+		}
+		nodes = append(nodes, tip)
+	}
+
+	const version = 0x60001
+	if got := b.contractVersion(nodes[48], version); got != version {
+		t.Errorf("contractVersion before activation: got %x, want %x",
+			got, version)
+	}
+	if got := b.contractVersion(nodes[49], version); got != 0x60000 {
+		t.Errorf("contractVersion after activation: got %x, want %x",
+			got, 0x60000)
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+const (
+	// DefaultUtxoCacheMaxSize is the suggested maximum size in bytes of
+	// the utxo cache.
+	DefaultUtxoCacheMaxSize = 250 * 1024 * 1024
+
+	// utxoFlushInterval is the longest time the changes to the utxo set
+	// are kept in the utxo cache before being flushed to the database.
+	utxoFlushInterval = 5 * time.Minute
+
+	// utxoCacheEntryOverhead is the approximate memory used by a cached
+	// utxo entry besides its public key script, including the outpoint
+	// and the map overhead.
+	utxoCacheEntryOverhead = 128
+)
+
+// utxoCacheEntry is a utxo entry held in the utxo cache.  A fresh entry does
+// not exist in the database, so it is simply dropped when spent, and a dirty
+// entry differs from the database and has to be written on the next flush.
+// A spent entry which is not fresh is kept until flushed so it is deleted from
+// the database.
+type utxoCacheEntry struct {
+	entry *viewpoint.UtxoEntry
+	fresh bool
+	dirty bool
+}
+
+// utxoCache is an in-memory cache of the utxo set layered between the utxo
+// views and the utxo set in the database.  The changes made to the utxo set
+// by connected and disconnected blocks are kept in the cache and written to
+// the database in large batches, together with a flush marker recording the
+// block the utxo set in the database is at.  The best chain state is still
+// written for every block, so after a crash the blocks after the flush marker
+// are replayed to rebuild the cache.
+//
+// The cache is similar to the cache of the ffldb database driver, except that
+// it works at the level of utxo entries, so they need not be serialized and
+// deserialized again while cached.
+type utxoCache struct {
+	mtx           sync.RWMutex
+	maxSize       uint64
+	size          uint64
+	entries       map[wire.OutPoint]*utxoCacheEntry
+	flushedHeight int32
+	lastFlush     time.Time
+}
+
+// newUtxoCache returns a new empty utxo cache with the passed maximum size in
+// bytes.  A cache with a zero maximum size is flushed on every block.
+func newUtxoCache(maxSize uint64) *utxoCache {
+	return &utxoCache{
+		maxSize:   maxSize,
+		entries:   make(map[wire.OutPoint]*utxoCacheEntry),
+		lastFlush: time.Now(),
+	}
+}
+
+// entrySize returns the approximate memory used by the passed cached entry.
+func entrySize(entry *viewpoint.UtxoEntry) uint64 {
+	return utxoCacheEntryOverhead + uint64(len(entry.PkScript()))
+}
+
+// lookupEntry returns the cached entry for the passed outpoint and whether it
+// is cached at all.  A spent entry is returned as nil.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) lookupEntry(outpoint wire.OutPoint) (*viewpoint.UtxoEntry, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	cached, ok := c.entries[outpoint]
+	if !ok {
+		return nil, false
+	}
+	if cached.entry.IsSpent() {
+		return nil, true
+	}
+	return cached.entry.Clone(), true
+}
+
+// fetchEntries adds a copy of the cached entries of the passed outpoints to
+// the view, unless the view already has them.  Cached spent entries are added
+// as well so they are not fetched from the database.  It returns the
+// outpoints which are not cached and must be fetched from the database.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntries(view *viewpoint.UtxoViewpoint, outpoints map[wire.OutPoint]struct{}) map[wire.OutPoint]struct{} {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	needed := make(map[wire.OutPoint]struct{}, len(outpoints))
+	entries := view.Entries()
+	for outpoint := range outpoints {
+		if _, ok := entries[outpoint]; ok {
+			continue
+		}
+		cached, ok := c.entries[outpoint]
+		if !ok {
+			needed[outpoint] = struct{}{}
+			continue
+		}
+		entries[outpoint] = cached.entry.Clone()
+	}
+	return needed
+}
+
+// fetchBlockEntries adds a copy of the cached entries of the utxos spent and
+// created by the passed block to the view, so the view does not fetch stale
+// entries from the database when the block is connected or disconnected.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchBlockEntries(view *viewpoint.UtxoViewpoint, block *btcutil.Block) {
+	outpoints := make(map[wire.OutPoint]struct{})
+	for i, tx := range block.Transactions() {
+		if i != 0 {
+			for _, txIn := range tx.MsgTx().TxIn {
+				if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+					continue
+				}
+				outpoints[txIn.PreviousOutPoint] = struct{}{}
+			}
+		}
+		prevOut := wire.OutPoint{Hash: *tx.Hash()}
+		for txOutIdx := range tx.MsgTx().TxOut {
+			prevOut.Index = uint32(txOutIdx)
+			outpoints[prevOut] = struct{}{}
+		}
+	}
+	c.fetchEntries(view, outpoints)
+}
+
+// utxoCacheUndo records the state of the cache entries replaced by a commit,
+// so the commit can be undone when the database update of the block fails.
+// The entries which were not cached are recorded as nil.
+type utxoCacheUndo struct {
+	entries map[wire.OutPoint]*utxoCacheEntry
+	size    uint64
+}
+
+// commit records the entries of the view, as left by connecting or
+// disconnecting the block at the passed height, in the cache.  When a block is
+// connected, unspent entries created at a lower height were only loaded from
+// the database and are left alone.  It returns what is needed to undo the
+// commit.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) commit(view *viewpoint.UtxoViewpoint, height int32, connected bool) *utxoCacheUndo {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	undo := &utxoCacheUndo{
+		entries: make(map[wire.OutPoint]*utxoCacheEntry),
+		size:    c.size,
+	}
+	for outpoint, entry := range view.Entries() {
+		if entry == nil {
+			continue
+		}
+
+		cached, ok := c.entries[outpoint]
+		if ok {
+			prev := *cached
+			undo.entries[outpoint] = &prev
+		}
+		if !ok {
+			spent := entry.IsSpent()
+			if connected && !spent && entry.BlockHeight() != height {
+				continue
+			}
+
+			// A new output of a connected block is not in the
+			// database since it would be in the cache otherwise.
+			undo.entries[outpoint] = nil
+			cached = &utxoCacheEntry{
+				fresh: connected && !spent,
+			}
+			c.entries[outpoint] = cached
+		} else {
+			c.size -= entrySize(cached.entry)
+		}
+
+		cached.entry = entry.Clone()
+		cached.dirty = true
+		if cached.fresh && cached.entry.IsSpent() {
+			delete(c.entries, outpoint)
+			continue
+		}
+		c.size += entrySize(cached.entry)
+	}
+	return undo
+}
+
+// rollback undoes a commit, restoring the entries it replaced.  Nothing else
+// may have been committed to the cache since.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) rollback(undo *utxoCacheUndo) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, prev := range undo.entries {
+		if prev == nil {
+			delete(c.entries, outpoint)
+			continue
+		}
+		c.entries[outpoint] = prev
+	}
+	c.size = undo.size
+}
+
+// needsFlush returns whether the cache has grown beyond its maximum size or
+// has not been flushed for too long.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) needsFlush() bool {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.size > c.maxSize || time.Since(c.lastFlush) > utxoFlushInterval
+}
+
+// flush writes the dirty entries of the cache to the utxo set in the database
+// and updates the flush marker to the passed block, whose utxo set is the
+// state of the cache.  The cache is not modified, so markFlushed must be
+// called once the database transaction is committed.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) flush(dbTx database.Tx, hash *chainhash.Hash) error {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	utxoBucket := dbTx.Metadata().Bucket(UtxoSetBucketName)
+	for outpoint, cached := range c.entries {
+		if !cached.dirty {
+			continue
+		}
+
+		key := *viewpoint.OutpointKey(outpoint)
+		if cached.entry.IsSpent() {
+			if err := utxoBucket.Delete(key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		serialized, err := viewpoint.SerializeUtxoEntry(cached.entry)
+		if err != nil {
+			return err
+		}
+		if err := utxoBucket.Put(key, serialized); err != nil {
+			return err
+		}
+	}
+
+	return dbPutUtxoFlushMarker(dbTx, hash)
+}
+
+// markFlushed marks the entries of the cache as written to the database at
+// the passed height.  Spent entries are dropped, and the whole cache is
+// emptied when it is still over its maximum size.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) markFlushed(height int32) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, cached := range c.entries {
+		if cached.entry.IsSpent() {
+			c.size -= entrySize(cached.entry)
+			delete(c.entries, outpoint)
+			continue
+		}
+		cached.fresh = false
+		cached.dirty = false
+	}
+	if c.size > c.maxSize {
+		c.entries = make(map[wire.OutPoint]*utxoCacheEntry)
+		c.size = 0
+	}
+
+	c.flushedHeight = height
+	c.lastFlush = time.Now()
+}
+
+// fetchInputUtxos loads the utxos referenced by the inputs of the passed block
+// into the view, taking them from the utxo cache when cached.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) fetchInputUtxos(views *viewpoint.ViewPointSet, block *btcutil.Block) error {
+	b.utxoCache.fetchBlockEntries(views.Utxo, block)
+	return views.FetchInputUtxos(block)
+}
+
+// fetchUtxosMain loads the utxos of the passed outpoints into the view, taking
+// them from the utxo cache when cached.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) fetchUtxosMain(view *viewpoint.UtxoViewpoint, outpoints map[wire.OutPoint]struct{}) error {
+	needed := b.utxoCache.fetchEntries(view, outpoints)
+	if len(needed) == 0 {
+		return nil
+	}
+	return view.FetchUtxosMain(b.db, needed)
+}
+
+// FetchUtxosMain loads the utxos of the passed outpoints into the view, taking
+// them from the utxo cache when cached.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchUtxosMain(view *viewpoint.UtxoViewpoint, outpoints map[wire.OutPoint]struct{}) error {
+	b.ChainLock.RLock()
+	defer b.ChainLock.RUnlock()
+
+	return b.fetchUtxosMain(view, outpoints)
+}
+
+// flushUtxoCache writes all the changes in the utxo cache to the database.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) flushUtxoCache() error {
+	tip := b.BestChain.Tip()
+	err := b.db.Update(func(dbTx database.Tx) error {
+		return b.utxoCache.flush(dbTx, &tip.Hash)
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoCache.markFlushed(tip.Height)
+	return nil
+}
+
+// FlushUtxoCache writes all the changes in the utxo cache to the database.  It
+// should be called before shutting down so the blocks connected since the last
+// flush need not be replayed on the next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushUtxoCache() error {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	return b.flushUtxoCache()
+}
+
+// initUtxoCache brings the utxo cache to the state of the best chain by
+// replaying the blocks connected after the last flush of the cache, which were
+// not written to the utxo set in the database when the node stopped without
+// flushing the cache.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initUtxoCache() error {
+	tip := b.BestChain.Tip()
+
+	var marker *chainhash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		marker = dbFetchUtxoFlushMarker(dbTx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A database without a flush marker predates the cache and is at the
+	// best chain.
+	if marker == nil {
+		return b.flushUtxoCache()
+	}
+
+	node := b.index.LookupNode(marker)
+	if node == nil || !b.BestChain.Contains(node) {
+		return AssertError(fmt.Sprintf("utxo set flushed at block %v "+
+			"which is not in the main chain", marker))
+	}
+	if node.Height == tip.Height {
+		b.utxoCache.flushedHeight = tip.Height
+		return nil
+	}
+
+	log.Infof("Replaying %d blocks since the last utxo cache flush at "+
+		"height %d", tip.Height-node.Height, node.Height)
+
+	for height := node.Height + 1; height <= tip.Height; height++ {
+		n := b.BestChain.NodeByHeight(height)
+		var block *btcutil.Block
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		views := b.NewViewPointSet()
+		views.SetBestHash(&n.Parent.Hash)
+		stxos := make([]viewpoint.SpentTxOut, 0, block.CountSpentOutputs())
+		if err := b.connectBlockUtxos(views, block, &stxos); err != nil {
+			return err
+		}
+		if err := b.checkReplayedSpends(block, stxos); err != nil {
+			return err
+		}
+		b.utxoCache.commit(views.Utxo, height, true)
+	}
+
+	return b.flushUtxoCache()
+}
+
+// connectBlockUtxos loads the utxos spent by the passed block into the view
+// and connects the transactions of the block to it, appending the outputs they
+// spend to stxos.  The transactions of a stored block are those left by the
+// execution of its contracts, including the outputs the contracts created, so
+// this is how a block whose contracts were already executed is connected.  The
+// contracts are not run again, since the contract state in the database is
+// already past the block.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) connectBlockUtxos(views *viewpoint.ViewPointSet, block *btcutil.Block, stxos *[]viewpoint.SpentTxOut) error {
+	if err := b.fetchInputUtxos(views, block); err != nil {
+		return err
+	}
+	return views.ConnectTransactions(block, stxos)
+}
+
+// checkReplayedSpends ensures the outputs spent by a block replayed into the
+// utxo cache are those recorded in its spend journal entry when the block was
+// connected, so the replay did not diverge from the utxo set the block was
+// connected to.
+func (b *BlockChain) checkReplayedSpends(block *btcutil.Block, stxos []viewpoint.SpentTxOut) error {
+	var journal []viewpoint.SpentTxOut
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		journal, err = dbFetchSpendJournalEntry(dbTx, block)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var replayed, recorded [][]byte
+	if err := spentTxOuts(block, stxos, func(element []byte) {
+		replayed = append(replayed, element)
+	}); err != nil {
+		return err
+	}
+	if err := spentTxOuts(block, journal, func(element []byte) {
+		recorded = append(recorded, element)
+	}); err != nil {
+		return err
+	}
+	for i := range replayed {
+		if !bytes.Equal(replayed[i], recorded[i]) {
+			return AssertError(fmt.Sprintf("replayed block %v "+
+				"spends output %d differing from its spend "+
+				"journal entry", block.Hash(), i))
+		}
+	}
+	return nil
+}
+
+// dbPutViewsExceptUtxos uses an existing database transaction to update the
+// views other than the utxo view, whose changes are kept in the utxo cache.
+func dbPutViewsExceptUtxos(dbTx database.Tx, views *viewpoint.ViewPointSet) error {
+	utxos := views.Utxo
+	views.Utxo = viewpoint.NewUtxoViewpoint()
+	err := viewpoint.DbPutViews(dbTx, views)
+	views.Utxo = utxos
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// TestUtxoCacheCommit ensures the utxo cache tracks fresh and dirty entries
+// as blocks are connected and flushed.
+func TestUtxoCacheCommit(t *testing.T) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 1000}}, PkScript: make([]byte, 25)})
+	msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 2000}}, PkScript: make([]byte, 25)})
+	tx := btcutil.NewTx(msgTx)
+	first := wire.OutPoint{Hash: *tx.Hash(), Index: 0}
+	second := wire.OutPoint{Hash: *tx.Hash(), Index: 1}
+
+	// Connecting the block creating the outputs adds them as fresh.
+	cache := newUtxoCache(DefaultUtxoCacheMaxSize)
+	view := viewpoint.NewUtxoViewpoint()
+	view.AddTxOuts(tx, 10)
+	cache.commit(view, 10, true)
+	for _, outpoint := range []wire.OutPoint{first, second} {
+		cached := cache.entries[outpoint]
+		if cached == nil || !cached.fresh || !cached.dirty {
+			t.Fatalf("commit: output %v not cached as fresh and dirty",
+				outpoint)
+		}
+	}
+
+	// Outputs of earlier blocks loaded by a later block are left alone.
+	cache.commit(view, 11, true)
+	if len(cache.entries) != 2 {
+		t.Fatalf("commit: got %d entries, want 2", len(cache.entries))
+	}
+
+	// A fresh output which is spent is dropped.
+	view.LookupEntry(first).Spend()
+	cache.commit(view, 11, true)
+	if _, ok := cache.entries[first]; ok {
+		t.Fatalf("commit: spent fresh output still cached")
+	}
+	if entry, ok := cache.lookupEntry(second); !ok || entry == nil {
+		t.Fatalf("lookupEntry: unspent output not found")
+	}
+
+	// Once flushed, an output spent is kept to be deleted on the next
+	// flush.
+	cache.markFlushed(11)
+	cached := cache.entries[second]
+	if cached == nil || cached.fresh || cached.dirty {
+		t.Fatalf("markFlushed: output not marked as flushed")
+	}
+	view.LookupEntry(second).Spend()
+	cache.commit(view, 12, true)
+	cached = cache.entries[second]
+	if cached == nil || !cached.dirty || !cached.entry.IsSpent() {
+		t.Fatalf("commit: spent flushed output not kept as dirty")
+	}
+	if entry, ok := cache.lookupEntry(second); !ok || entry != nil {
+		t.Fatalf("lookupEntry: spent output returned as unspent")
+	}
+
+	// A view fetching a cached output gets the cached state.
+	fetched := viewpoint.NewUtxoViewpoint()
+	needed := cache.fetchEntries(fetched, map[wire.OutPoint]struct{}{
+		first: {}, second: {},
+	})
+	if _, ok := needed[first]; !ok || len(needed) != 1 {
+		t.Fatalf("fetchEntries: got needed outputs %v, want only %v",
+			needed, first)
+	}
+	if entry := fetched.LookupEntry(second); entry == nil || !entry.IsSpent() {
+		t.Fatalf("fetchEntries: cached spent output not added to view")
+	}
+
+	cache.markFlushed(12)
+	if len(cache.entries) != 0 || cache.size != 0 {
+		t.Fatalf("markFlushed: got %d entries of size %d, want none",
+			len(cache.entries), cache.size)
+	}
+	if cache.flushedHeight != 12 {
+		t.Fatalf("markFlushed: got flushed height %d, want 12",
+			cache.flushedHeight)
+	}
+}
+
+// TestUtxoCacheRollback ensures undoing a commit restores the cached entries
+// it replaced and drops those it added.
+func TestUtxoCacheRollback(t *testing.T) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 1000}}, PkScript: make([]byte, 25)})
+	tx := btcutil.NewTx(msgTx)
+	flushed := wire.OutPoint{Hash: *tx.Hash(), Index: 0}
+
+	cache := newUtxoCache(DefaultUtxoCacheMaxSize)
+	view := viewpoint.NewUtxoViewpoint()
+	view.AddTxOuts(tx, 10)
+	cache.commit(view, 10, true)
+	cache.markFlushed(10)
+	size := cache.size
+
+	// The next block spends the flushed output and creates a new one.
+	spend := wire.NewMsgTx(wire.TxVersion)
+	spend.AddTxIn(wire.NewTxIn(&flushed, 0))
+	spend.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: 900}}, PkScript: make([]byte, 25)})
+	spendTx := btcutil.NewTx(spend)
+	created := wire.OutPoint{Hash: *spendTx.Hash(), Index: 0}
+	view.LookupEntry(flushed).Spend()
+	view.AddTxOuts(spendTx, 11)
+	undo := cache.commit(view, 11, true)
+	if cached := cache.entries[flushed]; cached == nil || !cached.dirty {
+		t.Fatalf("commit: spent output not marked dirty")
+	}
+
+	cache.rollback(undo)
+	if _, ok := cache.entries[created]; ok {
+		t.Fatalf("rollback: created output still cached")
+	}
+	cached := cache.entries[flushed]
+	if cached == nil || cached.dirty || cached.entry.IsSpent() {
+		t.Fatalf("rollback: spent output not restored")
+	}
+	if cache.size != size {
+		t.Fatalf("rollback: got size %d, want %d", cache.size, size)
+	}
+}
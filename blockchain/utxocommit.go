@@ -0,0 +1,150 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"encoding/binary"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// utxoCommitElement returns the element of the utxo set commitment for an
+// unspent output.  It is the outpoint followed by the output serialized as in
+// the spend journal, so an entry of the utxo set, a spent output and a newly
+// created output all map to the same element.
+func utxoCommitElement(op *wire.OutPoint, stxo *viewpoint.SpentTxOut) []byte {
+	if stxo.TokenType&2 == 2 && stxo.Rights == nil {
+		s := *stxo
+		s.Rights = &chainhash.Hash{}
+		stxo = &s
+	}
+
+	element := make([]byte, chainhash.HashSize+4+spentTxOutSerializeSize(stxo))
+	copy(element, op.Hash[:])
+	binary.LittleEndian.PutUint32(element[chainhash.HashSize:], op.Index)
+	putSpentTxOut(element[chainhash.HashSize+4:], stxo)
+	return element
+}
+
+// utxoEntryElement returns the element of the utxo set commitment for an entry
+// of the utxo set.
+func utxoEntryElement(op *wire.OutPoint, entry *viewpoint.UtxoEntry) []byte {
+	return utxoCommitElement(op, &viewpoint.SpentTxOut{
+		Amount:     entry.Amount,
+		PkScript:   entry.PkScript(),
+		Height:     entry.BlockHeight(),
+		IsCoinBase: entry.IsCoinBase(),
+		TokenType:  entry.TokenType,
+		Rights:     entry.Rights,
+	})
+}
+
+// txOutElements calls the passed function with the outpoint and the element
+// of each output created by the transaction at the passed height.
+func txOutElements(tx *btcutil.Tx, height int32, coinbase bool, f func(op wire.OutPoint, element []byte)) {
+	op := wire.OutPoint{Hash: *tx.Hash()}
+	for j, txOut := range tx.MsgTx().TxOut {
+		if txOut.IsSeparator() {
+			continue
+		}
+		op.Index = uint32(j)
+		f(op, utxoCommitElement(&op, &viewpoint.SpentTxOut{
+			Amount:     txOut.Value,
+			PkScript:   txOut.PkScript,
+			Height:     height,
+			IsCoinBase: coinbase,
+			TokenType:  txOut.TokenType,
+			Rights:     txOut.Rights,
+		}))
+	}
+}
+
+// createdTxOuts calls the passed function with the element of each output
+// created by the transactions of the block at the passed height.
+func createdTxOuts(txs []*btcutil.Tx, height int32, f func([]byte)) {
+	for i, tx := range txs {
+		txOutElements(tx, height, i == 0, func(_ wire.OutPoint, element []byte) {
+			f(element)
+		})
+	}
+}
+
+// spentTxOuts calls the passed function with the element of each output spent
+// by the block, given the spend journal entry of the block.
+func spentTxOuts(block *btcutil.Block, stxos []viewpoint.SpentTxOut, f func([]byte)) error {
+	// The coinbase transaction spends nothing.
+	var i int
+	for _, tx := range block.MsgBlock().Transactions[1:] {
+		for _, txIn := range tx.TxIn {
+			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			if i >= len(stxos) {
+				return AssertError("spend journal entry has fewer " +
+					"outputs than spent by the block")
+			}
+			f(utxoCommitElement(&txIn.PreviousOutPoint, &stxos[i]))
+			i++
+		}
+	}
+	if i != len(stxos) {
+		return AssertError("spend journal entry has more outputs than " +
+			"spent by the block")
+	}
+	return nil
+}
+
+// connectUtxoCommitment returns the utxo set commitment after connecting the
+// passed block at the passed height, given the commitment before it and the
+// outputs the block spends.
+func connectUtxoCommitment(m *MuHash, block *btcutil.Block, height int32, stxos []viewpoint.SpentTxOut) (*MuHash, error) {
+	m = m.Clone()
+	if err := spentTxOuts(block, stxos, m.Remove); err != nil {
+		return nil, err
+	}
+	createdTxOuts(block.Transactions(), height, m.Add)
+	return m, nil
+}
+
+// disconnectUtxoCommitment returns the utxo set commitment after
+// disconnecting the passed block at the passed height, given the commitment
+// with it and the outputs the block spends.
+func disconnectUtxoCommitment(m *MuHash, block *btcutil.Block, height int32, stxos []viewpoint.SpentTxOut) (*MuHash, error) {
+	m = m.Clone()
+	createdTxOuts(block.Transactions(), height, m.Remove)
+	if err := spentTxOuts(block, stxos, m.Add); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// dbComputeUtxoCommitment computes the utxo set commitment from the entries of
+// the utxo set.  It is used when the commitment is not stored with the best
+// chain state, such as for a database created by an older version.
+func dbComputeUtxoCommitment(dbTx database.Tx) (*MuHash, error) {
+	m := NewMuHash()
+	utxoBucket := dbTx.Metadata().Bucket(UtxoSetBucketName)
+	if utxoBucket == nil {
+		return m, nil
+	}
+	cursor := utxoBucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		entry, err := viewpoint.DeserializeUtxoEntry(cursor.Value())
+		if err != nil {
+			return nil, err
+		}
+		if entry.IsSpent() {
+			continue
+		}
+
+		op := viewpoint.Key2Outpoint(cursor.Key())
+		m.Add(utxoEntryElement(&op, entry))
+	}
+	return m, nil
+}
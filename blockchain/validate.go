@@ -336,32 +336,22 @@ func (b *BlockChain) Rotation(hash chainhash.Hash) int32 {
 	return rotate
 }
 
-// checkProofOfWork ensures the block header bits which indicate the target
-// difficulty is in min/max range and that the block hash is less than the
-// target difficulty as claimed.
-//
-// The flags modify the behavior of this function as follows:
-//  - BFNoPoWCheck: The check to ensure the block hash is less than the target
-//    difficulty is not performed.
-
-// if return value is nil,false, the block is ok. if nil,true, it may be added as orphan
-// but can not be connected. if err,_, it is a bad block and should be discarded
-func (b *BlockChain) checkProofOfWork(block *btcutil.Block, parent *chainutil.BlockNode, powLimit *big.Int, flags BehaviorFlags) (error, bool) {
+// rotationAt returns the height of the last rotation in the miner chain as of
+// the passed node, which may be on a side chain.  It returns false when the
+// node does not fork from the best chain.
+func (b *BlockChain) rotationAt(node *chainutil.BlockNode) (uint32, bool) {
 	best := b.BestSnapshot()
-	//	bits := best.Bits
 	rotate := best.LastRotation
 
-	header := &block.MsgBlock().Header
-
-	if parent.Hash != best.Hash {
-		pn := b.NodeByHash(&parent.Hash)
+	if node.Hash != best.Hash {
+		pn := b.NodeByHash(&node.Hash)
 		fork := b.FindFork(pn)
 
 		if fork == nil {
-			return nil, true
+			return 0, false
 		}
 
-		// parent is not the tip, go back to find correct rotation
+		// node is not the tip, go back to find correct rotation
 		for p := b.BestChain.Tip(); p != nil && p != fork; p = b.ParentNode(p) {
 			switch {
 			case p.Data.GetNonce() > 0:
@@ -382,6 +372,27 @@ func (b *BlockChain) checkProofOfWork(block *btcutil.Block, parent *chainutil.Bl
 		}
 	}
 
+	return rotate, true
+}
+
+// checkProofOfWork ensures the block header bits which indicate the target
+// difficulty is in min/max range and that the block hash is less than the
+// target difficulty as claimed.
+//
+// The flags modify the behavior of this function as follows:
+//  - BFNoPoWCheck: The check to ensure the block hash is less than the target
+//    difficulty is not performed.
+
+// if return value is nil,false, the block is ok. if nil,true, it may be added as orphan
+// but can not be connected. if err,_, it is a bad block and should be discarded
+func (b *BlockChain) checkProofOfWork(block *btcutil.Block, parent *chainutil.BlockNode, powLimit *big.Int, flags BehaviorFlags) (error, bool) {
+	rotate, ok := b.rotationAt(parent)
+	if !ok {
+		return nil, true
+	}
+
+	header := &block.MsgBlock().Header
+
 	if header.Nonce > 0 {
 		s, err := b.Miners.BlockByHeight(int32(rotate))
 		if err != nil || s == nil {
@@ -480,7 +491,7 @@ func (b *BlockChain) checkProofOfWork(block *btcutil.Block, parent *chainutil.Bl
 			return fmt.Errorf("Unexpected flags"), true
 		}
 
-		if len(block.MsgBlock().Transactions[0].SignatureScripts) <= wire.CommitteeSigs {
+		if wire.NumBlockSigners(block.MsgBlock().Transactions[0]) < wire.CommitteeSigs {
 			return fmt.Errorf("Insufficient signature"), false
 		}
 		if len(block.MsgBlock().Transactions[0].SignatureScripts[1]) < btcec.PubKeyBytesLenCompressed {
@@ -562,6 +573,34 @@ func (b *BlockChain) checkProofOfWork(block *btcutil.Block, parent *chainutil.Bl
 		tbr := make([]int, 0, 3)
 
 		for k, sign := range block.MsgBlock().Transactions[0].SignatureScripts[1:] {
+			if wire.IsVersionedBlockSig(sign) {
+				// Versioned block signatures are only valid once
+				// the deployment allowing them is active.
+				active, err := b.aggregateSigActive(parent)
+				if err != nil {
+					return err, false
+				}
+				if !active {
+					str := "aggregate block signature before " +
+						"its activation"
+					return ruleError(ErrUnexpectedBlockSig, str), false
+				}
+
+				signers, err := verifyAggregateBlockSig(sign, hash, mbs[:])
+				if err != nil {
+					return err, false
+				}
+				for _, pkh := range signers {
+					if _, ok := committee[pkh]; !ok {
+						return fmt.Errorf("Duplicated signer in aggregate signature."), false
+					}
+					delete(committee, pkh)
+					imin = imin && bytes.Compare(meme.ScriptAddress(), pkh[:]) != 0
+					nsigned++
+				}
+				continue
+			}
+
 			signer, err := btcutil.VerifySigScript(sign, hash, b.ChainParams)
 			if err != nil {
 				return err, false
@@ -1317,7 +1356,11 @@ func CheckAdditionalDefinitions(tx *btcutil.Tx, txHeight int32, views *viewpoint
 	return nil
 }
 
-func CheckTransactionIntegrity(tx *btcutil.Tx, views *viewpoint.ViewPointSet, version uint32) error {
+// CheckTransactionIntegrity checks the hash only tokens spent by the passed
+// transaction.  The inputs missing from the view are loaded with fetchUtxos,
+// which must take the utxos from the utxo cache of the chain as the utxo set
+// in the database may be behind the best chain.
+func CheckTransactionIntegrity(tx *btcutil.Tx, views *viewpoint.ViewPointSet, version uint32, fetchUtxos func(*viewpoint.UtxoViewpoint, map[wire.OutPoint]struct{}) error) error {
 	if IsCoinBase(tx) {
 		return nil
 	}
@@ -1338,8 +1381,9 @@ func CheckTransactionIntegrity(tx *btcutil.Tx, views *viewpoint.ViewPointSet, ve
 			r := make(map[wire.OutPoint]struct{})
 			r[out] = struct{}{}
 
-			fmt.Printf("Pre-fetch input %v\n", out)
-			views.Utxo.FetchUtxosMain(views.Db, r)
+			if err := fetchUtxos(views.Utxo, r); err != nil {
+				return err
+			}
 			x = views.Utxo.LookupEntry(out)
 		}
 		if x == nil {
@@ -1608,7 +1652,7 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 	//
 	// These utxo entries are needed for verification of things such as
 	// transaction inputs, counting pay-to-script-hashes, and scripts.
-	err := views.FetchInputUtxos(block)
+	independent, err := b.prefetchBlockInputs(node.Height, block, views)
 	if err != nil {
 		return err
 	}
@@ -1657,6 +1701,17 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 		runScripts = false
 	}
 
+	// Verify the signatures of the transactions spending only outputs of
+	// earlier blocks in parallel.  Those of the other transactions are
+	// verified in order with the execution of the contracts below, as
+	// their inputs may be created by the contracts.
+	if runScripts {
+		err = b.verifyBlockSigs(transactions, independent, views)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Perform several checks on the inputs for each transaction.  Also
 	// accumulate the total fees.  This could technically be combined with
 	// the loop above instead of running another loop over the transactions,
@@ -1688,6 +1743,9 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 				return op
 			})
 
+		version := b.contractVersion(node.Parent,
+			block.MsgBlock().Header.Version)
+
 		Vm.StepLimit = block.MsgBlock().Header.ContractExec
 		Vm.BlockNumber = func() uint64 {
 			return uint64(block.Height())
@@ -1695,13 +1753,13 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 		Vm.BlockTime = func() uint32 {
 			return uint32(block.MsgBlock().Header.Timestamp.Unix())
 		}
-		Vm.BlockVersion = func() uint32 { return block.MsgBlock().Header.Version }
+		Vm.BlockVersion = func() uint32 { return version }
 		//	Vm.Block = func() *btcutil.Block { return block }
 		Vm.GetCoinBase = func() *btcutil.Tx { return coinBase }
 		Vm.BlockTime = func() uint32 {
 			return uint32(block.MsgBlock().Header.Timestamp.Unix())
 		}
-		Vm.BlockVersion = func() uint32 { return block.MsgBlock().Header.Version }
+		Vm.BlockVersion = func() uint32 { return version }
 		//	} else {
 		//		oldcoinBase = transactions[0]
 	}
@@ -1712,7 +1770,7 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 	var unmached string
 
 	for i, tx := range transactions[1:] {
-		if runScripts {
+		if runScripts && !independent[i+1] {
 			err = ovm.VerifySigs(tx, b.ChainParams, 0, views)
 			if err != nil {
 				return err
@@ -1829,7 +1887,8 @@ func (b *BlockChain) checkConnectBlock(node *chainutil.BlockNode, block *btcutil
 			return err
 		}
 
-		err = CheckTransactionIntegrity(tx, views, block.MsgBlock().Header.Version)
+		err = CheckTransactionIntegrity(tx, views,
+			block.MsgBlock().Header.Version, b.fetchUtxosMain)
 		if err != nil {
 			return err
 		}
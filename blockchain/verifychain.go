@@ -0,0 +1,488 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// VerifyLevel specifies how thoroughly VerifyChain checks the chain state.
+// Each level includes the checks of the levels below it.
+type VerifyLevel int
+
+const (
+	// VerifyIndex checks the main chain block index against the blocks
+	// stored in the block files.
+	VerifyIndex VerifyLevel = iota
+
+	// VerifySanity runs the context free sanity checks of the blocks, and
+	// checks their coinbase height and the checkpoints.
+	VerifySanity
+
+	// VerifySpendJournal checks the spend journal entry of each block
+	// against the block.
+	VerifySpendJournal
+
+	// VerifyConnect disconnects the blocks from the best block on a scratch
+	// view set using their spend journal entries, and runs checkConnectBlock
+	// again as they are connected back.  The contracts are not executed,
+	// since their state can only be rolled back in the database, so the
+	// outputs they created are taken from the blocks.  It requires the miner
+	// chain.
+	VerifyConnect
+
+	// VerifyUtxoSet rebuilds the utxo set from the genesis block and
+	// compares it, along with its commitment, with the stored one.
+	VerifyUtxoSet
+)
+
+// Names of the checks reported in chain issues.
+const (
+	CheckIndex        = "index"
+	CheckBlockFile    = "blockfile"
+	CheckSanity       = "sanity"
+	CheckSpendJournal = "spendjournal"
+	CheckConnect      = "connect"
+	CheckUtxoSet      = "utxoset"
+)
+
+// maxChainIssues is the maximum number of issues listed in a chain report.
+const maxChainIssues = 1000
+
+// ChainIssue is an inconsistency found by VerifyChain.  Height and Hash
+// identify the block, or the transaction for utxo set issues.  Height is -1
+// and Hash nil when unknown.
+type ChainIssue struct {
+	Check   string
+	Height  int32
+	Hash    *chainhash.Hash
+	Message string
+}
+
+// ChainReport is the result of VerifyChain.
+type ChainReport struct {
+	Level       VerifyLevel
+	Tip         chainhash.Hash
+	TipHeight   int32
+	StartHeight int32
+
+	// Blocks is the number of blocks checked, and Pruned the number of
+	// them whose data was pruned, so only their index was checked.
+	Blocks int
+	Pruned int
+
+	// UtxoEntries is the number of entries of the stored utxo set, and
+	// UtxoCommitment its stored commitment.
+	UtxoEntries    int
+	UtxoCommitment chainhash.Hash
+
+	// NumIssues is the number of issues found.  Only the first
+	// maxChainIssues are listed in Issues.
+	NumIssues int
+	Issues    []ChainIssue
+}
+
+// addIssue records an inconsistency.
+func (r *ChainReport) addIssue(check string, height int32, hash *chainhash.Hash, format string, args ...interface{}) {
+	r.NumIssues++
+	if len(r.Issues) >= maxChainIssues {
+		return
+	}
+	var h *chainhash.Hash
+	if hash != nil {
+		h = &chainhash.Hash{}
+		*h = *hash
+	}
+	r.Issues = append(r.Issues, ChainIssue{
+		Check:   check,
+		Height:  height,
+		Hash:    h,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// verifyBlock checks the main chain block at the passed height, and returns
+// it unless its data is not available.
+func (b *BlockChain) verifyBlock(dbTx database.Tx, height int32, level VerifyLevel, r *ChainReport) *btcutil.Block {
+	hash, err := DbFetchHashByHeight(dbTx, height)
+	if err != nil {
+		r.addIssue(CheckIndex, height, nil, "no block in the main "+
+			"chain index: %v", err)
+		return nil
+	}
+	if h, err := DbFetchHeightByHash(dbTx, hash); err != nil || h != height {
+		r.addIssue(CheckIndex, height, hash, "hash index does not "+
+			"map the block to its height")
+	}
+
+	row := dbTx.Metadata().Bucket(blockIndexBucketName).Get(
+		BlockIndexKey(hash, uint32(height)))
+	if row == nil {
+		r.addIssue(CheckIndex, height, hash, "no block index row")
+		return nil
+	}
+	header, status, err := deserializeBlockRow(row)
+	if err != nil {
+		r.addIssue(CheckIndex, height, hash, "corrupt block index row: "+
+			"%v", err)
+		return nil
+	}
+	if header.BlockHash() != *hash {
+		r.addIssue(CheckIndex, height, hash, "block index row has the "+
+			"header of block %v", header.BlockHash())
+	}
+	if status.KnownInvalid() {
+		r.addIssue(CheckIndex, height, hash, "main chain block is "+
+			"marked invalid (status %#x)", byte(status))
+	}
+
+	// The blocks before the ones of an imported chain state snapshot are
+	// marked pruned without having been stored.
+	blockBytes, err := dbTx.FetchBlock(hash)
+	if IsDbBlockPrunedErr(err) || isDbBlockNotFoundErr(err) && status.Pruned() {
+		r.Pruned++
+		if !status.Pruned() {
+			r.addIssue(CheckIndex, height, hash, "pruned block is "+
+				"not marked pruned in the block index")
+		}
+		return nil
+	}
+	if err != nil {
+		if status.HaveData() {
+			r.addIssue(CheckBlockFile, height, hash, "unable to "+
+				"fetch block: %v", err)
+		}
+		return nil
+	}
+	if status.Pruned() {
+		r.addIssue(CheckIndex, height, hash, "stored block is marked "+
+			"pruned in the block index")
+	}
+	block, err := btcutil.NewBlockFromBytes(blockBytes)
+	if err != nil {
+		r.addIssue(CheckBlockFile, height, hash, "unable to "+
+			"deserialize block: %v", err)
+		return nil
+	}
+	block.SetHeight(height)
+	if *block.Hash() != *hash {
+		r.addIssue(CheckBlockFile, height, hash, "block files hold "+
+			"block %v", block.Hash())
+		return nil
+	}
+	if height > 0 {
+		prevHash, err := DbFetchHashByHeight(dbTx, height-1)
+		if err == nil && block.MsgBlock().Header.PrevBlock != *prevHash {
+			r.addIssue(CheckIndex, height, hash, "block does not "+
+				"extend the main chain block %v", prevHash)
+		}
+	}
+
+	if level < VerifySanity {
+		return block
+	}
+	if height > 0 {
+		err = checkBlockSanity(block, b.ChainParams.PowLimit,
+			b.timeSource, BFNone)
+		if err != nil {
+			r.addIssue(CheckSanity, height, hash, "%v", err)
+		}
+		coinbase := block.MsgBlock().Transactions[0].TxIn
+		if len(coinbase) == 0 || int32(coinbase[0].PreviousOutPoint.Index) != height {
+			r.addIssue(CheckSanity, height, hash, "bad block "+
+				"height in coinbase")
+		}
+	}
+	if !b.verifyCheckpoint(height, hash) {
+		r.addIssue(CheckSanity, height, hash, "block does not "+
+			"match checkpoint")
+	}
+
+	if level < VerifySpendJournal || height == 0 {
+		return block
+	}
+	if _, err := dbFetchSpendJournalEntry(dbTx, block); err != nil {
+		r.addIssue(CheckSpendJournal, height, hash, "%v", err)
+	}
+
+	return block
+}
+
+// replayConnect disconnects the main chain blocks from the best block down to
+// r.StartHeight on a scratch view set, then checks them with checkConnectBlock
+// as they are connected back, as a reorganization does before modifying the
+// chain.  The replay stops at the first block failing, since the views past it
+// are no longer those the following blocks were connected on.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) replayConnect(r *ChainReport, interrupt <-chan struct{}) error {
+	start := r.StartHeight
+	if start < 1 {
+		start = 1
+	}
+
+	views := b.NewViewPointSet()
+	views.SetBestHash(&r.Tip)
+
+	nodes := make([]*chainutil.BlockNode, 0, r.TipHeight-start+1)
+	blocks := make([]*btcutil.Block, 0, r.TipHeight-start+1)
+	for height := r.TipHeight; height >= start; height-- {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+
+		node := b.BestChain.NodeByHeight(height)
+		var block *btcutil.Block
+		var stxos []viewpoint.SpentTxOut
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, node)
+			if err != nil {
+				return err
+			}
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block)
+			return err
+		})
+		if err != nil {
+			r.addIssue(CheckConnect, height, &node.Hash, "unable to "+
+				"disconnect the block: %v", err)
+			return nil
+		}
+
+		if err := b.fetchInputUtxos(views, block); err != nil {
+			return err
+		}
+		err = views.DisconnectTransactions(b.db, block, stxos)
+		if err != nil {
+			r.addIssue(CheckConnect, height, &node.Hash, "unable to "+
+				"disconnect the block: %v", err)
+			return nil
+		}
+
+		nodes = append(nodes, node)
+		blocks = append(blocks, block)
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+
+		node, block := nodes[i], blocks[i]
+		stxos := make([]viewpoint.SpentTxOut, 0, block.CountSpentOutputs())
+		err := b.checkConnectBlock(node, block, views, &stxos, nil)
+		if err != nil {
+			r.addIssue(CheckConnect, node.Height, &node.Hash, "%v", err)
+			return nil
+		}
+
+		if node.Height%1000 == 0 {
+			log.Infof("Verifying chain: connected block %d of %d",
+				node.Height, r.TipHeight)
+		}
+	}
+	return nil
+}
+
+// rebuildUtxoSet replays the main chain from the genesis block to rebuild the
+// utxo set, checking the spend journal entries of the blocks from
+// r.StartHeight against the outputs they spend.  It returns the elements of
+// the utxo set commitment by outpoint, or nil when the chain can not be
+// replayed.
+func (b *BlockChain) rebuildUtxoSet(dbTx database.Tx, r *ChainReport, interrupt <-chan struct{}) (map[wire.OutPoint][]byte, error) {
+	utxos := make(map[wire.OutPoint][]byte)
+	var block *btcutil.Block
+	for height := int32(0); height <= r.TipHeight; height++ {
+		if interruptRequested(interrupt) {
+			return nil, errInterruptRequested
+		}
+
+		hash, err := DbFetchHashByHeight(dbTx, height)
+		if err != nil {
+			r.addIssue(CheckUtxoSet, height, nil, "unable to rebuild "+
+				"the utxo set: %v", err)
+			return nil, nil
+		}
+		blockBytes, err := dbTx.FetchBlock(hash)
+		if err == nil {
+			block, err = btcutil.NewBlockFromBytes(blockBytes)
+		}
+		if err != nil {
+			r.addIssue(CheckUtxoSet, height, hash, "unable to "+
+				"rebuild the utxo set: %v", err)
+			return nil, nil
+		}
+
+		var stxos []viewpoint.SpentTxOut
+		if height >= r.StartHeight && height > 0 {
+			stxos, _ = dbFetchSpendJournalEntry(dbTx, block)
+		}
+
+		var spent int
+		for i, tx := range block.Transactions() {
+			if i > 0 {
+				for _, txIn := range tx.MsgTx().TxIn {
+					op := txIn.PreviousOutPoint
+					if op.Hash.IsEqual(&zerohash) {
+						continue
+					}
+					element, ok := utxos[op]
+					if !ok {
+						r.addIssue(CheckUtxoSet, height, hash,
+							"block spends missing output %v", op)
+					}
+					if spent < len(stxos) && ok &&
+						!bytes.Equal(element, utxoCommitElement(&op, &stxos[spent])) {
+
+						r.addIssue(CheckSpendJournal, height,
+							hash, "spend journal entry "+
+								"does not match spent output %v",
+							op)
+					}
+					spent++
+					delete(utxos, op)
+				}
+			}
+			txOutElements(tx, height, i == 0, func(op wire.OutPoint, element []byte) {
+				utxos[op] = element
+			})
+		}
+
+		if height > 0 && height%10000 == 0 {
+			log.Infof("Rebuilding utxo set: replayed block %d of %d",
+				height, r.TipHeight)
+		}
+	}
+	return utxos, nil
+}
+
+// compareUtxoSet compares the rebuilt utxo set with the stored one and its
+// commitment.
+func (b *BlockChain) compareUtxoSet(dbTx database.Tx, utxos map[wire.OutPoint][]byte, r *ChainReport) {
+	m := NewMuHash()
+	for _, element := range utxos {
+		m.Add(element)
+	}
+	if m.Hash() != r.UtxoCommitment {
+		r.addIssue(CheckUtxoSet, r.TipHeight, nil, "utxo set "+
+			"commitment %v does not match the rebuilt utxo set %v",
+			r.UtxoCommitment, m.Hash())
+	}
+
+	cursor := dbTx.Metadata().Bucket(UtxoSetBucketName).Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		op := viewpoint.Key2Outpoint(cursor.Key())
+		entry, err := viewpoint.DeserializeUtxoEntry(cursor.Value())
+		if err != nil {
+			r.addIssue(CheckUtxoSet, -1, &op.Hash, "corrupt entry "+
+				"for %v: %v", op, err)
+			continue
+		}
+		if entry.IsSpent() {
+			continue
+		}
+		r.UtxoEntries++
+
+		element, ok := utxos[op]
+		switch {
+		case !ok:
+			r.addIssue(CheckUtxoSet, entry.BlockHeight(), &op.Hash,
+				"stored output %v is not in the rebuilt utxo set", op)
+		case !bytes.Equal(element, utxoEntryElement(&op, entry)):
+			r.addIssue(CheckUtxoSet, entry.BlockHeight(), &op.Hash,
+				"stored output %v differs from the rebuilt one", op)
+		}
+		delete(utxos, op)
+	}
+	for op := range utxos {
+		op := op
+		r.addIssue(CheckUtxoSet, -1, &op.Hash, "rebuilt output %v is "+
+			"not in the stored utxo set", op)
+	}
+}
+
+// VerifyChain checks the consistency of the stored chain state, up to the
+// passed level, for the depth blocks below the best block, or the whole main
+// chain when depth is 0.  The block index is checked against the block files,
+// the sanity of the blocks is checked, and the spend journal entries are
+// checked against their blocks.  At VerifyConnect, the blocks are disconnected
+// and connected back on a scratch view set, which is held in memory for the
+// whole range, and the rules checked when blocks are connected are run again,
+// apart from the contract execution.  At VerifyUtxoSet, the utxo set is rebuilt
+// from the genesis block, which requires an unpruned chain.  Inconsistencies
+// are listed in the returned report, and an error is only returned when the
+// checks could not be run.  The chain state lock is held for writes
+// throughout, so the flushed utxo set stays at the verified best block.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerifyChain(level VerifyLevel, depth int32, interrupt <-chan struct{}) (*ChainReport, error) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	if level >= VerifyConnect && b.Miners == nil {
+		return nil, fmt.Errorf("the connect checks need the miner chain")
+	}
+
+	// The utxo set in the database must be complete.
+	if err := b.flushUtxoCache(); err != nil {
+		return nil, err
+	}
+
+	best := b.BestSnapshot()
+	r := &ChainReport{
+		Level:          level,
+		Tip:            best.Hash,
+		TipHeight:      best.Height,
+		UtxoCommitment: best.UtxoCommitment,
+		Issues:         make([]ChainIssue, 0),
+	}
+	if depth > 0 && depth <= best.Height {
+		r.StartHeight = best.Height - depth + 1
+	}
+
+	err := b.db.View(func(dbTx database.Tx) error {
+		for height := r.StartHeight; height <= best.Height; height++ {
+			if interruptRequested(interrupt) {
+				return errInterruptRequested
+			}
+			b.verifyBlock(dbTx, height, level, r)
+			r.Blocks++
+
+			if r.Blocks%10000 == 0 {
+				log.Infof("Verifying chain: checked block %d of "+
+					"%d", height, best.Height)
+			}
+		}
+
+		if level < VerifyUtxoSet {
+			return nil
+		}
+		utxos, err := b.rebuildUtxoSet(dbTx, r, interrupt)
+		if err != nil || utxos == nil {
+			return err
+		}
+		b.compareUtxoSet(dbTx, utxos, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The replay reads the database through its own transactions.
+	if level >= VerifyConnect {
+		if err := b.replayConnect(r, interrupt); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
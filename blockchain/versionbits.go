@@ -0,0 +1,323 @@
+// Copyright (c) 2016-2017 The btcsuite developers
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math"
+
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/wire"
+)
+
+const (
+	// vbLowMask is the mask of the low 16 bits of the block version which
+	// are used for voting on deployments.  The high 16 bits are the code
+	// version.
+	vbLowMask = 0xFFFF
+
+	// vbNumBits is the total number of bits available for use with the
+	// version bits scheme.
+	vbNumBits = 16
+
+	// unknownVerNumToCheck is the number of previous blocks to consider
+	// when checking for a threshold of unknown block versions for the
+	// purposes of warning the user.
+	unknownVerNumToCheck = 100
+
+	// unknownVerWarnNum is the threshold of previous blocks that have an
+	// unknown version to use for the purposes of warning the user.
+	unknownVerWarnNum = unknownVerNumToCheck / 2
+)
+
+// ContractVersion returns the block version seen by contracts executed in a
+// block with the passed version.  When votesHidden is set, the deployment
+// votes in the low 16 bits are left out, so they don't change the outcome of
+// the contracts, and a block template executes its contracts the same way as
+// the mined block.
+func ContractVersion(version uint32, votesHidden bool) uint32 {
+	if !votesHidden {
+		return version
+	}
+	return version &^ vbLowMask
+}
+
+// contractVersion returns the block version seen by contracts executed in the
+// block with the passed version after the passed node.  The deployment votes
+// are only hidden once the aggregate signature deployment, the first voted
+// with the version bits, is active, so the contracts of the earlier blocks
+// are replayed with the raw version they were executed with.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) contractVersion(prevNode *chainutil.BlockNode, version uint32) uint32 {
+	if prevNode == nil {
+		return version
+	}
+	state, err := b.deploymentState(prevNode, chaincfg.DeploymentAggregateSig)
+	return ContractVersion(version, err == nil && state == ThresholdActive)
+}
+
+// bitConditionChecker provides a thresholdConditionChecker which can be used to
+// test whether or not a specific bit is set when it's not supposed to be
+// according to the expected version based on the known deployments and the
+// current state of the chain.  This is useful for detecting and warning about
+// unknown rule activations.
+type bitConditionChecker struct {
+	bit   uint32
+	chain *BlockChain
+}
+
+// Ensure the bitConditionChecker type implements the thresholdConditionChecker
+// interface.
+var _ thresholdConditionChecker = bitConditionChecker{}
+
+// BeginTime returns the unix timestamp for the median block time after which
+// voting on a rule change starts (at the next window).
+//
+// Since this implementation checks for unknown rules, it returns 0 so the rule
+// is always treated as active.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) BeginTime() uint64 {
+	return 0
+}
+
+// EndTime returns the unix timestamp for the median block time after which an
+// attempted rule change fails if it has not already been locked in or
+// activated.
+//
+// Since this implementation checks for unknown rules, it returns the maximum
+// possible timestamp so the rule is always treated as active.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) EndTime() uint64 {
+	return math.MaxUint64
+}
+
+// RuleChangeActivationThreshold is the number of blocks for which the condition
+// must be true in order to lock in a rule change.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) RuleChangeActivationThreshold() uint32 {
+	return c.chain.ChainParams.RuleChangeActivationThreshold
+}
+
+// MinerConfirmationWindow is the number of blocks in each threshold state
+// retarget window.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) MinerConfirmationWindow() uint32 {
+	return c.chain.ChainParams.MinerConfirmationWindow
+}
+
+// Condition returns true when the specific bit associated with the checker is
+// set and it's not supposed to be according to the expected version based on
+// the known deployments and the current state of the chain.
+//
+// This function MUST be called with the chain state lock held (for writes).
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) Condition(node *chainutil.BlockNode) (bool, error) {
+	conditionMask := uint32(1) << c.bit
+	version := node.Data.GetVersion()
+	if version&conditionMask == 0 {
+		return false, nil
+	}
+
+	expectedBits, err := c.chain.calcNextVoteBits(c.chain.ParentNode(node),
+		version)
+	if err != nil {
+		return false, err
+	}
+	return expectedBits&conditionMask == 0, nil
+}
+
+// deploymentChecker provides a thresholdConditionChecker which can be used to
+// test a specific deployment rule.  This is required for properly detecting
+// and activating consensus rule changes.
+type deploymentChecker struct {
+	deployment *chaincfg.ConsensusDeployment
+	chain      *BlockChain
+}
+
+// Ensure the deploymentChecker type implements the thresholdConditionChecker
+// interface.
+var _ thresholdConditionChecker = deploymentChecker{}
+
+// BeginTime returns the unix timestamp for the median block time after which
+// voting on a rule change starts (at the next window).
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) BeginTime() uint64 {
+	return c.deployment.StartTime
+}
+
+// EndTime returns the unix timestamp for the median block time after which an
+// attempted rule change fails if it has not already been locked in or
+// activated.
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) EndTime() uint64 {
+	return c.deployment.ExpireTime
+}
+
+// RuleChangeActivationThreshold is the number of blocks for which the condition
+// must be true in order to lock in a rule change.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) RuleChangeActivationThreshold() uint32 {
+	return c.chain.ChainParams.RuleChangeActivationThreshold
+}
+
+// MinerConfirmationWindow is the number of blocks in each threshold state
+// retarget window.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) MinerConfirmationWindow() uint32 {
+	return c.chain.ChainParams.MinerConfirmationWindow
+}
+
+// Condition returns true when the block version of the passed node is the
+// version the deployment upgrades from and has all the bits of the feature
+// mask of the deployment set.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) Condition(node *chainutil.BlockNode) (bool, error) {
+	return signalsDeployment(node.Data.GetVersion(), c.deployment), nil
+}
+
+// signalsDeployment returns whether a block with the passed version votes for
+// the passed deployment.
+func signalsDeployment(version uint32, deployment *chaincfg.ConsensusDeployment) bool {
+	mask := deployment.FeatureMask & vbLowMask
+	if mask == 0 {
+		return false
+	}
+	return version&^vbLowMask == deployment.PrevVersion &&
+		version&mask == mask
+}
+
+// calcNextVoteBits calculates the vote bits of the block after the passed
+// previous block node, given the version of the block, based on the state of
+// the deployments upgrading from that version.  Only the low 16 bits of the
+// block version are returned.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) calcNextVoteBits(prevNode *chainutil.BlockNode, version uint32) (uint32, error) {
+	// Set the appropriate bits for each actively defined rule deployment
+	// that is either in the process of being voted on, or locked in for the
+	// activation at the next threshold window change.
+	voteBits := uint32(0)
+	for id := 0; id < len(b.ChainParams.Deployments); id++ {
+		deployment := &b.ChainParams.Deployments[id]
+		if deployment.PrevVersion != version&^vbLowMask {
+			continue
+		}
+		state, err := b.deploymentState(prevNode, uint32(id))
+		if err != nil {
+			return 0, err
+		}
+		if state == ThresholdStarted || state == ThresholdLockedIn {
+			voteBits |= deployment.FeatureMask & vbLowMask
+		}
+	}
+	return voteBits, nil
+}
+
+// CalcNextVoteBits calculates the vote bits of the block after the end of the
+// current best chain with the passed version, based on the state of the
+// deployments upgrading from that version.  They are to be set in the low 16
+// bits of the block version.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcNextVoteBits(version uint32) (uint32, error) {
+	b.ChainLock.Lock()
+	defer b.ChainLock.Unlock()
+
+	return b.calcNextVoteBits(b.BestChain.Tip(), version)
+}
+
+// warnUnknownRuleActivations displays a warning when any unknown new rules are
+// either about to activate or have been activated.  This will only happen once
+// when new rules have been activated and every block for those about to be
+// activated.
+//
+// This function MUST be called with the chain state lock held (for writes)
+func (b *BlockChain) warnUnknownRuleActivations(node *chainutil.BlockNode) error {
+	// Warn if any unknown new rules are either about to activate or have
+	// already been activated.
+	for bit := uint32(0); bit < vbNumBits; bit++ {
+		checker := bitConditionChecker{bit: bit, chain: b}
+		cache := &b.warningCaches[bit]
+		state, err := b.thresholdState(b.ParentNode(node), checker, cache)
+		if err != nil {
+			return err
+		}
+
+		switch state {
+		case ThresholdActive:
+			if !b.unknownRulesWarned {
+				log.Warnf("Unknown new rules activated (bit %d)",
+					bit)
+				b.unknownRulesWarned = true
+			}
+
+		case ThresholdLockedIn:
+			window := int32(checker.MinerConfirmationWindow())
+			activationHeight := window - (node.Height % window)
+			log.Warnf("Unknown new rules are about to activate in "+
+				"%d blocks (bit %d)", activationHeight, bit)
+		}
+	}
+
+	return nil
+}
+
+// warnUnknownVersions logs a warning if a high enough percentage of the last
+// blocks have a code version newer than this one.
+//
+// This function MUST be called with the chain state lock held (for writes)
+func (b *BlockChain) warnUnknownVersions(node *chainutil.BlockNode) error {
+	// Nothing to do if already warned.
+	if b.unknownVersionsWarned {
+		return nil
+	}
+
+	// Warn if enough previous blocks have unexpected versions.
+	numUpgraded := uint32(0)
+	for i := uint32(0); i < unknownVerNumToCheck && node != nil; i++ {
+		if node.Data.GetVersion()&^vbLowMask > wire.CodeVersion&^vbLowMask {
+			numUpgraded++
+		}
+
+		node = b.ParentNode(node)
+	}
+	if numUpgraded > unknownVerWarnNum {
+		log.Warn("Unknown block versions are being accepted! This may " +
+			"mean a new code version is in use; consider upgrading.")
+		b.unknownVersionsWarned = true
+	}
+
+	return nil
+}
@@ -17,5 +17,9 @@ functionality is provided to parse signatures and public keys from
 standard formats.  It was designed for use with btcd, but should be
 general enough for other uses of elliptic curve crypto.  It was originally based
 on some initial work by ThePiachu, but has significantly diverged since then.
+
+Besides ECDSA, the package implements BIP340 Schnorr signatures and MuSig2
+(BIP327, without key tweaking), which lets a committee produce a single
+signature verifiable under the aggregate of its members' keys.
 */
 package btcec
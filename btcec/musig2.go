@@ -0,0 +1,359 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// MuSig2 lets a group of signers produce a single BIP340 signature valid
+// under an aggregate of their public keys.  It follows BIP327 without key
+// tweaking and takes two rounds once the set of signers is known:
+//
+//  1. Every signer generates a MuSigNonce and sends its public part to the
+//     others.  The public nonces are combined with AggregateMuSigNonces.
+//  2. Every signer opens a MuSigSession for the aggregate nonce and message,
+//     signs, and sends its partial signature.  Partial signatures can be
+//     checked one by one with VerifyPartial and are combined into the final
+//     SchnorrSignature with Aggregate.
+//
+// A nonce must never be used for more than one signature, Sign clears it.
+
+// MuSigPubNonceLen is the length of a serialized public nonce.  It is two
+// compressed points.
+const MuSigPubNonceLen = 2 * PubKeyBytesLenCompressed
+
+var (
+	// ErrMuSigNonceUsed is returned when signing with a nonce which has
+	// already been used.
+	ErrMuSigNonceUsed = errors.New("musig2 nonce already used")
+
+	// ErrMuSigSignerUnknown is returned when the key of a signer is not
+	// part of the aggregate key of a session.
+	ErrMuSigSignerUnknown = errors.New("musig2 signer is not part of the " +
+		"aggregate key")
+)
+
+// MuSigAggKey is the aggregate of the public keys of a group of signers.
+type MuSigAggKey struct {
+	// Q is the aggregate public key.  Signatures are valid under its x-only
+	// form.
+	Q *PublicKey
+
+	keys   [][]byte
+	coeffs []*big.Int
+}
+
+// SortPubKeys sorts the passed public keys by their compressed serialization
+// so that signers agree on a key order without coordination.
+func SortPubKeys(keys []*PublicKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].SerializeCompressed(),
+			keys[j].SerializeCompressed()) < 0
+	})
+}
+
+// AggregatePubKeys computes the MuSig2 aggregate of the passed public keys.
+// The order of the keys matters, see SortPubKeys.
+func AggregatePubKeys(keys []*PublicKey) (*MuSigAggKey, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys to aggregate")
+	}
+
+	curve := S256()
+	agg := &MuSigAggKey{
+		keys:   make([][]byte, len(keys)),
+		coeffs: make([]*big.Int, len(keys)),
+	}
+
+	for i, k := range keys {
+		agg.keys[i] = k.SerializeCompressed()
+	}
+	l := TaggedHash("KeyAgg list", agg.keys...)
+
+	// The first key different from the first one gets a coefficient of
+	// one, which saves a scalar multiplication.
+	var second []byte
+	for _, k := range agg.keys[1:] {
+		if !bytes.Equal(k, agg.keys[0]) {
+			second = k
+			break
+		}
+	}
+
+	var qx, qy *big.Int
+	for i, k := range keys {
+		if second != nil && bytes.Equal(agg.keys[i], second) {
+			agg.coeffs[i] = big.NewInt(1)
+		} else {
+			agg.coeffs[i] = hashToScalar(TaggedHash(
+				"KeyAgg coefficient", l, agg.keys[i]))
+		}
+
+		x, y := curve.ScalarMult(k.X, k.Y, intToBytes32(agg.coeffs[i]))
+		if qx == nil {
+			qx, qy = x, y
+		} else {
+			qx, qy = curve.Add(qx, qy, x, y)
+		}
+	}
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, errors.New("aggregate public key is infinity")
+	}
+
+	agg.Q = &PublicKey{Curve: curve, X: qx, Y: qy}
+	return agg, nil
+}
+
+// coeff returns the aggregation coefficient of the passed compressed key.
+func (k *MuSigAggKey) coeff(key []byte) *big.Int {
+	for i, kk := range k.keys {
+		if bytes.Equal(kk, key) {
+			return k.coeffs[i]
+		}
+	}
+	return nil
+}
+
+// MuSigNonce is the nonce of a signer for a single signing session.  Only
+// PubNonce may be shared.
+type MuSigNonce struct {
+	PubNonce [MuSigPubNonceLen]byte
+
+	k1, k2 *big.Int
+	pubKey []byte
+}
+
+// GenerateMuSigNonce generates the nonce of the owner of the passed private key
+// for signing msg under aggKey.  Besides fresh randomness, the nonce commits
+// to the key and the message so it is safe even with a weak random source.
+func GenerateMuSigNonce(priv *PrivateKey, aggKey *MuSigAggKey, msg []byte) (*MuSigNonce, error) {
+	var randBytes [32]byte
+	if _, err := rand.Read(randBytes[:]); err != nil {
+		return nil, err
+	}
+
+	curve := S256()
+	pk := priv.PubKey().SerializeCompressed()
+	sk := intToBytes32(priv.D)
+	aux := TaggedHash("MuSig/aux", randBytes[:])
+	for i := range aux {
+		aux[i] ^= sk[i]
+	}
+	aggPk := aggKey.Q.SerializeXOnly()
+
+	var msgLen [8]byte
+	binary.BigEndian.PutUint64(msgLen[:], uint64(len(msg)))
+	var k [2]*big.Int
+	for i := range k {
+		k[i] = hashToScalar(TaggedHash("MuSig/nonce", aux,
+			[]byte{byte(len(pk))}, pk,
+			[]byte{byte(len(aggPk))}, aggPk,
+			[]byte{1}, msgLen[:], msg,
+			[]byte{0, 0, 0, 0}, []byte{byte(i)}))
+		if k[i].Sign() == 0 {
+			return nil, errSchnorrZeroNonce
+		}
+	}
+
+	nonce := &MuSigNonce{k1: k[0], k2: k[1], pubKey: pk}
+	for i, ki := range k {
+		x, y := curve.ScalarBaseMult(intToBytes32(ki))
+		r := (&PublicKey{Curve: curve, X: x, Y: y}).SerializeCompressed()
+		copy(nonce.PubNonce[i*PubKeyBytesLenCompressed:], r)
+	}
+	return nonce, nil
+}
+
+// parseNoncePoint parses a compressed point of a nonce.  The all zero encoding
+// stands for the point at infinity, which may result from aggregation.
+func parseNoncePoint(b []byte) (*big.Int, *big.Int, error) {
+	if bytes.Equal(b, make([]byte, PubKeyBytesLenCompressed)) {
+		return new(big.Int), new(big.Int), nil
+	}
+	pk, err := ParsePubKey(b, S256())
+	if err != nil {
+		return nil, nil, err
+	}
+	return pk.X, pk.Y, nil
+}
+
+// serializeNoncePoint is the inverse of parseNoncePoint.
+func serializeNoncePoint(x, y *big.Int) []byte {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return make([]byte, PubKeyBytesLenCompressed)
+	}
+	return (&PublicKey{Curve: S256(), X: x, Y: y}).SerializeCompressed()
+}
+
+// AggregateMuSigNonces combines the public nonces of all signers into the
+// aggregate nonce used by the signing session.
+func AggregateMuSigNonces(pubNonces [][MuSigPubNonceLen]byte) ([MuSigPubNonceLen]byte, error) {
+	var agg [MuSigPubNonceLen]byte
+	if len(pubNonces) == 0 {
+		return agg, errors.New("no nonces to aggregate")
+	}
+
+	curve := S256()
+	for j := 0; j < 2; j++ {
+		var rx, ry *big.Int
+		for i, n := range pubNonces {
+			x, y, err := parseNoncePoint(
+				n[j*PubKeyBytesLenCompressed : (j+1)*PubKeyBytesLenCompressed])
+			if err != nil || (x.Sign() == 0 && y.Sign() == 0) {
+				return agg, fmt.Errorf("invalid public nonce of signer "+
+					"%d: %v", i, err)
+			}
+			if rx == nil {
+				rx, ry = x, y
+			} else {
+				rx, ry = curve.Add(rx, ry, x, y)
+			}
+		}
+		copy(agg[j*PubKeyBytesLenCompressed:], serializeNoncePoint(rx, ry))
+	}
+	return agg, nil
+}
+
+// MuSigSession holds the values shared by all signers of a message once the
+// nonces are aggregated.
+type MuSigSession struct {
+	aggKey *MuSigAggKey
+	msg    []byte
+
+	b      *big.Int
+	e      *big.Int
+	r      *PublicKey
+	negate bool // the final nonce has an odd y
+	g      *big.Int
+}
+
+// NewMuSigSession starts the signing of msg under aggKey with the aggregate of
+// the nonces of all signers.
+func NewMuSigSession(aggKey *MuSigAggKey, aggNonce [MuSigPubNonceLen]byte, msg []byte) (*MuSigSession, error) {
+	curve := S256()
+	r1x, r1y, err := parseNoncePoint(aggNonce[:PubKeyBytesLenCompressed])
+	if err != nil {
+		return nil, err
+	}
+	r2x, r2y, err := parseNoncePoint(aggNonce[PubKeyBytesLenCompressed:])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MuSigSession{aggKey: aggKey, msg: msg}
+	qx := aggKey.Q.SerializeXOnly()
+	s.b = hashToScalar(TaggedHash("MuSig/noncecoef", aggNonce[:], qx, msg))
+
+	bx, by := curve.ScalarMult(r2x, r2y, intToBytes32(s.b))
+	rx, ry := curve.Add(r1x, r1y, bx, by)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		rx, ry = curve.Gx, curve.Gy
+	}
+	s.r = &PublicKey{Curve: curve, X: rx, Y: ry}
+	s.negate = isOdd(ry)
+	s.e = schnorrChallenge(rx, aggKey.Q.X, msg)
+
+	s.g = big.NewInt(1)
+	if isOdd(aggKey.Q.Y) {
+		s.g.Sub(curve.N, s.g)
+	}
+	return s, nil
+}
+
+// Sign produces the partial signature of the owner of priv with the nonce it
+// generated for this session.  The nonce is cleared and can't be used again.
+func (s *MuSigSession) Sign(nonce *MuSigNonce, priv *PrivateKey) (*big.Int, error) {
+	if nonce.k1 == nil {
+		return nil, ErrMuSigNonceUsed
+	}
+	k1, k2 := nonce.k1, nonce.k2
+	nonce.k1, nonce.k2 = nil, nil
+
+	pk := priv.PubKey().SerializeCompressed()
+	if !bytes.Equal(pk, nonce.pubKey) {
+		return nil, errors.New("musig2 nonce was generated for another key")
+	}
+	a := s.aggKey.coeff(pk)
+	if a == nil {
+		return nil, ErrMuSigSignerUnknown
+	}
+
+	curve := S256()
+	if s.negate {
+		k1 = new(big.Int).Sub(curve.N, k1)
+		k2 = new(big.Int).Sub(curve.N, k2)
+	}
+
+	// s = k1 + b*k2 + e*a*g*d
+	d := new(big.Int).Mul(s.g, priv.D)
+	d.Mul(d, a)
+	d.Mul(d, s.e)
+	sig := new(big.Int).Mul(s.b, k2)
+	sig.Add(sig, k1)
+	sig.Add(sig, d)
+	sig.Mod(sig, curve.N)
+
+	if !s.VerifyPartial(sig, nonce.PubNonce, priv.PubKey()) {
+		return nil, errors.New("musig2 partial signature does not verify")
+	}
+	return sig, nil
+}
+
+// VerifyPartial checks the partial signature of the signer with the passed
+// public nonce and public key.  It returns true if the partial signature is
+// valid, false otherwise.
+func (s *MuSigSession) VerifyPartial(partial *big.Int, pubNonce [MuSigPubNonceLen]byte, pubKey *PublicKey) bool {
+	curve := S256()
+	if partial.Sign() < 0 || partial.Cmp(curve.N) >= 0 {
+		return false
+	}
+	a := s.aggKey.coeff(pubKey.SerializeCompressed())
+	if a == nil {
+		return false
+	}
+
+	r1x, r1y, err := parseNoncePoint(pubNonce[:PubKeyBytesLenCompressed])
+	if err != nil {
+		return false
+	}
+	r2x, r2y, err := parseNoncePoint(pubNonce[PubKeyBytesLenCompressed:])
+	if err != nil {
+		return false
+	}
+
+	// R = R1 + b*R2, negated with the final nonce.
+	bx, by := curve.ScalarMult(r2x, r2y, intToBytes32(s.b))
+	rx, ry := curve.Add(r1x, r1y, bx, by)
+	if s.negate {
+		ry = new(big.Int).Sub(curve.P, ry)
+	}
+
+	// s*G == R + e*a*g*P
+	c := new(big.Int).Mul(s.e, a)
+	c.Mul(c, s.g)
+	c.Mod(c, curve.N)
+	px, py := curve.ScalarMult(pubKey.X, pubKey.Y, intToBytes32(c))
+	wx, wy := curve.Add(rx, ry, px, py)
+	gx, gy := curve.ScalarBaseMult(intToBytes32(partial))
+	return gx.Cmp(wx) == 0 && gy.Cmp(wy) == 0
+}
+
+// Aggregate combines the partial signatures of all signers into a Schnorr
+// signature valid under the x-only form of the aggregate key.
+func (s *MuSigSession) Aggregate(partials []*big.Int) *SchnorrSignature {
+	sum := new(big.Int)
+	for _, p := range partials {
+		sum.Add(sum, p)
+	}
+	sum.Mod(sum, S256().N)
+	return &SchnorrSignature{R: new(big.Int).Set(s.r.X), S: sum}
+}
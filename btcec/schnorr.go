@@ -0,0 +1,215 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// These constants define the lengths of serialized Schnorr signatures and
+// x-only public keys as specified by BIP340.
+const (
+	SchnorrSigLen   = 64
+	XOnlyPubKeyLen  = 32
+	schnorrAuxLen   = 32
+	schnorrFieldLen = 32
+)
+
+var (
+	// errSchnorrZeroKey is returned when signing with a zero private key.
+	errSchnorrZeroKey = errors.New("private key is zero or exceeds the " +
+		"group order")
+
+	// errSchnorrZeroNonce is returned in the negligible case that the
+	// derived nonce is zero.
+	errSchnorrZeroNonce = errors.New("generated nonce is zero")
+)
+
+// TaggedHash implements the tagged hash of BIP340:
+// sha256(sha256(tag) || sha256(tag) || msgs...).
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+// SchnorrSignature is a type representing a BIP340 Schnorr signature.  R is
+// the x coordinate of the nonce point, whose y coordinate is always even.
+type SchnorrSignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// Serialize returns the 64-byte encoding of the signature, the x coordinate
+// of R followed by S, both big endian.
+func (sig *SchnorrSignature) Serialize() []byte {
+	b := make([]byte, 0, SchnorrSigLen)
+	b = paddedAppend(schnorrFieldLen, b, sig.R.Bytes())
+	return paddedAppend(schnorrFieldLen, b, sig.S.Bytes())
+}
+
+// IsEqual compares this SchnorrSignature instance to the one passed, returning
+// true if both signatures are equivalent.
+func (sig *SchnorrSignature) IsEqual(otherSig *SchnorrSignature) bool {
+	return sig.R.Cmp(otherSig.R) == 0 &&
+		sig.S.Cmp(otherSig.S) == 0
+}
+
+// ParseSchnorrSignature parses a 64-byte BIP340 signature.  It only checks the
+// ranges of R and S, whether R is on the curve is left to Verify.
+func ParseSchnorrSignature(sigStr []byte) (*SchnorrSignature, error) {
+	if len(sigStr) != SchnorrSigLen {
+		return nil, fmt.Errorf("malformed schnorr signature: wrong "+
+			"length %d", len(sigStr))
+	}
+
+	curve := S256()
+	r := new(big.Int).SetBytes(sigStr[:schnorrFieldLen])
+	if r.Cmp(curve.P) >= 0 {
+		return nil, errors.New("signature R is not a field element")
+	}
+	s := new(big.Int).SetBytes(sigStr[schnorrFieldLen:])
+	if s.Cmp(curve.N) >= 0 {
+		return nil, errors.New("signature S is >= curve.N")
+	}
+	return &SchnorrSignature{R: r, S: s}, nil
+}
+
+// SerializeXOnly serializes a public key in the 32-byte x-only format of
+// BIP340.  The information about the parity of Y is dropped, the key is
+// understood to be the one with the same X and an even Y.
+func (p *PublicKey) SerializeXOnly() []byte {
+	return paddedAppend(XOnlyPubKeyLen, make([]byte, 0, XOnlyPubKeyLen),
+		p.X.Bytes())
+}
+
+// ParseXOnlyPubKey parses a 32-byte x-only public key into the point with the
+// passed X and an even Y.
+func ParseXOnlyPubKey(pubKeyStr []byte) (*PublicKey, error) {
+	if len(pubKeyStr) != XOnlyPubKeyLen {
+		return nil, fmt.Errorf("malformed x-only public key: wrong "+
+			"length %d", len(pubKeyStr))
+	}
+	return liftX(new(big.Int).SetBytes(pubKeyStr))
+}
+
+// liftX returns the point with the passed X and an even Y.
+func liftX(x *big.Int) (*PublicKey, error) {
+	curve := S256()
+	if x.Cmp(curve.P) >= 0 {
+		return nil, errors.New("pubkey X parameter is >= to P")
+	}
+	y, err := decompressPoint(curve, x, false)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// intToBytes32 returns the 32-byte big endian encoding of a scalar or field
+// element.
+func intToBytes32(v *big.Int) []byte {
+	return paddedAppend(schnorrFieldLen, make([]byte, 0, schnorrFieldLen),
+		v.Bytes())
+}
+
+// hashToScalar interprets a hash as a big endian integer modulo the group
+// order.
+func hashToScalar(h []byte) *big.Int {
+	e := new(big.Int).SetBytes(h)
+	return e.Mod(e, S256().N)
+}
+
+// schnorrChallenge computes the BIP340 challenge e for nonce point x rx,
+// x-only public key px and message m.
+func schnorrChallenge(rx, px *big.Int, m []byte) *big.Int {
+	return hashToScalar(TaggedHash("BIP0340/challenge", intToBytes32(rx),
+		intToBytes32(px), m))
+}
+
+// SignSchnorr produces a BIP340 Schnorr signature of hash using the private
+// key.  Fresh auxiliary randomness is mixed into the nonce, which is still
+// safe should the random source fail since the nonce also commits to the key
+// and the message.
+func (p *PrivateKey) SignSchnorr(hash []byte) (*SchnorrSignature, error) {
+	var aux [schnorrAuxLen]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return nil, err
+	}
+	return signSchnorr(p, hash, aux[:])
+}
+
+// signSchnorr implements the BIP340 signing algorithm with the passed
+// auxiliary randomness.
+func signSchnorr(priv *PrivateKey, hash, aux []byte) (*SchnorrSignature, error) {
+	curve := S256()
+	d := new(big.Int).Set(priv.D)
+	if d.Sign() == 0 || d.Cmp(curve.N) >= 0 {
+		return nil, errSchnorrZeroKey
+	}
+
+	px, py := curve.ScalarBaseMult(intToBytes32(d))
+	if isOdd(py) {
+		d.Sub(curve.N, d)
+	}
+
+	t := TaggedHash("BIP0340/aux", aux)
+	db := intToBytes32(d)
+	for i := range t {
+		t[i] ^= db[i]
+	}
+	k := hashToScalar(TaggedHash("BIP0340/nonce", t, intToBytes32(px), hash))
+	if k.Sign() == 0 {
+		return nil, errSchnorrZeroNonce
+	}
+
+	rx, ry := curve.ScalarBaseMult(intToBytes32(k))
+	if isOdd(ry) {
+		k.Sub(curve.N, k)
+	}
+
+	e := schnorrChallenge(rx, px, hash)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	return &SchnorrSignature{R: rx, S: s}, nil
+}
+
+// Verify calls BIP340 verification on the signature of hash using the public
+// key.  Only the X coordinate of the public key is used.  It returns true if
+// the signature is valid, false otherwise.
+func (sig *SchnorrSignature) Verify(hash []byte, pubKey *PublicKey) bool {
+	curve := S256()
+	if sig.R.Cmp(curve.P) >= 0 || sig.S.Cmp(curve.N) >= 0 {
+		return false
+	}
+
+	pub, err := liftX(pubKey.X)
+	if err != nil {
+		return false
+	}
+
+	// R = s*G - e*P
+	e := schnorrChallenge(sig.R, pub.X, hash)
+	e.Sub(curve.N, e)
+	sx, sy := curve.ScalarBaseMult(intToBytes32(sig.S))
+	ex, ey := curve.ScalarMult(pub.X, pub.Y, intToBytes32(e))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return !isOdd(ry) && rx.Cmp(sig.R) == 0
+}
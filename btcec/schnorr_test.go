@@ -0,0 +1,177 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// TestSchnorrVectors checks signing and verification against the test
+// vectors of BIP340.
+func TestSchnorrVectors(t *testing.T) {
+	tests := []struct {
+		secKey string
+		pubKey string
+		aux    string
+		msg    string
+		sig    string
+	}{
+		{
+			secKey: "0000000000000000000000000000000000000000000000000000000000000003",
+			pubKey: "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000000",
+			msg:    "0000000000000000000000000000000000000000000000000000000000000000",
+			sig:    "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			secKey: "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			pubKey: "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000001",
+			msg:    "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			sig:    "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+	}
+
+	for i, test := range tests {
+		priv, _ := PrivKeyFromBytes(S256(), decodeHex(test.secKey))
+		wantPub := decodeHex(test.pubKey)
+		if !bytes.Equal(priv.PubKey().SerializeXOnly(), wantPub) {
+			t.Errorf("#%d: wrong public key %x, want %x", i,
+				priv.PubKey().SerializeXOnly(), wantPub)
+			continue
+		}
+
+		msg := decodeHex(test.msg)
+		sig, err := signSchnorr(priv, msg, decodeHex(test.aux))
+		if err != nil {
+			t.Errorf("#%d: sign failed: %v", i, err)
+			continue
+		}
+		wantSig := decodeHex(test.sig)
+		if !bytes.Equal(sig.Serialize(), wantSig) {
+			t.Errorf("#%d: wrong signature %x, want %x", i,
+				sig.Serialize(), wantSig)
+		}
+
+		pub, err := ParseXOnlyPubKey(wantPub)
+		if err != nil {
+			t.Errorf("#%d: ParseXOnlyPubKey failed: %v", i, err)
+			continue
+		}
+		parsed, err := ParseSchnorrSignature(wantSig)
+		if err != nil {
+			t.Errorf("#%d: ParseSchnorrSignature failed: %v", i, err)
+			continue
+		}
+		if !parsed.Verify(msg, pub) {
+			t.Errorf("#%d: valid signature rejected", i)
+		}
+
+		// Any change to the message must invalidate the signature.
+		msg[0] ^= 0x01
+		if parsed.Verify(msg, pub) {
+			t.Errorf("#%d: signature of modified message accepted", i)
+		}
+	}
+}
+
+// TestSchnorrSignVerify ensures signatures made with random auxiliary data
+// verify under the x-only and the full public key.
+func TestSchnorrSignVerify(t *testing.T) {
+	msg := decodeHex("243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89")
+	for i := 0; i < 8; i++ {
+		priv, err := NewPrivateKey(S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey failed: %v", err)
+		}
+		sig, err := priv.SignSchnorr(msg)
+		if err != nil {
+			t.Fatalf("#%d: SignSchnorr failed: %v", i, err)
+		}
+		if !sig.Verify(msg, priv.PubKey()) {
+			t.Errorf("#%d: signature rejected", i)
+		}
+
+		other, _ := NewPrivateKey(S256())
+		if sig.Verify(msg, other.PubKey()) {
+			t.Errorf("#%d: signature accepted under another key", i)
+		}
+	}
+
+	if _, err := ParseSchnorrSignature(make([]byte, SchnorrSigLen-1)); err == nil {
+		t.Errorf("ParseSchnorrSignature: short signature accepted")
+	}
+}
+
+// TestMuSig2 runs complete signing sessions for groups of signers and checks
+// the aggregate signature against the aggregate key.
+func TestMuSig2(t *testing.T) {
+	msg := []byte("committee block signature hash..")
+	for _, n := range []int{1, 2, 3, 5} {
+		privs := make([]*PrivateKey, n)
+		pubs := make([]*PublicKey, n)
+		for i := range privs {
+			privs[i], _ = NewPrivateKey(S256())
+			pubs[i] = privs[i].PubKey()
+		}
+		SortPubKeys(pubs)
+		aggKey, err := AggregatePubKeys(pubs)
+		if err != nil {
+			t.Fatalf("n=%d: AggregatePubKeys failed: %v", n, err)
+		}
+
+		// Round one: nonces.
+		nonces := make([]*MuSigNonce, n)
+		pubNonces := make([][MuSigPubNonceLen]byte, n)
+		for i, priv := range privs {
+			nonces[i], err = GenerateMuSigNonce(priv, aggKey, msg)
+			if err != nil {
+				t.Fatalf("n=%d: GenerateMuSigNonce failed: %v", n, err)
+			}
+			pubNonces[i] = nonces[i].PubNonce
+		}
+		aggNonce, err := AggregateMuSigNonces(pubNonces)
+		if err != nil {
+			t.Fatalf("n=%d: AggregateMuSigNonces failed: %v", n, err)
+		}
+
+		// Round two: partial signatures.
+		session, err := NewMuSigSession(aggKey, aggNonce, msg)
+		if err != nil {
+			t.Fatalf("n=%d: NewMuSigSession failed: %v", n, err)
+		}
+		partials := make([]*big.Int, n)
+		for i, priv := range privs {
+			partials[i], err = session.Sign(nonces[i], priv)
+			if err != nil {
+				t.Fatalf("n=%d: Sign failed: %v", n, err)
+			}
+			if !session.VerifyPartial(partials[i], pubNonces[i], priv.PubKey()) {
+				t.Errorf("n=%d: partial signature %d rejected", n, i)
+			}
+		}
+
+		if _, err := session.Sign(nonces[0], privs[0]); err != ErrMuSigNonceUsed {
+			t.Errorf("n=%d: nonce reuse not detected: %v", n, err)
+		}
+
+		sig := session.Aggregate(partials)
+		if !sig.Verify(msg, aggKey.Q) {
+			t.Errorf("n=%d: aggregate signature rejected", n)
+		}
+		if n > 1 && sig.Verify(msg, pubs[0]) {
+			t.Errorf("n=%d: aggregate signature accepted under a "+
+				"single key", n)
+		}
+
+		// A wrong partial signature is caught before aggregation.
+		bad := new(big.Int).Add(partials[0], big.NewInt(1))
+		if session.VerifyPartial(bad, pubNonces[0], privs[0].PubKey()) {
+			t.Errorf("n=%d: bad partial signature accepted", n)
+		}
+	}
+}
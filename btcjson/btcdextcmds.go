@@ -150,6 +150,19 @@ func NewGetHeadersCmd(blockLocators []string, hashStop string) *GetHeadersCmd {
 	}
 }
 
+// SimulateForfeitureCmd defines the simulateforfeiture JSON-RPC command.
+type SimulateForfeitureCmd struct {
+	Height int32
+}
+
+// NewSimulateForfeitureCmd returns a new instance which can be used to issue a
+// simulateforfeiture JSON-RPC command.
+func NewSimulateForfeitureCmd(height int32) *SimulateForfeitureCmd {
+	return &SimulateForfeitureCmd{
+		Height: height,
+	}
+}
+
 // VersionCmd defines the version JSON-RPC command.
 //
 // NOTE: This is a btcsuite extension ported from
@@ -188,4 +201,5 @@ func init() {
 	MustRegisterCmd("shutdownserver", (*ShutdownCmd)(nil), flags)
 	MustRegisterCmd("vmdebug", (*VMDebugCmd)(nil), flags)
 	MustRegisterCmd("settip", (*SetTipCmd)(nil), flags)
+	MustRegisterCmd("simulateforfeiture", (*SimulateForfeitureCmd)(nil), flags)
 }
@@ -176,6 +176,19 @@ func TestBtcdExtCmds(t *testing.T) {
 				HashStop: "000000000000000000ba33b33e1fad70b69e234fc24414dd47113bff38f523f7",
 			},
 		},
+		{
+			name: "simulateforfeiture",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("simulateforfeiture", 1200)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSimulateForfeitureCmd(1200)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"simulateforfeiture","params":[1200],"id":1}`,
+			unmarshalled: &btcjson.SimulateForfeitureCmd{
+				Height: 1200,
+			},
+		},
 		{
 			name: "version",
 			newCmd: func() (interface{}, error) {
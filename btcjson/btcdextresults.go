@@ -18,3 +18,40 @@ type VersionResult struct {
 	Prerelease    string `json:"prerelease"`
 	BuildMetadata string `json:"buildmetadata"`
 }
+
+// ForfeitureVictimResult models a victim transaction in the result of the
+// simulateforfeiture command.
+type ForfeitureVictimResult struct {
+	TxID    string   `json:"txid"`
+	Claim   int64    `json:"claim"`
+	Secured int64    `json:"secured"`
+	Payees  []string `json:"payees"`
+}
+
+// ForfeitureAwardResult models a reporter award in the result of the
+// simulateforfeiture command.
+type ForfeitureAwardResult struct {
+	Reporter string `json:"reporter"`
+	Amount   int64  `json:"amount"`
+}
+
+// ViolatorForfeitureResult models the forfeiture of a violator in the result of
+// the simulateforfeiture command.
+type ViolatorForfeitureResult struct {
+	MinerBlock string                   `json:"minerblock"`
+	Height     int32                    `json:"height"`
+	Collateral int64                    `json:"collateral"`
+	Contract   int64                    `json:"contract"`
+	Awards     []ForfeitureAwardResult  `json:"awards"`
+	Burned     int64                    `json:"burned"`
+	Victims    []ForfeitureVictimResult `json:"victims"`
+}
+
+// SimulateForfeitureResult models the data from the simulateforfeiture
+// command.  Txs are the hex-encoded compensation transactions.
+type SimulateForfeitureResult struct {
+	Height     int32                      `json:"height"`
+	MinerBlock string                     `json:"minerblock"`
+	Violators  []ViolatorForfeitureResult `json:"violators"`
+	Txs        []string                   `json:"txs"`
+}
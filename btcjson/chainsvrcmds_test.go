@@ -413,6 +413,30 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getconnectioncount","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetConnectionCountCmd{},
 		},
+		{
+			name: "getdeploymentinfo",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getdeploymentinfo")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetDeploymentInfoCmd(nil)
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getdeploymentinfo","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetDeploymentInfoCmd{},
+		},
+		{
+			name: "getdeploymentinfo optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getdeploymentinfo", "123")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetDeploymentInfoCmd(btcjson.String("123"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getdeploymentinfo","params":["123"],"id":1}`,
+			unmarshalled: &btcjson.GetDeploymentInfoCmd{
+				BlockHash: btcjson.String("123"),
+			},
+		},
 		{
 			name: "getdifficulty",
 			newCmd: func() (interface{}, error) {
@@ -197,6 +197,36 @@ type GetBlockTemplateResult struct {
 	RejectReasion string   `json:"reject-reason,omitempty"`
 }
 
+// DeploymentStatistics models the voting statistics of a started deployment
+// in the current confirmation window.
+type DeploymentStatistics struct {
+	Period    uint32 `json:"period"`
+	Threshold uint32 `json:"threshold"`
+	Elapsed   uint32 `json:"elapsed"`
+	Count     uint32 `json:"count"`
+	Possible  bool   `json:"possible"`
+}
+
+// DeploymentDescription models the state of a version bits deployment.
+type DeploymentDescription struct {
+	Status      string                `json:"status"`
+	Since       int32                 `json:"since"`
+	PrevVersion uint32                `json:"prevversion"`
+	FeatureMask uint32                `json:"featuremask"`
+	StartTime   int64                 `json:"starttime"`
+	Timeout     int64                 `json:"timeout"`
+	Active      bool                  `json:"active"`
+	Statistics  *DeploymentStatistics `json:"statistics,omitempty"`
+}
+
+// GetDeploymentInfoResult models the data returned from the getdeploymentinfo
+// command.  The states are those of the block after the block with the hash.
+type GetDeploymentInfoResult struct {
+	Hash        string                            `json:"hash"`
+	Height      int32                             `json:"height"`
+	Deployments map[string]*DeploymentDescription `json:"deployments"`
+}
+
 // GetMempoolEntryResult models the data returned from the getmempoolentry
 // command.
 type GetMempoolEntryResult struct {
@@ -297,6 +327,9 @@ type GetRawMempoolVerboseResult struct {
 	StartingPriority float64  `json:"startingpriority"`
 	CurrentPriority  float64  `json:"currentpriority"`
 	Depends          []string `json:"depends"`
+	AncestorCount    int64    `json:"ancestorcount"`
+	AncestorSize     int64    `json:"ancestorsize"`
+	AncestorFees     float64  `json:"ancestorfees"`
 }
 
 // ScriptPubKeyResult models the scriptPubKey data of a tx script.  It is
@@ -320,6 +353,15 @@ type GetTxOutResult struct {
 	Coinbase      bool               `json:"coinbase"`
 }
 
+// GetTxOutSetInfoResult models the data from the gettxoutsetinfo command.
+// MuHash is the hash of the rolling commitment to the utxo set, which is
+// equal on two nodes with the same utxo set.
+type GetTxOutSetInfoResult struct {
+	Height    int32  `json:"height"`
+	BestBlock string `json:"bestblock"`
+	MuHash    string `json:"muhash"`
+}
+
 type VertexDefinition struct {
 	Lat int32 `json:"lat"` // a wire.Vertex
 	Lng int32 `json:"lng"` // a wire.Vertex
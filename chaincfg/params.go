@@ -64,6 +64,16 @@ type Checkpoint struct {
 	Hash   *chainhash.Hash
 }
 
+// AssumeUtxo pins a chain state snapshot which a node may start from instead
+// of validating the chain from genesis.  StateHash is the hash of the chain
+// state in the snapshot taken at the block with the given height and hash.
+// See blockchain.ImportSnapshot.
+type AssumeUtxo struct {
+	Height    int32
+	BlockHash *chainhash.Hash
+	StateHash *chainhash.Hash
+}
+
 // DNSSeed identifies a DNS seed.
 type DNSSeed struct {
 	// Host defines the hostname of the seed.
@@ -123,6 +133,11 @@ const (
 	// DeploymentVersion6 includes: a bug fix in miner chain about h2 value
 	DeploymentVersion6
 
+	// DeploymentAggregateSig allows the committee to sign tx blocks with an
+	// aggregate (MuSig2) block signature instead of one signature per
+	// member.
+	DeploymentAggregateSig
+
 	// DefinedDeployments is the number of currently defined deployments.
 	// It must always come last since it is used to determine how many
 	// defined deployments there currently are.
@@ -228,6 +243,17 @@ type Params struct {
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// MinerCheckpoints are the checkpoints of the miner chain, ordered from
+	// oldest to newest.  They are used by the headers-first sync of the
+	// miner chain.
+	MinerCheckpoints []Checkpoint
+
+	// AssumeUtxo are the chain state snapshots trusted by a node starting
+	// from a snapshot, ordered from oldest to newest.  Snapshots are pinned
+	// here once reviewed for a release.  Until then, the snapshot tool
+	// takes a pin on the command line.
+	AssumeUtxo []AssumeUtxo
+
 	// These fields are related to voting on consensus rule changes as
 	// defined by BIP0009.
 	//
@@ -322,6 +348,10 @@ var MainNetParams = Params{
 	Checkpoints: []Checkpoint{
 	},
 
+	// Miner chain checkpoints ordered from oldest to newest.
+	MinerCheckpoints: []Checkpoint{
+	},
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -365,6 +395,12 @@ var MainNetParams = Params{
 			StartTime:   uint64(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC).Unix()),
 			ExpireTime:  uint64(time.Date(2023, 11, 2, 0, 0, 0, 0, time.UTC).Unix()),
 		},
+		DeploymentAggregateSig: {
+			PrevVersion: 0x60000,
+			FeatureMask: 0x1,
+			StartTime:   math.MaxInt64, // Not yet scheduled
+			ExpireTime:  math.MaxInt64, // Never expires
+		},
 	},
 
 	// Mempool parameters
@@ -434,6 +470,9 @@ var RegressionNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// Miner chain checkpoints ordered from oldest to newest.
+	MinerCheckpoints: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -477,6 +516,12 @@ var RegressionNetParams = Params{
 			StartTime:   uint64(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC).Unix()),
 			ExpireTime:  math.MaxInt64, // Never expires
 		},
+		DeploymentAggregateSig: {
+			PrevVersion: 0x60000,
+			FeatureMask: 0x1,
+			StartTime:   0,             // Always available for vote
+			ExpireTime:  math.MaxInt64, // Never expires
+		},
 	},
 
 	// Mempool parameters
@@ -550,6 +595,10 @@ var TestNet3Params = Params{
 	Checkpoints: []Checkpoint{
 	},
 
+	// Miner chain checkpoints ordered from oldest to newest.
+	MinerCheckpoints: []Checkpoint{
+	},
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -593,6 +642,12 @@ var TestNet3Params = Params{
 			StartTime:   uint64(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC).Unix()),
 			ExpireTime:  math.MaxInt64, // Never expires
 		},
+		DeploymentAggregateSig: {
+			PrevVersion: 0x60000,
+			FeatureMask: 0x1,
+			StartTime:   math.MaxInt64, // Not yet scheduled
+			ExpireTime:  math.MaxInt64, // Never expires
+		},
 	},
 
 	// Mempool parameters
@@ -667,6 +722,9 @@ var SimNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// Miner chain checkpoints ordered from oldest to newest.
+	MinerCheckpoints: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -710,6 +768,12 @@ var SimNetParams = Params{
 			StartTime:   uint64(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC).Unix()),
 			ExpireTime:  math.MaxInt64, // Never expires
 		},
+		DeploymentAggregateSig: {
+			PrevVersion: 0x60000,
+			FeatureMask: 0x1,
+			StartTime:   0,             // Always available for vote
+			ExpireTime:  math.MaxInt64, // Never expires
+		},
 	},
 
 	// Mempool parameters
@@ -21,6 +21,10 @@ const (
 	defaultDbType   = "ffldb"
 	defaultDataFile = "bootstrap.dat"
 	defaultProgress = 10
+
+	// defaultUtxoCacheSize is the default maximum size of the utxo cache
+	// in MiB.
+	defaultUtxoCacheSize = 250
 )
 
 var (
@@ -43,6 +47,7 @@ type config struct {
 	TxIndex        bool   `long:"txindex" description:"Build a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	AddrIndex      bool   `long:"addrindex" description:"Build a full address-based transaction index which makes the searchrawtransactions RPC available"`
 	Progress       int    `short:"p" long:"progress" description:"Show a progress message each time this number of seconds have passed -- Use 0 to disable progress announcements"`
+	UtxoCacheSize  uint64 `long:"utxocachemaxsize" description:"The maximum size in MiB of the utxo cache -- Use 0 to write the utxo set changes of every block"`
 }
 
 // filesExists reports whether the named file or directory exists.
@@ -88,10 +93,11 @@ func netName(chainParams *chaincfg.Params) string {
 func loadConfig() (*config, []string, error) {
 	// Default config.
 	cfg := config{
-		DataDir:  defaultDataDir,
-		DbType:   defaultDbType,
-		InFile:   defaultDataFile,
-		Progress: defaultProgress,
+		DataDir:       defaultDataDir,
+		DbType:        defaultDbType,
+		InFile:        defaultDataFile,
+		Progress:      defaultProgress,
+		UtxoCacheSize: defaultUtxoCacheSize,
 	}
 
 	// Parse command line options.
@@ -287,6 +287,12 @@ func (bi *blockImporter) Import() chan *importResults {
 	// the status handler when done.
 	go func() {
 		bi.wg.Wait()
+
+		// Write the utxo set changes still in the utxo cache.
+		if err := bi.chain.FlushUtxoCache(); err != nil {
+			bi.errChan <- err
+			return
+		}
 		bi.doneChan <- true
 	}()
 
@@ -331,10 +337,11 @@ func newBlockImporter(db database.DB, r io.ReadSeeker) (*blockImporter, error) {
 	}
 
 	chain, err := blockchain.New(&blockchain.Config{
-		DB:           db,
-		ChainParams:  activeNetParams,
-		TimeSource:   chainutil.NewMedianTime(),
-		IndexManager: indexManager,
+		DB:               db,
+		ChainParams:      activeNetParams,
+		TimeSource:       chainutil.NewMedianTime(),
+		IndexManager:     indexManager,
+		UtxoCacheMaxSize: cfg.UtxoCacheSize * 1024 * 1024,
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,184 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	_ "github.com/zeusyf/btcd/database/ffldb"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+const (
+	defaultDbType = "ffldb"
+)
+
+var (
+	btcdHomeDir     = btcutil.AppDataDir("btcd", false)
+	defaultDataDir  = filepath.Join(btcdHomeDir, "data")
+	knownDbTypes    = database.SupportedDrivers()
+	activeNetParams = &chaincfg.MainNetParams
+)
+
+// config defines the configuration options for snapshot.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	DataDir        string `short:"b" long:"datadir" description:"Location of the btcd data directory"`
+	DbType         string `long:"dbtype" description:"Database backend to use for the Block Chain"`
+	TestNet3       bool   `long:"testnet" description:"Use the test network"`
+	RegressionTest bool   `long:"regtest" description:"Use the regression test network"`
+	SimNet         bool   `long:"simnet" description:"Use the simulation test network"`
+	Export         string `short:"e" long:"export" description:"Write a snapshot of the chain state at the best block to this file"`
+	Import         string `short:"i" long:"import" description:"Initialize an empty block database with the snapshot in this file"`
+	Height         int32  `long:"height" description:"Height the exported snapshot is expected at -- Use 0 for the best block"`
+	AssumeUtxo     string `long:"assumeutxo" description:"Trust the imported snapshot if it is at <height>:<block hash>:<state hash>, in addition to the snapshots pinned in the chain parameters"`
+}
+
+// validDbType returns whether or not dbType is a supported database type.
+func validDbType(dbType string) bool {
+	for _, knownType := range knownDbTypes {
+		if dbType == knownType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// netName returns the name used when referring to a bitcoin network.  At the
+// time of writing, btcd currently places blocks for testnet version 3 in the
+// data and log directory "testnet", which does not match the Name field of the
+// chaincfg parameters.  This function can be used to override this directory name
+// as "testnet" when the passed active network matches wire.TestNet.
+func netName(chainParams *chaincfg.Params) string {
+	switch chainParams.Net {
+	case wire.TestNet3:
+		return "testnet"
+	default:
+		return chainParams.Name
+	}
+}
+
+// parseAssumeUtxo parses a snapshot pin in the <height>:<block hash>:<state
+// hash> form, as printed when exporting a snapshot.
+func parseAssumeUtxo(s string) (*chaincfg.AssumeUtxo, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("snapshot pin %q is not in the "+
+			"<height>:<block hash>:<state hash> form", s)
+	}
+	height, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil || height <= 0 {
+		return nil, fmt.Errorf("invalid snapshot pin height %q", parts[0])
+	}
+	blockHash, err := chainhash.NewHashFromStr(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot pin block hash: %v", err)
+	}
+	stateHash, err := chainhash.NewHashFromStr(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot pin state hash: %v", err)
+	}
+	return &chaincfg.AssumeUtxo{
+		Height:    int32(height),
+		BlockHash: blockHash,
+		StateHash: stateHash,
+	}, nil
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DataDir: defaultDataDir,
+		DbType:  defaultDbType,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	// Count number of network flags passed; assign active network params
+	// while we're at it
+	if cfg.TestNet3 {
+		numNets++
+		activeNetParams = &chaincfg.TestNet3Params
+	}
+	if cfg.RegressionTest {
+		numNets++
+		activeNetParams = &chaincfg.RegressionNetParams
+	}
+	if cfg.SimNet {
+		numNets++
+		activeNetParams = &chaincfg.SimNetParams
+	}
+	if numNets > 1 {
+		str := "%s: The testnet, regtest, and simnet params can't be " +
+			"used together -- choose one of the three"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate database type.
+	if !validDbType(cfg.DbType) {
+		str := "%s: The specified database type [%v] is invalid -- " +
+			"supported types %v"
+		err := fmt.Errorf(str, funcName, cfg.DbType, knownDbTypes)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Exactly one of export and import must be requested.
+	if (cfg.Export == "") == (cfg.Import == "") {
+		err := errors.New("specify either --export or --import")
+		fmt.Fprintf(os.Stderr, "%s: %v\n", funcName, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Add the snapshot pin to a copy of the chain parameters, so the
+	// parameters of the network stay untouched.
+	if cfg.AssumeUtxo != "" {
+		pin, err := parseAssumeUtxo(cfg.AssumeUtxo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", funcName, err)
+			parser.WriteHelp(os.Stderr)
+			return nil, nil, err
+		}
+		params := *activeNetParams
+		params.AssumeUtxo = append(append([]chaincfg.AssumeUtxo(nil),
+			params.AssumeUtxo...), *pin)
+		activeNetParams = &params
+	}
+
+	// Append the network type to the data directory so it is "namespaced"
+	// per network.
+	cfg.DataDir = filepath.Join(cfg.DataDir, netName(activeNetParams))
+
+	return &cfg, remainingArgs, nil
+}
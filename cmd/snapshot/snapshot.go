@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// snapshot exports the chain state of a block database into a snapshot file,
+// or initializes a new block database from a snapshot file.  A node started
+// on an imported database trusts the snapshot, which must be pinned in the
+// chain parameters or with the --assumeutxo option, until it has validated
+// the chain up to the snapshot height.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btclog"
+)
+
+const blockDbNamePrefix = "blocks"
+
+var (
+	cfg *config
+	log btclog.Logger
+)
+
+// blockDbPath returns the path of the block database.
+func blockDbPath() string {
+	// The database name is based on the database type.
+	dbName := blockDbNamePrefix + "_" + cfg.DbType
+	return filepath.Join(cfg.DataDir, dbName)
+}
+
+// exportSnapshot writes a snapshot of the chain state of the block database.
+func exportSnapshot() error {
+	dbPath := blockDbPath()
+	log.Infof("Loading block database from '%s'", dbPath)
+	db, err := database.Open(cfg.DbType, dbPath, activeNetParams.Net)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: activeNetParams,
+		TimeSource:  chainutil.NewMedianTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Snapshots can only be taken at the best block.
+	best := chain.BestSnapshot()
+	if cfg.Height != 0 && cfg.Height != best.Height {
+		return fmt.Errorf("the block database is at height %d, not %d",
+			best.Height, cfg.Height)
+	}
+
+	f, err := os.Create(cfg.Export)
+	if err != nil {
+		return err
+	}
+	info, err := chain.ExportSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(cfg.Export)
+		return err
+	}
+
+	fmt.Printf("Height:     %d\n", info.Height)
+	fmt.Printf("Block hash: %v\n", info.Hash)
+	fmt.Printf("State hash: %v\n", info.StateHash)
+	fmt.Printf("Pin:        --assumeutxo=%d:%v:%v\n", info.Height,
+		info.Hash, info.StateHash)
+	return nil
+}
+
+// importSnapshot creates a block database initialized with a snapshot.  The
+// database is removed if the snapshot can not be imported.
+func importSnapshot() error {
+	dbPath := blockDbPath()
+	if _, err := os.Stat(dbPath); err == nil {
+		return fmt.Errorf("block database '%s' already exists", dbPath)
+	}
+
+	f, err := os.Open(cfg.Import)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	log.Infof("Creating block database in '%s'", dbPath)
+	db, err := database.Create(cfg.DbType, dbPath, activeNetParams.Net)
+	if err != nil {
+		return err
+	}
+
+	info, err := blockchain.ImportSnapshot(db, activeNetParams, f)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(dbPath)
+		return err
+	}
+
+	log.Infof("Block database initialized at height %d (%v)", info.Height,
+		info.Hash)
+	return nil
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	tcfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg = tcfg
+
+	// Setup logging.
+	backendLogger := btclog.NewBackend(os.Stdout)
+	defer os.Stdout.Sync()
+	log = backendLogger.Logger("MAIN")
+	database.UseLogger(backendLogger.Logger("BCDB"))
+	blockchain.UseLogger(backendLogger.Logger("CHAN"))
+
+	if cfg.Export != "" {
+		err = exportSnapshot()
+	} else {
+		err = importSnapshot()
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+	}
+	return err
+}
+
+func main() {
+	// Work around defer not working after os.Exit()
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/zeusyf/btcd/wire"
+)
+
+// config defines the configuration options for wiredecode.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	InFile          string `short:"i" long:"infile" description:"Read the raw message from this file instead of a hex string"`
+	Command         string `short:"c" long:"command" description:"Command of the message when it has no message header"`
+	ProtocolVersion uint32 `short:"p" long:"pver" description:"Protocol version the message was encoded with"`
+	Encoding        string `short:"e" long:"encoding" description:"Encoding the message was encoded with {base, signature, full}"`
+	Compact         bool   `long:"compact" description:"Print the JSON on a single line"`
+}
+
+// encodings maps the names of the encodings to their value.
+var encodings = map[string]wire.MessageEncoding{
+	"base":      wire.BaseEncoding,
+	"signature": wire.SignatureEncoding,
+	"full":      wire.FullEncoding,
+}
+
+// loadConfig initializes and parses the config using command line options.
+// The remaining arguments hold the hex encoded message when it is not read
+// from a file.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		ProtocolVersion: wire.ProtocolVersion,
+		Encoding:        "signature",
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "[OPTIONS] [hex message]"
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Validate encoding.
+	if _, ok := encodings[cfg.Encoding]; !ok {
+		str := "%s: The specified encoding [%v] is invalid"
+		err := fmt.Errorf(str, "loadConfig", cfg.Encoding)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	if cfg.InFile != "" && len(remainingArgs) > 0 {
+		str := "%s: A hex message can't be given along with --infile"
+		err := fmt.Errorf(str, "loadConfig")
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	return &cfg, remainingArgs, nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// wiredecode prints a raw wire message in its canonical JSON representation.
+// The message is given as a hex string, on the command line or on stdin, or
+// read from a binary file.  It may include the 24-byte message header, in
+// which case the network and command are taken from it, or be a bare payload
+// along with its command.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// knownNets are the networks whose message headers are recognized.
+var knownNets = []common.OmegaNet{common.MainNet, common.TestNet,
+	common.RegNet, common.SimNet}
+
+// readInput returns the raw message from the file, the arguments or stdin.
+func readInput(cfg *config, args []string) ([]byte, error) {
+	if cfg.InFile != "" {
+		return os.ReadFile(cfg.InFile)
+	}
+
+	var s string
+	if len(args) > 0 {
+		s = strings.Join(args, "")
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimPrefix(s, "0x")
+	return hex.DecodeString(s)
+}
+
+// frame returns the message with a header.  A message which already has one
+// is returned as is along with its network.
+func frame(raw []byte, command string) ([]byte, common.OmegaNet, error) {
+	if command == "" {
+		if len(raw) < wire.MessageHeaderSize {
+			return nil, 0, errors.New("message is shorter than a " +
+				"message header -- use --command for a bare payload")
+		}
+		magic := common.OmegaNet(binary.LittleEndian.Uint32(raw))
+		for _, net := range knownNets {
+			if magic == net {
+				return raw, net, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("unknown network magic %08x -- use "+
+			"--command for a bare payload", uint32(magic))
+	}
+
+	if len(command) > common.CommandSize {
+		return nil, 0, fmt.Errorf("command %q is longer than %d bytes",
+			command, common.CommandSize)
+	}
+	msg := make([]byte, wire.MessageHeaderSize, wire.MessageHeaderSize+
+		len(raw))
+	binary.LittleEndian.PutUint32(msg[0:], uint32(common.MainNet))
+	copy(msg[4:4+common.CommandSize], command)
+	binary.LittleEndian.PutUint32(msg[16:], uint32(len(raw)))
+	copy(msg[20:], chainhash.DoubleHashB(raw)[:4])
+	return append(msg, raw...), common.MainNet, nil
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	cfg, args, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	raw, err := readInput(cfg, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read message: %v\n", err)
+		return err
+	}
+	raw, net, err := frame(raw, cfg.Command)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	enc := encodings[cfg.Encoding]
+	if cfg.ProtocolVersion >= wire.AggregateSigVersion {
+		enc |= wire.AggregateSignatureEncoding
+	}
+	_, msg, _, err := wire.ReadMessageWithEncodingN(bytes.NewReader(raw),
+		cfg.ProtocolVersion, net, enc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to decode message: %v\n", err)
+		return err
+	}
+
+	j, err := wire.MarshalMessageJSON(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode message: %v\n", err)
+		return err
+	}
+	if !cfg.Compact {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, j, "", "  "); err != nil {
+			return err
+		}
+		j = buf.Bytes()
+	}
+	fmt.Println(string(j))
+	return nil
+}
+
+func main() {
+	// Work around defer not working after os.Exit()
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
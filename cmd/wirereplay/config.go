@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/database"
+	_ "github.com/zeusyf/btcd/database/ffldb"
+)
+
+const (
+	defaultDbType = "ffldb"
+)
+
+var (
+	knownDbTypes    = database.SupportedDrivers()
+	activeNetParams = &chaincfg.MainNetParams
+)
+
+// config defines the configuration options for wirereplay.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	DbType         string `long:"dbtype" description:"Database backend to use for the test chain"`
+	TestNet3       bool   `long:"testnet" description:"Use the test network"`
+	RegressionTest bool   `long:"regtest" description:"Use the regression test network"`
+	SimNet         bool   `long:"simnet" description:"Use the simulation test network"`
+	Replay         bool   `short:"r" long:"replay" description:"Feed the received messages to a sync manager on a new test chain instead of printing them"`
+	PeerID         int32  `short:"p" long:"peer" description:"Only use the messages of the peer with this ID -- Use 0 for all peers"`
+	Verbose        bool   `short:"v" long:"verbose" description:"Dump the content of the printed messages"`
+	DebugLevel     string `short:"d" long:"debuglevel" description:"Logging level of the test chain for replays {trace, debug, info, warn, error, critical}"`
+}
+
+// validDbType returns whether or not dbType is a supported database type.
+func validDbType(dbType string) bool {
+	for _, knownType := range knownDbTypes {
+		if dbType == knownType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadConfig initializes and parses the config using command line options.
+// The remaining arguments are the record files or directories to read.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DbType:     defaultDbType,
+		DebugLevel: "info",
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "[OPTIONS] <record file or directory>..."
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	// Count number of network flags passed; assign active network params
+	// while we're at it
+	if cfg.TestNet3 {
+		numNets++
+		activeNetParams = &chaincfg.TestNet3Params
+	}
+	if cfg.RegressionTest {
+		numNets++
+		activeNetParams = &chaincfg.RegressionNetParams
+	}
+	if cfg.SimNet {
+		numNets++
+		activeNetParams = &chaincfg.SimNetParams
+	}
+	if numNets > 1 {
+		str := "%s: The testnet, regtest, and simnet params can't be " +
+			"used together -- choose one of the three"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate database type.
+	if !validDbType(cfg.DbType) {
+		str := "%s: The specified database type [%v] is invalid -- " +
+			"supported types %v"
+		err := fmt.Errorf(str, funcName, cfg.DbType, knownDbTypes)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	if len(remainingArgs) == 0 {
+		err := errors.New("no record file or directory specified")
+		fmt.Fprintf(os.Stderr, "%s: %v\n", funcName, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	return &cfg, remainingArgs, nil
+}
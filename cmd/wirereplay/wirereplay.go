@@ -0,0 +1,375 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// wirereplay decodes the message logs written by a peer.Recorder.  It either
+// prints the recorded messages or feeds the messages received from the peers
+// to a sync manager on a new test chain, in order to reproduce offline what
+// a node went through.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/database"
+	"github.com/zeusyf/btcd/mempool"
+	"github.com/zeusyf/btcd/netsync"
+	"github.com/zeusyf/btcd/peer"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btclog"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/minerchain"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+var (
+	cfg *config
+	log btclog.Logger
+)
+
+// recordFiles expands the passed arguments into the record files to read.
+// Directories are expanded into the record files they contain, oldest first.
+func recordFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		dirFiles, err := peer.RecordFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+// forEachRecord calls fn with each record of the passed files, in order.  A
+// truncated record at the end of a file, which is left when the node is
+// stopped while writing it, is ignored.
+func forEachRecord(files []string, fn func(*peer.Record) error) error {
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		rr, err := peer.NewRecordReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for {
+			rec, err := rr.Next()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if cfg.PeerID != 0 && rec.PeerID != cfg.PeerID {
+				continue
+			}
+			if err := fn(rec); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// printRecord prints a record and the summary of its message.
+func printRecord(rec *peer.Record) error {
+	dir := "out"
+	if rec.Inbound {
+		dir = "in "
+	}
+	prefix := fmt.Sprintf("%s %s peer %d", rec.Time.Format(
+		"2006-01-02 15:04:05.000000"), dir, rec.PeerID)
+
+	msg, err := rec.Message()
+	if err != nil {
+		fmt.Printf("%s undecodable message (%d bytes): %v\n", prefix,
+			len(rec.Raw), err)
+		return nil
+	}
+	summary := peer.MessageSummary(msg)
+	if len(summary) > 0 {
+		summary = " (" + summary + ")"
+	}
+	fmt.Printf("%s %s%s\n", prefix, msg.Command(), summary)
+	if cfg.Verbose {
+		spew.Dump(msg)
+	}
+	return nil
+}
+
+// nopNotifier implements netsync.PeerNotifier.  There are no peers to relay
+// to during a replay.
+type nopNotifier struct{}
+
+func (nopNotifier) AnnounceNewTransactions(newTxs []*mempool.TxDesc) {}
+func (nopNotifier) UpdatePeerHeights(latestBlkHash *chainhash.Hash, latestHeight int32, updateSource *peer.Peer) {
+}
+func (nopNotifier) UpdatePeerMinerHeights(latestBlkHash *chainhash.Hash, latestHeight int32, updateSource *peer.Peer) {
+}
+func (nopNotifier) RelayInventory(invVect *wire.InvVect, data interface{}) {}
+func (nopNotifier) TransactionConfirmed(tx *btcutil.Tx)                    {}
+
+// createDB creates a new database of the test chain in dir.
+func createDB(dir, name string) (database.DB, error) {
+	dbPath := filepath.Join(dir, name+"_"+cfg.DbType)
+	return database.Create(cfg.DbType, dbPath, activeNetParams.Net)
+}
+
+// replayer feeds recorded messages to a sync manager.
+type replayer struct {
+	sm    *netsync.SyncManager
+	peers map[int32]*peer.Peer
+	fed   int
+	skip  int
+}
+
+// peer returns the peer standing for the recorded peer with the passed ID.
+// The peers are never connected, so whatever the sync manager sends them is
+// dropped.
+func (r *replayer) peer(rec *peer.Record) *peer.Peer {
+	if p, ok := r.peers[rec.PeerID]; ok {
+		return p
+	}
+	p := peer.NewInboundPeer(&peer.Config{
+		ChainParams:     activeNetParams,
+		ProtocolVersion: rec.ProtocolVersion,
+	})
+	r.peers[rec.PeerID] = p
+	r.sm.NewPeer(p)
+	return p
+}
+
+// feed passes a received message to the sync manager and waits until it has
+// been processed when the sync manager reports it.
+func (r *replayer) feed(rec *peer.Record) error {
+	if !rec.Inbound {
+		return nil
+	}
+	msg, err := rec.Message()
+	if err != nil {
+		log.Warnf("Skipping undecodable message of peer %d: %v",
+			rec.PeerID, err)
+		r.skip++
+		return nil
+	}
+
+	p := r.peer(rec)
+	done := make(chan struct{}, 1)
+	switch m := msg.(type) {
+	case *wire.MsgTx:
+		r.sm.QueueTx(btcutil.NewTx(m), p, done)
+		<-done
+	case *wire.MsgBlock:
+		r.sm.QueueBlock(btcutil.NewBlock(m), p, done)
+		<-done
+	case *wire.MingingRightBlock:
+		r.sm.QueueMinerBlock(wire.NewMinerBlock(m), p, done)
+		<-done
+	case *wire.MsgSignatures:
+		r.sm.QueueSignatures(m, p, done)
+		<-done
+	case *wire.MsgInv:
+		r.sm.QueueInv(m, p)
+	case *wire.MsgHeaders:
+		r.sm.QueueHeaders(m, p)
+	case *wire.MsgMinerHeaders:
+		r.sm.QueueMinerHeaders(m, p)
+	case *wire.MsgGetMinerHeaders:
+		r.sm.QueueGetMinerHeaders(m, p)
+	case *wire.MsgCmpctBlock:
+		r.sm.QueueCmpctBlock(m, p)
+	case *wire.MsgBlockTxn:
+		r.sm.QueueBlockTxn(m, p)
+	case *wire.MsgGetBlockTxn:
+		r.sm.QueueGetBlockTxn(m, p)
+	case *wire.MsgPackage:
+		r.sm.QueuePackage(m, p)
+	case *wire.MsgFeeFilter:
+		r.sm.QueueFeeFilter(m, p)
+	default:
+		// Messages handled by the server rather than the sync manager.
+		r.skip++
+		return nil
+	}
+	r.fed++
+	return nil
+}
+
+// replay feeds the messages received in the passed files to a sync manager on
+// a new test chain and reports the state of the chain afterwards.
+func replay(files []string, backendLogger *btclog.Backend) error {
+	level, _ := btclog.LevelFromString(cfg.DebugLevel)
+	for _, sub := range []struct {
+		tag string
+		use func(btclog.Logger)
+	}{
+		{"BCDB", database.UseLogger},
+		{"CHAN", blockchain.UseLogger},
+		{"SYNC", netsync.UseLogger},
+		{"TXMP", mempool.UseLogger},
+		{"PEER", peer.UseLogger},
+	} {
+		l := backendLogger.Logger(sub.tag)
+		l.SetLevel(level)
+		sub.use(l)
+	}
+
+	dir, err := os.MkdirTemp("", "wirereplay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := createDB(dir, "blocks")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	minerDB, err := createDB(dir, "miners")
+	if err != nil {
+		return err
+	}
+	defer minerDB.Close()
+
+	// Checkpoints are left out so the recorded blocks are validated in
+	// full.
+	timeSource := chainutil.NewMedianTime()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		MinerDB:     minerDB,
+		ChainParams: activeNetParams,
+		TimeSource:  timeSource,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize chain: %v", err)
+	}
+	miners, err := minerchain.New(&blockchain.Config{
+		DB:          db,
+		MinerDB:     minerDB,
+		ChainParams: activeNetParams,
+		TimeSource:  timeSource,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize miner chain: %v", err)
+	}
+	chain.SetMinerChain(miners)
+
+	txPool := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			AcceptNonStd:    activeNetParams.RelayNonStdTxs,
+			MaxTxVersion:    wire.TxVersion,
+			MaxOrphanTxs:    100,
+			MaxOrphanTxSize: 100000,
+			MinRelayTxFee:   mempool.DefaultMinRelayTxFee,
+		},
+		ChainParams:   activeNetParams,
+		FetchUtxoView: chain.FetchUtxoView,
+		BestHeight: func() int32 {
+			return chain.BestSnapshot().Height
+		},
+		MedianTimePast: func() time.Time {
+			return chain.BestSnapshot().MedianTime
+		},
+		CalcSequenceLock: func(tx *btcutil.Tx, view *viewpoint.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return chain.CalcSequenceLock(tx, view, true)
+		},
+		ContractExecLimit: chain.ContractExecLimit,
+	})
+
+	sm, err := netsync.New(&netsync.Config{
+		PeerNotifier:       nopNotifier{},
+		Chain:              chain,
+		TxMemPool:          txPool,
+		ChainParams:        activeNetParams,
+		DisableCheckpoints: true,
+		MaxPeers:           125,
+	})
+	if err != nil {
+		return err
+	}
+	sm.Start()
+
+	r := &replayer{
+		sm:    sm,
+		peers: make(map[int32]*peer.Peer),
+	}
+	err = forEachRecord(files, r.feed)
+	for _, p := range r.peers {
+		sm.DonePeer(p)
+	}
+	if stopErr := sm.Stop(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return err
+	}
+
+	best := chain.BestSnapshot()
+	mbest := chain.Miners.BestSnapshot()
+	log.Infof("Replayed %d messages of %d peers (%d skipped)", r.fed,
+		len(r.peers), r.skip)
+	log.Infof("Chain at height %d (%v), miner chain at height %d (%v)",
+		best.Height, best.Hash, mbest.Height, mbest.Hash)
+	return nil
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	tcfg, args, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg = tcfg
+
+	// Setup logging.
+	backendLogger := btclog.NewBackend(os.Stdout)
+	defer os.Stdout.Sync()
+	log = backendLogger.Logger("MAIN")
+
+	files, err := recordFiles(args)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+
+	if cfg.Replay {
+		err = replay(files, backendLogger)
+	} else {
+		err = forEachRecord(files, printRecord)
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+	}
+	return err
+}
+
+func main() {
+	// Work around defer not working after os.Exit()
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
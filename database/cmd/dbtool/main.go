@@ -18,6 +18,9 @@ import (
 const (
 	// blockDbNamePrefix is the prefix for the btcd block database.
 	blockDbNamePrefix = "blocks"
+
+	// minerDbNamePrefix is the prefix for the btcd miner chain database.
+	minerDbNamePrefix = "miners"
 )
 
 var (
@@ -57,6 +60,22 @@ func loadBlockDB() (database.DB, error) {
 	return db, nil
 }
 
+// loadMinerDB opens the existing miner chain database and returns a handle to
+// it.
+func loadMinerDB() (database.DB, error) {
+	dbName := minerDbNamePrefix + "_" + cfg.DbType
+	dbPath := filepath.Join(cfg.DataDir, dbName)
+
+	log.Infof("Loading miner database from '%s'", dbPath)
+	db, err := database.Open(cfg.DbType, dbPath, activeNetParams.Net)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Miner database loaded")
+	return db, nil
+}
+
 // realMain is the real main function for the utility.  It is necessary to work
 // around the fact that deferred functions do not run when os.Exit() is called.
 func realMain() error {
@@ -89,6 +108,14 @@ func realMain() error {
 	parser.AddCommand("fetchblockregion",
 		"Fetch the specified block region from the database", "",
 		&blockRegionCfg)
+	parser.AddCommand("verifychain",
+		"Verify the consistency of the chain state",
+		"Verify the block index against the block files, run the "+
+			"sanity checks of the blocks, check the spend journal, "+
+			"disconnect and reconnect the blocks to rerun the "+
+			"connect checks without executing the contracts, and "+
+			"rebuild the utxo set, depending on the level, and print "+
+			"a JSON report of any inconsistencies.", &verifyChainCfg)
 
 	// Parse command line and invoke the Execute function for the specified
 	// command.
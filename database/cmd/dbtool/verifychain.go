@@ -0,0 +1,173 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/blockchain/chainutil"
+	"github.com/zeusyf/omega/minerchain"
+)
+
+// verifyChainCmd defines the configuration options for the verifychain
+// command.
+type verifyChainCmd struct {
+	Level  int    `long:"level" description:"Verification level: 0 checks the block index against the block files, 1 also runs the context free sanity checks of the blocks, 2 also checks the spend journal, 3 also disconnects the blocks and reruns the connect checks, except the contract execution, as they are connected back, 4 also rebuilds the utxo set from genesis and compares it with the stored one"`
+	Depth  int32  `long:"depth" description:"Number of blocks below the best block to check -- Use 0 for the whole chain"`
+	Output string `long:"output" description:"Write the report to this file instead of stdout"`
+}
+
+var (
+	// verifyChainCfg defines the configuration options for the command.
+	verifyChainCfg = verifyChainCmd{
+		Level: int(blockchain.VerifySpendJournal),
+		Depth: 288,
+	}
+)
+
+// chainIssue is the JSON form of a blockchain.ChainIssue.
+type chainIssue struct {
+	Check   string `json:"check"`
+	Height  int32  `json:"height"`
+	Hash    string `json:"hash,omitempty"`
+	Message string `json:"message"`
+}
+
+// chainReport is the JSON form of a blockchain.ChainReport.
+type chainReport struct {
+	Level          int          `json:"level"`
+	Tip            string       `json:"tip"`
+	TipHeight      int32        `json:"tipheight"`
+	StartHeight    int32        `json:"startheight"`
+	Blocks         int          `json:"blocks"`
+	Pruned         int          `json:"pruned"`
+	UtxoEntries    int          `json:"utxoentries,omitempty"`
+	UtxoCommitment string       `json:"utxocommitment"`
+	NumIssues      int          `json:"numissues"`
+	Issues         []chainIssue `json:"issues"`
+}
+
+// writeReport writes the report as JSON.
+func writeReport(w io.Writer, r *blockchain.ChainReport) error {
+	report := chainReport{
+		Level:          int(r.Level),
+		Tip:            r.Tip.String(),
+		TipHeight:      r.TipHeight,
+		StartHeight:    r.StartHeight,
+		Blocks:         r.Blocks,
+		Pruned:         r.Pruned,
+		UtxoEntries:    r.UtxoEntries,
+		UtxoCommitment: r.UtxoCommitment.String(),
+		NumIssues:      r.NumIssues,
+		Issues:         make([]chainIssue, 0, len(r.Issues)),
+	}
+	for _, issue := range r.Issues {
+		i := chainIssue{
+			Check:   issue.Check,
+			Height:  issue.Height,
+			Message: issue.Message,
+		}
+		if issue.Hash != nil {
+			i.Hash = issue.Hash.String()
+		}
+		report.Issues = append(report.Issues, i)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&report)
+}
+
+// Execute is the main entry point for the command.  It's invoked by the parser.
+func (cmd *verifyChainCmd) Execute(args []string) error {
+	// Setup the global config options and ensure they are valid.
+	if err := setupGlobalConfig(); err != nil {
+		return err
+	}
+
+	level := blockchain.VerifyLevel(cmd.Level)
+	if level < blockchain.VerifyIndex || level > blockchain.VerifyUtxoSet {
+		return fmt.Errorf("verification level must be between %d and %d",
+			blockchain.VerifyIndex, blockchain.VerifyUtxoSet)
+	}
+	if cmd.Depth < 0 {
+		return fmt.Errorf("depth must not be negative")
+	}
+
+	// Load the block database.
+	db, err := loadBlockDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Stop the verification on Ctrl+C.
+	interrupt := make(chan struct{})
+	addInterruptHandler(func() {
+		close(interrupt)
+	})
+
+	timeSource := chainutil.NewMedianTime()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: activeNetParams,
+		TimeSource:  timeSource,
+		Interrupt:   interrupt,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The connect checks need the miner chain.
+	if level >= blockchain.VerifyConnect {
+		minerDB, err := loadMinerDB()
+		if err != nil {
+			return err
+		}
+		defer minerDB.Close()
+
+		miners, err := minerchain.New(&blockchain.Config{
+			DB:          db,
+			MinerDB:     minerDB,
+			ChainParams: activeNetParams,
+			TimeSource:  timeSource,
+			Interrupt:   interrupt,
+		})
+		if err != nil {
+			return err
+		}
+		chain.SetMinerChain(miners)
+	}
+
+	log.Infof("Verifying chain at level %d", level)
+	report, err := chain.VerifyChain(level, cmd.Depth, interrupt)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if cmd.Output != "" {
+		out, err = os.Create(cmd.Output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := writeReport(out, report); err != nil {
+		return err
+	}
+
+	if report.NumIssues > 0 {
+		return fmt.Errorf("found %d inconsistencies in the chain state",
+			report.NumIssues)
+	}
+	log.Infof("Verified %d blocks, no inconsistencies found",
+		report.Blocks)
+	return nil
+}
@@ -117,6 +117,10 @@ const (
 	// ErrBlockNotFound instead.
 	ErrBlockRegionInvalid
 
+	// ErrBlockPruned indicates the data of a block which has been removed
+	// from the database by pruning was requested.
+	ErrBlockPruned
+
 	// ***********************************
 	// Support for driver-specific errors.
 	// ***********************************
@@ -153,6 +157,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrBlockNotFound:      "ErrBlockNotFound",
 	ErrBlockExists:        "ErrBlockExists",
 	ErrBlockRegionInvalid: "ErrBlockRegionInvalid",
+	ErrBlockPruned:        "ErrBlockPruned",
 	ErrDriverSpecific:     "ErrDriverSpecific",
 }
 
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/database"
@@ -169,6 +170,11 @@ type blockStore struct {
 	// new blocks are written to.
 	writeCursor *writeCursor
 
+	// prunedFileNum is the number of the oldest block file which has not
+	// been pruned.  All the files before it have been removed.  It is
+	// accessed atomically.
+	prunedFileNum uint32
+
 	// These functions are set to openFile, openWriteFile, and deleteFile by
 	// default, but are exposed here to allow the whitebox tests to replace
 	// them when working with mock files.
@@ -501,12 +507,18 @@ func (s *blockStore) writeBlock(rawBlock []byte) (blockLocation, error) {
 // and closing files as necessary to stay within the maximum allowed open files
 // limit.
 //
-// Returns ErrDriverSpecific if the data fails to read for any reason and
-// ErrCorruption if the checksum of the read data doesn't match the checksum
+// Returns ErrBlockPruned if the block file has been pruned, ErrDriverSpecific
+// if the data fails to read for any reason and ErrCorruption if the checksum of the read data doesn't match the checksum
 // read from the file.
 //
 // Format: <network><block length><serialized block><checksum>
 func (s *blockStore) readBlock(hash *chainhash.Hash, loc blockLocation) ([]byte, error) {
+	// The file of a pruned block is gone.
+	if s.isPruned(loc) {
+		str := fmt.Sprintf("block %s has been pruned", hash)
+		return nil, makeDbErr(database.ErrBlockPruned, str, nil)
+	}
+
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
@@ -561,8 +573,16 @@ func (s *blockStore) readBlock(hash *chainhash.Hash, loc blockLocation) ([]byte,
 // closing files as necessary to stay within the maximum allowed open files
 // limit.
 //
-// Returns ErrDriverSpecific if the data fails to read for any reason.
+// Returns ErrBlockPruned if the block file has been pruned and
+// ErrDriverSpecific if the data fails to read for any reason.
 func (s *blockStore) readBlockRegion(loc blockLocation, offset, numBytes uint32) ([]byte, error) {
+	// The file of a pruned block is gone.
+	if s.isPruned(loc) {
+		str := fmt.Sprintf("block file %d has been pruned",
+			loc.blockFileNum)
+		return nil, makeDbErr(database.ErrBlockPruned, str, nil)
+	}
+
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
@@ -588,6 +608,57 @@ func (s *blockStore) readBlockRegion(loc blockLocation, offset, numBytes uint32)
 	return serializedData, nil
 }
 
+// isPruned returns whether the block at the passed location has been pruned.
+func (s *blockStore) isPruned(loc blockLocation) bool {
+	return loc.blockFileNum < atomic.LoadUint32(&s.prunedFileNum)
+}
+
+// pruneFiles removes the block files before the passed file number.  The files
+// are closed first when they are open.  Failures to remove a file are only
+// logged since the blocks in it are no longer reachable anyways.
+//
+// This function MUST only be called once the metadata recording the pruning is
+// on disk and with the database write lock held.
+func (s *blockStore) pruneFiles(fileNum uint32) {
+	oldFileNum := atomic.LoadUint32(&s.prunedFileNum)
+	if fileNum <= oldFileNum {
+		return
+	}
+	atomic.StoreUint32(&s.prunedFileNum, fileNum)
+
+	// Close the open files which are about to be removed under the write
+	// lock for the file in case any readers are currently reading from
+	// it.
+	s.obfMutex.Lock()
+	s.lruMutex.Lock()
+	for num, blockFile := range s.openBlockFiles {
+		if num >= fileNum {
+			continue
+		}
+		blockFile.Lock()
+		_ = blockFile.file.Close()
+		blockFile.Unlock()
+
+		s.openBlocksLRU.Remove(s.fileNumToLRUElem[num])
+		delete(s.openBlockFiles, num)
+		delete(s.fileNumToLRUElem, num)
+	}
+	s.lruMutex.Unlock()
+	s.obfMutex.Unlock()
+
+	for num := oldFileNum; num < fileNum; num++ {
+		if !fileExists(blockFilePath(s.basePath, num)) {
+			continue
+		}
+		if err := s.deleteFileFunc(num); err != nil {
+			log.Warnf("PRUNE: Failed to delete block file number "+
+				"%d: %v", num, err)
+		}
+	}
+
+	log.Debugf("PRUNE: Pruned block files up to %d", fileNum)
+}
+
 // syncBlocks performs a file system sync on the flat file associated with the
 // store's current write cursor.  It is safe to call even when there is not a
 // current write file in which case it will have no effect.
@@ -713,6 +784,32 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 	}
 }
 
+// firstBlockFile returns the number of the oldest flat block file in the
+// database directory.  It is 0 unless block files have been pruned.
+func firstBlockFile(dbPath string) int {
+	files, err := filepath.Glob(filepath.Join(dbPath, "*.fdb"))
+	if err != nil {
+		return 0
+	}
+
+	first := -1
+	for _, file := range files {
+		var fileNum int
+		_, err := fmt.Sscanf(filepath.Base(file), blockFilenameTemplate,
+			&fileNum)
+		if err != nil {
+			continue
+		}
+		if first == -1 || fileNum < first {
+			first = fileNum
+		}
+	}
+	if first == -1 {
+		return 0
+	}
+	return first
+}
+
 // scanBlockFiles searches the database directory for all flat block files to
 // find the end of the most recent file.  This position is considered the
 // current write cursor which is also stored in the metadata.  Thus, it is used
@@ -721,7 +818,7 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 func scanBlockFiles(dbPath string) (int, uint32) {
 	lastFile := -1
 	fileLen := uint32(0)
-	for i := 0; ; i++ {
+	for i := firstBlockFile(dbPath); ; i++ {
 		filePath := blockFilePath(dbPath, uint32(i))
 		st, err := os.Stat(filePath)
 		if err != nil {
@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/database"
@@ -75,6 +76,10 @@ var (
 	// writeLocKeyName is the key used to store the current write file
 	// location.
 	writeLocKeyName = []byte("ffldb-writeloc")
+
+	// pruneLocKeyName is the key used to store the number of the oldest
+	// block file which has not been pruned.
+	pruneLocKeyName = []byte("ffldb-pruneloc")
 )
 
 // Common error strings.
@@ -967,6 +972,10 @@ type transaction struct {
 	pendingBlocks    map[chainhash.Hash]int
 	pendingBlockData []pendingBlock
 
+	// The oldest block file to keep when block files are to be pruned on
+	// commit.  It is zero when there is nothing to prune.
+	pendingPruneFileNum uint32
+
 	// Keys that need to be stored or deleted on commit.
 	pendingKeys   *treap.Mutable
 	pendingRemove *treap.Mutable
@@ -1337,6 +1346,81 @@ func (tx *transaction) HasBlocks(hashes []chainhash.Hash) ([]bool, error) {
 	return results, nil
 }
 
+// PruneBlocks removes the oldest block files from the block storage as long as
+// all the blocks they hold are accepted by the prunable function.  The file
+// blocks are currently written to is never removed.  The pruned blocks are
+// still known by HasBlock, however fetching their data returns ErrBlockPruned.
+// The hashes of the pruned blocks are returned.  The files are removed once the
+// transaction is committed.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(prunable func(hash *chainhash.Hash) bool) ([]chainhash.Hash, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return nil, makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	firstFileNum := atomic.LoadUint32(&tx.db.store.prunedFileNum)
+	if tx.pendingPruneFileNum > firstFileNum {
+		firstFileNum = tx.pendingPruneFileNum
+	}
+	wc := tx.db.store.writeCursor
+	wc.RLock()
+	lastFileNum := wc.curFileNum
+	wc.RUnlock()
+
+	if lastFileNum <= firstFileNum {
+		return nil, nil
+	}
+
+	// Find the oldest file holding a block which must be kept, while
+	// collecting the blocks of the files before it in the same pass over
+	// the block index.
+	keepFileNum := lastFileNum
+	files := make(map[uint32][]chainhash.Hash)
+	err := tx.blockIdxBucket.ForEach(func(k, v []byte) error {
+		loc := deserializeBlockLoc(v)
+		if loc.blockFileNum < firstFileNum || loc.blockFileNum >= keepFileNum {
+			return nil
+		}
+		var hash chainhash.Hash
+		copy(hash[:], k)
+		if !prunable(&hash) {
+			keepFileNum = loc.blockFileNum
+			return nil
+		}
+		files[loc.blockFileNum] = append(files[loc.blockFileNum], hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if keepFileNum <= firstFileNum {
+		return nil, nil
+	}
+
+	var pruned []chainhash.Hash
+	for fileNum := firstFileNum; fileNum < keepFileNum; fileNum++ {
+		pruned = append(pruned, files[fileNum]...)
+	}
+
+	tx.pendingPruneFileNum = keepFileNum
+	log.Tracef("Pruning block files %d to %d (%d blocks)", firstFileNum,
+		keepFileNum-1, len(pruned))
+
+	return pruned, nil
+}
+
 // fetchBlockRow fetches the metadata stored in the block index for the provided
 // hash.  It will return ErrBlockNotFound if there is no entry.
 func (tx *transaction) fetchBlockRow(hash *chainhash.Hash) ([]byte, error) {
@@ -1703,6 +1787,7 @@ func (tx *transaction) close() {
 	// Clear pending blocks that would have been written on commit.
 	tx.pendingBlocks = nil
 	tx.pendingBlockData = nil
+	tx.pendingPruneFileNum = 0
 
 	// Clear pending keys that would have been written or deleted on commit.
 	tx.pendingKeys = nil
@@ -1775,9 +1860,34 @@ func (tx *transaction) writePendingAndCommit() error {
 		return convertErr("failed to store write cursor", err)
 	}
 
+	// Update the metadata for the oldest block file kept when pruning.
+	pruneFileNum := tx.pendingPruneFileNum
+	if pruneFileNum != 0 {
+		var pruneRow [4]byte
+		byteOrder.PutUint32(pruneRow[:], pruneFileNum)
+		if err := tx.metaBucket.Put(pruneLocKeyName, pruneRow[:]); err != nil {
+			rollback()
+			return convertErr("failed to store prune location", err)
+		}
+	}
+
 	// Atomically update the database cache.  The cache automatically
 	// handles flushing to the underlying persistent storage database.
-	return tx.db.cache.commitTx(tx)
+	if err := tx.db.cache.commitTx(tx); err != nil {
+		return err
+	}
+	if pruneFileNum == 0 {
+		return nil
+	}
+
+	// The pruned files are only removed once the metadata is on disk so
+	// the blocks in them are reported as pruned after a crash rather than
+	// as corrupted.
+	if err := tx.db.cache.flush(); err != nil {
+		return err
+	}
+	tx.db.store.pruneFiles(pruneFileNum)
+	return nil
 }
 
 // Commit commits all changes that have been made to the root metadata bucket
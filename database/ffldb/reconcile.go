@@ -75,6 +75,34 @@ func reconcileDB(pdb *db, create bool) (database.DB, error) {
 		return nil, err
 	}
 
+	// Remove the block files which were pruned before an unclean shutdown
+	// could remove them.
+	var pruneFileNum uint32
+	err = pdb.View(func(tx database.Tx) error {
+		pruneRow := tx.Metadata().Get(pruneLocKeyName)
+		if pruneRow == nil {
+			return nil
+		}
+		if len(pruneRow) < 4 {
+			str := "prune location is corrupted"
+			return makeDbErr(database.ErrCorruption, str, nil)
+		}
+		pruneFileNum = byteOrder.Uint32(pruneRow)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pdb.store.pruneFiles(pruneFileNum)
+
+	// No block file is left on disk when every file was pruned before any
+	// block was written to the current one, so the scan could not find it.
+	wc := pdb.store.writeCursor
+	if wc.curFileNum < pruneFileNum {
+		wc.curFileNum = pruneFileNum
+		wc.curOffset = 0
+	}
+
 	// When the write cursor position found by scanning the block files on
 	// disk is AFTER the position the metadata believes to be true, truncate
 	// the files on disk to match the metadata.  This can be a fairly common
@@ -82,7 +110,6 @@ func reconcileDB(pdb *db, create bool) (database.DB, error) {
 	// the middle of being written.  Since the metadata isn't updated until
 	// after the block data is written, this is effectively just a rollback
 	// to the known good point before the unclean shutdown.
-	wc := pdb.store.writeCursor
 	if wc.curFileNum > curFileNum || (wc.curFileNum == curFileNum &&
 		wc.curOffset > curOffset) {
 
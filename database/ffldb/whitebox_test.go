@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/database"
 	"github.com/zeusyf/btcd/wire"
 	"github.com/zeusyf/btcutil"
@@ -704,3 +705,85 @@ func TestFailureScenarios(t *testing.T) {
 	// Test various corruption scenarios.
 	testCorruption(tc)
 }
+
+// TestPruneBlocks ensures only the oldest block files holding prunable blocks
+// are removed and the data of the pruned blocks is reported as pruned.
+func TestPruneBlocks(t *testing.T) {
+	// Create a new database to run tests against.
+	dbPath := filepath.Join(os.TempDir(), "ffldb-pruneblocks")
+	_ = os.RemoveAll(dbPath)
+	idb, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+	defer idb.Close()
+
+	// Use small block files so the blocks are spread over several files.
+	idb.(*db).store.maxBlockFileSize = 1024 // 1KiB
+
+	blocks, err := loadBlocks(t, blockDataFile, blockDataNet)
+	if err != nil {
+		t.Errorf("loadBlocks: Unexpected error: %v", err)
+		return
+	}
+	for _, block := range blocks {
+		err := idb.Update(func(tx database.Tx) error {
+			return tx.StoreBlock(block)
+		})
+		if err != nil {
+			t.Errorf("StoreBlock: Unexpected error: %v", err)
+			return
+		}
+	}
+
+	// Keep the second half of the blocks.
+	keep := make(map[chainhash.Hash]struct{})
+	for _, block := range blocks[len(blocks)/2:] {
+		keep[*block.Hash()] = struct{}{}
+	}
+	var pruned []chainhash.Hash
+	err = idb.Update(func(tx database.Tx) error {
+		var err error
+		pruned, err = tx.PruneBlocks(func(hash *chainhash.Hash) bool {
+			_, ok := keep[*hash]
+			return !ok
+		})
+		return err
+	})
+	if err != nil {
+		t.Errorf("PruneBlocks: Unexpected error: %v", err)
+		return
+	}
+	if len(pruned) == 0 || len(pruned) > len(blocks)/2 {
+		t.Errorf("PruneBlocks: pruned %d of %d blocks", len(pruned),
+			len(blocks))
+		return
+	}
+	for _, hash := range pruned {
+		if _, ok := keep[hash]; ok {
+			t.Errorf("PruneBlocks: pruned kept block %v", hash)
+		}
+	}
+	if fileExists(blockFilePath(dbPath, 0)) {
+		t.Errorf("PruneBlocks: block file 0 not removed")
+	}
+
+	_ = idb.View(func(tx database.Tx) error {
+		hash := blocks[0].Hash()
+		if ok, _ := tx.HasBlock(hash); !ok {
+			t.Errorf("HasBlock: pruned block %v not known", hash)
+		}
+		_, err := tx.FetchBlock(hash)
+		checkDbError(t, "FetchBlock", err, database.ErrBlockPruned)
+		_, err = tx.FetchBlockHeader(hash)
+		checkDbError(t, "FetchBlockHeader", err, database.ErrBlockPruned)
+
+		hash = blocks[len(blocks)-1].Hash()
+		if _, err := tx.FetchBlock(hash); err != nil {
+			t.Errorf("FetchBlock: Unexpected error: %v", err)
+		}
+		return nil
+	})
+}
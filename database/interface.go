@@ -309,6 +309,7 @@ type Tx interface {
 	// The interface contract guarantees at least the following errors will
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if the requested block hash does not exist
+	//   - ErrBlockPruned if the requested block has been pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -352,6 +353,7 @@ type Tx interface {
 	// The interface contract guarantees at least the following errors will
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if the requested block hash does not exist
+	//   - ErrBlockPruned if the requested block has been pruned
 	//   - ErrBlockRegionInvalid if the region exceeds the bounds of the
 	//     associated block
 	//   - ErrTxClosed if the transaction has already been closed
@@ -380,6 +382,7 @@ type Tx interface {
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if any of the requested block hashed do not
 	//     exist
+	//   - ErrBlockPruned if any of the requested blocks has been pruned
 	//   - ErrBlockRegionInvalid if one or more region exceed the bounds of
 	//     the associated block
 	//   - ErrTxClosed if the transaction has already been closed
@@ -392,6 +395,20 @@ type Tx interface {
 	// implementations.
 	FetchBlockRegions(regions []BlockRegion) ([][]byte, error)
 
+	// PruneBlocks removes the oldest block files from the block storage as
+	// long as all the blocks they hold are accepted by the prunable
+	// function.  The file blocks are currently written to is never
+	// removed.  The pruned blocks are still known by HasBlock, however
+	// fetching their data returns ErrBlockPruned.  The hashes of the pruned
+	// blocks are returned.  The files are removed once the transaction is
+	// committed.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	PruneBlocks(prunable func(hash *chainhash.Hash) bool) ([]chainhash.Hash, error)
+
 	// ******************************************************************
 	// Methods related to both atomic metadata storage and block storage.
 	// ******************************************************************
@@ -5,6 +5,7 @@
 # 3. go vet        (http://golang.org/cmd/vet)
 # 4. gosimple      (https://github.com/dominikh/go-simple)
 # 5. unconvert     (https://github.com/mdempsky/unconvert)
+# 6. race detector (http://blog.golang.org/race-detector)
 #
 # gometalinter (github.com/alecthomas/gometalinter) is used to run each static
 # checker.
@@ -35,4 +36,4 @@ test -z "$(gometalinter.v2 -j 4 --disable-all \
 --enable=gosimple \
 --enable=unconvert \
 --deadline=10m $linter_targets 2>&1 | grep -v 'ALL_CAPS\|OP_' 2>&1 | tee /dev/stderr)"
-go test -tags rpctest $linter_targets
+env GORACE="halt_on_error=1" go test -race -tags rpctest $linter_targets
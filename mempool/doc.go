@@ -63,6 +63,12 @@ be an exhaustive list.
    - The starting priority for the transaction
  - Manual control of transaction removal
    - Recursive removal of all dependent transactions
+ - Package acceptance (transactions that only make sense together)
+   - Atomic validation of a transaction along with its unconfirmed ancestors
+   - Fees evaluated for the package as a whole
+ - Persistence of the pool across restarts
+   - Dump of the transactions and their metadata to a versioned file
+   - Full validation of the dumped transactions when they are loaded back
 
 Errors
 
@@ -221,6 +221,18 @@ func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 	}
 }
 
+// RemoveTransaction is called when a transaction observed in the mempool is
+// removed from it without being mined, such as when it is replaced by a
+// transaction paying a higher fee.  It is then no longer taken into account.
+func (ef *FeeEstimator) RemoveTransaction(hash *chainhash.Hash) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	if o, ok := ef.observed[*hash]; ok && o.mined == mining.UnminedHeight {
+		delete(ef.observed, *hash)
+	}
+}
+
 // RegisterBlock informs the fee estimator of a new block to take into account.
 func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 	ef.mtx.Lock()
@@ -0,0 +1,51 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+// evictionHeap implements a min-heap of the transactions in the pool ordered
+// by the fee rate of each transaction together with its descendants in the
+// pool, which are evicted along with it when the pool is full.
+type evictionHeap []*TxDesc
+
+// Len returns the number of transactions in the heap.  It is part of the
+// heap.Interface implementation.
+func (h evictionHeap) Len() int {
+	return len(h)
+}
+
+// Less returns whether the transaction in the heap with index i has a lower
+// descendant fee rate than the one with index j.  It is part of the
+// heap.Interface implementation.
+func (h evictionHeap) Less(i, j int) bool {
+	return h[i].descendantFeeRate() < h[j].descendantFeeRate()
+}
+
+// Swap swaps the transactions at the passed indices in the heap.  It is part
+// of the heap.Interface implementation.
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].evictIndex = i
+	h[j].evictIndex = j
+}
+
+// Push pushes the passed transaction onto the heap.  It is part of the
+// heap.Interface implementation.
+func (h *evictionHeap) Push(x interface{}) {
+	txDesc := x.(*TxDesc)
+	txDesc.evictIndex = len(*h)
+	*h = append(*h, txDesc)
+}
+
+// Pop removes the transaction with the lowest descendant fee rate from the
+// heap and returns it.  It is part of the heap.Interface implementation.
+func (h *evictionHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	txDesc := old[n-1]
+	txDesc.evictIndex = -1
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return txDesc
+}
@@ -6,6 +6,7 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"fmt"
 	"github.com/zeusyf/btcd/btcec"
@@ -13,6 +14,7 @@ import (
 
 	//	"github.com/zeusyf/omega/token"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -44,6 +46,44 @@ const (
 	// orphanExpireScanInterval is the minimum amount of time in between
 	// scans of the orphan pool to evict expired transactions.
 	orphanExpireScanInterval = time.Minute * 5
+
+	// MaxRBFSequence is the maximum sequence number an input can use to
+	// signal that the transaction spending it can be replaced using the
+	// Replace-By-Fee (RBF) policy.
+	MaxRBFSequence = 0xfffffffd
+
+	// MaxReplacementEvictions is the maximum number of transactions that
+	// can be evicted from the pool when accepting a replacement
+	// transaction, including the descendants of the replaced ones.
+	MaxReplacementEvictions = 100
+
+	// MaxAncestorCount is the maximum number of transactions in the pool a
+	// transaction may depend on, directly or indirectly, counting itself.
+	MaxAncestorCount = 25
+
+	// MaxAncestorSize is the maximum total weight of a transaction and its
+	// ancestors in the pool.
+	MaxAncestorSize = 101000
+
+	// MaxDescendantCount is the maximum number of transactions in the pool
+	// which may depend on a transaction, counting itself.
+	MaxDescendantCount = 25
+
+	// DefaultMaxPoolBytes is the default maximum total serialized size of
+	// the transactions in the pool.
+	DefaultMaxPoolBytes = 300 * 1024 * 1024
+
+	// DefaultMaxTxAge is the default maximum amount of time a transaction
+	// is allowed to stay in the pool before it expires and is evicted.
+	DefaultMaxTxAge = time.Hour * 24 * 14
+
+	// rollingFeeHalfLife is the amount of time it takes for the minimum
+	// fee rate raised by evictions to decay by half.
+	rollingFeeHalfLife = time.Hour * 12
+
+	// txExpireScanInterval is the minimum amount of time in between scans
+	// of the pool to evict expired transactions.
+	txExpireScanInterval = time.Hour
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -87,6 +127,12 @@ type Config struct {
 	// into the mempool or not.
 	IsDeploymentActive func(deploymentID uint32) (bool, error)
 
+	// ContractExecLimit defines the function to use to access the maximum
+	// number of contract execution steps of the next block.  It bounds the
+	// execution cost of a contract execution transaction.  When it is nil,
+	// the default limit of the chain parameters is used.
+	ContractExecLimit func() int64
+
 	// SigCache defines a signature cache to use.
 //	SigCache *txscript.SigCache
 
@@ -141,6 +187,23 @@ type Policy struct {
 	// MinRelayTxFee defines the minimum transaction fee in OMC/kB to be
 	// considered a non-zero fee.
 	MinRelayTxFee btcutil.Amount
+
+	// RejectReplacement, if true, rejects accepting replacement
+	// transactions using the Replace-By-Fee (RBF) signaling policy into
+	// the mempool.
+	RejectReplacement bool
+
+	// MaxPoolBytes is the maximum total serialized size of the
+	// transactions in the pool.  When it is exceeded, the transactions with
+	// the lowest fee rate, counting their descendants, are evicted and the
+	// minimum fee rate to enter the pool is raised.  Zero selects
+	// DefaultMaxPoolBytes, and a negative value means no limit.
+	MaxPoolBytes int64
+
+	// MaxTxAge is the maximum amount of time a transaction is allowed to
+	// stay in the pool before it is evicted.  Zero selects DefaultMaxTxAge,
+	// and a negative value means no limit.
+	MaxTxAge time.Duration
 }
 
 // TxDesc is a descriptor containing a transaction in the mempool along with
@@ -151,6 +214,28 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// DescendantCount is the number of transactions in the pool depending
+	// on the transaction, directly or indirectly, counting itself.
+	DescendantCount int
+
+	// DescendantSize is the total weight of the transaction and its
+	// descendants in the pool.
+	DescendantSize int64
+
+	// DescendantFees is the total fee the transaction and its descendants
+	// in the pool pay.
+	DescendantFees int64
+
+	// evictIndex is the index of the transaction in the eviction heap of
+	// the pool.
+	evictIndex int
+}
+
+// descendantFeeRate returns the fee rate in hao/kB of the transaction together
+// with its descendants in the pool, which would be evicted with it.
+func (txD *TxDesc) descendantFeeRate() float64 {
+	return float64(txD.DescendantFees) * 1000 / float64(txD.DescendantSize)
 }
 
 // orphanTx is normal transaction that references an ancestor transaction
@@ -178,6 +263,14 @@ type TxPool struct {
 	outpoints     map[wire.OutPoint]*btcutil.Tx
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
+	poolBytes     int64   // total serialized size of the pool transactions
+	evictHeap     evictionHeap
+
+	// rollingMinFee is the minimum fee rate in hao/kB raised when
+	// transactions are evicted because the pool is full.  It decays with
+	// time since lastRollingFeeUpdate.
+	rollingMinFee        float64
+	lastRollingFeeUpdate time.Time
 
 	// nextExpireScan is the time after which the orphan pool will be
 	// scanned in order to evict orphans.  This is NOT a hard deadline as
@@ -185,6 +278,11 @@ type TxPool struct {
 	// to on an unconditional timer.
 	nextExpireScan time.Time
 
+	// nextTxExpireScan is the time after which the pool will be scanned in
+	// order to evict expired transactions.  Like nextExpireScan, it is not
+	// a hard deadline as the scan only runs when a block is connected.
+	nextTxExpireScan time.Time
+
 //	Blacklist blockchain.Violations
 }
 
@@ -494,6 +592,21 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 
 	// Remove the transaction if needed.
 	if txDesc, exists := mp.pool[*txHash]; exists {
+		// The transaction no longer depends on its ancestors, and the
+		// descendants left in the pool no longer depend on it, such as
+		// when it is mined.
+		ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+		mp.txAncestors(tx, ancestors)
+		for hash := range ancestors {
+			mp.unlinkDescendant(mp.pool[hash], txDesc)
+		}
+		descendants := make(map[chainhash.Hash]*btcutil.Tx)
+		mp.txDescendants(tx, descendants)
+		for hash := range descendants {
+			mp.unlinkDescendant(txDesc, mp.pool[hash])
+		}
+		heap.Remove(&mp.evictHeap, txDesc.evictIndex)
+
 		// Remove unconfirmed address index entries associated with the
 		// transaction if enabled.
 		if mp.cfg.AddrIndex != nil {
@@ -508,10 +621,55 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		mp.poolBytes -= int64(tx.MsgTx().SerializeSize())
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+
+		// The descendants no longer depend on the ancestors they were
+		// only depending on through the transaction.
+		for hash, descendant := range descendants {
+			remaining := make(map[chainhash.Hash]*btcutil.Tx)
+			mp.txAncestors(descendant, remaining)
+			for ancestorHash := range ancestors {
+				if _, ok := remaining[ancestorHash]; !ok {
+					mp.unlinkDescendant(mp.pool[ancestorHash],
+						mp.pool[hash])
+				}
+			}
+		}
 	}
 }
 
+// linkDescendant accounts for the passed descendant in the descendant stats of
+// the passed transaction, and for the transaction in the ancestor stats of the
+// descendant.  The transaction is moved in the eviction heap accordingly.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) linkDescendant(txDesc, descendant *TxDesc) {
+	txDesc.DescendantCount++
+	txDesc.DescendantSize += blockchain.GetTransactionWeight(descendant.Tx)
+	txDesc.DescendantFees += descendant.Fee
+	heap.Fix(&mp.evictHeap, txDesc.evictIndex)
+
+	descendant.AncestorCount++
+	descendant.AncestorSize += blockchain.GetTransactionWeight(txDesc.Tx)
+	descendant.AncestorFees += txDesc.Fee
+}
+
+// unlinkDescendant reverses linkDescendant when the passed descendant no
+// longer depends on the passed transaction.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) unlinkDescendant(txDesc, descendant *TxDesc) {
+	txDesc.DescendantCount--
+	txDesc.DescendantSize -= blockchain.GetTransactionWeight(descendant.Tx)
+	txDesc.DescendantFees -= descendant.Fee
+	heap.Fix(&mp.evictHeap, txDesc.evictIndex)
+
+	descendant.AncestorCount--
+	descendant.AncestorSize -= blockchain.GetTransactionWeight(txDesc.Tx)
+	descendant.AncestorFees -= txDesc.Fee
+}
+
 // RemoveTransaction removes the passed transaction from the mempool. When the
 // removeRedeemers flag is set, any transactions that redeem outputs from the
 // removed transaction will also be removed recursively from the mempool, as
@@ -556,25 +714,71 @@ func (mp *TxPool) RemoveDoubleSpends(tx *btcutil.Tx) {
 func (mp *TxPool) addTransaction(utxoView *viewpoint.UtxoViewpoint, tx *btcutil.Tx, height int32, fee int64) *TxDesc {
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
+	txSize := blockchain.GetTransactionWeight(tx)
 	txD := &TxDesc{
 		TxDesc: mining.TxDesc{
-			Tx:       tx,
-			Added:    time.Now(),
-			Height:   height,
-			Fee:      fee,
-			FeePerKB: fee * 1000 / blockchain.GetTransactionWeight(tx),
-			Tried:	  0,
+			Tx:            tx,
+			Added:         time.Now(),
+			Height:        height,
+			Fee:           fee,
+			FeePerKB:      fee * 1000 / txSize,
+			Tried:         0,
+			AncestorCount: 1,
+			AncestorSize:  txSize,
+			AncestorFees:  fee,
 		},
 		StartingPriority: mining.CalcPriority(tx.MsgTx(), utxoView, height),
+		DescendantCount:  1,
+		DescendantSize:   txSize,
+		DescendantFees:   fee,
+	}
+
+	// Account for the ancestors of the transaction in the pool.
+	ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+	mp.txAncestors(tx, ancestors)
+	for hash := range ancestors {
+		mp.linkDescendant(mp.pool[hash], txD)
+	}
+
+	// The pool may already have descendants of the transaction, such as
+	// when the transactions of a disconnected block are added back after
+	// their own descendants.  Those of its ancestors don't depend on them
+	// yet, unless through another transaction.
+	descendants := make(map[chainhash.Hash]*btcutil.Tx)
+	mp.txDescendants(tx, descendants)
+	var unlinked map[chainhash.Hash][]*TxDesc
+	if len(descendants) > 0 {
+		unlinked = make(map[chainhash.Hash][]*TxDesc, len(ancestors))
+		for hash, ancestor := range ancestors {
+			linked := make(map[chainhash.Hash]*btcutil.Tx)
+			mp.txDescendants(ancestor, linked)
+			for descendantHash := range descendants {
+				if _, ok := linked[descendantHash]; !ok {
+					unlinked[hash] = append(unlinked[hash],
+						mp.pool[descendantHash])
+				}
+			}
+		}
 	}
 
 	mp.pool[*tx.Hash()] = txD
+	heap.Push(&mp.evictHeap, txD)
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
 			continue
 		}
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+
+	for hash := range descendants {
+		mp.linkDescendant(txD, mp.pool[hash])
+	}
+	for hash, descendants := range unlinked {
+		for _, descendant := range descendants {
+			mp.linkDescendant(mp.pool[hash], descendant)
+		}
+	}
+	mp.poolBytes += int64(tx.MsgTx().SerializeSize())
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -593,26 +797,523 @@ func (mp *TxPool) addTransaction(utxoView *viewpoint.UtxoViewpoint, tx *btcutil.
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
-// Note it does not check for double spends against transactions already in the
-// main chain.
+// If it does, we'll check whether each of those transactions are signaling for
+// replacement.  If just one of them isn't, an error is returned.  Otherwise, a
+// boolean is returned signaling that the transaction is a replacement.  Note it
+// does not check for double spends against transactions already in the main
+// chain.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) checkPoolDoubleSpend(tx *btcutil.Tx) error {
+func (mp *TxPool) checkPoolDoubleSpend(tx *btcutil.Tx) (bool, error) {
+	var isReplacement bool
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
 			continue
 		}
-		if txR, exists := mp.outpoints[txIn.PreviousOutPoint]; exists {
+		txR, exists := mp.outpoints[txIn.PreviousOutPoint]
+		if !exists {
+			continue
+		}
+
+		// Reject the transaction if replacements are not accepted or if
+		// the conflicting transaction doesn't signal replacement.
+		if mp.cfg.Policy.RejectReplacement ||
+			!mp.signalsReplacement(txR, nil) {
+
 			str := fmt.Sprintf("output %v already spent by "+
 				"transaction %v in the memory pool",
 				txIn.PreviousOutPoint, txR.Hash())
-			return txRuleError(common.RejectDuplicate, str)
+			return false, txRuleError(common.RejectDuplicate, str)
+		}
+
+		isReplacement = true
+	}
+
+	return isReplacement, nil
+}
+
+// signalsReplacement determines if a transaction is signaling that it can be
+// replaced using the Replace-By-Fee (RBF) policy.  This policy specifies two
+// ways a transaction can signal that it is replaceable:
+//
+// Explicit signaling: A transaction is considered to have opted in to allowing
+// replacement of itself if any of its inputs have a sequence number less than
+// or equal to MaxRBFSequence.
+//
+// Inherited signaling: Transactions that don't explicitly signal replaceability
+// are replaceable under this policy for as long as any one of their ancestors
+// signals replaceability and remains unconfirmed.
+//
+// The cache is optional and tracks the transactions already known not to
+// signal replacement, so they are not walked more than once.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) signalsReplacement(tx *btcutil.Tx, cache map[chainhash.Hash]struct{}) bool {
+	if cache == nil {
+		cache = make(map[chainhash.Hash]struct{})
+	}
+
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+			continue
+		}
+		if txIn.Sequence <= MaxRBFSequence {
+			return true
+		}
+	}
+
+	// A transaction with an unconfirmed parent signaling replacement
+	// inherits the signal.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+			continue
+		}
+		hash := txIn.PreviousOutPoint.Hash
+		if _, ok := cache[hash]; ok {
+			continue
+		}
+		parent, exists := mp.pool[hash]
+		if !exists {
+			continue
+		}
+		if mp.signalsReplacement(parent.Tx, cache) {
+			return true
+		}
+		cache[hash] = struct{}{}
+	}
+
+	return false
+}
+
+// txDescendants adds all of the transactions in the pool which spend outputs
+// of the passed transaction, directly or indirectly, to the passed set.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txDescendants(tx *btcutil.Tx, descendants map[chainhash.Hash]*btcutil.Tx) {
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for i, txOut := range tx.MsgTx().TxOut {
+		if txOut.IsSeparator() {
+			continue
+		}
+		prevOut.Index = uint32(i)
+		txR, exists := mp.outpoints[prevOut]
+		if !exists {
+			continue
+		}
+		if _, ok := descendants[*txR.Hash()]; ok {
+			continue
+		}
+		descendants[*txR.Hash()] = txR
+		mp.txDescendants(txR, descendants)
+	}
+}
+
+// txAncestors adds all of the transactions in the pool which the passed
+// transaction spends outputs of, directly or indirectly, to the passed set.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txAncestors(tx *btcutil.Tx, ancestors map[chainhash.Hash]*btcutil.Tx) {
+	for _, txIn := range tx.MsgTx().TxIn {
+		hash := txIn.PreviousOutPoint.Hash
+		if hash.IsEqual(&zerohash) {
+			continue
+		}
+		if _, ok := ancestors[hash]; ok {
+			continue
+		}
+		parent, exists := mp.pool[hash]
+		if !exists {
+			continue
+		}
+		ancestors[hash] = parent.Tx
+		mp.txAncestors(parent.Tx, ancestors)
+	}
+}
+
+// checkPackageLimits ensures adding the passed transaction to the pool does
+// not make it, or any of its ancestors, part of a package of dependent
+// transactions larger than the limits.  Large packages are expensive to track
+// and to select transactions from for block templates.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(tx *btcutil.Tx) error {
+	txHash := tx.Hash()
+
+	ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+	mp.txAncestors(tx, ancestors)
+	if len(ancestors)+1 > MaxAncestorCount {
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", txHash, len(ancestors)+1,
+			MaxAncestorCount)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	size := blockchain.GetTransactionWeight(tx)
+	for _, ancestor := range ancestors {
+		size += blockchain.GetTransactionWeight(ancestor)
+	}
+	if size > MaxAncestorSize {
+		str := fmt.Sprintf("transaction %v has too large unconfirmed "+
+			"ancestors: %d > %d", txHash, size, MaxAncestorSize)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	for hash, ancestor := range ancestors {
+		descendants := make(map[chainhash.Hash]*btcutil.Tx)
+		mp.txDescendants(ancestor, descendants)
+		if len(descendants)+2 > MaxDescendantCount {
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"limit of %d descendants of transaction %v",
+				txHash, MaxDescendantCount, hash)
+			return txRuleError(common.RejectNonstandard, str)
 		}
 	}
 
 	return nil
 }
 
+// txConflicts returns the transactions in the pool which spend the same
+// outputs as the passed transaction.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txConflicts(tx *btcutil.Tx) map[chainhash.Hash]*btcutil.Tx {
+	conflicts := make(map[chainhash.Hash]*btcutil.Tx)
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+			continue
+		}
+		if txR, exists := mp.outpoints[txIn.PreviousOutPoint]; exists {
+			conflicts[*txR.Hash()] = txR
+		}
+	}
+
+	return conflicts
+}
+
+// validateReplacement determines whether the passed transaction paying the
+// passed fee may replace the transactions in the pool it conflicts with.  A
+// replacement must:
+//
+//   - not evict more than MaxReplacementEvictions transactions, counting the
+//     descendants of the conflicting transactions
+//   - not spend outputs of any of the transactions it evicts
+//   - pay a higher fee rate than each of the conflicting transactions
+//   - pay a higher absolute fee than all of the evicted transactions together,
+//     by at least the minimum relay fee for its own size
+//
+// The fee of a contract execution transaction pays for the execution of its
+// contracts, whose cost is only known once they are executed.  So the fee of
+// such a replacement is reduced by the cost of executing all of the contract
+// execution steps permitted in the next block before being compared, so it
+// can't shift the fees the evicted transactions paid to its own execution.
+//
+// It returns all of the transactions to evict from the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) validateReplacement(tx *btcutil.Tx, txFee int64, contract bool) (map[chainhash.Hash]*btcutil.Tx, error) {
+	txHash := tx.Hash()
+
+	if contract {
+		execFee := mp.contractExecFee()
+		if txFee <= execFee {
+			str := fmt.Sprintf("replacement transaction %v with "+
+				"contract execution has %d fees which is under "+
+				"the execution cost of %d", txHash, txFee, execFee)
+			return nil, txRuleError(common.RejectInsufficientFee, str)
+		}
+		txFee -= execFee
+	}
+
+	txSize := blockchain.GetTransactionWeight(tx)
+	txFeeRate := txFee * 1000 / txSize
+
+	conflicts := mp.txConflicts(tx)
+	evicted := make(map[chainhash.Hash]*btcutil.Tx, len(conflicts))
+	for hash, conflict := range conflicts {
+		conflictDesc := mp.pool[hash]
+		if txFeeRate <= conflictDesc.FeePerKB {
+			str := fmt.Sprintf("replacement transaction %v has an "+
+				"insufficient fee rate: needs more than %v, has %v",
+				txHash, conflictDesc.FeePerKB, txFeeRate)
+			return nil, txRuleError(common.RejectInsufficientFee, str)
+		}
+
+		evicted[hash] = conflict
+		mp.txDescendants(conflict, evicted)
+		if len(evicted) > MaxReplacementEvictions {
+			str := fmt.Sprintf("replacement transaction %v evicts "+
+				"more transactions than permitted: max is %v",
+				txHash, MaxReplacementEvictions)
+			return nil, txRuleError(common.RejectNonstandard, str)
+		}
+	}
+
+	// The replacement must not spend outputs of the transactions it
+	// evicts, as it would be left without its inputs.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if _, ok := evicted[txIn.PreviousOutPoint.Hash]; ok {
+			str := fmt.Sprintf("replacement transaction %v spends "+
+				"output of transaction %v which it replaces",
+				txHash, txIn.PreviousOutPoint.Hash)
+			return nil, txRuleError(common.RejectInvalid, str)
+		}
+	}
+
+	// The replacement must pay for the evicted transactions, and for its
+	// own relay.
+	var evictedFees int64
+	for hash := range evicted {
+		evictedFees += mp.pool[hash].Fee
+	}
+	minFee := evictedFees + CalcMinRequiredTxRelayFee(txSize,
+		mp.cfg.Policy.MinRelayTxFee)
+	if txFee < minFee {
+		str := fmt.Sprintf("replacement transaction %v has an "+
+			"insufficient absolute fee: needs %v, has %v",
+			txHash, minFee, txFee)
+		return nil, txRuleError(common.RejectInsufficientFee, str)
+	}
+
+	return evicted, nil
+}
+
+// transactionFee returns the fee the passed transaction pays before any of its
+// contracts is executed, which is the fee it is accounted for in the pool.
+// Executing the contracts of a contract execution transaction may add inputs
+// to it, so its fee is only checked when it has to pay for replacing other
+// transactions.  Otherwise, lenient should be set, and it is accounted as
+// paying no fee when its fee can't be determined before execution.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) transactionFee(tx *btcutil.Tx, views *viewpoint.ViewPointSet, lenient bool) (int64, error) {
+	txFee, err := blockchain.CheckTransactionFees(tx, chaincfg.Version2, 0,
+		views, mp.cfg.ChainParams)
+	if err != nil {
+		if lenient {
+			return 0, nil
+		}
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return 0, chainRuleError(cerr)
+		}
+		return 0, err
+	}
+	return txFee, nil
+}
+
+// contractExecFee returns the cost of executing all of the contract execution
+// steps permitted in the next block, which is the most the execution of a
+// transaction can take from its fee.
+func (mp *TxPool) contractExecFee() int64 {
+	limit := mp.cfg.ChainParams.ContractExecLimit
+	if mp.cfg.ContractExecLimit != nil {
+		if l := mp.cfg.ContractExecLimit(); l > limit {
+			limit = l
+		}
+	}
+	return mp.cfg.ChainParams.ContractExecFee * limit / 10000
+}
+
+// evictTransaction removes the passed transaction and its descendants from the
+// pool, along with their address index entries, and tells the fee estimator
+// they will not be mined.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) evictTransaction(tx *btcutil.Tx) {
+	evicted := map[chainhash.Hash]*btcutil.Tx{*tx.Hash(): tx}
+	mp.txDescendants(tx, evicted)
+	mp.removeTransaction(tx, true)
+
+	if mp.cfg.FeeEstimator != nil {
+		for hash := range evicted {
+			mp.cfg.FeeEstimator.RemoveTransaction(&hash)
+		}
+	}
+}
+
+// expireTransactions evicts the transactions which have stayed in the pool
+// longer than the maximum transaction age of the policy, along with their
+// descendants.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) expireTransactions() {
+	if mp.cfg.Policy.MaxTxAge <= 0 {
+		return
+	}
+
+	expiry := time.Now().Add(-mp.cfg.Policy.MaxTxAge)
+	for hash, txDesc := range mp.pool {
+		if txDesc.Added.Before(expiry) {
+			log.Debugf("Expired transaction %v (added %v)", hash,
+				txDesc.Added)
+			mp.evictTransaction(txDesc.Tx)
+		}
+	}
+}
+
+// ExpireTransactions evicts the transactions which have stayed in the pool
+// longer than the maximum transaction age of the policy, along with their
+// descendants.  The pool is only scanned once txExpireScanInterval has passed
+// since the previous scan, so it is meant to be called as blocks are
+// connected.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ExpireTransactions() {
+	mp.mtx.Lock()
+	if now := time.Now(); now.After(mp.nextTxExpireScan) {
+		mp.expireTransactions()
+		mp.nextTxExpireScan = now.Add(txExpireScanInterval)
+	}
+	mp.mtx.Unlock()
+}
+
+// limitPoolSize evicts, while the pool is larger than the maximum size of the
+// policy, the transactions with the lowest fee rate counting their
+// descendants, along with the descendants.  The rolling minimum fee rate is
+// raised above the rate of the evicted transactions, so they are not accepted
+// again at once.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	maxBytes := mp.cfg.Policy.MaxPoolBytes
+	if maxBytes <= 0 || mp.poolBytes <= maxBytes {
+		return
+	}
+
+	var evictedFeeRate float64
+	for mp.poolBytes > maxBytes && len(mp.evictHeap) > 0 {
+		txDesc := mp.evictHeap[0]
+		feeRate := txDesc.descendantFeeRate()
+
+		log.Debugf("Evicting transaction %v (package fee_rate=%v "+
+			"hao/kb) from full pool", txDesc.Tx.Hash(), feeRate)
+		mp.evictTransaction(txDesc.Tx)
+		if feeRate > evictedFeeRate {
+			evictedFeeRate = feeRate
+		}
+	}
+
+	minFeeRate := evictedFeeRate + float64(mp.cfg.Policy.MinRelayTxFee)
+	if minFeeRate > mp.rollingFeeRate() {
+		mp.rollingMinFee = minFeeRate
+		mp.lastRollingFeeUpdate = time.Now()
+		log.Debugf("Raised the minimum pool fee rate to %v hao/kb",
+			int64(minFeeRate))
+	}
+}
+
+// checkReplacementPoolSize checks that the passed replacement would not be
+// evicted from the pool by limitPoolSize once it replaces the passed evicted
+// transactions.  When the pool would be larger than the maximum size of the
+// policy, the remaining transactions are evicted by their fee rate counting
+// their descendants, the lowest first, so the replacement is rejected when
+// the pool doesn't fit before reaching a transaction paying at least its fee
+// rate, or one of its ancestors.  It must be checked before the replaced
+// transactions are evicted, as they would be lost along with the replacement.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkReplacementPoolSize(tx *btcutil.Tx, txFee int64, evicted map[chainhash.Hash]*btcutil.Tx) error {
+	maxBytes := mp.cfg.Policy.MaxPoolBytes
+	poolBytes := mp.poolBytes + int64(tx.MsgTx().SerializeSize())
+	for _, evictedTx := range evicted {
+		poolBytes -= int64(evictedTx.MsgTx().SerializeSize())
+	}
+	if maxBytes <= 0 || poolBytes <= maxBytes {
+		return nil
+	}
+
+	txFeeRate := float64(txFee) * 1000 /
+		float64(blockchain.GetTransactionWeight(tx))
+	ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+	mp.txAncestors(tx, ancestors)
+
+	remaining := make([]*TxDesc, 0, len(mp.evictHeap))
+	for _, txDesc := range mp.evictHeap {
+		if _, ok := evicted[*txDesc.Tx.Hash()]; !ok {
+			remaining = append(remaining, txDesc)
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		return remaining[i].descendantFeeRate() <
+			remaining[j].descendantFeeRate()
+	})
+
+	removed := make(map[chainhash.Hash]*btcutil.Tx, len(evicted))
+	for hash, evictedTx := range evicted {
+		removed[hash] = evictedTx
+	}
+	for _, txDesc := range remaining {
+		hash := *txDesc.Tx.Hash()
+		if _, ok := removed[hash]; ok {
+			continue
+		}
+
+		// Evicting an ancestor of the replacement would evict it too.
+		if _, ok := ancestors[hash]; ok ||
+			txDesc.descendantFeeRate() >= txFeeRate {
+
+			break
+		}
+
+		descendants := map[chainhash.Hash]*btcutil.Tx{hash: txDesc.Tx}
+		mp.txDescendants(txDesc.Tx, descendants)
+		for descendantHash, descendant := range descendants {
+			if _, ok := removed[descendantHash]; ok {
+				continue
+			}
+			removed[descendantHash] = descendant
+			poolBytes -= int64(descendant.MsgTx().SerializeSize())
+		}
+		if poolBytes <= maxBytes {
+			return nil
+		}
+	}
+
+	str := fmt.Sprintf("replacement transaction %v would be evicted from "+
+		"the full memory pool", tx.Hash())
+	return txRuleError(common.RejectInsufficientFee, str)
+}
+
+// rollingFeeRate returns the minimum fee rate in hao/kB raised by evictions
+// from the full pool after decaying it with the time elapsed since the last
+// update.  It returns zero once it has decayed below half of the minimum relay
+// fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) rollingFeeRate() float64 {
+	if mp.rollingMinFee == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(mp.lastRollingFeeUpdate)
+	mp.rollingMinFee *= math.Pow(0.5,
+		float64(elapsed)/float64(rollingFeeHalfLife))
+	mp.lastRollingFeeUpdate = now
+
+	if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+		mp.rollingMinFee = 0
+	}
+
+	return mp.rollingMinFee
+}
+
+// MinFeeRate returns the minimum fee rate in hao/kB a transaction must pay to
+// enter the pool.  It is the minimum relay fee, unless raised by evictions
+// from the full pool.  It is meant to be advertised to peers with fee filter
+// messages.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFeeRate() btcutil.Amount {
+	mp.mtx.Lock()
+	rate := btcutil.Amount(mp.rollingFeeRate())
+	mp.mtx.Unlock()
+
+	if rate < mp.cfg.Policy.MinRelayTxFee {
+		return mp.cfg.Policy.MinRelayTxFee
+	}
+	return rate
+}
+
 // CheckSpend checks whether the passed outpoint is already spent by a
 // transaction in the mempool. If that's the case the spending transaction will
 // be returned, if not nil will be returned.
@@ -676,55 +1377,33 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*btcutil.Tx, error)
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
-// maybeAcceptTransaction is the internal function which implements the public
-// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// checkTransactionSanity performs the checks of the passed transaction which
+// don't depend on the outputs it spends: it must be sane, must not be a
+// coinbase, and must be standard unless the policy accepts non-standard
+// transactions.
 //
-// This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool, fulllValidate bool) ([]*chainhash.Hash, *TxDesc, error) {
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkTransactionSanity(tx *btcutil.Tx, nextBlockHeight int32, medianTimePast time.Time) error {
 	txHash := tx.Hash()
 
-	if txHash.IsEqual(&zerohash) {
-		str := fmt.Sprintf("transaction txid is zero")
-		return nil, nil, txRuleError(common.RejectDuplicate, str)
-	}
-
-	// Don't accept the transaction if it already exists in the pool.  This
-	// applies to orphan transactions as well when the reject duplicate
-	// orphans flag is set.  This check is intended to be a quick check to
-	// weed out duplicates.
-	if mp.isTransactionInPool(txHash) || (rejectDupOrphans &&
-		mp.isOrphanInPool(txHash)) {
-		str := fmt.Sprintf("already have transaction %v", txHash)
-		return nil, nil, txRuleError(common.RejectDuplicate, str)
-	}
-
 	// Perform preliminary sanity checks on the transaction.  This makes
 	// use of blockchain which contains the invariant rules for what
 	// transactions are allowed into blocks.
 	err := blockchain.CheckTransactionSanity(tx)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return err
 	}
 
 	// A standalone transaction must not be a coinbase transaction.
 	if blockchain.IsCoinBase(tx) {
 		str := fmt.Sprintf("transaction %v is an individual coinbase",
 			txHash)
-		return nil, nil, txRuleError(common.RejectInvalid, str)
+		return txRuleError(common.RejectInvalid, str)
 	}
 
-	// Get the current height of the main chain.  A standalone transaction
-	// will be mined into the next block at best, so its height is at least
-	// one more than the current height.
-	bestHeight := mp.cfg.BestHeight()
-	nextBlockHeight := bestHeight + 1
-
-	medianTimePast := mp.cfg.MedianTimePast()
-
 	// Don't allow non-standard transactions if the network parameters
 	// forbid their acceptance.
 	if !mp.cfg.Policy.AcceptNonStd {
@@ -741,42 +1420,22 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 			}
 			str := fmt.Sprintf("transaction %v is not standard: %v",
 				txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return txRuleError(rejectCode, str)
 		}
 	}
 
-	// The transaction may not use any of the same outputs as other
-	// transactions already in the pool as that would ultimately result in a
-	// double spend.  This check is intended to be quick and therefore only
-	// detects double spends within the transaction pool itself.  The
-	// transaction could still be double spending coins from the main chain
-	// at this point.  There is a more in-depth check that happens later
-	// after fetching the referenced transaction inputs from the main chain
-	// which examines the actual spend data and prevents double spends.
-	err = mp.checkPoolDoubleSpend(tx)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	// Fetch all of the unspent transaction outputs referenced by the inputs
-	// to this transaction.  This function also attempts to fetch the
-	// transaction itself to be used for detecting a duplicate transaction
-	// without needing to do a separate lookup.
-
-	views, err := mp.fetchInputUtxos(tx)
-	utxoView := views.Utxo
-	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
-		return nil, nil, err
-	}
+	return nil
+}
 
+// checkTransactionOutputs ensures the passed transaction doesn't exist in the
+// main chain with outputs not already fully spent, and returns whether it is a
+// contract execution transaction.  The entries of the outputs of the
+// transaction are removed from the view, as they are only fetched to detect
+// duplicates.
+func checkTransactionOutputs(tx *btcutil.Tx, utxoView *viewpoint.UtxoViewpoint) (bool, error) {
 	contract := false
 
-	// Don't allow the transaction if it exists in the main chain and is not
-	// not already fully spent.
-	prevOut := wire.OutPoint{Hash: *txHash}
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
 	for txOutIdx, txOut := range tx.MsgTx().TxOut {
 		if txOut.IsSeparator() {
 			continue
@@ -789,49 +1448,23 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		prevOut.Index = uint32(txOutIdx)
 		entry := utxoView.LookupEntry(prevOut)
 		if entry != nil && !entry.IsSpent() {
-			return nil, nil, txRuleError(common.RejectDuplicate,
+			return false, txRuleError(common.RejectDuplicate,
 				"transaction already exists")
 		}
 		utxoView.RemoveEntry(prevOut)
 	}
-/*
-	for _, txIn := range tx.MsgTx().TxIn {
-		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
-			continue
-		}
-		// Ensure the referenced input transaction is available.
-		utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
-		if utxo == nil || utxo.IsSpent() {
-			continue
-		}
 
-		// check blacklist
-//		var name [20]byte
-//		copy(name[:], utxo.PkScript()[1:21])
-//		if mp.Blacklist.IsGrey(name) {
-//			return nil, nil, fmt.Errorf("Blacklised input")
-//		}
-	}
- */
+	return contract, nil
+}
 
-	// Transaction is an orphan if any of the referenced transaction outputs
-	// don't exist or are already spent.  Adding orphans to the orphan pool
-	// is not handled by this function, and the caller should use
-	// maybeAddOrphan if this behavior is desired.
-	var missingParents []*chainhash.Hash
-	for outpoint, entry := range utxoView.Entries() {
-		if entry == nil || entry.IsSpent() {
-			// Must make a copy of the hash here since the iterator
-			// is replaced and taking its address directly would
-			// result in all of the entries pointing to the same
-			// memory location and thus all be the final hash.
-			hashCopy := outpoint.Hash
-			missingParents = append(missingParents, &hashCopy)
-		}
-	}
-	if len(missingParents) > 0 {
-		return missingParents, nil, nil
-	}
+// checkTransactionInputs performs the checks of the passed transaction against
+// the outputs it spends, which must all be in the passed view.  It doesn't
+// check the fees nor the signatures of the transaction.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkTransactionInputs(tx *btcutil.Tx, views *viewpoint.ViewPointSet, nextBlockHeight int32, medianTimePast time.Time) error {
+	txHash := tx.Hash()
+	utxoView := views.Utxo
 
 	// Don't allow the transaction into the mempool unless its sequence
 	// lock is active, meaning that it'll be allowed into the next block
@@ -839,39 +1472,26 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	sequenceLock, err := mp.cfg.CalcSequenceLock(tx, utxoView)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return err
 	}
 	if !blockchain.SequenceLockActive(sequenceLock, nextBlockHeight,
 		medianTimePast) {
-		return nil, nil, txRuleError(common.RejectNonstandard,
+		return txRuleError(common.RejectNonstandard,
 			"transaction's sequence locks on inputs not met")
 	}
 
 	// Perform several checks on the transaction inputs using the invariant
 	// rules in blockchain for what transactions are allowed into blocks.
-	// Also returns the fees associated with the transaction which will be
-	// used later.
 
 	// here we don't do signature, contract execution, integrity check.
 	err = blockchain.CheckTransactionInputs(tx, nextBlockHeight, views, mp.cfg.ChainParams)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
-		return nil, nil, err
-	}
-
-	txFee := int64(0)
-	if !contract {
-		txFee, err = blockchain.CheckTransactionFees(tx, chaincfg.Version2, 0, views, mp.cfg.ChainParams)
-		if err != nil {
-			if cerr, ok := err.(blockchain.RuleError); ok {
-				return nil, nil, chainRuleError(cerr)
-			}
-			return nil, nil, err
+			return chainRuleError(cerr)
 		}
+		return err
 	}
 
 	// Don't allow transactions with non-standard inputs if the network
@@ -888,7 +1508,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 			}
 			str := fmt.Sprintf("transaction %v has a non-standard "+
 				"input: %v", txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return txRuleError(rejectCode, str)
 		}
 	}
 
@@ -905,14 +1525,173 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	sigOpCost, err := blockchain.GetSigOpCost(tx, false, utxoView, true, true)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return err
 	}
 	if sigOpCost > mp.cfg.Policy.MaxSigOpCostPerTx {
 		str := fmt.Sprintf("transaction %v sigop cost is too high: %d > %d",
 			txHash, sigOpCost, mp.cfg.Policy.MaxSigOpCostPerTx)
-		return nil, nil, txRuleError(common.RejectNonstandard, str)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+			continue
+		}
+		// Ensure the referenced input transaction is available.
+		utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if utxo == nil || utxo.IsSpent() {
+			return fmt.Errorf("Input does not exist")
+		}
+		if utxo.PkScript()[0] == 0x88 {
+			continue
+		}
+		if txIn.SignatureIndex >= uint32(len(tx.MsgTx().SignatureScripts)) {
+			return fmt.Errorf("Incorrect signature index")
+		}
+	}
+	for i, sig := range tx.MsgTx().SignatureScripts {
+		if len(sig) < btcec.MinSigLen {
+			return fmt.Errorf("Incorrect signature")
+		}
+		m := false
+		for _, txIn := range tx.MsgTx().TxIn {
+			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			if txIn.SignatureIndex == uint32(i) {
+				m = true
+			}
+		}
+		if !m {
+			return fmt.Errorf("Tx contains unrefernced signature")
+		}
+	}
+
+	return nil
+}
+
+// maybeAcceptTransaction is the internal function which implements the public
+// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
+// more details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool, fulllValidate bool) ([]*chainhash.Hash, *TxDesc, error) {
+	txHash := tx.Hash()
+
+	if txHash.IsEqual(&zerohash) {
+		str := fmt.Sprintf("transaction txid is zero")
+		return nil, nil, txRuleError(common.RejectDuplicate, str)
+	}
+
+	// Don't accept the transaction if it already exists in the pool.  This
+	// applies to orphan transactions as well when the reject duplicate
+	// orphans flag is set.  This check is intended to be a quick check to
+	// weed out duplicates.
+	if mp.isTransactionInPool(txHash) || (rejectDupOrphans &&
+		mp.isOrphanInPool(txHash)) {
+		str := fmt.Sprintf("already have transaction %v", txHash)
+		return nil, nil, txRuleError(common.RejectDuplicate, str)
+	}
+
+	// Get the current height of the main chain.  A standalone transaction
+	// will be mined into the next block at best, so its height is at least
+	// one more than the current height.
+	bestHeight := mp.cfg.BestHeight()
+	nextBlockHeight := bestHeight + 1
+
+	medianTimePast := mp.cfg.MedianTimePast()
+
+	err := mp.checkTransactionSanity(tx, nextBlockHeight, medianTimePast)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The transaction may not use any of the same outputs as other
+	// transactions already in the pool as that would ultimately result in a
+	// double spend.  This check is intended to be quick and therefore only
+	// detects double spends within the transaction pool itself.  The
+	// transaction could still be double spending coins from the main chain
+	// at this point.  There is a more in-depth check that happens later
+	// after fetching the referenced transaction inputs from the main chain
+	// which examines the actual spend data and prevents double spends.
+	//
+	// A transaction spending outputs spent by transactions in the pool
+	// signaling replacement is a replacement, which is validated later on.
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fetch all of the unspent transaction outputs referenced by the inputs
+	// to this transaction.  This function also attempts to fetch the
+	// transaction itself to be used for detecting a duplicate transaction
+	// without needing to do a separate lookup.
+
+	views, err := mp.fetchInputUtxos(tx)
+	utxoView := views.Utxo
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return nil, nil, chainRuleError(cerr)
+		}
+		return nil, nil, err
+	}
+
+	// Don't allow the transaction if it exists in the main chain and is not
+	// not already fully spent.
+	contract, err := checkTransactionOutputs(tx, utxoView)
+	if err != nil {
+		return nil, nil, err
+	}
+/*
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+			continue
+		}
+		// Ensure the referenced input transaction is available.
+		utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if utxo == nil || utxo.IsSpent() {
+			continue
+		}
+
+		// check blacklist
+//		var name [20]byte
+//		copy(name[:], utxo.PkScript()[1:21])
+//		if mp.Blacklist.IsGrey(name) {
+//			return nil, nil, fmt.Errorf("Blacklised input")
+//		}
+	}
+ */
+
+	// Transaction is an orphan if any of the referenced transaction outputs
+	// don't exist or are already spent.  Adding orphans to the orphan pool
+	// is not handled by this function, and the caller should use
+	// maybeAddOrphan if this behavior is desired.
+	var missingParents []*chainhash.Hash
+	for outpoint, entry := range utxoView.Entries() {
+		if entry == nil || entry.IsSpent() {
+			// Must make a copy of the hash here since the iterator
+			// is replaced and taking its address directly would
+			// result in all of the entries pointing to the same
+			// memory location and thus all be the final hash.
+			hashCopy := outpoint.Hash
+			missingParents = append(missingParents, &hashCopy)
+		}
+	}
+	if len(missingParents) > 0 {
+		return missingParents, nil, nil
+	}
+
+	err = mp.checkTransactionInputs(tx, views, nextBlockHeight,
+		medianTimePast)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txFee, err := mp.transactionFee(tx, views, contract && !isReplacement)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if !contract {
@@ -938,6 +1717,19 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 			return nil, nil, txRuleError(common.RejectInsufficientFee, str)
 		}
 
+		// Don't allow new transactions paying less than the minimum fee
+		// rate raised by evictions from the full pool.
+		if rollingFeeRate := mp.rollingFeeRate(); isNew && rollingFeeRate > 0 {
+			rollingMinFee := CalcMinRequiredTxRelayFee(serializedSize,
+				btcutil.Amount(rollingFeeRate))
+			if txFee < rollingMinFee {
+				str := fmt.Sprintf("transaction %v has %d fees which "+
+					"is under the full memory pool minimum of %d",
+					txHash, txFee, rollingMinFee)
+				return nil, nil, txRuleError(common.RejectInsufficientFee, str)
+			}
+		}
+
 		// Require that free transactions have sufficient priority to be mined
 		// in the next block.  Height which are being added back to the
 		// memory pool from blocks that have been disconnected during a reorg
@@ -978,39 +1770,30 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		}
 	}
 
-	for _, txIn := range tx.MsgTx().TxIn {
-		if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
-			continue
-		}
-		// Ensure the referenced input transaction is available.
-		utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
-		if utxo == nil || utxo.IsSpent() {
-			return nil, nil, fmt.Errorf("Input does not exist")
-		}
-		if utxo.PkScript()[0] == 0x88 {
-			continue
-		}
-		if txIn.SignatureIndex >= uint32(len(tx.MsgTx().SignatureScripts)) {
-			return nil, nil, fmt.Errorf("Incorrect signature index")
-		}
+	// Don't allow the transaction to grow packages of dependent transactions
+	// in the pool beyond the limits.
+	err = mp.checkPackageLimits(tx)
+	if err != nil {
+		return nil, nil, err
 	}
-	for i,sig := range tx.MsgTx().SignatureScripts {
-		if len(sig) < btcec.MinSigLen {
-			return nil, nil, fmt.Errorf("Incorrect signature")
-		}
-		m := false
-		for _, txIn := range tx.MsgTx().TxIn {
-			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
-				continue
-			}
-			if txIn.SignatureIndex == uint32(i) {
-				m = true
-			}
+
+	// If the transaction is a replacement, make sure it is worth evicting
+	// the transactions it conflicts with and their descendants.
+	var evicted map[chainhash.Hash]*btcutil.Tx
+	if isReplacement {
+		evicted, err = mp.validateReplacement(tx, txFee, contract)
+		if err != nil {
+			return nil, nil, err
 		}
-		if !m {
-			return nil, nil, fmt.Errorf("Tx contains unrefernced signature")
+
+		// The transactions it replaces are kept when the replacement
+		// would not stay in a full pool.
+		err = mp.checkReplacementPoolSize(tx, txFee, evicted)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+
 	if fulllValidate {
 		err = ovm.VerifySigs(tx, mp.cfg.ChainParams, 0, views)
 		if err != nil {
@@ -1018,9 +1801,29 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		}
 	}
 
+	// Now that the replacement is validated, evict the transactions it
+	// replaces.
+	for hash, evictedTx := range evicted {
+		if evictedDesc, exists := mp.pool[hash]; exists {
+			log.Debugf("Replacing transaction %v (fee_rate=%v "+
+				"hao/kb) with %v", hash, evictedDesc.FeePerKB,
+				txHash)
+			mp.evictTransaction(evictedTx)
+		}
+	}
+
 	// Add to transaction pool.
 	txD := mp.addTransaction(utxoView, tx, bestHeight, txFee)
 
+	// Keep the pool within its limits.  The transaction itself may be
+	// evicted when its fee rate is among the lowest of a full pool.
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v has been evicted from the "+
+			"full memory pool", txHash)
+		return nil, nil, txRuleError(common.RejectInsufficientFee, str)
+	}
+
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
 
@@ -1313,6 +2116,9 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 			StartingPriority: desc.StartingPriority,
 			CurrentPriority:  currentPriority,
 			Depends:          make([]string, 0),
+			AncestorCount:    int64(desc.AncestorCount),
+			AncestorSize:     desc.AncestorSize,
+			AncestorFees:     btcutil.Amount(desc.AncestorFees).ToOMC(),
 		}
 		for _, txIn := range tx.MsgTx().TxIn {
 			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
@@ -1342,12 +2148,23 @@ func (mp *TxPool) LastUpdated() time.Time {
 // New returns a new memory pool for validating and storing standalone
 // transactions until they are mined into a block.
 func New(cfg *Config) *TxPool {
-	return &TxPool{
+	mp := &TxPool{
 		cfg:            *cfg,
 		pool:           make(map[chainhash.Hash]*TxDesc),
 		orphans:        make(map[chainhash.Hash]*orphanTx),
 		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
 		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
 		outpoints:      make(map[wire.OutPoint]*btcutil.Tx),
+
+		nextTxExpireScan: time.Now().Add(txExpireScanInterval),
 	}
+
+	if mp.cfg.Policy.MaxPoolBytes == 0 {
+		mp.cfg.Policy.MaxPoolBytes = DefaultMaxPoolBytes
+	}
+	if mp.cfg.Policy.MaxTxAge == 0 {
+		mp.cfg.Policy.MaxTxAge = DefaultMaxTxAge
+	}
+
+	return mp
 }
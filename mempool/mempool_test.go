@@ -13,12 +13,15 @@ import (
 	"time"
 
 	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/blockchain/indexers"
 	"github.com/zeusyf/btcd/btcec"
 	"github.com/zeusyf/btcd/chaincfg"
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 //	"github.com/zeusyf/btcd/txscript"
 	"github.com/zeusyf/btcd/wire"
 	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
 )
 
 // fakeChain is used by the pool harness to provide generated test utxos and
@@ -870,3 +873,364 @@ func TestCheckSpend(t *testing.T) {
 		t.Fatalf("Unexpeced spend found in pool: %v", spend)
 	}
 }
+
+// newTestTx returns a transaction spending the passed outpoint with the passed
+// sequence number to a single output of the passed value.
+func newTestTx(prevOut wire.OutPoint, sequence uint32, value int64) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: prevOut,
+		Sequence:         sequence,
+	})
+	msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: value}},
+		PkScript: make([]byte, 25)})
+	return btcutil.NewTx(msgTx)
+}
+
+// addTestTx adds the passed transaction paying the passed fee to the pool
+// without any validation.
+func addTestTx(mp *TxPool, tx *btcutil.Tx, fee int64, added time.Time) {
+	txD := mp.addTransaction(viewpoint.NewUtxoViewpoint(), tx, 1, fee)
+	txD.Added = added
+}
+
+// TestReplacement ensures transactions signaling replacement, directly or
+// through an unconfirmed ancestor, can be replaced by a transaction paying
+// enough fees, and that the descendants are evicted with them.
+func TestReplacement(t *testing.T) {
+	t.Parallel()
+
+	mp := New(&Config{
+		Policy:      Policy{MinRelayTxFee: 1000},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// The parent signals replacement, and the child inherits it.
+	confirmed := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	parent := newTestTx(confirmed, MaxRBFSequence, 100000)
+	child := newTestTx(wire.OutPoint{Hash: *parent.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	addTestTx(mp, parent, 1000, time.Now())
+	addTestTx(mp, child, 1000, time.Now())
+	if !mp.signalsReplacement(child, nil) {
+		t.Fatalf("signalsReplacement: child does not inherit signal")
+	}
+	final := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 100000)
+	if mp.signalsReplacement(final, nil) {
+		t.Fatalf("signalsReplacement: final transaction signals")
+	}
+
+	replacement := newTestTx(confirmed, wire.MaxTxInSequenceNum, 100000)
+	isReplacement, err := mp.checkPoolDoubleSpend(replacement)
+	if err != nil || !isReplacement {
+		t.Fatalf("checkPoolDoubleSpend: got %v, %v, want replacement",
+			isReplacement, err)
+	}
+
+	// The replacement must pay for both evicted transactions.
+	if _, err := mp.validateReplacement(replacement, 2000, false); err == nil {
+		t.Fatalf("validateReplacement: accepted insufficient fee")
+	}
+	evicted, err := mp.validateReplacement(replacement, 100000, false)
+	if err != nil {
+		t.Fatalf("validateReplacement: unexpected error %v", err)
+	}
+	if len(evicted) != 2 || evicted[*parent.Hash()] == nil ||
+		evicted[*child.Hash()] == nil {
+		t.Fatalf("validateReplacement: got %d evicted transactions, "+
+			"want parent and child", len(evicted))
+	}
+
+	// A contract execution replacement pays for its execution as well, up
+	// to the execution limit of the next block.
+	mp.cfg.ContractExecLimit = func() int64 { return 1e9 }
+	execFee := mp.contractExecFee()
+	if execFee != mp.cfg.ChainParams.ContractExecFee*1e9/10000 {
+		t.Fatalf("contractExecFee: got %d for the block limit", execFee)
+	}
+	if _, err := mp.validateReplacement(replacement, execFee, true); err == nil {
+		t.Fatalf("validateReplacement: accepted contract execution " +
+			"replacement paying only its execution")
+	}
+	if _, err := mp.validateReplacement(replacement, 100000, true); err == nil {
+		t.Fatalf("validateReplacement: accepted contract execution " +
+			"replacement paying the evicted fees from its execution")
+	}
+	if _, err := mp.validateReplacement(replacement, execFee+100000, true); err != nil {
+		t.Fatalf("validateReplacement: unexpected error %v", err)
+	}
+
+	mp.cfg.Policy.RejectReplacement = true
+	if _, err := mp.checkPoolDoubleSpend(replacement); err == nil {
+		t.Fatalf("checkPoolDoubleSpend: accepted replacement while " +
+			"rejecting replacements")
+	}
+}
+
+// TestProcessReplacement ensures the transactions replaced by a transaction
+// are evicted along with their address index entries and fee estimator
+// records, and kept when the replacement would not stay in the full pool.
+func TestProcessReplacement(t *testing.T) {
+	t.Parallel()
+
+	mp, confirmed := newPackageTestPool(1000)
+	mp.cfg.AddrIndex = indexers.NewAddrIndex(nil, &chaincfg.MainNetParams)
+	mp.cfg.FeeEstimator = newTestFeeEstimator(10, 10, 10)
+
+	// The replaced transaction pays to an address, so it is indexed.
+	confirmedOut := wire.OutPoint{Hash: *confirmed.Hash()}
+	conflict := newTestTx(confirmedOut, MaxRBFSequence, 90000)
+	pkScript := conflict.MsgTx().TxOut[0].PkScript
+	pkScript[0] = chaincfg.MainNetParams.PubKeyHashAddrID
+	pkScript[21] = indexers.OP_PAY2PKH
+	conflict = btcutil.NewTx(conflict.MsgTx())
+	addr, err := btcutil.NewAddressPubKeyHash(pkScript[1:21],
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error %v", err)
+	}
+
+	// checkReplaced ensures the replaced transaction is in the pool, the
+	// address index and the fee estimator, or in none of them.
+	checkReplaced := func(desc string, want bool) {
+		t.Helper()
+		inPool := mp.IsTransactionInPool(conflict.Hash())
+		indexed := len(mp.cfg.AddrIndex.UnconfirmedTxnsForAddress(addr)) != 0
+		_, observed := mp.cfg.FeeEstimator.observed[*conflict.Hash()]
+		if inPool != want || indexed != want || observed != want {
+			t.Fatalf("%s: got replaced transaction in pool %v, "+
+				"indexed %v, observed %v, want %v", desc, inPool,
+				indexed, observed, want)
+		}
+	}
+
+	if _, err := mp.ProcessTransaction(conflict, false, false, 0, false); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error %v", err)
+	}
+	checkReplaced("replaced transaction accepted", true)
+
+	// A full pool would evict the replacement for a transaction paying a
+	// higher fee rate, so the replaced transaction is kept.
+	other := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 1000)
+	addTestTx(mp, other, 100000, time.Now())
+	mp.cfg.Policy.MaxPoolBytes = mp.poolBytes - 1
+
+	replacement := newTestTx(confirmedOut, wire.MaxTxInSequenceNum, 70000)
+	if _, err := mp.ProcessTransaction(replacement, false, false, 0, false); err == nil {
+		t.Fatalf("ProcessTransaction: accepted replacement evicted " +
+			"from the full pool")
+	}
+	if mp.IsTransactionInPool(replacement.Hash()) {
+		t.Fatalf("ProcessTransaction: rejected replacement in the pool")
+	}
+	checkReplaced("replacement rejected", true)
+
+	mp.cfg.Policy.MaxPoolBytes = 0
+	if _, err := mp.ProcessTransaction(replacement, false, false, 0, false); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error %v", err)
+	}
+	if !mp.IsTransactionInPool(replacement.Hash()) {
+		t.Fatalf("ProcessTransaction: replacement not in the pool")
+	}
+	checkReplaced("replacement accepted", false)
+}
+
+// TestLimitPoolSize ensures expired transactions are evicted, that the
+// descendant stats the pool is ordered by for eviction are kept up to date,
+// and that a full pool evicts the transactions with the lowest package fee rate along with
+// their descendants and raises its minimum fee rate.
+func TestLimitPoolSize(t *testing.T) {
+	t.Parallel()
+
+	mp := New(&Config{
+		Policy: Policy{
+			MinRelayTxFee: 1000,
+			MaxTxAge:      time.Hour,
+		},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// A low fee parent is not saved by a high fee child, while a high fee
+	// parent is kept with its low fee child.
+	lowParent := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		wire.MaxTxInSequenceNum, 100000)
+	highChild := newTestTx(wire.OutPoint{Hash: *lowParent.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	highParent := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 100000)
+	lowChild := newTestTx(wire.OutPoint{Hash: *highParent.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	expired := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x03}},
+		wire.MaxTxInSequenceNum, 100000)
+	addTestTx(mp, lowParent, 100, time.Now())
+	addTestTx(mp, highChild, 1500, time.Now())
+	addTestTx(mp, highParent, 50000, time.Now())
+	addTestTx(mp, lowChild, 1000, time.Now())
+	addTestTx(mp, expired, 100000, time.Now().Add(-2*time.Hour))
+
+	low := mp.pool[*lowParent.Hash()]
+	wantSize := blockchain.GetTransactionWeight(lowParent) +
+		blockchain.GetTransactionWeight(highChild)
+	if low.DescendantCount != 2 || low.DescendantFees != 1600 ||
+		low.DescendantSize != wantSize {
+		t.Fatalf("addTransaction: got descendant stats %d, %d, %d, "+
+			"want 2, 1600, %d", low.DescendantCount,
+			low.DescendantFees, low.DescendantSize, wantSize)
+	}
+	if mp.evictHeap[0] != low {
+		t.Fatalf("addTransaction: transaction %v is not the first to "+
+			"evict", lowParent.Hash())
+	}
+
+	// The pool is only scanned for expired transactions once the scan
+	// interval has passed.
+	mp.ExpireTransactions()
+	if !mp.isTransactionInPool(expired.Hash()) {
+		t.Fatalf("ExpireTransactions: transaction %v evicted before "+
+			"the scan interval", expired.Hash())
+	}
+	mp.nextTxExpireScan = time.Now().Add(-time.Second)
+	mp.ExpireTransactions()
+	if mp.isTransactionInPool(expired.Hash()) {
+		t.Fatalf("ExpireTransactions: transaction %v not evicted",
+			expired.Hash())
+	}
+
+	mp.cfg.Policy.MaxPoolBytes = mp.poolBytes - 1
+	mp.limitPoolSize()
+
+	for _, tx := range []*btcutil.Tx{expired, lowParent, highChild} {
+		if mp.isTransactionInPool(tx.Hash()) {
+			t.Fatalf("limitPoolSize: transaction %v not evicted",
+				tx.Hash())
+		}
+	}
+	for _, tx := range []*btcutil.Tx{highParent, lowChild} {
+		if !mp.isTransactionInPool(tx.Hash()) {
+			t.Fatalf("limitPoolSize: transaction %v evicted", tx.Hash())
+		}
+	}
+	wantBytes := int64(highParent.MsgTx().SerializeSize() +
+		lowChild.MsgTx().SerializeSize())
+	if mp.poolBytes != wantBytes {
+		t.Fatalf("limitPoolSize: got pool size %d, want %d",
+			mp.poolBytes, wantBytes)
+	}
+
+	if rate := mp.MinFeeRate(); rate <= mp.cfg.Policy.MinRelayTxFee {
+		t.Fatalf("MinFeeRate: got %v, want more than the minimum "+
+			"relay fee", rate)
+	}
+
+	// The minimum fee rate decays back to the minimum relay fee.
+	mp.lastRollingFeeUpdate = time.Now().Add(-10 * rollingFeeHalfLife)
+	if rate := mp.MinFeeRate(); rate != mp.cfg.Policy.MinRelayTxFee {
+		t.Fatalf("MinFeeRate: got %v, want %v", rate,
+			mp.cfg.Policy.MinRelayTxFee)
+	}
+}
+
+// TestAncestorTracking ensures the ancestors of the transactions in the pool
+// are tracked as transactions are added and mined, and that the package limits
+// are enforced.
+func TestAncestorTracking(t *testing.T) {
+	t.Parallel()
+
+	mp := New(&Config{
+		Policy:      Policy{MinRelayTxFee: 1000},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// Add a chain of transactions up to the ancestor limit.
+	txs := make([]*btcutil.Tx, 0, MaxAncestorCount)
+	prevOut := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	for i := 0; i < MaxAncestorCount; i++ {
+		tx := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+		if err := mp.checkPackageLimits(tx); err != nil {
+			t.Fatalf("checkPackageLimits #%d: unexpected error %v",
+				i, err)
+		}
+		txD := mp.addTransaction(viewpoint.NewUtxoViewpoint(), tx, 1,
+			int64(1000*(i+1)))
+		if txD.AncestorCount != i+1 {
+			t.Fatalf("addTransaction #%d: got %d ancestors, want %d",
+				i, txD.AncestorCount, i+1)
+		}
+		txs = append(txs, tx)
+		prevOut = wire.OutPoint{Hash: *tx.Hash()}
+	}
+
+	tooMany := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+	if err := mp.checkPackageLimits(tooMany); err == nil {
+		t.Fatalf("checkPackageLimits: accepted transaction exceeding " +
+			"the ancestor limit")
+	}
+
+	first := mp.pool[*txs[0].Hash()]
+	if first.DescendantCount != MaxAncestorCount {
+		t.Fatalf("addTransaction: got %d descendants, want %d",
+			first.DescendantCount, MaxAncestorCount)
+	}
+
+	// Mining the first transaction removes it from the ancestors of the
+	// others.
+	mp.removeTransaction(txs[0], false)
+	last := mp.pool[*txs[len(txs)-1].Hash()]
+	if last.AncestorCount != MaxAncestorCount-1 {
+		t.Fatalf("removeTransaction: got %d ancestors, want %d",
+			last.AncestorCount, MaxAncestorCount-1)
+	}
+	var wantFees, wantSize int64
+	for i, tx := range txs[1:] {
+		wantFees += int64(1000 * (i + 2))
+		wantSize += blockchain.GetTransactionWeight(tx)
+	}
+	if last.AncestorFees != wantFees || last.AncestorSize != wantSize {
+		t.Fatalf("removeTransaction: got ancestor fees %d and size %d, "+
+			"want %d and %d", last.AncestorFees, last.AncestorSize,
+			wantFees, wantSize)
+	}
+	if err := mp.checkPackageLimits(tooMany); err != nil {
+		t.Fatalf("checkPackageLimits: unexpected error %v", err)
+	}
+
+	// Removing a transaction without its descendants removes it, and its
+	// ancestors, from their ancestors.
+	mp.removeTransaction(txs[2], false)
+	second := mp.pool[*txs[1].Hash()]
+	if second.DescendantCount != 1 || second.DescendantFees != 2000 {
+		t.Fatalf("removeTransaction: got %d descendants paying %d, "+
+			"want 1 paying 2000", second.DescendantCount,
+			second.DescendantFees)
+	}
+	if last.AncestorCount != MaxAncestorCount-3 {
+		t.Fatalf("removeTransaction: got %d ancestors, want %d",
+			last.AncestorCount, MaxAncestorCount-3)
+	}
+
+	// Adding the transaction back after its descendants, as when its
+	// block is disconnected, makes it and its ancestors their ancestors
+	// again.
+	mp.addTransaction(viewpoint.NewUtxoViewpoint(), txs[2], 1, 3000)
+	if last.AncestorCount != MaxAncestorCount-1 {
+		t.Fatalf("addTransaction: got %d ancestors, want %d",
+			last.AncestorCount, MaxAncestorCount-1)
+	}
+	if last.AncestorFees != wantFees || last.AncestorSize != wantSize {
+		t.Fatalf("addTransaction: got ancestor fees %d and size %d, "+
+			"want %d and %d", last.AncestorFees, last.AncestorSize,
+			wantFees, wantSize)
+	}
+	if second.DescendantCount != MaxAncestorCount-1 {
+		t.Fatalf("addTransaction: got %d descendants, want %d",
+			second.DescendantCount, MaxAncestorCount-1)
+	}
+	third := mp.pool[*txs[2].Hash()]
+	if third.DescendantCount != MaxAncestorCount-2 {
+		t.Fatalf("addTransaction: got %d descendants, want %d",
+			third.DescendantCount, MaxAncestorCount-2)
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/mining"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/ovm"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// checkPackageTopology ensures the passed transactions form a package which
+// can be accepted as a whole: a transaction along with the unconfirmed
+// ancestors it needs, each one coming after the transactions it spends outputs
+// of.  Every transaction but the last one must therefore be spent by a later
+// transaction of the package, so transactions which don't need each other
+// can't be bundled to share their fees.
+func checkPackageTopology(txs []*btcutil.Tx) error {
+	if len(txs) == 0 {
+		return txRuleError(common.RejectInvalid, "package is empty")
+	}
+	if len(txs) > wire.MaxPackageTxns {
+		str := fmt.Sprintf("package has too many transactions: %d > %d",
+			len(txs), wire.MaxPackageTxns)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	index := make(map[chainhash.Hash]int, len(txs))
+	for i, tx := range txs {
+		if _, exists := index[*tx.Hash()]; exists {
+			str := fmt.Sprintf("package has duplicate transaction %v",
+				tx.Hash())
+			return txRuleError(common.RejectInvalid, str)
+		}
+		index[*tx.Hash()] = i
+	}
+
+	spent := make(map[wire.OutPoint]*btcutil.Tx)
+	hasChild := make([]bool, len(txs))
+	for i, tx := range txs {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if prevOut.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			if txR, exists := spent[prevOut]; exists {
+				str := fmt.Sprintf("package transactions %v and "+
+					"%v both spend output %v", txR.Hash(),
+					tx.Hash(), prevOut)
+				return txRuleError(common.RejectInvalid, str)
+			}
+			spent[prevOut] = tx
+
+			parent, exists := index[prevOut.Hash]
+			if !exists {
+				continue
+			}
+			if parent >= i {
+				str := fmt.Sprintf("package transaction %v comes "+
+					"before its parent %v", tx.Hash(),
+					prevOut.Hash)
+				return txRuleError(common.RejectInvalid, str)
+			}
+			hasChild[parent] = true
+		}
+	}
+
+	for i := 0; i < len(txs)-1; i++ {
+		if !hasChild[i] {
+			str := fmt.Sprintf("package transaction %v is not spent "+
+				"by the package", txs[i].Hash())
+			return txRuleError(common.RejectNonstandard, str)
+		}
+	}
+
+	return nil
+}
+
+// checkPackageTxLimits ensures adding the passed transactions of a package to
+// the pool does not make them, or any of their ancestors, part of a package of
+// dependent transactions larger than the limits.  See checkPackageLimits.  The
+// transactions are accounted as one, so the check is conservative.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageTxLimits(txs []*btcutil.Tx) error {
+	ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+	size := int64(0)
+	for _, tx := range txs {
+		mp.txAncestors(tx, ancestors)
+		size += blockchain.GetTransactionWeight(tx)
+	}
+
+	if len(ancestors)+len(txs) > MaxAncestorCount {
+		str := fmt.Sprintf("package has too many unconfirmed "+
+			"ancestors: %d > %d", len(ancestors)+len(txs),
+			MaxAncestorCount)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	for _, ancestor := range ancestors {
+		size += blockchain.GetTransactionWeight(ancestor)
+	}
+	if size > MaxAncestorSize {
+		str := fmt.Sprintf("package has too large unconfirmed "+
+			"ancestors: %d > %d", size, MaxAncestorSize)
+		return txRuleError(common.RejectNonstandard, str)
+	}
+
+	for hash, ancestor := range ancestors {
+		descendants := make(map[chainhash.Hash]*btcutil.Tx)
+		mp.txDescendants(ancestor, descendants)
+		if len(descendants)+1+len(txs) > MaxDescendantCount {
+			str := fmt.Sprintf("package would exceed the limit of "+
+				"%d descendants of transaction %v",
+				MaxDescendantCount, hash)
+			return txRuleError(common.RejectNonstandard, str)
+		}
+	}
+
+	return nil
+}
+
+// fetchPackageInputUtxos loads the utxos referenced by the inputs of the passed
+// transactions of a package into a single view set shared by the whole
+// package, along with the outputs of the transactions themselves to detect
+// duplicates.  The set is fetched at once, through a transaction spending all
+// of these outpoints, so no view needs to be merged, and everything the earlier
+// transactions of the package add to the set is seen by the later ones.  As in
+// fetchInputUtxos, the missing inputs are then taken from the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) fetchPackageInputUtxos(txs []*btcutil.Tx) (*viewpoint.ViewPointSet, error) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	for _, tx := range txs {
+		for _, txIn := range tx.MsgTx().TxIn {
+			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			msgTx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: txIn.PreviousOutPoint,
+			})
+		}
+		prevOut := wire.OutPoint{Hash: *tx.Hash()}
+		for txOutIdx, txOut := range tx.MsgTx().TxOut {
+			if txOut.IsSeparator() {
+				continue
+			}
+			prevOut.Index = uint32(txOutIdx)
+			msgTx.AddTxIn(&wire.TxIn{PreviousOutPoint: prevOut})
+		}
+	}
+
+	views, err := mp.cfg.FetchUtxoView(btcutil.NewTx(msgTx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempt to populate any missing inputs from the transaction pool.
+	for _, tx := range txs {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := &txIn.PreviousOutPoint
+			if prevOut.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			entry := views.Utxo.LookupEntry(*prevOut)
+			if entry != nil && !entry.IsSpent() {
+				continue
+			}
+
+			if poolTxDesc, exists := mp.pool[prevOut.Hash]; exists {
+				views.AddTxOut(poolTxDesc.Tx, prevOut.Index,
+					mining.UnminedHeight)
+			}
+		}
+	}
+
+	return views, nil
+}
+
+// maybeAcceptPackage is the internal function which implements the public
+// ProcessPackage.  It validates the transactions of the package in order
+// against a view shared by the whole package, so each transaction can spend
+// the outputs of the earlier ones, and accepts all of them or none.  It
+// returns the transactions added to the pool, which don't include the ones of
+// the package already there.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeAcceptPackage(txs []*btcutil.Tx, fulllValidate bool) ([]*TxDesc, error) {
+	err := checkPackageTopology(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the current height of the main chain.  The package will be mined
+	// into the next block at best, so its height is at least one more than
+	// the current height.
+	bestHeight := mp.cfg.BestHeight()
+	nextBlockHeight := bestHeight + 1
+
+	medianTimePast := mp.cfg.MedianTimePast()
+
+	// Skip the transactions of the package which are already in the pool,
+	// their outputs are fetched from the pool like any other ones.
+	newTxs := make([]*btcutil.Tx, 0, len(txs))
+	for _, tx := range txs {
+		if tx.Hash().IsEqual(&zerohash) {
+			str := fmt.Sprintf("transaction txid is zero")
+			return nil, txRuleError(common.RejectDuplicate, str)
+		}
+		if mp.isTransactionInPool(tx.Hash()) {
+			continue
+		}
+
+		err := mp.checkTransactionSanity(tx, nextBlockHeight,
+			medianTimePast)
+		if err != nil {
+			return nil, err
+		}
+
+		// Packages are not allowed to replace transactions in the
+		// pool, as the fees of the replaced transactions would have
+		// to be weighed against the whole package.
+		isReplacement, err := mp.checkPoolDoubleSpend(tx)
+		if err != nil {
+			return nil, err
+		}
+		if isReplacement {
+			str := fmt.Sprintf("package transaction %v replaces "+
+				"transactions in the memory pool", tx.Hash())
+			return nil, txRuleError(common.RejectDuplicate, str)
+		}
+
+		newTxs = append(newTxs, tx)
+	}
+	if len(newTxs) == 0 {
+		str := fmt.Sprintf("already have the %d transactions of the "+
+			"package", len(txs))
+		return nil, txRuleError(common.RejectDuplicate, str)
+	}
+
+	// Don't allow the package to grow packages of dependent transactions
+	// in the pool beyond the limits.
+	err = mp.checkPackageTxLimits(newTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the transactions in order against a view shared by the whole
+	// package.  The outputs of each transaction are added to the view once
+	// it is validated, so the later transactions can spend them.
+	views, err := mp.fetchPackageInputUtxos(newTxs)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return nil, chainRuleError(cerr)
+		}
+		return nil, err
+	}
+	fees := make([]int64, len(newTxs))
+	packageFee, packageSize := int64(0), int64(0)
+	for i, tx := range newTxs {
+		// Don't allow the transaction if it exists in the main chain
+		// and is not already fully spent.
+		contract, err := checkTransactionOutputs(tx, views.Utxo)
+		if err != nil {
+			return nil, err
+		}
+
+		// Unlike individual transactions, a package is not an orphan
+		// when it misses inputs, as it is supposed to come with all
+		// the unconfirmed transactions it needs.
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if prevOut.Hash.IsEqual(&zerohash) {
+				continue
+			}
+			entry := views.Utxo.LookupEntry(prevOut)
+			if entry == nil || entry.IsSpent() {
+				str := fmt.Sprintf("package transaction %v "+
+					"references outputs of unknown or "+
+					"fully-spent transaction %v", tx.Hash(),
+					prevOut.Hash)
+				return nil, txRuleError(common.RejectDuplicate, str)
+			}
+		}
+
+		err = mp.checkTransactionInputs(tx, views, nextBlockHeight,
+			medianTimePast)
+		if err != nil {
+			return nil, err
+		}
+
+		// The fee of a contract execution transaction pays for the
+		// execution of its contracts, so it doesn't count in the fee
+		// rate of the package.
+		fees[i], err = mp.transactionFee(tx, views, contract)
+		if err != nil {
+			return nil, err
+		}
+		if !contract {
+			packageFee += fees[i]
+			packageSize += blockchain.GetTransactionWeight(tx)
+		}
+
+		if fulllValidate {
+			err = ovm.VerifySigs(tx, mp.cfg.ChainParams, 0, views)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		views.AddTxOuts(tx, mining.UnminedHeight)
+	}
+
+	// Don't allow packages with fees too low to get into a mined block.
+	// The fees are evaluated for the package as a whole, so a transaction
+	// can pay for the ones it depends on.  Unlike individual transactions,
+	// packages are never free, and they have to pay the minimum fee rate
+	// raised by evictions from the full pool.
+	if packageSize > 0 {
+		minFee := CalcMinRequiredTxRelayFee(packageSize,
+			mp.cfg.Policy.MinRelayTxFee)
+		if rollingFeeRate := mp.rollingFeeRate(); rollingFeeRate > 0 {
+			rollingMinFee := CalcMinRequiredTxRelayFee(packageSize,
+				btcutil.Amount(rollingFeeRate))
+			if rollingMinFee > minFee {
+				minFee = rollingMinFee
+			}
+		}
+		if packageFee < minFee {
+			str := fmt.Sprintf("package of %d transactions has %d "+
+				"fees which is under the required amount of %d",
+				len(newTxs), packageFee, minFee)
+			return nil, txRuleError(common.RejectInsufficientFee, str)
+		}
+	}
+
+	// Add the whole package to the transaction pool.  Its transactions
+	// are no longer orphans if they were.
+	acceptedTxs := make([]*TxDesc, 0, len(newTxs))
+	for i, tx := range newTxs {
+		txD := mp.addTransaction(views.Utxo, tx, bestHeight, fees[i])
+		acceptedTxs = append(acceptedTxs, txD)
+		mp.removeOrphan(tx, false)
+	}
+
+	// Keep the pool within its limits.  The package is removed as a whole
+	// when any of its transactions is evicted from a full pool.
+	mp.limitPoolSize()
+	for _, txD := range acceptedTxs {
+		if mp.isTransactionInPool(txD.Tx.Hash()) {
+			continue
+		}
+
+		for _, txD := range acceptedTxs {
+			if mp.isTransactionInPool(txD.Tx.Hash()) {
+				mp.evictTransaction(txD.Tx)
+			}
+		}
+		str := fmt.Sprintf("package of %d transactions has been "+
+			"evicted from the full memory pool", len(newTxs))
+		return nil, txRuleError(common.RejectInsufficientFee, str)
+	}
+
+	log.Debugf("Accepted package of %d transactions with %d fees (pool "+
+		"size: %v)", len(acceptedTxs), packageFee, len(mp.pool))
+
+	return acceptedTxs, nil
+}
+
+// ProcessPackage is the main workhorse for handling insertion of packages of
+// dependent transactions into the memory pool.  A package is a transaction
+// along with the unconfirmed transactions it depends on, sorted topologically,
+// which is validated and accepted atomically: either all of its transactions
+// are added to the pool or none.  The fees are evaluated for the package as a
+// whole, so a child can pay for a parent which would be rejected on its own,
+// without going through the orphan pool.
+//
+// It returns a slice of transactions added to the mempool.  When the error is
+// nil, the list will include the transactions of the package which were not
+// already in the pool, in order, along with any additional orphan transactions
+// that were added as a result of the package being accepted.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessPackage(txs []*btcutil.Tx, fulllValidate bool) ([]*TxDesc, error) {
+	log.Tracef("Processing package of %d transactions", len(txs))
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	acceptedTxs, err := mp.maybeAcceptPackage(txs, fulllValidate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accept any orphan transactions that depend on the package.  The
+	// range only covers the transactions of the package.
+	for _, txD := range acceptedTxs {
+		acceptedTxs = append(acceptedTxs, mp.processOrphans(txD.Tx)...)
+	}
+
+	return acceptedTxs, nil
+}
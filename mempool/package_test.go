@@ -0,0 +1,227 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// TestPackageTopology ensures only packages made of a transaction along with
+// the ancestors it needs, sorted topologically, are accepted for validation.
+func TestPackageTopology(t *testing.T) {
+	t.Parallel()
+
+	parent := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		wire.MaxTxInSequenceNum, 100000)
+	child := newTestTx(wire.OutPoint{Hash: *parent.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	grandChild := newTestTx(wire.OutPoint{Hash: *child.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	unrelated := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 100000)
+	conflict := newTestTx(wire.OutPoint{Hash: *parent.Hash()}, 0, 100000)
+
+	tooMany := make([]*btcutil.Tx, 0, wire.MaxPackageTxns+1)
+	prevOut := wire.OutPoint{Hash: chainhash.Hash{0x03}}
+	for i := 0; i <= wire.MaxPackageTxns; i++ {
+		tx := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+		tooMany = append(tooMany, tx)
+		prevOut = wire.OutPoint{Hash: *tx.Hash()}
+	}
+
+	tests := []struct {
+		name  string
+		txs   []*btcutil.Tx
+		valid bool
+	}{
+		{"single", []*btcutil.Tx{child}, true},
+		{"parent and child", []*btcutil.Tx{parent, child}, true},
+		{"chain", []*btcutil.Tx{parent, child, grandChild}, true},
+		{"max size", tooMany[:wire.MaxPackageTxns], true},
+		{"empty", nil, false},
+		{"too many", tooMany, false},
+		{"unsorted", []*btcutil.Tx{child, parent}, false},
+		{"duplicate", []*btcutil.Tx{parent, parent, child}, false},
+		{"unrelated", []*btcutil.Tx{unrelated, child}, false},
+		{"double spend", []*btcutil.Tx{parent, child, conflict}, false},
+	}
+
+	for _, test := range tests {
+		err := checkPackageTopology(test.txs)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: invalid package accepted", test.name)
+		}
+	}
+}
+
+// TestPackageTxLimits ensures packages are accounted as a whole against the
+// limits of dependent transactions in the pool.
+func TestPackageTxLimits(t *testing.T) {
+	t.Parallel()
+
+	mp := New(&Config{
+		Policy:      Policy{MinRelayTxFee: 1000},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// Add a chain of transactions to the pool, leaving room for a package
+	// of two transactions only.
+	prevOut := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	for i := 0; i < MaxAncestorCount-2; i++ {
+		tx := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+		addTestTx(mp, tx, 1000, time.Now())
+		prevOut = wire.OutPoint{Hash: *tx.Hash()}
+	}
+
+	parent := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+	child := newTestTx(wire.OutPoint{Hash: *parent.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	err := mp.checkPackageTxLimits([]*btcutil.Tx{parent, child})
+	if err != nil {
+		t.Fatalf("checkPackageTxLimits: unexpected error %v", err)
+	}
+
+	grandChild := newTestTx(wire.OutPoint{Hash: *child.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	err = mp.checkPackageTxLimits([]*btcutil.Tx{parent, child, grandChild})
+	if err == nil {
+		t.Fatalf("checkPackageTxLimits: accepted package exceeding the " +
+			"ancestor limit")
+	}
+}
+
+// newPackageTestPool returns a pool fetching the inputs of transactions from
+// the returned view, standing for the utxo set of the main chain, which holds
+// the outputs of the returned confirmed transaction.
+func newPackageTestPool(minRelayTxFee btcutil.Amount) (*TxPool, *btcutil.Tx) {
+	confirmed := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		wire.MaxTxInSequenceNum, 100000)
+	utxos := viewpoint.NewUtxoViewpoint()
+	utxos.AddTxOuts(confirmed, 1)
+
+	mp := New(&Config{
+		Policy: Policy{
+			AcceptNonStd:  true,
+			MaxTxVersion:  wire.TxVersion,
+			MinRelayTxFee: minRelayTxFee,
+		},
+		ChainParams: &chaincfg.MainNetParams,
+		FetchUtxoView: func(tx *btcutil.Tx) (*viewpoint.ViewPointSet, error) {
+			views := viewpoint.NewViewPointSet(nil)
+			for _, txIn := range tx.MsgTx().TxIn {
+				prevOut := txIn.PreviousOutPoint
+				views.Utxo.Entries()[prevOut] = utxos.LookupEntry(prevOut)
+			}
+			return views, nil
+		},
+		BestHeight:     func() int32 { return 100 },
+		MedianTimePast: time.Now,
+		CalcSequenceLock: func(*btcutil.Tx, *viewpoint.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{Seconds: -1, BlockHeight: -1}, nil
+		},
+	})
+	return mp, confirmed
+}
+
+// TestProcessPackage ensures a package is accepted by the fee rate of the
+// package as a whole, so a child pays for its parent, and that its
+// transactions are accepted all together or not at all.
+func TestProcessPackage(t *testing.T) {
+	t.Parallel()
+
+	mp, confirmed := newPackageTestPool(10000)
+
+	// The parent pays no fee, which is not enough on its own.
+	parent := newTestTx(wire.OutPoint{Hash: *confirmed.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	if _, err := mp.ProcessPackage([]*btcutil.Tx{parent}, false); err == nil {
+		t.Fatalf("ProcessPackage: accepted parent paying no fee")
+	}
+
+	// A child which doesn't pay for both is rejected along with its parent.
+	parentOut := wire.OutPoint{Hash: *parent.Hash()}
+	poorChild := newTestTx(parentOut, wire.MaxTxInSequenceNum, 100000-1)
+	_, err := mp.ProcessPackage([]*btcutil.Tx{parent, poorChild}, false)
+	if err == nil {
+		t.Fatalf("ProcessPackage: accepted package with insufficient fees")
+	}
+
+	// An invalid child is rejected along with its parent.
+	badChild := newTestTx(parentOut, wire.MaxTxInSequenceNum, 200000)
+	_, err = mp.ProcessPackage([]*btcutil.Tx{parent, badChild}, false)
+	if err == nil {
+		t.Fatalf("ProcessPackage: accepted package with invalid child")
+	}
+	for _, tx := range []*btcutil.Tx{parent, poorChild, badChild} {
+		if mp.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("ProcessPackage: transaction %v of rejected "+
+				"package in the pool", tx.Hash())
+		}
+	}
+
+	// A child paying for both gets its parent accepted too.
+	child := newTestTx(parentOut, wire.MaxTxInSequenceNum, 50000)
+	accepted, err := mp.ProcessPackage([]*btcutil.Tx{parent, child}, false)
+	if err != nil {
+		t.Fatalf("ProcessPackage: unexpected error %v", err)
+	}
+	if len(accepted) != 2 || accepted[0].Tx != parent ||
+		accepted[1].Tx != child {
+		t.Fatalf("ProcessPackage: got %d accepted transactions, want "+
+			"parent and child", len(accepted))
+	}
+	desc := mp.pool[*child.Hash()]
+	if desc.Fee != 50000 || desc.AncestorCount != 2 {
+		t.Fatalf("ProcessPackage: got child fee %d with %d ancestors, "+
+			"want 50000 with 2", desc.Fee, desc.AncestorCount)
+	}
+}
+
+// TestProcessPackageEvicted ensures a package evicted from the full pool as it
+// is accepted is removed as a whole.
+func TestProcessPackageEvicted(t *testing.T) {
+	t.Parallel()
+
+	mp, confirmed := newPackageTestPool(1000)
+
+	// Fill the pool with a transaction paying a higher fee rate than the
+	// package, leaving no room for it.
+	other := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 1000)
+	addTestTx(mp, other, 100000, time.Now())
+	mp.cfg.Policy.MaxPoolBytes = mp.poolBytes + 1
+
+	parent := newTestTx(wire.OutPoint{Hash: *confirmed.Hash()},
+		wire.MaxTxInSequenceNum, 100000)
+	child := newTestTx(wire.OutPoint{Hash: *parent.Hash()},
+		wire.MaxTxInSequenceNum, 90000)
+	_, err := mp.ProcessPackage([]*btcutil.Tx{parent, child}, false)
+	if err == nil {
+		t.Fatalf("ProcessPackage: accepted package evicted from the " +
+			"full pool")
+	}
+	for _, tx := range []*btcutil.Tx{parent, child} {
+		if mp.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("ProcessPackage: transaction %v of evicted "+
+				"package in the pool", tx.Hash())
+		}
+	}
+	if !mp.IsTransactionInPool(other.Hash()) {
+		t.Fatalf("ProcessPackage: evicted transaction paying a " +
+			"higher fee rate")
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+)
+
+const (
+	// DefaultDumpFile is the default name of the file the transactions of
+	// the pool are dumped to on shutdown.
+	DefaultDumpFile = "mempool.dat"
+
+	// dumpVersion is the version of the format of the dumped pool.
+	dumpVersion = 1
+
+	// maxDumpedTxSize is the maximum size of a dumped transaction which is
+	// read back.  It guards against allocating huge buffers for a corrupt
+	// file.
+	maxDumpedTxSize = wire.MaxBlockPayload
+
+	// maxDumpTxs is the maximum number of dumped transactions space is
+	// allocated for up front when reading them back.  It guards against
+	// allocating a huge slice for the count of a corrupt file, while more
+	// transactions are still read.
+	maxDumpTxs = 100000
+)
+
+// dumpedTx is a transaction of the pool as it is dumped, along with the time
+// it was added to the pool and the fee it pays.
+type dumpedTx struct {
+	tx    *btcutil.Tx
+	added time.Time
+	fee   int64
+}
+
+// Dump writes the transactions of the pool, with the time they were added and
+// the fee they pay, to the passed writer so they can be loaded back with Load
+// after a restart.  The transactions are written after their ancestors in the
+// pool, so they can be loaded back in order.  Orphans are not dumped.
+//
+// The format is the version, the number of transactions, then for each one,
+// the unix time it was added, its fee, and the length and bytes of its
+// serialization, all integers being big endian.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Dump(w io.Writer) error {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	for _, desc := range mp.pool {
+		descs = append(descs, desc)
+	}
+
+	// A transaction has more ancestors than each of its ancestors.
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].AncestorCount < descs[j].AncestorCount
+	})
+
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.BigEndian, uint32(dumpVersion))
+	binary.Write(bw, binary.BigEndian, uint32(len(descs)))
+	var buf bytes.Buffer
+	for _, desc := range descs {
+		buf.Reset()
+		if err := desc.Tx.MsgTx().Serialize(&buf); err != nil {
+			return err
+		}
+		binary.Write(bw, binary.BigEndian, desc.Added.Unix())
+		binary.Write(bw, binary.BigEndian, desc.Fee)
+		binary.Write(bw, binary.BigEndian, uint32(buf.Len()))
+		bw.Write(buf.Bytes())
+	}
+
+	return bw.Flush()
+}
+
+// readDump reads the transactions dumped by Dump from the passed reader.
+func readDump(r io.Reader) ([]dumpedTx, error) {
+	br := bufio.NewReader(r)
+
+	var version, count uint32
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != dumpVersion {
+		return nil, fmt.Errorf("Incorrect version: expected %d found %d",
+			dumpVersion, version)
+	}
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	prealloc := count
+	if prealloc > maxDumpTxs {
+		prealloc = maxDumpTxs
+	}
+	txs := make([]dumpedTx, 0, prealloc)
+	for i := uint32(0); i < count; i++ {
+		var added, fee int64
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &added); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &fee); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if size > maxDumpedTxSize {
+			return nil, fmt.Errorf("dumped transaction %d is too "+
+				"large: %d bytes", i, size)
+		}
+
+		serialized := make([]byte, size)
+		if _, err := io.ReadFull(br, serialized); err != nil {
+			return nil, err
+		}
+		var msgTx wire.MsgTx
+		err := msgTx.Deserialize(bytes.NewReader(serialized))
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, dumpedTx{
+			tx:    btcutil.NewTx(&msgTx),
+			added: time.Unix(added, 0),
+			fee:   fee,
+		})
+	}
+
+	return txs, nil
+}
+
+// Load reads the transactions dumped by Dump from the passed reader and adds
+// them back to the pool through ProcessTransaction, fully validating them
+// against the current tip of the chain.  The transactions which expired, or
+// which are no longer valid, such as the ones mined while the node was down,
+// are skipped.  The transactions added back keep the time they were first
+// added to the pool, so they still expire in time.
+//
+// It returns the number of transactions added back.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(r io.Reader) (int, error) {
+	txs, err := readDump(r)
+	if err != nil {
+		return 0, err
+	}
+
+	nextBlockHeight := mp.cfg.BestHeight() + 1
+	var expiry time.Time
+	if mp.cfg.Policy.MaxTxAge > 0 {
+		expiry = time.Now().Add(-mp.cfg.Policy.MaxTxAge)
+	}
+
+	loaded := 0
+	for _, dumped := range txs {
+		tx := dumped.tx
+		msgTx := tx.MsgTx()
+		if dumped.added.Before(expiry) || ((msgTx.Version&wire.TxExpire) != 0 &&
+			msgTx.LockTime < uint32(nextBlockHeight)) {
+
+			log.Debugf("Skipping expired dumped transaction %v",
+				tx.Hash())
+			continue
+		}
+
+		_, err := mp.ProcessTransaction(tx, false, false, 0, true)
+		if err != nil {
+			log.Debugf("Skipping dumped transaction %v: %v",
+				tx.Hash(), err)
+			continue
+		}
+
+		mp.mtx.Lock()
+		if desc, exists := mp.pool[*tx.Hash()]; exists {
+			desc.Added = dumped.added
+			if desc.Fee != dumped.fee {
+				log.Debugf("Dumped transaction %v pays %d fees "+
+					"instead of %d", tx.Hash(), desc.Fee,
+					dumped.fee)
+			}
+		}
+		mp.mtx.Unlock()
+		loaded++
+	}
+
+	log.Infof("Loaded %d of %d dumped transactions into the memory pool",
+		loaded, len(txs))
+
+	return loaded, nil
+}
+
+// DumpToFile dumps the transactions of the pool to the file at the passed
+// path with Dump.  The file is written in place atomically, and synced before
+// it replaces the earlier one, so an interrupted dump or a crash doesn't
+// corrupt an earlier one.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) DumpToFile(path string) error {
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := mp.Dump(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// LoadFromFile loads back the transactions dumped to the file at the passed
+// path with Load.  A missing file is not an error, as there is nothing to load
+// on the first start.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) LoadFromFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return mp.Load(f)
+}
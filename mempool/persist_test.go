@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// TestDump ensures the transactions of the pool are dumped after their
+// ancestors along with their metadata, and can be read back.
+func TestDump(t *testing.T) {
+	t.Parallel()
+
+	mp := New(&Config{
+		Policy:      Policy{MinRelayTxFee: 1000},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// Add a chain of transactions, so each depends on the previous one.
+	const numTxs = 5
+	prevOut := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	for i := 0; i < numTxs; i++ {
+		tx := newTestTx(prevOut, wire.MaxTxInSequenceNum, 100000)
+		mp.addTransaction(viewpoint.NewUtxoViewpoint(), tx, 1,
+			int64(1000*(i+1)))
+		prevOut = wire.OutPoint{Hash: *tx.Hash()}
+	}
+
+	var buf bytes.Buffer
+	if err := mp.Dump(&buf); err != nil {
+		t.Fatalf("Dump: unexpected error %v", err)
+	}
+	serialized := buf.Bytes()
+
+	txs, err := readDump(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("readDump: unexpected error %v", err)
+	}
+	if len(txs) != numTxs {
+		t.Fatalf("readDump: got %d transactions, want %d", len(txs),
+			numTxs)
+	}
+	for i, dumped := range txs {
+		desc, exists := mp.pool[*dumped.tx.Hash()]
+		if !exists {
+			t.Fatalf("readDump: transaction #%d %v not in the pool",
+				i, dumped.tx.Hash())
+		}
+		if desc.AncestorCount != i+1 {
+			t.Fatalf("readDump: transaction #%d dumped before its "+
+				"ancestors", i)
+		}
+		if dumped.fee != desc.Fee ||
+			dumped.added.Unix() != desc.Added.Unix() {
+
+			t.Fatalf("readDump: transaction #%d got fee %d added at "+
+				"%v, want %d at %v", i, dumped.fee, dumped.added,
+				desc.Fee, desc.Added)
+		}
+	}
+
+	// A dump of another version is rejected.
+	serialized[3]++
+	if _, err := readDump(bytes.NewReader(serialized)); err == nil {
+		t.Fatalf("readDump: accepted dump of unknown version")
+	}
+	serialized[3]--
+
+	// A corrupt count is rejected once the transactions run out, without
+	// allocating space for all of them.
+	copy(serialized[4:8], []byte{0xff, 0xff, 0xff, 0xff})
+	if _, err := readDump(bytes.NewReader(serialized)); err == nil {
+		t.Fatalf("readDump: accepted dump with a corrupt count")
+	}
+}
+
+// newExpiringTestTx returns a transaction spending the passed outpoint to a
+// single output of the passed value, which expires after the block at the
+// passed height.
+func newExpiringTestTx(prevOut wire.OutPoint, value int64, lockTime uint32) *btcutil.Tx {
+	msgTx := newTestTx(prevOut, wire.MaxTxInSequenceNum, value).MsgTx()
+	msgTx.Version |= wire.TxExpire
+	msgTx.LockTime = lockTime
+	return btcutil.NewTx(msgTx)
+}
+
+// TestLoad ensures the dumped transactions are added back to the pool with the
+// time they were first added, and that the expired ones and those no longer
+// valid are skipped.
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	// The pool loading the transactions is at height 100.
+	mp, confirmed := newPackageTestPool(1000)
+	src := New(&Config{
+		Policy:      Policy{MinRelayTxFee: 1000},
+		ChainParams: &chaincfg.MainNetParams,
+	})
+
+	// valid -> fresh -> expired, where fresh expires after the next block
+	// and expired has stayed in the pool too long, while stale, spending
+	// valid as well, expires before the next block.  invalid spends an
+	// output which is not in the chain.
+	added := time.Unix(time.Now().Add(-time.Hour).Unix(), 0)
+	valid := newTestTx(wire.OutPoint{Hash: *confirmed.Hash()},
+		wire.MaxTxInSequenceNum, 90000)
+	fresh := newExpiringTestTx(wire.OutPoint{Hash: *valid.Hash()},
+		80000, 101)
+	expired := newTestTx(wire.OutPoint{Hash: *fresh.Hash()},
+		wire.MaxTxInSequenceNum, 70000)
+	stale := newExpiringTestTx(wire.OutPoint{Hash: *valid.Hash()},
+		80000, 100)
+	invalid := newTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}},
+		wire.MaxTxInSequenceNum, 90000)
+	addTestTx(src, valid, 10000, added)
+	addTestTx(src, fresh, 10000, added.Add(time.Minute))
+	addTestTx(src, expired, 10000,
+		time.Now().Add(-DefaultMaxTxAge-time.Hour))
+	addTestTx(src, stale, 10000, added)
+	addTestTx(src, invalid, 10000, added)
+
+	var buf bytes.Buffer
+	if err := src.Dump(&buf); err != nil {
+		t.Fatalf("Dump: unexpected error %v", err)
+	}
+	loaded, err := mp.Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: unexpected error %v", err)
+	}
+	if loaded != 2 {
+		t.Fatalf("Load: got %d loaded transactions, want 2", loaded)
+	}
+
+	for _, tx := range []*btcutil.Tx{expired, stale, invalid} {
+		if mp.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("Load: skipped transaction %v in the pool",
+				tx.Hash())
+		}
+	}
+	for _, tx := range []*btcutil.Tx{valid, fresh} {
+		desc, exists := mp.pool[*tx.Hash()]
+		if !exists {
+			t.Fatalf("Load: transaction %v not in the pool",
+				tx.Hash())
+		}
+		want := src.pool[*tx.Hash()].Added
+		if !desc.Added.Equal(want) || desc.Fee != 10000 {
+			t.Fatalf("Load: transaction %v got fee %d added at %v, "+
+				"want 10000 at %v", tx.Hash(), desc.Fee,
+				desc.Added, want)
+		}
+	}
+}
@@ -58,6 +58,29 @@ type TxDesc struct {
 
 	// Tried is the number of times the entry was tried to add to a block.
 	Tried uint32
+
+	// AncestorCount is the number of transactions in the source pool the
+	// transaction depends on, directly or indirectly, counting itself.
+	AncestorCount int
+
+	// AncestorSize is the total weight of the transaction and its
+	// ancestors in the source pool.
+	AncestorSize int64
+
+	// AncestorFees is the total fee the transaction and its ancestors in
+	// the source pool pay.
+	AncestorFees int64
+}
+
+// AncestorFeePerKB returns the fee in Hao per 1000 bytes the transaction pays
+// together with its ancestors in the source pool, which must be mined along
+// with it.  It is the fee rate of the transaction itself when the ancestors
+// are not tracked by the source pool.
+func (d *TxDesc) AncestorFeePerKB() int64 {
+	if d.AncestorSize <= 0 {
+		return d.FeePerKB
+	}
+	return d.AncestorFees * 1000 / d.AncestorSize
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
@@ -90,11 +113,42 @@ type txPrioItem struct {
 	priority float64
 	feePerKB int64
 
+	// weight is the weight of the transaction in the source pool.
+	weight int64
+
+	// ancestorFees and ancestorSize are the total fee and weight of the
+	// transaction together with its ancestors in the source pool which are
+	// not in the block yet.  descendantFeePerKB is the highest fee rate of
+	// a descendant which is waiting for the transaction to be added to the
+	// block.
+	ancestorFees       int64
+	ancestorSize       int64
+	descendantFeePerKB int64
+
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
 	// transactions in the source pool and hence must come after them in
 	// a block.
 	dependsOn map[chainhash.Hash]struct{}
+
+	// index is the index of the item in the priority queue, or -1 when it
+	// is not in the queue.
+	index int
+}
+
+// selectionFeePerKB returns the fee rate the transaction is selected by.  It
+// is the fee rate of the transaction together with its ancestors not in the
+// block yet, raised to the fee rate of a descendant waiting for it, so a child
+// pays for its parents.
+func (item *txPrioItem) selectionFeePerKB() int64 {
+	feePerKB := item.feePerKB
+	if len(item.dependsOn) > 0 && item.ancestorSize > 0 {
+		feePerKB = item.ancestorFees * 1000 / item.ancestorSize
+	}
+	if item.descendantFeePerKB > feePerKB {
+		feePerKB = item.descendantFeePerKB
+	}
+	return feePerKB
 }
 
 // txPriorityQueueLessFunc describes a function that can be used as a compare
@@ -127,12 +181,16 @@ func (pq *txPriorityQueue) Less(i, j int) bool {
 // part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Swap(i, j int) {
 	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 // Push pushes the passed item onto the priority queue.  It is part of the
 // heap.Interface implementation.
 func (pq *txPriorityQueue) Push(x interface{}) {
-	pq.items = append(pq.items, x.(*txPrioItem))
+	item := x.(*txPrioItem)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
 }
 
 // Pop removes the highest priority item (according to Less) from the priority
@@ -140,6 +198,7 @@ func (pq *txPriorityQueue) Push(x interface{}) {
 func (pq *txPriorityQueue) Pop() interface{} {
 	n := len(pq.items)
 	item := pq.items[n-1]
+	item.index = -1
 	pq.items[n-1] = nil
 	pq.items = pq.items[0 : n-1]
 	return item
@@ -159,21 +218,25 @@ func txPQByPriority(pq *txPriorityQueue, i, j int) bool {
 	// Using > here so that pop gives the highest priority item as opposed
 	// to the lowest.  Sort by priority first, then fee.
 	if pq.items[i].priority == pq.items[j].priority {
-		return pq.items[i].feePerKB > pq.items[j].feePerKB
+		return pq.items[i].selectionFeePerKB() >
+			pq.items[j].selectionFeePerKB()
 	}
 	return pq.items[i].priority > pq.items[j].priority
 
 }
 
-// txPQByFee sorts a txPriorityQueue by fees per kilobyte and then transaction
-// priority.
+// txPQByFee sorts a txPriorityQueue by fees per kilobyte, counting the
+// ancestors and descendants the transaction is selected with, and then
+// transaction priority.
 func txPQByFee(pq *txPriorityQueue, i, j int) bool {
 	// Using > here so that pop gives the highest fee item as opposed
 	// to the lowest.  Sort by fee first, then priority.
-	if pq.items[i].feePerKB == pq.items[j].feePerKB {
+	feeI := pq.items[i].selectionFeePerKB()
+	feeJ := pq.items[j].selectionFeePerKB()
+	if feeI == feeJ {
 		return pq.items[i].priority > pq.items[j].priority
 	}
-	return pq.items[i].feePerKB > pq.items[j].feePerKB
+	return feeI > feeJ
 }
 
 // newTxPriorityQueue returns a new transaction priority queue that reserves the
@@ -347,6 +410,45 @@ func logSkippedDeps(tx *btcutil.Tx, deps map[chainhash.Hash]*txPrioItem) {
 	}
 }
 
+// promoteAncestors raises the fee rate the ancestors of the passed item which
+// are not in the block yet are selected by to the passed fee rate of the item,
+// so they are added ahead of the transactions paying less than it.
+func promoteAncestors(pq *txPriorityQueue, items map[chainhash.Hash]*txPrioItem, item *txPrioItem, feePerKB int64) {
+	for hash := range item.dependsOn {
+		parent, exists := items[hash]
+		if !exists || parent.descendantFeePerKB >= feePerKB {
+			continue
+		}
+		parent.descendantFeePerKB = feePerKB
+		if parent.index >= 0 {
+			heap.Fix(pq, parent.index)
+		}
+		promoteAncestors(pq, items, parent, feePerKB)
+	}
+}
+
+// deductAncestor removes the transaction with the passed hash, fee and weight,
+// which has been added to the block, from the ancestors of its descendants not
+// in the block yet, so they are selected by the fee rate of the ancestors they
+// still need only.
+func deductAncestor(pq *txPriorityQueue, dependers map[chainhash.Hash]map[chainhash.Hash]*txPrioItem, hash *chainhash.Hash, fee, weight int64, seen map[chainhash.Hash]struct{}) {
+	for depHash, item := range dependers[*hash] {
+		if _, ok := seen[depHash]; ok {
+			continue
+		}
+		seen[depHash] = struct{}{}
+
+		if item.ancestorSize > 0 {
+			item.ancestorFees -= fee
+			item.ancestorSize -= weight
+			if item.index >= 0 {
+				heap.Fix(pq, item.index)
+			}
+		}
+		deductAncestor(pq, dependers, &depHash, fee, weight, seen)
+	}
+}
+
 // MinimumMedianTime returns the minimum allowed timestamp for a block building
 // on the end of the provided best Chain.  In particular, it is one second after
 // the median timestamp of the last several blocks per the Chain consensus
@@ -387,6 +489,10 @@ type BlkTmplGenerator struct {
 
 	// only used by minerchain
 	Collateral []*wire.OutPoint
+
+	// DoubleSigns, when set, provides the double signing proofs reported
+	// in new miner blocks.
+	DoubleSigns *blockchain.DoubleSignWatcher
 	//	sigCache    *txscript.SigCache
 	//	hashCache   *txscript.HashCache
 }
@@ -437,9 +543,11 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 // prioritizes based on the priority (then fee per kilobyte) or the fee per
 // kilobyte (then priority) depending on whether or not the BlockPrioritySize
 // Policy setting allots space for high-priority transactions.  Height
-// which spend outputs from other transactions in the source pool are added to a
-// dependency map so they can be added to the priority queue once the
-// transactions they depend on have been included.
+// which spend outputs from other transactions in the source pool are ordered by
+// the fee per kilobyte of the package made of them and those ancestors.  When
+// such a transaction is selected, its ancestors are raised to its fee per
+// kilobyte, so a high fee child pays for its low fee parents, and it is added
+// once all of its ancestors have been included.
 //
 // Once the high-priority area (if configured) has been filled with
 // transactions, or the priority falls below what is considered high-priority,
@@ -552,6 +660,10 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress []btcutil.Address, nonc
 	// in the block once each transaction has been included.
 	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
 
+	// prioItems holds the items of all the transactions considered, so the
+	// ancestors of a transaction can be promoted to its fee rate.
+	prioItems := make(map[chainhash.Hash]*txPrioItem, len(sourceTxns))
+
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -686,7 +798,7 @@ mempoolLoop:
 		// Setup dependencies for any transactions which reference
 		// other transactions in the mempool so they can be properly
 		// ordered below.
-		prioItem := &txPrioItem{tx: tx}
+		prioItem := &txPrioItem{tx: tx, index: -1}
 		for _, txIn := range tx.MsgTx().TxIn {
 			if txIn.PreviousOutPoint.Hash.IsEqual(&zerohash) {
 				// never here
@@ -734,13 +846,16 @@ mempoolLoop:
 
 		// Calculate the fee in Hao/kB.
 		prioItem.feePerKB = txDesc.FeePerKB
+		prioItem.weight = blockchain.GetTransactionWeight(tx)
+		prioItem.ancestorFees = txDesc.AncestorFees
+		prioItem.ancestorSize = txDesc.AncestorSize
 		prioItem.fee = txDesc.Fee
 
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
-		}
+		// Add the transaction to the priority queue.  When it has
+		// dependencies, it is ordered by its ancestor fee rate so it can
+		// pull its ancestors into the block once selected.
+		prioItems[*tx.Hash()] = prioItem
+		heap.Push(priorityQueue, prioItem)
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -789,7 +904,11 @@ mempoolLoop:
 	Vm.BlockTime = func() uint32 {
 		return uint32(ts.Unix())
 	}
-	Vm.BlockVersion = func() uint32 { return s.MsgBlock().Version &^ 0xFFFF }
+	// The vote bits are only set once the contracts are executed, so they
+	// are left out whether the chain hides them or not.
+	Vm.BlockVersion = func() uint32 {
+		return blockchain.ContractVersion(s.MsgBlock().Version, true)
+	}
 	//	Vm.Block = func() *btcutil.Block { return nil }
 	Vm.GetCoinBase = func() *btcutil.Tx { return coinbaseTx }
 	//	Vm.CheckExecCost = true
@@ -811,6 +930,16 @@ skiprest:
 		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
 		tx := prioItem.tx
 
+		// A transaction depending on transactions in the source pool
+		// which are not in the block yet can't be added now.  Instead, its
+		// ancestors are promoted to its fee rate, so a child pays for its
+		// parents, and it is queued again once they are all added.
+		if len(prioItem.dependsOn) > 0 {
+			promoteAncestors(priorityQueue, prioItems, prioItem,
+				prioItem.selectionFeePerKB())
+			continue
+		}
+
 		// Grab any transactions which depend on this one.
 		deps := dependers[*tx.Hash()]
 
@@ -844,7 +973,7 @@ skiprest:
 
 		// Skip free transactions once the block is larger than the
 		// minimum txs which is 10 txs.
-		if sortedByFee && prioItem.feePerKB < int64(g.Policy.TxMinFreeFee) {
+		if sortedByFee && prioItem.selectionFeePerKB() < int64(g.Policy.TxMinFreeFee) {
 			// free tx Policy only apply to simple small txs
 			qualified, sum := false, int64(0)
 			for _, txo := range tx.MsgTx().TxOut {
@@ -975,7 +1104,8 @@ skiprest:
 			continue
 		}
 
-		err = blockchain.CheckTransactionIntegrity(tx, views, s.MsgBlock().Version&^0xFFFF)
+		err = blockchain.CheckTransactionIntegrity(tx, views,
+			s.MsgBlock().Version&^0xFFFF, g.Chain.FetchUtxosMain)
 		if err != nil {
 			g.txSource.RemoveTransaction(tx, true)
 			g.Chain.SendNotification(blockchain.NTBlockRejected, tx)
@@ -1023,6 +1153,11 @@ skiprest:
 
 		blksz += tx.MsgTx().SerializeSize()
 
+		// The transaction no longer counts in the ancestor fee rate of
+		// its descendants.
+		deductAncestor(priorityQueue, dependers, tx.Hash(), prioItem.fee,
+			prioItem.weight, make(map[chainhash.Hash]struct{}))
+
 		prioItem.fee = fees
 
 		// Spend the transaction inputs in the block utxo view and add
@@ -1049,10 +1184,15 @@ skiprest:
 		// queue.
 		for _, item := range deps {
 			// Add the transaction to the priority queue if there
-			// are no more dependencies after this one.
+			// are no more dependencies after this one, or reorder it
+			// by its own fee rate if it is still queued.
 			delete(item.dependsOn, *tx.Hash())
 			if len(item.dependsOn) == 0 {
-				heap.Push(priorityQueue, item)
+				if item.index >= 0 {
+					heap.Fix(priorityQueue, item.index)
+				} else {
+					heap.Push(priorityQueue, item)
+				}
 			}
 		}
 	}
@@ -1105,11 +1245,22 @@ skiprest:
 
 	//	reqDifficulty := g.Chain.BestSnapshot().Bits
 
+	// Vote for the deployments upgrading from the version of the block in
+	// the low 16 bits of the version.
+	version := s.MsgBlock().Version &^ 0xFFFF
+	if version != chaincfg.Version1 {
+		voteBits, err := g.Chain.CalcNextVoteBits(version)
+		if err != nil {
+			return nil, err
+		}
+		version |= voteBits
+	}
+
 	// Create a new block ready to be solved.
 	merkles := blockchain.BuildMerkleTreeStore(blockTxns, false, s.MsgBlock().Version&^0xFFFF)
 	var msgBlock wire.MsgBlock
 	msgBlock.Header = wire.BlockHeader{
-		Version:      s.MsgBlock().Version &^ 0xFFFF,
+		Version:      version,
 		PrevBlock:    best.Hash,
 		MerkleRoot:   *merkles[len(merkles)-1],
 		Timestamp:    ts,
@@ -1253,6 +1404,9 @@ func (g *BlkTmplGenerator) NewMinerBlockTemplate(last *chainutil.BlockNode, payT
 	}
 
 	copy(msgBlock.Miner[:], payToAddress.ScriptAddress())
+	if g.DoubleSigns != nil && uc != nil {
+		msgBlock.ViolationReport = g.violationReports(last, nextBlockHeight)
+	}
 	if nextBlockVersion >= chaincfg.Version2 {
 		msgBlock.TphReports = g.Chain.Miners.TphReport(wire.MinTPSReports, last, msgBlock.Miner)
 		sum := uint32(0)
@@ -1307,6 +1461,56 @@ func (g *BlkTmplGenerator) NewMinerBlockTemplate(last *chainutil.BlockNode, payT
 	}, nil
 }
 
+// maxViolationReportSize is the maximum size of the violation reports put in a
+// new miner block, leaving room for the rest of the block within
+// wire.MaxMinerBlockHeaderPayload.
+const maxViolationReportSize = wire.MaxMinerBlockHeaderPayload / 2
+
+// violationReports returns the double signing proofs to report in a miner block
+// at the passed height on top of last.  Proofs already reported in the chain
+// of last, or against miner blocks not in it, are left out.
+func (g *BlkTmplGenerator) violationReports(last *chainutil.BlockNode, height int32) []*wire.Violations {
+	reported := make(map[chainhash.Hash]struct{})
+	inChain := make(map[chainhash.Hash]struct{})
+	for p, i := last, int32(0); i <= g.chainParams.ViolationReportDeadline && p != nil; i++ {
+		inChain[p.Hash] = struct{}{}
+		for _, r := range g.Chain.Miners.NodetoHeader(p).ViolationReport {
+			for _, h := range r.Blocks {
+				reported[h] = struct{}{}
+			}
+		}
+		p = p.Parent
+	}
+
+	reports := make([]*wire.Violations, 0)
+	size := 0
+	for _, v := range g.DoubleSigns.Violations(height) {
+		if _, ok := inChain[v.MRBlock]; !ok {
+			continue
+		}
+		fresh := false
+		for _, h := range v.Blocks {
+			if _, ok := reported[h]; !ok {
+				fresh = true
+				break
+			}
+		}
+		if !fresh {
+			continue
+		}
+
+		var w bytes.Buffer
+		v.Write(&w)
+		if size+w.Len() > maxViolationReportSize {
+			break
+		}
+		size += w.Len()
+		reports = append(reports, v)
+	}
+
+	return reports
+}
+
 // UpdateBlockTime updates the timestamp in the header of the passed block to
 // the current time while taking into account the median time of the last
 // several blocks to ensure the new time is after that time per the Chain
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcutil"
 )
 
@@ -108,3 +109,104 @@ func TestTxFeePrioHeap(t *testing.T) {
 		highest = prioItem
 	}
 }
+
+// TestPromoteAncestors ensures a transaction waiting for its parent promotes
+// the parent to its ancestor fee rate, so a child pays for its parent.
+func TestPromoteAncestors(t *testing.T) {
+	parentHash := chainhash.Hash{0x01}
+	parent := &txPrioItem{feePerKB: 100, index: -1}
+	child := &txPrioItem{
+		feePerKB:     5000,
+		ancestorFees: 5100,
+		ancestorSize: 2000,
+		dependsOn:    map[chainhash.Hash]struct{}{parentHash: {}},
+		index:        -1,
+	}
+	other := &txPrioItem{feePerKB: 1000, index: -1}
+	items := map[chainhash.Hash]*txPrioItem{parentHash: parent}
+
+	priorityQueue := newTxPriorityQueue(3, true)
+	for _, item := range []*txPrioItem{parent, child, other} {
+		heap.Push(priorityQueue, item)
+	}
+
+	// The child is selected by its ancestor fee rate, and promotes its
+	// parent as it can't be added first.
+	if item := heap.Pop(priorityQueue).(*txPrioItem); item != child {
+		t.Fatalf("pop: got fee rate %d, want the child",
+			item.selectionFeePerKB())
+	}
+	promoteAncestors(priorityQueue, items, child, child.selectionFeePerKB())
+	if item := heap.Pop(priorityQueue).(*txPrioItem); item != parent {
+		t.Fatalf("pop: got fee rate %d, want the promoted parent",
+			item.selectionFeePerKB())
+	}
+
+	// Once the parent is added, the child is selected by its own fee rate.
+	delete(child.dependsOn, parentHash)
+	heap.Push(priorityQueue, child)
+	for _, want := range []*txPrioItem{child, other} {
+		if item := heap.Pop(priorityQueue).(*txPrioItem); item != want {
+			t.Fatalf("pop: got fee rate %d, want %d",
+				item.selectionFeePerKB(), want.selectionFeePerKB())
+		}
+	}
+
+	desc := &TxDesc{FeePerKB: 300, AncestorFees: 2000, AncestorSize: 1000}
+	if rate := desc.AncestorFeePerKB(); rate != 2000 {
+		t.Fatalf("AncestorFeePerKB: got %d, want 2000", rate)
+	}
+}
+
+// TestDeductAncestor ensures the ancestors added to the block no longer count
+// in the fee rate their descendants are selected by.
+func TestDeductAncestor(t *testing.T) {
+	grandParentHash := chainhash.Hash{0x01}
+	parentHash := chainhash.Hash{0x02}
+	childHash := chainhash.Hash{0x03}
+	parent := &txPrioItem{
+		feePerKB:     100,
+		weight:       1000,
+		ancestorFees: 10100,
+		ancestorSize: 2000,
+		dependsOn:    map[chainhash.Hash]struct{}{grandParentHash: {}},
+		index:        -1,
+	}
+	child := &txPrioItem{
+		feePerKB:     1000,
+		weight:       1000,
+		ancestorFees: 11100,
+		ancestorSize: 3000,
+		dependsOn:    map[chainhash.Hash]struct{}{parentHash: {}},
+		index:        -1,
+	}
+	other := &txPrioItem{feePerKB: 1000, index: -1}
+	dependers := map[chainhash.Hash]map[chainhash.Hash]*txPrioItem{
+		grandParentHash: {parentHash: parent},
+		parentHash:      {childHash: child},
+	}
+
+	priorityQueue := newTxPriorityQueue(2, true)
+	heap.Push(priorityQueue, child)
+	heap.Push(priorityQueue, other)
+	if rate := child.selectionFeePerKB(); rate != 3700 {
+		t.Fatalf("selectionFeePerKB: got %d, want 3700", rate)
+	}
+
+	// Once the grandparent is added, the child is selected by the fee rate
+	// of its parent and itself only, which is lower than the other one.
+	deductAncestor(priorityQueue, dependers, &grandParentHash, 10000, 1000,
+		make(map[chainhash.Hash]struct{}))
+	if rate := parent.selectionFeePerKB(); rate != 100 {
+		t.Fatalf("selectionFeePerKB: got %d for the parent, want 100",
+			rate)
+	}
+	if rate := child.selectionFeePerKB(); rate != 550 {
+		t.Fatalf("selectionFeePerKB: got %d for the child, want 550",
+			rate)
+	}
+	if item := heap.Pop(priorityQueue).(*txPrioItem); item != other {
+		t.Fatalf("pop: got fee rate %d, want %d",
+			item.selectionFeePerKB(), other.selectionFeePerKB())
+	}
+}
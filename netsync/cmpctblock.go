@@ -0,0 +1,322 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/mempool"
+	peerpkg "github.com/zeusyf/btcd/peer"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/btcutil"
+)
+
+// maxPartialBlocks is the maximum number of compact blocks waiting for
+// missing transactions to keep in memory.
+const maxPartialBlocks = 16
+
+// cmpctBlockMsg packages a compact block message and the peer it came from
+// together so the block handler has access to that information.
+type cmpctBlockMsg struct {
+	msg  *wire.MsgCmpctBlock
+	peer *peerpkg.Peer
+}
+
+// getBlockTxnMsg packages a getblocktxn message and the peer it came from
+// together so the block handler has access to that information.
+type getBlockTxnMsg struct {
+	msg  *wire.MsgGetBlockTxn
+	peer *peerpkg.Peer
+}
+
+// blockTxnMsg packages a blocktxn message and the peer it came from together
+// so the block handler has access to that information.
+type blockTxnMsg struct {
+	msg  *wire.MsgBlockTxn
+	peer *peerpkg.Peer
+}
+
+// partialBlock is a block being rebuilt from a compact block announcement.
+// The transactions not found in the mempool are nil until they arrive in a
+// blocktxn message from the announcing peer.
+type partialBlock struct {
+	header  wire.BlockHeader
+	txns    []*wire.MsgTx
+	missing []uint32
+	peer    *peerpkg.Peer
+}
+
+// newPartialBlock places the prefilled transactions of a compact block and
+// matches its short ids against the passed mempool transactions.  It returns
+// nil when the compact block is malformed.
+func newPartialBlock(msg *wire.MsgCmpctBlock, peer *peerpkg.Peer, poolTxs []*mempool.TxDesc) *partialBlock {
+	pb := &partialBlock{
+		header: msg.Header,
+		txns:   make([]*wire.MsgTx, msg.TxCount()),
+		peer:   peer,
+	}
+
+	for _, pt := range msg.PrefilledTxns {
+		if int(pt.Index) >= len(pb.txns) || pb.txns[pt.Index] != nil {
+			return nil
+		}
+		pb.txns[pt.Index] = pt.Tx
+	}
+	if pb.txns[0] == nil {
+		// The coinbase carries the committee signatures and is never
+		// in the mempool.
+		return nil
+	}
+
+	// Index the mempool by short id.  Ids matching more than one
+	// transaction are useless and treated as missing.
+	key := msg.ShortIDKey()
+	pool := make(map[uint64]*wire.MsgTx)
+	for _, desc := range poolTxs {
+		sigHash := desc.Tx.MsgTx().SignatureHash()
+		id := wire.CmpctShortID(key, &sigHash)
+		if _, ok := pool[id]; ok {
+			pool[id] = nil
+			continue
+		}
+		pool[id] = desc.Tx.MsgTx()
+	}
+
+	next := 0
+	for i := range pb.txns {
+		if pb.txns[i] != nil {
+			continue
+		}
+		if next >= len(msg.ShortIDs) {
+			return nil
+		}
+		// Contract execution appends to the transactions of a block, so
+		// never share them with the mempool.
+		if tx := pool[msg.ShortIDs[next]]; tx != nil {
+			pb.txns[i] = tx.Copy()
+		} else {
+			pb.missing = append(pb.missing, uint32(i))
+		}
+		next++
+	}
+
+	return pb
+}
+
+// fill places the missing transactions of the partial block, received in a
+// blocktxn message in the order they were requested.  It returns false if the
+// number of transactions doesn't match the request.
+func (pb *partialBlock) fill(txns []*wire.MsgTx) bool {
+	if len(txns) != len(pb.missing) {
+		return false
+	}
+	for i, idx := range pb.missing {
+		pb.txns[idx] = txns[i]
+	}
+	pb.missing = nil
+	return true
+}
+
+// block assembles the block once all of its transactions are known.  It
+// returns nil if the transactions do not match the witness commitment in the
+// coinbase, which happens when a short id collided with an unrelated mempool
+// transaction.
+func (pb *partialBlock) block() *btcutil.Block {
+	msgBlock := wire.NewMsgBlock(&pb.header)
+	for _, tx := range pb.txns {
+		msgBlock.AddTransaction(tx)
+	}
+	block := btcutil.NewBlock(msgBlock)
+
+	if len(pb.txns[0].SignatureScripts) == 0 ||
+		blockchain.ValidateWitnessCommitment(block) != nil {
+		return nil
+	}
+	return block
+}
+
+// requestFullBlock falls back to fetching the full block when a compact block
+// can't be rebuilt.
+func (sm *SyncManager) requestFullBlock(hash *chainhash.Hash, peer *peerpkg.Peer) {
+	gdmsg := wire.NewMsgGetData()
+	gdmsg.AddInvVect(wire.NewInvVect(common.InvTypeWitnessBlock, hash))
+	peer.QueueMessage(gdmsg, nil)
+}
+
+// handleCmpctBlockMsg handles compact block announcements from all peers.  The
+// block is rebuilt from the mempool and processed like a block message.  The
+// transactions which are not in the mempool are requested from the peer.
+func (sm *SyncManager) handleCmpctBlockMsg(cmsg *cmpctBlockMsg) {
+	peer := cmsg.peer
+	if _, exists := sm.peerStates[peer]; !exists {
+		log.Warnf("Received cmpctblock message from unknown peer %s", peer)
+		return
+	}
+
+	hash := cmsg.msg.BlockHash()
+	if _, ok := sm.partialBlocks[hash]; ok {
+		return
+	}
+	if _, ok := sm.cachedBlocks[hash]; ok {
+		return
+	}
+	if have, err := sm.chain.HaveBlock(&hash); err == nil && have {
+		return
+	}
+
+	pb := newPartialBlock(cmsg.msg, peer, sm.txMemPool.TxDescs())
+	if pb == nil {
+		log.Warnf("Received malformed cmpctblock %s from %s -- "+
+			"disconnecting", hash, peer)
+		peer.Disconnect()
+		return
+	}
+
+	if len(pb.missing) == 0 {
+		sm.completePartialBlock(&hash, pb)
+		return
+	}
+
+	log.Debugf("Requesting %d of %d transactions of cmpctblock %s from %s",
+		len(pb.missing), len(pb.txns), hash, peer)
+
+	if len(sm.partialBlocks)+1 > maxPartialBlocks {
+		for h := range sm.partialBlocks {
+			delete(sm.partialBlocks, h)
+			break
+		}
+	}
+	sm.partialBlocks[hash] = pb
+	peer.QueueMessage(wire.NewMsgGetBlockTxn(&hash, pb.missing), nil)
+}
+
+// handleBlockTxnMsg fills in the missing transactions of a partial block with
+// the ones received in a blocktxn message.
+func (sm *SyncManager) handleBlockTxnMsg(bmsg *blockTxnMsg) {
+	hash := bmsg.msg.BlockHash
+	pb, ok := sm.partialBlocks[hash]
+	if !ok || pb.peer != bmsg.peer {
+		log.Debugf("Received unrequested blocktxn %s from %s", hash,
+			bmsg.peer)
+		return
+	}
+	delete(sm.partialBlocks, hash)
+
+	if !pb.fill(bmsg.msg.Transactions) {
+		log.Warnf("Received %d transactions for cmpctblock %s from %s, "+
+			"expected %d", len(bmsg.msg.Transactions), hash, bmsg.peer,
+			len(pb.missing))
+		sm.requestFullBlock(&hash, bmsg.peer)
+		return
+	}
+
+	sm.completePartialBlock(&hash, pb)
+}
+
+// completePartialBlock processes a partial block whose transactions are all
+// known, falling back to a full block request if it doesn't check out.
+func (sm *SyncManager) completePartialBlock(hash *chainhash.Hash, pb *partialBlock) {
+	block := pb.block()
+	if block == nil {
+		log.Infof("Unable to rebuild cmpctblock %s from %s, requesting "+
+			"full block", hash, pb.peer)
+		sm.requestFullBlock(hash, pb.peer)
+		return
+	}
+
+	sm.handleBlockMsg(&blockMsg{block: block, peer: pb.peer})
+}
+
+// handleGetBlockTxnMsg serves the transactions a peer is missing to rebuild a
+// compact block we announced.
+func (sm *SyncManager) handleGetBlockTxnMsg(gmsg *getBlockTxnMsg) {
+	hash := gmsg.msg.BlockHash
+	block, ok := sm.cachedBlocks[hash]
+	if !ok {
+		var err error
+		block, err = sm.chain.BlockByHash(&hash)
+		if err != nil {
+			log.Debugf("Unable to serve getblocktxn %s to %s: %v", hash,
+				gmsg.peer, err)
+			return
+		}
+	}
+
+	reply := blockTxns(block, gmsg.msg.Indexes)
+	if reply == nil {
+		log.Warnf("Peer %s requested transactions beyond the %d of "+
+			"block %s -- disconnecting", gmsg.peer,
+			len(block.MsgBlock().Transactions), hash)
+		gmsg.peer.Disconnect()
+		return
+	}
+	gmsg.peer.QueueMessageWithEncoding(reply, nil, wire.SignatureEncoding)
+}
+
+// blockTxns returns the blocktxn message carrying the transactions of the
+// passed block at the requested indexes, or nil if an index is out of range.
+func blockTxns(block *btcutil.Block, indexes []uint32) *wire.MsgBlockTxn {
+	txns := block.MsgBlock().Transactions
+	reply := wire.NewMsgBlockTxn(block.Hash(), len(indexes))
+	for _, idx := range indexes {
+		if int(idx) >= len(txns) {
+			return nil
+		}
+		reply.AddTransaction(txns[idx])
+	}
+	return reply
+}
+
+// announceCmpctBlock sends a compact block announcement to every peer which
+// asked for them with a sendcmpct message.  The passed inventory of the block
+// is marked as known to those peers so it isn't relayed to them again.
+func (sm *SyncManager) announceCmpctBlock(block *btcutil.Block, iv *wire.InvVect) {
+	var msg *wire.MsgCmpctBlock
+	for peer := range sm.peerStates {
+		if peer.ProtocolVersion() < wire.CmpctBlockVersion ||
+			!peer.WantsCmpctBlocks() {
+			continue
+		}
+		if msg == nil {
+			msg = wire.NewMsgCmpctBlock(block.MsgBlock(), rand.Uint64())
+		}
+		peer.AddKnownInventory(iv)
+		peer.QueueMessageWithEncoding(msg, nil, wire.SignatureEncoding)
+	}
+}
+
+// QueueCmpctBlock adds the passed compact block message and peer to the block
+// handling queue.
+func (sm *SyncManager) QueueCmpctBlock(msg *wire.MsgCmpctBlock, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &cmpctBlockMsg{msg: msg, peer: peer}
+}
+
+// QueueGetBlockTxn adds the passed getblocktxn message and peer to the block
+// handling queue.
+func (sm *SyncManager) QueueGetBlockTxn(msg *wire.MsgGetBlockTxn, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &getBlockTxnMsg{msg: msg, peer: peer}
+}
+
+// QueueBlockTxn adds the passed blocktxn message and peer to the block
+// handling queue.
+func (sm *SyncManager) QueueBlockTxn(msg *wire.MsgBlockTxn, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &blockTxnMsg{msg: msg, peer: peer}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/mempool"
+	"github.com/zeusyf/btcd/mining"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+)
+
+// newCmpctTestBlock returns a block of a coinbase committing to numTxs other
+// transactions.
+func newCmpctTestBlock(numTxs int) *btcutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+		wire.MaxPrevOutIndex), 0))
+	msgBlock.AddTransaction(coinbase)
+
+	for i := 0; i < numTxs; i++ {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(
+			&chainhash.Hash{byte(i + 1)}, 0), 0))
+		msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+			Value: &token.NumToken{Val: int64(i + 1)}},
+			PkScript: make([]byte, 25)})
+		msgBlock.AddTransaction(msgTx)
+	}
+
+	// The coinbase carries the witness commitment, which doesn't cover
+	// its own signatures.
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(msgBlock).Transactions(), true, 0)
+	coinbase.SignatureScripts = [][]byte{merkles[len(merkles)-1][:]}
+
+	return btcutil.NewBlock(msgBlock)
+}
+
+// TestCmpctBlockRoundTrip ensures a compact block is rebuilt from the mempool
+// transactions and the missing ones served in reply to a getblocktxn request.
+func TestCmpctBlockRoundTrip(t *testing.T) {
+	block := newCmpctTestBlock(4)
+	txns := block.MsgBlock().Transactions
+
+	// The mempool holds the first and the third transactions only.
+	var pool []*mempool.TxDesc
+	for _, i := range []int{1, 3} {
+		pool = append(pool, &mempool.TxDesc{TxDesc: mining.TxDesc{
+			Tx: btcutil.NewTx(txns[i])}})
+	}
+
+	cmpct := wire.NewMsgCmpctBlock(block.MsgBlock(), 42)
+	pb := newPartialBlock(cmpct, nil, pool)
+	if pb == nil {
+		t.Fatalf("newPartialBlock: rejected well formed compact block")
+	}
+	if len(pb.missing) != 2 || pb.missing[0] != 2 || pb.missing[1] != 4 {
+		t.Fatalf("newPartialBlock: got missing transactions %v, want "+
+			"[2 4]", pb.missing)
+	}
+
+	// The announcing peer serves the missing transactions.
+	reply := blockTxns(block, pb.missing)
+	if reply == nil {
+		t.Fatalf("blockTxns: no reply for transactions %v", pb.missing)
+	}
+	if reply.BlockHash != *block.Hash() {
+		t.Fatalf("blockTxns: got block hash %v, want %v",
+			reply.BlockHash, block.Hash())
+	}
+	if !pb.fill(reply.Transactions) {
+		t.Fatalf("fill: rejected %d requested transactions",
+			len(reply.Transactions))
+	}
+
+	rebuilt := pb.block()
+	if rebuilt == nil {
+		t.Fatalf("block: rebuilt block doesn't match its commitment")
+	}
+	if *rebuilt.Hash() != *block.Hash() {
+		t.Fatalf("block: got block %v, want %v", rebuilt.Hash(),
+			block.Hash())
+	}
+	for i, tx := range rebuilt.MsgBlock().Transactions {
+		if tx.SignatureHash() != txns[i].SignatureHash() {
+			t.Fatalf("block: transaction %d differs from the "+
+				"announced block", i)
+		}
+	}
+}
+
+// TestCmpctBlockMismatch ensures malformed requests and replies and rebuilt
+// blocks not matching their commitment are detected.
+func TestCmpctBlockMismatch(t *testing.T) {
+	block := newCmpctTestBlock(2)
+	txns := block.MsgBlock().Transactions
+
+	if blockTxns(block, []uint32{1, 3}) != nil {
+		t.Fatalf("blockTxns: served transaction beyond the block")
+	}
+
+	cmpct := wire.NewMsgCmpctBlock(block.MsgBlock(), 7)
+	pb := newPartialBlock(cmpct, nil, nil)
+	if pb == nil || len(pb.missing) != 2 {
+		t.Fatalf("newPartialBlock: expected 2 missing transactions")
+	}
+	if pb.fill(txns[1:2]) {
+		t.Fatalf("fill: accepted fewer transactions than requested")
+	}
+
+	// Transactions out of place don't match the witness commitment.
+	if !pb.fill([]*wire.MsgTx{txns[2], txns[1]}) {
+		t.Fatalf("fill: rejected requested transactions")
+	}
+	if pb.block() != nil {
+		t.Fatalf("block: accepted block with transactions out of order")
+	}
+
+	// A compact block without its coinbase prefilled is malformed.
+	cmpct.PrefilledTxns = nil
+	cmpct.ShortIDs = append(cmpct.ShortIDs, 0)
+	if newPartialBlock(cmpct, nil, nil) != nil {
+		t.Fatalf("newPartialBlock: accepted compact block without " +
+			"coinbase")
+	}
+}
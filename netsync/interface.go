@@ -40,4 +40,8 @@ type Config struct {
 	MaxPeers           int
 
 	FeeEstimator *mempool.FeeEstimator
+
+	// DoubleSigns, when set, is fed with the signatures of the tx blocks
+	// received to find committee members signing conflicting blocks.
+	DoubleSigns *blockchain.DoubleSignWatcher
 }
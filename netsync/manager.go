@@ -190,6 +190,7 @@ type peerSyncState struct {
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]int
 	syncTime        int64 // unix time this peer became a sync peer
+	feeFilter       int64 // minimum fee rate in hao/kB the peer relays
 }
 
 type pendginGetBlocks struct {
@@ -235,9 +236,24 @@ type SyncManager struct {
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
+	// The following fields are used for the headers-first mode of the
+	// miner chain.
+	minerCheckpoints      []chaincfg.Checkpoint
+	minerHeadersFirstMode bool
+	minerHeaderList       *list.List
+	minerStartHeader      *list.Element
+	minerNextCheckpoint   *chaincfg.Checkpoint
+
 	// An optional fee estimator.
 	feeEstimator *mempool.FeeEstimator
 
+	// feeFilter is the minimum fee rate of the memory pool in hao/kB last
+	// advertised to the peers with fee filter messages.
+	feeFilter int64
+
+	// An optional double sign watcher.
+	doubleSigns *blockchain.DoubleSignWatcher
+
 	// blocks already processed for consensus. we cache them to avoid double processing
 	cachedBlocks map[chainhash.Hash]*btcutil.Block // block hash=>block
 	castedMsg    map[chainhash.Hash]int64          // time a message is broadcated, to prevent re-casting
@@ -248,6 +264,9 @@ type SyncManager struct {
 	castmx      sync.Mutex
 
 	tmpblksrc map[chainhash.Hash]*peerpkg.Peer
+
+	// compact blocks waiting for missing transactions
+	partialBlocks map[chainhash.Hash]*partialBlock
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -575,6 +594,7 @@ func (sm *SyncManager) startSync(avoid *peerpkg.Peer) bool {
 				"%d from peer %s", best.Height+1,
 				sm.nextCheckpoint.Height, bestPeer.Addr())
 		}
+		sm.startMinerHeadersSync(bestPeer)
 		if deferexec == 0 {
 			//			log.Infof("startSync %d: PushGetBlocksMsg from %s", bestPeer.ID(), bestPeer.Addr())
 			bestPeer.PushGetBlocksMsg(locator, mlocator, &zeroHash, &zeroHash)
@@ -645,6 +665,15 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 		requestQueue:    make([]*wire.InvVect, 0, 1000),
 	}
 
+	// Ask the peer to announce new blocks with compact blocks.
+	if peer.ProtocolVersion() >= wire.CmpctBlockVersion {
+		peer.QueueMessage(wire.NewMsgSendCmpct(true), nil)
+	}
+
+	// Tell the peer not to relay transactions paying less than the
+	// minimum fee rate of the memory pool.
+	sendFeeFilter(peer, int64(sm.txMemPool.MinFeeRate()))
+
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
 		sm.startSync(nil)
@@ -681,6 +710,13 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 		delete(sm.requestedBlocks, blockHash)
 	}
 
+	// Drop the compact blocks waiting for transactions from the peer.
+	for blockHash, pb := range sm.partialBlocks {
+		if pb.peer == peer {
+			delete(sm.partialBlocks, blockHash)
+		}
+	}
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer.  Also, reset the headers-first state if in headers-first
 	// mode so
@@ -692,6 +728,10 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 			best := sm.chain.BestSnapshot()
 			sm.resetHeaderState(&best.Hash, best.Height)
 		}
+		if sm.minerHeadersFirstMode {
+			mbest := sm.chain.Miners.BestSnapshot()
+			sm.resetMinerHeaderState(&mbest.Hash, mbest.Height)
+		}
 		sm.updateSyncPeer()
 		//		sm.startSync(p)
 	}
@@ -737,6 +777,10 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	delete(state.requestedTxns, *txHash)
 	delete(sm.requestedTxns, *txHash)
 
+	// The transaction may have filled the memory pool and raised its
+	// minimum fee rate.
+	sm.updateFeeFilter()
+
 	if err != nil {
 		// Do not request this transaction again until a new block
 		// has been processed.
@@ -765,6 +809,29 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 }
 
+// sendFeeFilter sends a fee filter message with the passed minimum fee rate in
+// hao/kB to the peer if it supports it.
+func sendFeeFilter(peer *peerpkg.Peer, feeFilter int64) {
+	if peer.ProtocolVersion() >= wire.FeeFilterVersion {
+		peer.QueueMessage(wire.NewMsgFeeFilter(feeFilter), nil)
+	}
+}
+
+// updateFeeFilter advertises the minimum fee rate of the memory pool to all
+// peers when it has changed by more than a tenth since last advertised, so
+// they stop relaying transactions which would be rejected as the pool is full.
+func (sm *SyncManager) updateFeeFilter() {
+	feeFilter := int64(sm.txMemPool.MinFeeRate())
+	if feeFilter*10 >= sm.feeFilter*9 && feeFilter*10 <= sm.feeFilter*11 {
+		return
+	}
+	sm.feeFilter = feeFilter
+
+	for peer := range sm.peerStates {
+		sendFeeFilter(peer, feeFilter)
+	}
+}
+
 // current returns true if we believe we are synced with our peers, false if we
 // still have blocks to check
 func (sm *SyncManager) current(t int) bool {
@@ -816,7 +883,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	// if it is a block being processed by the committee, veryfy it is from the peer
 	// producing, i.e. the address in coinbase signature is the peer's
 	if wire.CommitteeSize > 1 && bmsg.block.MsgBlock().Header.Nonce < 0 &&
-		len(bmsg.block.MsgBlock().Transactions[0].SignatureScripts) <= wire.CommitteeSigs {
+		wire.NumBlockSigners(bmsg.block.MsgBlock().Transactions[0]) < wire.CommitteeSigs {
 		//		if len(bmsg.block.MsgBlock().Transactions[0].SignatureScripts) < 2 {
 		//			log.Errorf("handleBlockMsg: blocked because of insufficient signatures. Require 2 items in coinbase signatures.")
 		//			return
@@ -950,6 +1017,14 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 				return
 			}
 		}
+
+		// Look for double signing once the block has passed the checks
+		// of the chain, a conflicting block is evidence even when it is
+		// not on the main chain.  The signatures of unchecked blocks are
+		// not verified, as that would be a cheap way to waste CPU time.
+		if sm.doubleSigns != nil && err == nil && !isOrphan {
+			sm.doubleSigns.AddBlock(bmsg.block)
+		}
 	}
 
 	if b1 == nil {
@@ -1145,6 +1220,13 @@ func (sm *SyncManager) handleMinerBlockMsg(bmsg *minerBlockMsg) {
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	// When in headers-first mode, the block must match the header it was
+	// requested for.
+	matches, isCheckpointBlock := sm.checkMinerHeaderBlock(peer, bmsg.block)
+	if !matches {
+		return
+	}
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
 
@@ -1263,6 +1345,8 @@ func (sm *SyncManager) handleMinerBlockMsg(bmsg *minerBlockMsg) {
 				peer)
 		}
 	}
+
+	sm.minerHeaderBlockConnected(peer, state, isCheckpointBlock)
 }
 
 // fetchHeaderBlocks creates and sends a request to the syncPeer for the next
@@ -2045,6 +2129,15 @@ out:
 						msg.reply,
 					}
 					sm.handleBlockMsg(&bm)
+				} else {
+					sigs := &wire.MsgSignatures{
+						Hash:       msg.hash,
+						Signatures: msg.signatures,
+					}
+					if sm.doubleSigns != nil {
+						sm.doubleSigns.AddSignatures(sigs)
+					}
+					sm.chain.AddBlockSignatures(sigs)
 				}
 				msg.reply <- struct{}{}
 
@@ -2063,12 +2156,33 @@ out:
 				sm.handleMinerBlockMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *cmpctBlockMsg:
+				sm.handleCmpctBlockMsg(msg)
+
+			case *getBlockTxnMsg:
+				sm.handleGetBlockTxnMsg(msg)
+
+			case *blockTxnMsg:
+				sm.handleBlockTxnMsg(msg)
+
+			case *packageMsg:
+				sm.handlePackageMsg(msg)
+
+			case *feeFilterMsg:
+				sm.handleFeeFilterMsg(msg)
+
 			case *invMsg:
 				sm.handleInvMsg(msg)
 
 			case *headersMsg:
 				sm.handleHeadersMsg(msg)
 
+			case *minerHeadersMsg:
+				sm.handleMinerHeadersMsg(msg)
+
+			case *getMinerHeadersMsg:
+				sm.handleGetMinerHeadersMsg(msg)
+
 			case *donePeerMsg:
 				sm.handleDonePeerMsg(msg.peer)
 
@@ -2176,8 +2290,10 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				return
 			}
 			block := notification.Data.(*btcutil.Block)
-			// Generate the inventory vector and relay it.
+			// Generate the inventory vector and relay it to the
+			// peers not getting a compact block announcement.
 			iv := wire.NewInvVect(common.InvTypeWitnessBlock, block.Hash())
+			sm.announceCmpctBlock(block, iv)
 			sm.peerNotifier.RelayInventory(iv, block.MsgBlock().Header)
 
 		case *wire.MinerBlock:
@@ -2245,6 +2361,14 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 			}
 
+			// Evict the transactions which have stayed in the
+			// memory pool for too long.
+			sm.txMemPool.ExpireTransactions()
+
+			// The minimum fee rate of the memory pool decays over
+			// time once it is no longer full.
+			sm.updateFeeFilter()
+
 			// Register block with the fee estimator, if it exists.
 			if sm.feeEstimator != nil {
 				err := sm.feeEstimator.RegisterBlock(block)
@@ -2432,7 +2556,7 @@ func (sm *SyncManager) SyncPeerID() int32 {
 func (sm *SyncManager) ProcessBlock(block *btcutil.Block, flags blockchain.BehaviorFlags) (bool, error) {
 	reply := make(chan processBlockResponse, 1)
 
-	if block.MsgBlock().Header.Nonce < 0 && wire.CommitteeSize > 1 && len(block.MsgBlock().Transactions[0].SignatureScripts) <= wire.CommitteeSigs {
+	if block.MsgBlock().Header.Nonce < 0 && wire.CommitteeSize > 1 && wire.NumBlockSigners(block.MsgBlock().Transactions[0]) < wire.CommitteeSigs {
 		log.Debugf("procssing a comittee block, height = %d", block.Height())
 		sm.msgChan <- processBlockMsg{block: block, flags: flags | blockchain.BFSubmission, reply: reply}
 
@@ -2498,13 +2622,16 @@ func New(config *Config) (*SyncManager, error) {
 		progressLogger:   newBlockProgressLogger("Processed", log),
 		msgChan:          make(chan interface{}, config.MaxPeers*3),
 		headerList:       list.New(),
+		minerHeaderList:  list.New(),
 		quit:             make(chan struct{}),
 		feeEstimator:     config.FeeEstimator,
+		doubleSigns:      config.DoubleSigns,
 		cachedBlocks:     make(map[chainhash.Hash]*btcutil.Block),
 		castedMsg:        make(map[chainhash.Hash]int64),
 		syncjobs:         make([]*pendginGetBlocks, 0),
 		syncPeer:         nil,
 		tmpblksrc:        make(map[chainhash.Hash]*peerpkg.Peer),
+		partialBlocks:    make(map[chainhash.Hash]*partialBlock),
 	}
 
 	best := sm.chain.BestSnapshot()
@@ -2514,6 +2641,14 @@ func New(config *Config) (*SyncManager, error) {
 		if sm.nextCheckpoint != nil {
 			sm.resetHeaderState(&best.Hash, best.Height)
 		}
+
+		// The same for the miner chain.
+		sm.minerCheckpoints = sm.chainParams.MinerCheckpoints
+		mbest := sm.chain.Miners.BestSnapshot()
+		sm.minerNextCheckpoint = sm.findNextMinerHeaderCheckpoint(mbest.Height)
+		if sm.minerNextCheckpoint != nil {
+			sm.resetMinerHeaderState(&mbest.Hash, mbest.Height)
+		}
 	} else {
 		log.Info("Checkpoints are disabled")
 	}
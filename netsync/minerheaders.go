@@ -0,0 +1,448 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	peerpkg "github.com/zeusyf/btcd/peer"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// maxMinerHeaderTimeOffset is how far in the future the timestamp of a miner
+// header may be.
+const maxMinerHeaderTimeOffset = 2 * time.Hour
+
+// minerHeadersMsg packages a minerheaders message and the peer it came from
+// together so the block handler has access to that information.
+type minerHeadersMsg struct {
+	msg  *wire.MsgMinerHeaders
+	peer *peerpkg.Peer
+}
+
+// getMinerHeadersMsg packages a getminerheaders message and the peer it came
+// from together so the block handler has access to that information.
+type getMinerHeadersMsg struct {
+	msg  *wire.MsgGetMinerHeaders
+	peer *peerpkg.Peer
+}
+
+// minerHeaderNode is used as a node in the list of miner headers that are
+// linked together between miner chain checkpoints.  header is nil for the
+// first node, which is the last block already known.
+type minerHeaderNode struct {
+	height int32
+	hash   *chainhash.Hash
+	header *wire.MinerHeader
+}
+
+// The miner chain is synced headers first in the same way as the tx chain:
+// while the best miner block is below the next miner checkpoint, the compact
+// headers up to the checkpoint are downloaded from the sync peer and checked
+// to link together, to carry valid proof of work and to reach the checkpoint.
+// Only then the blocks are requested with getdata.  The hash of a miner block
+// covers fields the header leaves out, so the hash a header claims is only
+// proven by its block: every block received for a header must match it,
+// otherwise the headers are dropped and the sync peer is disconnected.
+
+// resetMinerHeaderState sets the miner chain headers-first mode state to
+// values appropriate for syncing from a new peer.
+func (sm *SyncManager) resetMinerHeaderState(newestHash *chainhash.Hash, newestHeight int32) {
+	sm.minerHeadersFirstMode = false
+	sm.minerHeaderList.Init()
+	sm.minerStartHeader = nil
+
+	// When there is a next checkpoint, add an entry for the latest known
+	// block into the header pool.  This allows the next downloaded header
+	// to prove it links to the chain properly.
+	if sm.minerNextCheckpoint != nil {
+		hash := *newestHash
+		node := minerHeaderNode{height: newestHeight, hash: &hash}
+		sm.minerHeaderList.PushBack(&node)
+	}
+}
+
+// findNextMinerHeaderCheckpoint returns the next miner chain checkpoint after
+// the passed height.  It returns nil when there is not one either because the
+// height is already later than the final checkpoint or checkpoints are
+// disabled.
+func (sm *SyncManager) findNextMinerHeaderCheckpoint(height int32) *chaincfg.Checkpoint {
+	checkpoints := sm.minerCheckpoints
+	if len(checkpoints) == 0 {
+		return nil
+	}
+
+	// There is no next checkpoint if the height is already after the final
+	// checkpoint.
+	finalCheckpoint := &checkpoints[len(checkpoints)-1]
+	if height >= finalCheckpoint.Height {
+		return nil
+	}
+
+	// Find the next checkpoint.
+	nextCheckpoint := finalCheckpoint
+	for i := len(checkpoints) - 2; i >= 0; i-- {
+		if height >= checkpoints[i].Height {
+			break
+		}
+		nextCheckpoint = &checkpoints[i]
+	}
+	return nextCheckpoint
+}
+
+// startMinerHeadersSync starts the miner chain headers-first sync from the
+// passed sync peer when the best miner block is below the next checkpoint and
+// the peer supports the minerheaders message.
+func (sm *SyncManager) startMinerHeadersSync(peer *peerpkg.Peer) {
+	mbest := sm.chain.Miners.BestSnapshot()
+	if sm.minerNextCheckpoint == nil ||
+		mbest.Height >= sm.minerNextCheckpoint.Height ||
+		sm.chainParams == &chaincfg.RegressionNetParams ||
+		peer.ProtocolVersion() < wire.MinerHeadersVersion {
+		return
+	}
+
+	sm.resetMinerHeaderState(&mbest.Hash, mbest.Height)
+	locator := chainhash.BlockLocator([]*chainhash.Hash{&mbest.Hash})
+	err := peer.PushGetMinerHeadersMsg(locator, sm.minerNextCheckpoint.Hash)
+	if err != nil {
+		log.Warnf("Failed to send getminerheaders message to peer %s: %v",
+			peer.Addr(), err)
+		return
+	}
+	sm.minerHeadersFirstMode = true
+	log.Tracef("Downloading miner headers for blocks %d to %d from peer %s",
+		mbest.Height+1, sm.minerNextCheckpoint.Height, peer.Addr())
+}
+
+// checkMinerHeader performs the context free checks of a miner header with the
+// passed block hash: its timestamp must not be too far in the future and its
+// hash must satisfy the proof of work target it claims.
+func (sm *SyncManager) checkMinerHeader(h *wire.MinerHeader, hash *chainhash.Hash) error {
+	if h.Timestamp.After(time.Now().Add(maxMinerHeaderTimeOffset)) {
+		return fmt.Errorf("miner header %s has a timestamp too far in "+
+			"the future", hash)
+	}
+
+	// The test networks accept easy blocks, see handleMinerBlockMsg.
+	switch sm.chainParams.Net {
+	case common.TestNet, common.SimNet, common.RegNet:
+		return nil
+	}
+
+	target := blockchain.CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(sm.chainParams.PowLimit) > 0 {
+		return fmt.Errorf("miner header %s has an invalid target "+
+			"difficulty %08x", hash, h.Bits)
+	}
+	if blockchain.HashToBig(hash).Cmp(target) > 0 {
+		return fmt.Errorf("miner header %s does not satisfy its "+
+			"target difficulty %08x", hash, h.Bits)
+	}
+	return nil
+}
+
+// handleMinerHeadersMsg handles minerheaders messages from all peers.  Miner
+// headers are requested when performing a headers-first sync of the miner
+// chain.
+func (sm *SyncManager) handleMinerHeadersMsg(hmsg *minerHeadersMsg) {
+	peer := hmsg.peer
+	if _, exists := sm.peerStates[peer]; !exists {
+		log.Warnf("Received minerheaders message from unknown peer %s", peer)
+		return
+	}
+
+	// The remote peer is misbehaving if we didn't request headers.
+	msg := hmsg.msg
+	numHeaders := len(msg.Headers)
+	if !sm.minerHeadersFirstMode || peer != sm.syncPeer {
+		log.Warnf("Got %d unrequested miner headers from %s -- "+
+			"disconnecting", numHeaders, peer.Addr())
+		peer.Disconnect("handleMinerHeadersMsg @ minerHeadersFirstMode")
+		return
+	}
+
+	// Nothing to do for an empty headers message.
+	if numHeaders == 0 {
+		return
+	}
+
+	// Process all of the received headers ensuring each one connects to the
+	// previous, carries valid proof of work and that checkpoints match.
+	receivedCheckpoint := false
+	var finalHash *chainhash.Hash
+	for _, header := range msg.Headers {
+		hash := header.BlockHash()
+		finalHash = &hash
+
+		// Ensure there is a previous header to compare against.
+		prevNodeEl := sm.minerHeaderList.Back()
+		if prevNodeEl == nil {
+			log.Warnf("Miner header list does not contain a previous " +
+				"element as expected -- disconnecting peer")
+			peer.Disconnect("handleMinerHeadersMsg @ prevNodeEl")
+			return
+		}
+
+		if err := sm.checkMinerHeader(header, &hash); err != nil {
+			log.Warnf("Received invalid miner header from peer %s: %v "+
+				"-- disconnecting", peer.Addr(), err)
+			peer.Disconnect("handleMinerHeadersMsg @ checkMinerHeader")
+			return
+		}
+
+		// Ensure the header properly connects to the previous one and
+		// add it to the list of headers.
+		prevNode := prevNodeEl.Value.(*minerHeaderNode)
+		if !prevNode.hash.IsEqual(&header.PrevBlock) {
+			log.Warnf("Received miner header that does not properly "+
+				"connect to the chain from peer %s -- disconnecting",
+				peer.Addr())
+			peer.Disconnect("handleMinerHeadersMsg @ PrevBlock")
+			return
+		}
+		node := minerHeaderNode{
+			height: prevNode.height + 1,
+			hash:   &hash,
+			header: header,
+		}
+		e := sm.minerHeaderList.PushBack(&node)
+		if sm.minerStartHeader == nil {
+			sm.minerStartHeader = e
+		}
+
+		// Verify the header at the next checkpoint height matches.
+		if node.height == sm.minerNextCheckpoint.Height {
+			if !node.hash.IsEqual(sm.minerNextCheckpoint.Hash) {
+				log.Warnf("Miner header at height %d/hash %s from "+
+					"peer %s does NOT match expected checkpoint "+
+					"hash of %s -- disconnecting", node.height,
+					node.hash, peer.Addr(),
+					sm.minerNextCheckpoint.Hash)
+				peer.Disconnect("handleMinerHeadersMsg @ minerNextCheckpoint")
+				return
+			}
+			receivedCheckpoint = true
+			log.Infof("Verified downloaded miner header against "+
+				"checkpoint at height %d/hash %s", node.height,
+				node.hash)
+			break
+		}
+	}
+
+	// When this header is a checkpoint, switch to fetching the blocks for
+	// all of the headers since the last checkpoint.
+	if receivedCheckpoint {
+		// The first entry of the list is the last block already known,
+		// which is only used to link the headers.
+		sm.minerHeaderList.Remove(sm.minerHeaderList.Front())
+		log.Tracef("Received %v miner headers: Fetching blocks",
+			sm.minerHeaderList.Len())
+		sm.fetchMinerHeaderBlocks()
+		return
+	}
+
+	// This header is not a checkpoint, so request the next batch of
+	// headers starting from the latest known header and ending with the
+	// next checkpoint.
+	locator := chainhash.BlockLocator([]*chainhash.Hash{finalHash})
+	err := peer.PushGetMinerHeadersMsg(locator, sm.minerNextCheckpoint.Hash)
+	if err != nil {
+		log.Warnf("Failed to send getminerheaders message to peer %s: %v",
+			peer.Addr(), err)
+	}
+}
+
+// fetchMinerHeaderBlocks creates and sends a request to the syncPeer for the
+// next list of miner blocks to be downloaded based on the current list of
+// miner headers.
+func (sm *SyncManager) fetchMinerHeaderBlocks() {
+	// Nothing to do if there is no start header.
+	if sm.minerStartHeader == nil {
+		log.Warnf("fetchMinerHeaderBlocks called with no start header")
+		return
+	}
+
+	syncPeerState := sm.peerStates[sm.syncPeer]
+	gdmsg := wire.NewMsgGetDataSizeHint(uint(sm.minerHeaderList.Len()))
+	for e := sm.minerStartHeader; e != nil; e = e.Next() {
+		node := e.Value.(*minerHeaderNode)
+		sm.minerStartHeader = e.Next()
+
+		have, err := sm.chain.Miners.HaveBlock(node.hash)
+		if err != nil {
+			log.Warnf("Unexpected failure when checking for existing "+
+				"miner block during header block fetch: %v", err)
+		}
+		if have {
+			continue
+		}
+
+		sm.requestedBlocks[*node.hash] = 1
+		syncPeerState.requestedBlocks[*node.hash] = 1
+		gdmsg.AddInvVect(wire.NewInvVect(common.InvTypeMinerBlock, node.hash))
+		if len(gdmsg.InvList) >= wire.MaxInvPerMsg {
+			break
+		}
+	}
+	if len(gdmsg.InvList) > 0 {
+		sm.syncPeer.QueueMessage(gdmsg, nil)
+	}
+}
+
+// checkMinerHeaderBlock checks a miner block received from the passed peer
+// against the miner headers when in headers-first mode.  It returns false
+// when the block does not match its header, in which case the peer has been
+// disconnected and the headers dropped, and whether the block is the one of
+// the next checkpoint.
+func (sm *SyncManager) checkMinerHeaderBlock(peer *peerpkg.Peer, block *wire.MinerBlock) (bool, bool) {
+	if !sm.minerHeadersFirstMode {
+		return true, false
+	}
+	firstNodeEl := sm.minerHeaderList.Front()
+	if firstNodeEl == nil {
+		return true, false
+	}
+	firstNode := firstNodeEl.Value.(*minerHeaderNode)
+	if firstNode.header == nil || !block.Hash().IsEqual(firstNode.hash) {
+		return true, false
+	}
+
+	if !firstNode.header.Matches(block.MsgBlock()) {
+		log.Warnf("Miner block %s from peer %s does not match its "+
+			"header -- disconnecting", block.Hash(), peer.Addr())
+		peer.Disconnect("checkMinerHeaderBlock @ Matches")
+		mbest := sm.chain.Miners.BestSnapshot()
+		sm.resetMinerHeaderState(&mbest.Hash, mbest.Height)
+		return false, false
+	}
+
+	// Keep the checkpoint entry, it is needed to link the next round of
+	// headers.
+	if firstNode.hash.IsEqual(sm.minerNextCheckpoint.Hash) {
+		return true, true
+	}
+	sm.minerHeaderList.Remove(firstNodeEl)
+	return true, false
+}
+
+// minerHeaderBlockConnected requests more miner blocks or the next round of
+// miner headers after a block of the headers-first sync has been processed.
+func (sm *SyncManager) minerHeaderBlockConnected(peer *peerpkg.Peer, state *peerSyncState, isCheckpointBlock bool) {
+	if !sm.minerHeadersFirstMode {
+		return
+	}
+
+	// Request more blocks using the header list when the request queue
+	// is getting short.
+	if !isCheckpointBlock {
+		if sm.minerStartHeader != nil &&
+			len(state.requestedBlocks) < minInFlightBlocks {
+			sm.fetchMinerHeaderBlocks()
+		}
+		return
+	}
+
+	// The block is a checkpoint.  When there is a next checkpoint, get the
+	// next round of headers.
+	prevHeight := sm.minerNextCheckpoint.Height
+	prevHash := sm.minerNextCheckpoint.Hash
+	sm.minerNextCheckpoint = sm.findNextMinerHeaderCheckpoint(prevHeight)
+	if sm.minerNextCheckpoint != nil {
+		sm.resetMinerHeaderState(prevHash, prevHeight)
+		sm.minerHeadersFirstMode = true
+		locator := chainhash.BlockLocator([]*chainhash.Hash{prevHash})
+		err := peer.PushGetMinerHeadersMsg(locator,
+			sm.minerNextCheckpoint.Hash)
+		if err != nil {
+			log.Warnf("Failed to send getminerheaders message to "+
+				"peer %s: %v", peer.Addr(), err)
+			return
+		}
+		log.Tracef("Downloading miner headers for blocks %d to %d "+
+			"from peer %s", prevHeight+1, sm.minerNextCheckpoint.Height,
+			peer.Addr())
+		return
+	}
+
+	// There are no more checkpoints, so the miner chain is synced with
+	// the normal getblocks exchange from now on.
+	sm.minerHeadersFirstMode = false
+	sm.minerHeaderList.Init()
+	sm.minerStartHeader = nil
+	log.Infof("Reached the final miner checkpoint -- switching to " +
+		"normal mode")
+}
+
+// handleGetMinerHeadersMsg answers a getminerheaders message with the headers
+// of the main miner chain following the first known locator hash, up to the
+// stop hash or the maximum number of headers per message.  The headers are
+// served from the block index of the miner chain, so no block is loaded from
+// the database.
+func (sm *SyncManager) handleGetMinerHeadersMsg(gmsg *getMinerHeadersMsg) {
+	miners := sm.chain.Miners
+	msg := gmsg.msg
+
+	// Find the most recent locator block on the main miner chain.  The
+	// headers start after the genesis block when none is known.
+	start := int32(1)
+	for _, hash := range msg.BlockLocatorHashes {
+		node := miners.NodeByHash(hash)
+		if node == nil {
+			continue
+		}
+		if main := miners.NodeByHeight(node.Height); main != nil &&
+			main.Hash == *hash {
+			start = node.Height + 1
+			break
+		}
+	}
+
+	best := miners.BestSnapshot()
+	reply := wire.NewMsgMinerHeaders()
+	for height := start; height <= best.Height &&
+		len(reply.Headers) < wire.MaxBlockHeadersPerMsg; height++ {
+
+		node := miners.NodeByHeight(height)
+		if node == nil {
+			break
+		}
+		header := miners.NodetoHeader(node)
+		reply.AddMinerHeader(wire.NewMinerHeader(&header, &node.Hash))
+		if node.Hash == msg.HashStop {
+			break
+		}
+	}
+
+	gmsg.peer.QueueMessage(reply, nil)
+}
+
+// QueueMinerHeaders adds the passed minerheaders message and peer to the
+// block handling queue.
+func (sm *SyncManager) QueueMinerHeaders(msg *wire.MsgMinerHeaders, peer *peerpkg.Peer) {
+	// No channel handling here because peers do not need to block on
+	// headers messages.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &minerHeadersMsg{msg: msg, peer: peer}
+}
+
+// QueueGetMinerHeaders adds the passed getminerheaders message and peer to
+// the block handling queue.
+func (sm *SyncManager) QueueGetMinerHeaders(msg *wire.MsgGetMinerHeaders, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &getMinerHeadersMsg{msg: msg, peer: peer}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"sync/atomic"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/mempool"
+	peerpkg "github.com/zeusyf/btcd/peer"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/btcutil"
+)
+
+// packageMsg packages a package message and the peer it came from together
+// so the block handler has access to that information.
+type packageMsg struct {
+	msg  *wire.MsgPackage
+	peer *peerpkg.Peer
+}
+
+// feeFilterMsg packages a feefilter message and the peer it came from
+// together so the block handler has access to that information.
+type feeFilterMsg struct {
+	msg  *wire.MsgFeeFilter
+	peer *peerpkg.Peer
+}
+
+// handlePackageMsg handles package messages from all peers.  The transactions
+// of the package are accepted to the memory pool as a whole, and the package
+// is relayed to the peers supporting package relay.
+func (sm *SyncManager) handlePackageMsg(pmsg *packageMsg) {
+	peer := pmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received package message from unknown peer %s", peer)
+		return
+	}
+
+	txs := make([]*btcutil.Tx, 0, len(pmsg.msg.Transactions))
+	for _, msgTx := range pmsg.msg.Transactions {
+		tx := btcutil.NewTx(msgTx)
+		txs = append(txs, tx)
+
+		// The peer sending the package has its transactions, so they
+		// are not announced back to it.
+		peer.AddKnownInventory(wire.NewInvVect(common.InvTypeTx, tx.Hash()))
+	}
+
+	acceptedTxs, err := sm.txMemPool.ProcessPackage(txs, false)
+
+	// The transactions of the package may have been requested on their own
+	// too.  Either they are now in the pool, or they will be requested again
+	// when announced.
+	for _, tx := range txs {
+		delete(state.requestedTxns, *tx.Hash())
+		delete(sm.requestedTxns, *tx.Hash())
+	}
+
+	// The package may have filled the memory pool and raised its minimum
+	// fee rate.
+	sm.updateFeeFilter()
+
+	if err != nil {
+		// The transactions of a rejected package are not remembered
+		// as rejected, as they may still be accepted in another one.
+		if _, ok := err.(mempool.RuleError); ok {
+			log.Debugf("Rejected package of %d transactions from "+
+				"%s: %v", len(txs), peer, err)
+		} else {
+			log.Errorf("Failed to process package of %d "+
+				"transactions: %v", len(txs), err)
+		}
+
+		code, reason := mempool.ErrToRejectErr(err)
+		peer.PushRejectMsg(wire.CmdPackage, code, reason, nil, false)
+		return
+	}
+
+	// The package is relayed before its transactions are announced, so the
+	// peers it is sent to don't request them on their own.
+	sm.relayPackage(txs, acceptedTxs, peer)
+	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
+}
+
+// newMsgPackage returns a package message of the passed transactions, which
+// are sorted topologically.
+func newMsgPackage(txs []*btcutil.Tx) *wire.MsgPackage {
+	msg := wire.NewMsgPackage(len(txs))
+	for _, tx := range txs {
+		msg.Transactions = append(msg.Transactions, tx.MsgTx())
+	}
+	return msg
+}
+
+// relayPackage sends the passed package, accepted to the memory pool, to the
+// peers supporting package relay other than the one it came from, when the
+// transactions of the package accepted to the pool meet their fee filter as a
+// whole, even when its parents alone pay less than the filter.  Announcing the
+// transactions by inventory isn't enough, as a peer would request the parents
+// and reject them on their own.  The transactions are marked as known to the
+// peers the package is sent to, so they are not announced to them again.
+func (sm *SyncManager) relayPackage(txs []*btcutil.Tx, txDescs []*mempool.TxDesc, from *peerpkg.Peer) {
+	// The accepted transactions include the orphans accepted along with
+	// the package, which are announced on their own.
+	inPackage := make(map[chainhash.Hash]struct{}, len(txs))
+	for _, tx := range txs {
+		inPackage[*tx.Hash()] = struct{}{}
+	}
+	var fees, size int64
+	for _, txD := range txDescs {
+		if _, ok := inPackage[*txD.Tx.Hash()]; !ok {
+			continue
+		}
+		fees += txD.Fee
+		size += int64(txD.Tx.MsgTx().SerializeSize())
+	}
+	if size == 0 {
+		return
+	}
+	feeRate := fees * 1000 / size
+
+	msg := newMsgPackage(txs)
+	for peer, state := range sm.peerStates {
+		if peer == from ||
+			peer.ProtocolVersion() < wire.PackageRelayVersion ||
+			feeRate < state.feeFilter {
+
+			continue
+		}
+		peer.QueueMessageWithEncoding(msg, nil, wire.SignatureEncoding)
+		for _, tx := range txs {
+			peer.AddKnownInventory(wire.NewInvVect(common.InvTypeTx,
+				tx.Hash()))
+		}
+	}
+}
+
+// handleFeeFilterMsg records the minimum fee rate of the transactions a peer
+// wants to be announced, so packages paying less are not announced to it.
+func (sm *SyncManager) handleFeeFilterMsg(fmsg *feeFilterMsg) {
+	state, exists := sm.peerStates[fmsg.peer]
+	if !exists {
+		log.Warnf("Received feefilter message from unknown peer %s",
+			fmsg.peer)
+		return
+	}
+
+	if fmsg.msg.MinFee < 0 {
+		log.Debugf("Peer %s sent an invalid feefilter '%d'", fmsg.peer,
+			fmsg.msg.MinFee)
+		return
+	}
+	state.feeFilter = fmsg.msg.MinFee
+}
+
+// QueuePackage adds the passed package message and peer to the block handling
+// queue.
+func (sm *SyncManager) QueuePackage(msg *wire.MsgPackage, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &packageMsg{msg: msg, peer: peer}
+}
+
+// QueueFeeFilter adds the passed feefilter message and peer to the block
+// handling queue.
+func (sm *SyncManager) QueueFeeFilter(msg *wire.MsgFeeFilter, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &feeFilterMsg{msg: msg, peer: peer}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/blockchain"
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/mempool"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcutil"
+	"github.com/zeusyf/omega/token"
+	"github.com/zeusyf/omega/viewpoint"
+)
+
+// newPkgRelayTestTx returns a transaction spending the passed outpoint to a
+// single output of the passed value.
+func newPkgRelayTestTx(prevOut wire.OutPoint, value int64) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: prevOut,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	msgTx.AddTxOut(&wire.TxOut{Token: token.Token{TokenType: 0,
+		Value: &token.NumToken{Val: value}},
+		PkScript: make([]byte, 25)})
+	return btcutil.NewTx(msgTx)
+}
+
+// newPkgRelayTestPool returns a pool fetching the inputs of transactions from
+// the passed view, standing for the utxo set of the main chain.
+func newPkgRelayTestPool(utxos *viewpoint.UtxoViewpoint) *mempool.TxPool {
+	return mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			AcceptNonStd:  true,
+			MaxTxVersion:  wire.TxVersion,
+			MinRelayTxFee: 10000,
+		},
+		ChainParams: &chaincfg.MainNetParams,
+		FetchUtxoView: func(tx *btcutil.Tx) (*viewpoint.ViewPointSet, error) {
+			views := viewpoint.NewViewPointSet(nil)
+			for _, txIn := range tx.MsgTx().TxIn {
+				prevOut := txIn.PreviousOutPoint
+				views.Utxo.Entries()[prevOut] = utxos.LookupEntry(prevOut)
+			}
+			return views, nil
+		},
+		BestHeight:     func() int32 { return 100 },
+		MedianTimePast: time.Now,
+		CalcSequenceLock: func(*btcutil.Tx, *viewpoint.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{Seconds: -1, BlockHeight: -1}, nil
+		},
+	})
+}
+
+// TestRelayedPackage ensures a package accepted by a pool is relayed in a
+// package message which another pool accepts, while it would reject the parent
+// paying no fee announced on its own.
+func TestRelayedPackage(t *testing.T) {
+	confirmed := newPkgRelayTestTx(wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		100000)
+	utxos := viewpoint.NewUtxoViewpoint()
+	utxos.AddTxOuts(confirmed, 1)
+	relaying := newPkgRelayTestPool(utxos)
+	receiving := newPkgRelayTestPool(utxos)
+
+	// The child pays for its parent, which pays no fee.
+	parent := newPkgRelayTestTx(wire.OutPoint{Hash: *confirmed.Hash()},
+		100000)
+	child := newPkgRelayTestTx(wire.OutPoint{Hash: *parent.Hash()}, 50000)
+	txs := []*btcutil.Tx{parent, child}
+	if _, err := relaying.ProcessPackage(txs, false); err != nil {
+		t.Fatalf("ProcessPackage: unexpected error %v", err)
+	}
+
+	_, err := receiving.ProcessTransaction(parent, false, false, 0, false)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted parent paying no fee")
+	}
+
+	// The package goes through the wire as it is relayed to peers.
+	var buf bytes.Buffer
+	err = newMsgPackage(txs).OmcEncode(&buf, wire.PackageRelayVersion,
+		wire.SignatureEncoding)
+	if err != nil {
+		t.Fatalf("OmcEncode: unexpected error %v", err)
+	}
+	var msg wire.MsgPackage
+	err = msg.OmcDecode(&buf, wire.PackageRelayVersion,
+		wire.SignatureEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: unexpected error %v", err)
+	}
+
+	relayed := make([]*btcutil.Tx, 0, len(msg.Transactions))
+	for _, msgTx := range msg.Transactions {
+		relayed = append(relayed, btcutil.NewTx(msgTx))
+	}
+	accepted, err := receiving.ProcessPackage(relayed, false)
+	if err != nil {
+		t.Fatalf("ProcessPackage: relayed package rejected: %v", err)
+	}
+	if len(accepted) != len(txs) {
+		t.Fatalf("ProcessPackage: got %d accepted transactions, want "+
+			"%d", len(accepted), len(txs))
+	}
+	for _, tx := range txs {
+		if !receiving.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("ProcessPackage: relayed transaction %v not "+
+				"in the pool", tx.Hash())
+		}
+	}
+}
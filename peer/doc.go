@@ -36,6 +36,8 @@ A quick overview of the major features peer provides are as follows:
    and avoidance
  - Automatic periodic keep-alive pinging and pong responses
  - Random nonce generation and self connection detection
+ - Optional encrypted and authenticated v2 transport negotiated during the
+   version handshake with fallback to plaintext for peers that lack support
  - Proper handling of bloom filter related commands when the caller does not
    specify the related flag to signal support
    - Disconnects the peer when the protocol version is high enough
@@ -58,6 +60,16 @@ network to use, which services it supports, and callbacks to invoke when bitcoin
 messages are received.  See the documentation for each field of the Config
 struct for more details.
 
+Encrypted Transport
+
+Setting the V2Transport field of the Config struct advertises the
+SFNodeV2Transport service flag.  When the remote peer advertises it as well, the
+connection is upgraded right after the version messages have been exchanged.
+Both sides perform an ephemeral ECDH key exchange and from then on every byte
+is carried in AES-GCM sealed packets whose keys are rolled forward regularly.
+Peers that do not advertise the flag keep using the plaintext transport.  The
+V2Transport method reports whether a connection was upgraded.
+
 Inbound and Outbound Peers
 
 A peer can either be inbound or outbound.  The caller is responsible for
@@ -143,6 +143,12 @@ func sanitizeString(str string, maxLength uint) string {
 	return str
 }
 
+// MessageSummary returns a human-readable string which summarizes a message.
+// Not all messages have or need a summary.
+func MessageSummary(msg wire.Message) string {
+	return messageSummary(msg)
+}
+
 // messageSummary returns a human-readable string which summarizes a message.
 // Not all messages have or need a summary.  This is used for debug logging.
 func messageSummary(msg wire.Message) string {
@@ -160,6 +166,9 @@ func messageSummary(msg wire.Message) string {
 	case *wire.MsgAddr:
 		return fmt.Sprintf("%d addr", len(msg.AddrList))
 
+	case *wire.MsgAddrV2:
+		return fmt.Sprintf("%d addr", len(msg.AddrList))
+
 	case *wire.MsgPing:
 		// No summary - perhaps add nonce.
 
@@ -177,11 +186,26 @@ func messageSummary(msg wire.Message) string {
 			msg.TxHash(), len(msg.TxIn), len(msg.TxOut),
 			formatLockTime(msg.LockTime))
 
+	case *wire.MsgPackage:
+		return fmt.Sprintf("%d tx", len(msg.Transactions))
+
 	case *wire.MsgBlock:
 		header := &msg.Header
 		return fmt.Sprintf("hash %s, ver %d, %d tx, %s", msg.BlockHash(),
 			header.Version, len(msg.Transactions), header.Timestamp)
 
+	case *wire.MsgCmpctBlock:
+		return fmt.Sprintf("hash %s, %d short ids, %d prefilled",
+			msg.BlockHash(), len(msg.ShortIDs), len(msg.PrefilledTxns))
+
+	case *wire.MsgGetBlockTxn:
+		return fmt.Sprintf("hash %s, %d tx", msg.BlockHash,
+			len(msg.Indexes))
+
+	case *wire.MsgBlockTxn:
+		return fmt.Sprintf("hash %s, %d tx", msg.BlockHash,
+			len(msg.Transactions))
+
 	case *wire.MsgInv:
 		return invSummary(msg.InvList)
 
@@ -203,6 +227,12 @@ func messageSummary(msg wire.Message) string {
 	case *wire.MsgHeaders:
 		return fmt.Sprintf("num %d", len(msg.Headers))
 
+	case *wire.MsgGetMinerHeaders:
+		return locatorSummary(msg.BlockLocatorHashes, &msg.HashStop)
+
+	case *wire.MsgMinerHeaders:
+		return fmt.Sprintf("num %d", len(msg.Headers))
+
 	case *wire.MsgGetCFHeaders:
 		return fmt.Sprintf("start_height=%d, stop_hash=%v",
 			msg.StartHeight, msg.StopHash)
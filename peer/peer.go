@@ -30,7 +30,7 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.FeeFilterVersion
+	MaxProtocolVersion = wire.PackageRelayVersion
 
 	// DefaultTrickleInterval is the min time between attempts to send an
 	// inv message to a peer.
@@ -108,6 +108,9 @@ type MessageListeners struct {
 	// OnAddr is invoked when a peer receives an addr bitcoin message.
 	OnAddr func(p *Peer, msg *wire.MsgAddr)
 
+	// OnAddrV2 is invoked when a peer receives an addrv2 message.
+	OnAddrV2 func(p *Peer, msg *wire.MsgAddrV2)
+
 //	OnInvitation func(p *Peer, msg *wire.MsgInvitation)
 //	OnAckInvitation func(p *Peer, msg *wire.MsgAckInvitation)
 
@@ -149,6 +152,10 @@ type MessageListeners struct {
 	// OnHeaders is invoked when a peer receives a headers bitcoin message.
 	OnHeaders func(p *Peer, msg *wire.MsgHeaders)
 
+	// OnMinerHeaders is invoked when a peer receives a minerheaders
+	// message.
+	OnMinerHeaders func(p *Peer, msg *wire.MsgMinerHeaders)
+
 	// OnNotFound is invoked when a peer receives a notfound bitcoin
 	// message.
 	OnNotFound func(p *Peer, msg *wire.MsgNotFound)
@@ -166,6 +173,10 @@ type MessageListeners struct {
 	// message.
 	OnGetHeaders func(p *Peer, msg *wire.MsgGetHeaders)
 
+	// OnGetMinerHeaders is invoked when a peer receives a getminerheaders
+	// message.
+	OnGetMinerHeaders func(p *Peer, msg *wire.MsgGetMinerHeaders)
+
 	// OnGetCFilters is invoked when a peer receives a getcfilters bitcoin
 	// message.
 	OnGetCFilters func(p *Peer, msg *wire.MsgGetCFilters)
@@ -211,6 +222,24 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnSendCmpct is invoked when a peer receives a sendcmpct message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnSendAddrV2 is invoked when a peer receives a sendaddrv2 message.
+	OnSendAddrV2 func(p *Peer, msg *wire.MsgSendAddrV2)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
+	// OnPackage is invoked when a peer receives a package message.
+	OnPackage func(p *Peer, msg *wire.MsgPackage)
+
 	// OnRead is invoked when a peer receives a bitcoin message.  It
 	// consists of the number of bytes read, the message, and whether or not
 	// an error in the read occurred.  Typically, callers will opt to use
@@ -288,6 +317,15 @@ type Config struct {
 	// TrickleInterval is the duration of the ticker which trickles down the
 	// inventory to a peer.
 	TrickleInterval time.Duration
+
+	// V2Transport specifies whether to advertise and use the encrypted v2
+	// transport.  The connection is only upgraded when the remote peer
+	// advertises it as well, otherwise the plaintext transport is used.
+	V2Transport bool
+
+	// Recorder, when set, records every message received from and sent to
+	// the peer.  It may be shared by all peers.
+	Recorder *Recorder
 }
 
 // minUint32 is a helper function to return the minimum of two uint32s.
@@ -394,6 +432,7 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	V2Transport    bool
 }
 
 // HashFunc is a function which returns a block hash, height and error
@@ -460,8 +499,11 @@ type Peer struct {
 	advertisedProtoVer   uint32 // protocol version advertised by remote
 	protocolVersion      uint32 // negotiated protocol version
 	sendHeadersPreferred bool   // peer sent a sendheaders message
+	sendCmpctPreferred   bool   // peer sent a sendcmpct message
+	sendAddrV2Preferred  bool   // peer sent a sendaddrv2 message
 	verAckReceived       bool
 	witnessEnabled       bool
+	v2Transport          bool // connection upgraded to the v2 transport
 
 	wireEncoding wire.MessageEncoding
 
@@ -475,6 +517,8 @@ type Peer struct {
 	prevGetHdrsMtx     sync.Mutex
 	prevGetHdrsBegin   *chainhash.Hash
 	prevGetHdrsStop    *chainhash.Hash
+	prevGetMinerHdrsBegin *chainhash.Hash
+	prevGetMinerHdrsStop  *chainhash.Hash
 
 	// These fields keep track of statistics for the peer and are protected
 	// by the statsMtx mutex.
@@ -581,6 +625,7 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	userAgent := p.userAgent
 	services := p.services
 	protocolVersion := p.advertisedProtoVer
+	v2Transport := p.v2Transport
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
@@ -604,6 +649,7 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
 		LastPingTime:   p.lastPingTime,
+		V2Transport:    v2Transport,
 	}
 
 	p.statsMtx.RUnlock()
@@ -863,6 +909,30 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// WantsCmpctBlocks returns if the peer wants new blocks to be announced with
+// compact block messages.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	sendCmpctPreferred := p.sendCmpctPreferred
+	p.flagsMtx.Unlock()
+
+	return sendCmpctPreferred
+}
+
+// WantsAddrV2 returns if the peer wants addresses to be relayed with addrv2
+// messages.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsAddrV2() bool {
+	p.flagsMtx.Lock()
+	sendAddrV2Preferred := p.sendAddrV2Preferred
+	p.flagsMtx.Unlock()
+
+	return sendAddrV2Preferred
+}
+
 // IsWitnessEnabled returns true if the peer has signalled that it supports
 // segregated witness.
 //
@@ -875,6 +945,18 @@ func (p *Peer) IsWitnessEnabled() bool {
 	return witnessEnabled
 }
 
+// V2Transport returns true if the connection to the peer has been upgraded to
+// the encrypted v2 transport.
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2Transport() bool {
+	p.flagsMtx.Lock()
+	v2Transport := p.v2Transport
+	p.flagsMtx.Unlock()
+
+	return v2Transport
+}
+
 // PushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.  This function is useful over manually sending the message via
 // QueueMessage since it automatically limits the addresses to the maximum
@@ -882,9 +964,22 @@ func (p *Peer) IsWitnessEnabled() bool {
 // are too many.  It returns the addresses that were actually sent and no
 // message will be sent if there are no entries in the provided addresses slice.
 //
+// An addrv2 message is sent instead to peers which asked for it, otherwise
+// addresses which can't be carried by an addr message are left out.
+//
 // This function is safe for concurrent access.
 func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, error) {
-	addressCount := len(addresses)
+	if p.WantsAddrV2() {
+		return p.pushAddrV2Msg(addresses)
+	}
+
+	addrList := make([]*wire.NetAddress, 0, len(addresses))
+	for _, na := range addresses {
+		if na.IsAddrV1() {
+			addrList = append(addrList, na)
+		}
+	}
+	addressCount := len(addrList)
 
 	// Nothing to send.
 	if addressCount == 0 {
@@ -892,8 +987,7 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	}
 
 	msg := wire.NewMsgAddr()
-	msg.AddrList = make([]*wire.NetAddress, addressCount)
-	copy(msg.AddrList, addresses)
+	msg.AddrList = addrList
 
 	// Randomize the addresses sent if there are more than the maximum allowed.
 	if addressCount > wire.MaxAddrPerMsg {
@@ -911,6 +1005,33 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	return msg.AddrList, nil
 }
 
+// pushAddrV2Msg sends an addrv2 message to the connected peer using the
+// provided addresses.  See PushAddrMsg.
+func (p *Peer) pushAddrV2Msg(addresses []*wire.NetAddress) ([]*wire.NetAddress, error) {
+	addressCount := len(addresses)
+
+	// Nothing to send.
+	if addressCount == 0 {
+		return nil, nil
+	}
+
+	msg := wire.NewMsgAddrV2()
+	msg.AddrList = make([]*wire.NetAddress, addressCount)
+	copy(msg.AddrList, addresses)
+
+	// Randomize the addresses sent if there are more than the maximum allowed.
+	if addressCount > wire.MaxAddrPerMsg {
+		for i := 0; i < wire.MaxAddrPerMsg; i++ {
+			j := i + rand.Intn(addressCount-i)
+			msg.AddrList[i], msg.AddrList[j] = msg.AddrList[j], msg.AddrList[i]
+		}
+		msg.AddrList = msg.AddrList[:wire.MaxAddrPerMsg]
+	}
+
+	p.QueueMessage(msg, nil)
+	return msg.AddrList, nil
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 //
@@ -1015,6 +1136,53 @@ func (p *Peer) PushGetHeadersMsg(locator chainhash.BlockLocator, stopHash *chain
 	return nil
 }
 
+// PushGetMinerHeadersMsg sends a getminerheaders message for the provided
+// miner chain block locator and stop hash.  It will ignore back-to-back
+// duplicate requests.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PushGetMinerHeadersMsg(locator chainhash.BlockLocator, stopHash *chainhash.Hash) error {
+	// Extract the begin hash from the block locator, if one was specified,
+	// to use for filtering duplicate getminerheaders requests.
+	var beginHash *chainhash.Hash
+	if len(locator) > 0 {
+		beginHash = locator[0]
+	}
+
+	// Filter duplicate getminerheaders requests.
+	p.prevGetHdrsMtx.Lock()
+	isDuplicate := p.prevGetMinerHdrsStop != nil &&
+		p.prevGetMinerHdrsBegin != nil && beginHash != nil &&
+		stopHash.IsEqual(p.prevGetMinerHdrsStop) &&
+		beginHash.IsEqual(p.prevGetMinerHdrsBegin)
+	p.prevGetHdrsMtx.Unlock()
+
+	if isDuplicate {
+		log.Tracef("Filtering duplicate [getminerheaders] with begin "+
+			"hash %v", beginHash)
+		return nil
+	}
+
+	// Construct the getminerheaders request and queue it to be sent.
+	msg := wire.NewMsgGetMinerHeaders()
+	msg.HashStop = *stopHash
+	for _, hash := range locator {
+		err := msg.AddBlockLocatorHash(hash)
+		if err != nil {
+			return err
+		}
+	}
+	p.QueueMessage(msg, nil)
+
+	// Update the previous getminerheaders request information for
+	// filtering duplicates.
+	p.prevGetHdrsMtx.Lock()
+	p.prevGetMinerHdrsBegin = beginHash
+	p.prevGetMinerHdrsStop = stopHash
+	p.prevGetHdrsMtx.Unlock()
+	return nil
+}
+
 // PushRejectMsg sends a reject message for the provided command, reject code,
 // reject reason, and hash.  The hash will only be used when the command is a tx
 // or block and should be nil in other cases.  The wait parameter will cause the
@@ -1083,11 +1251,34 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 	}
 }
 
+// blockSigEncoding adds wire.AggregateSignatureEncoding to the passed
+// encoding when the negotiated protocol version supports versioned block
+// signatures.
+func (p *Peer) blockSigEncoding(enc wire.MessageEncoding) wire.MessageEncoding {
+	if p.ProtocolVersion() >= wire.AggregateSigVersion {
+		return enc | wire.AggregateSignatureEncoding
+	}
+	return enc
+}
+
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageWithEncodingN(p.conn,
+	encoding = p.blockSigEncoding(encoding)
+
+	// The message is recorded as read from the wire, before it is decoded,
+	// so messages which fail to decode are recorded too.
+	var r io.Reader = p.conn
+	var raw bytes.Buffer
+	if p.cfg.Recorder != nil {
+		r = io.TeeReader(p.conn, &raw)
+	}
+	n, msg, buf, err := wire.ReadMessageWithEncodingN(r,
 		p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
+	if p.cfg.Recorder != nil && raw.Len() > 0 {
+		p.cfg.Recorder.record(true, p.ID(), p.ProtocolVersion(),
+			p.cfg.ChainParams.Net, encoding, raw.Bytes())
+	}
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
 	}
@@ -1122,6 +1313,7 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 	if atomic.LoadInt32(&p.disconnect) != 0 {
 		return nil
 	}
+	enc = p.blockSigEncoding(enc)
 
 	// Use closures to log expensive operations so they are only run when
 	// the logging level requires it.
@@ -1147,9 +1339,28 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 		return spew.Sdump(buf.Bytes())
 	}))
 
-	// Write the message to the peer.
-	n, err := wire.WriteMessageWithEncodingN(p.conn, msg,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	// Write the message to the peer.  The v2 transport encrypts each write
+	// into its own packet, so the message is assembled first in order to
+	// send it as a single packet.  The same is needed to record it, which
+	// is only done once it has been written successfully.
+	var n int
+	var err error
+	if _, ok := p.conn.(*v2Conn); ok || p.cfg.Recorder != nil {
+		var buf bytes.Buffer
+		_, err = wire.WriteMessageWithEncodingN(&buf, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+		if err == nil {
+			n, err = p.conn.Write(buf.Bytes())
+		}
+		if err == nil && p.cfg.Recorder != nil {
+			p.cfg.Recorder.record(false, p.ID(),
+				p.ProtocolVersion(), p.cfg.ChainParams.Net,
+				enc, buf.Bytes())
+		}
+	} else {
+		n, err = wire.WriteMessageWithEncodingN(p.conn, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -1252,6 +1463,16 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		// headers.
 		deadline = time.Now().Add(stallResponseTimeout * 3)
 		pendingResponses[wire.CmdHeaders] = deadline
+
+	case wire.CmdGetBlockTxn:
+		// Expects a blocktxn message.
+		pendingResponses[wire.CmdBlockTxn] = deadline
+
+	case wire.CmdGetMinerHeaders:
+		// Expects a minerheaders message.  Use a longer deadline for the
+		// same reason as getheaders.
+		deadline = time.Now().Add(stallResponseTimeout * 3)
+		pendingResponses[wire.CmdMinerHeaders] = deadline
 	}
 }
 
@@ -1533,6 +1754,11 @@ out:
 			if p.cfg.Listeners.OnAddr != nil {
 				p.cfg.Listeners.OnAddr(p, msg)
 			}
+
+		case *wire.MsgAddrV2:
+			if p.cfg.Listeners.OnAddrV2 != nil {
+				p.cfg.Listeners.OnAddrV2(p, msg)
+			}
 /*
 		case *wire.MsgInvitation:
 			if p.cfg.Listeners.OnInvitation != nil {
@@ -1607,6 +1833,11 @@ out:
 				p.cfg.Listeners.OnHeaders(p, msg)
 			}
 
+		case *wire.MsgMinerHeaders:
+			if p.cfg.Listeners.OnMinerHeaders != nil {
+				p.cfg.Listeners.OnMinerHeaders(p, msg)
+			}
+
 		case *wire.MsgNotFound:
 //			log.Infof("inHandler MsgNotFound")
 			if p.cfg.Listeners.OnNotFound != nil {
@@ -1637,6 +1868,11 @@ out:
 				p.cfg.Listeners.OnGetHeaders(p, msg)
 			}
 
+		case *wire.MsgGetMinerHeaders:
+			if p.cfg.Listeners.OnGetMinerHeaders != nil {
+				p.cfg.Listeners.OnGetMinerHeaders(p, msg)
+			}
+
 		case *wire.MsgGetCFilters:
 //			log.Infof("inHandler MsgGetCFilters")
 			if p.cfg.Listeners.OnGetCFilters != nil {
@@ -1713,6 +1949,50 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			p.flagsMtx.Lock()
+			p.sendCmpctPreferred = msg.Announce
+			p.flagsMtx.Unlock()
+
+			if p.cfg.Listeners.OnSendCmpct != nil {
+				p.cfg.Listeners.OnSendCmpct(p, msg)
+			}
+
+		case *wire.MsgSendAddrV2:
+			// The request is only valid before the verack.
+			if p.VerAckReceived() {
+				log.Debugf("Ignoring late sendaddrv2 from peer %v", p)
+				break
+			}
+			p.flagsMtx.Lock()
+			p.sendAddrV2Preferred = true
+			p.flagsMtx.Unlock()
+
+			if p.cfg.Listeners.OnSendAddrV2 != nil {
+				p.cfg.Listeners.OnSendAddrV2(p, msg)
+			}
+
+		case *wire.MsgCmpctBlock:
+			log.Tracef("inHandler MsgCmpctBlock")
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
+		case *wire.MsgPackage:
+			if p.cfg.Listeners.OnPackage != nil {
+				p.cfg.Listeners.OnPackage(p, msg)
+			}
+
 		case consensus.Message:
 			if consensus.VerifySig(msg) {
 				var ea [20]byte
@@ -2304,6 +2584,9 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 
 	// Advertise local services.
 	msg.Services = p.cfg.Services
+	if p.cfg.V2Transport {
+		msg.Services |= common.SFNodeV2Transport
+	}
 
 	// Advertise our max supported protocol version.
 	msg.ProtocolVersion = int32(p.cfg.ProtocolVersion)
@@ -2332,7 +2615,11 @@ func (p *Peer) negotiateInboundProtocol() error {
 		return err
 	}
 
-	return p.writeLocalVersionMsg()
+	if err := p.writeLocalVersionMsg(); err != nil {
+		return err
+	}
+
+	return p.maybeUpgradeTransport()
 }
 
 // negotiateOutboundProtocol sends our version message then waits to receive a
@@ -2343,7 +2630,36 @@ func (p *Peer) negotiateOutboundProtocol() error {
 		return err
 	}
 
-	return p.readRemoteVersionMsg()
+	if err := p.readRemoteVersionMsg(); err != nil {
+		return err
+	}
+
+	return p.maybeUpgradeTransport()
+}
+
+// maybeUpgradeTransport upgrades the connection to the encrypted v2 transport
+// when both sides advertised support for it in their version messages.  Peers
+// that do not support it keep using the plaintext transport.  It must only be
+// called once the version messages have been exchanged and before any other
+// messages are read or written.
+func (p *Peer) maybeUpgradeTransport() error {
+	if !p.cfg.V2Transport || p.Services()&common.SFNodeV2Transport == 0 {
+		return nil
+	}
+
+	conn, err := v2Handshake(p.conn, !p.inbound, p.cfg.ChainParams.Net)
+	if err != nil {
+		return fmt.Errorf("v2 transport handshake failed: %v", err)
+	}
+	p.conn = conn
+
+	p.flagsMtx.Lock()
+	p.v2Transport = true
+	p.flagsMtx.Unlock()
+
+	log.Debugf("Upgraded connection to %s to v2 transport (session %x)",
+		p, conn.sessionID[:8])
+	return nil
 }
 
 // start begins processing input and output messages.
@@ -2380,6 +2696,12 @@ func (p *Peer) start() error {
 	go p.outHandler()
 	go p.pingHandler()
 
+	// Ask for addrv2 messages before the verack, as the peer only accepts
+	// the request during the handshake.
+	if p.ProtocolVersion() >= wire.AddrV2Version {
+		p.QueueMessage(wire.NewMsgSendAddrV2(), nil)
+	}
+
 	// Send our verack message now that the IO processing machinery has started.
 	p.QueueMessage(wire.NewMsgVerAck(), nil)
 	return nil
@@ -0,0 +1,316 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+const (
+	// recordFilePrefix and recordFileSuffix make up the names of the
+	// files written by a Recorder together with their sequence number.
+	recordFilePrefix = "wire-"
+	recordFileSuffix = ".log"
+
+	// recordFileVersion is the version of the record log format.
+	recordFileVersion = 1
+
+	// recordHeaderSize is the size of the fixed part of a record:
+	// timestamp 8 bytes + direction 1 byte + peer id 4 bytes + protocol
+	// version 4 bytes + network 4 bytes + encoding 4 bytes + message
+	// length 4 bytes.
+	recordHeaderSize = 29
+
+	// maxRecordLen is the maximum length of a recorded message.
+	maxRecordLen = wire.MessageHeaderSize + common.MaxMessagePayload
+)
+
+// recordFileMagic starts every file written by a Recorder.
+var recordFileMagic = [4]byte{'o', 'w', 'r', 'l'}
+
+// ErrBadRecordFile is returned when reading a file which is not a record log
+// or of an unsupported version.
+var ErrBadRecordFile = errors.New("not a wire record log")
+
+// Record is a message recorded by a Recorder.
+type Record struct {
+	// Time is when the message was received or sent.
+	Time time.Time
+
+	// Inbound is true for messages received from the peer and false for
+	// messages sent to it.
+	Inbound bool
+
+	// PeerID is the ID of the peer as returned by Peer.ID.
+	PeerID int32
+
+	// ProtocolVersion, Net and Encoding are the parameters the message was
+	// encoded with.
+	ProtocolVersion uint32
+	Net             common.OmegaNet
+	Encoding        wire.MessageEncoding
+
+	// Raw is the message as it went over the wire, including the message
+	// header.
+	Raw []byte
+}
+
+// Message decodes the recorded message.
+func (r *Record) Message() (wire.Message, error) {
+	_, msg, _, err := wire.ReadMessageWithEncodingN(bytes.NewReader(r.Raw),
+		r.ProtocolVersion, r.Net, r.Encoding)
+	return msg, err
+}
+
+// Recorder writes every message received from or sent to the peers using it
+// to a binary log in a directory.  The log is split into files of a maximum
+// size, and the oldest files are removed to keep a maximum number of them.
+// It is safe for concurrent access, so a single Recorder may be shared by all
+// peers.
+type Recorder struct {
+	mtx      sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	seq      uint32
+	file     *os.File
+	size     int64
+	err      error
+}
+
+// NewRecorder returns a Recorder writing to the passed directory, which is
+// created if needed.  A new file is started when the current one reaches
+// maxSize bytes, and only the newest maxFiles files are kept.  A maxFiles of
+// zero keeps all files.  Existing files are never appended to.
+func NewRecorder(dir string, maxSize int64, maxFiles int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := RecordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if len(files) > 0 {
+		r.seq, _ = recordFileSeq(files[len(files)-1])
+	}
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// recordFileSeq returns the sequence number of a record file name.
+func recordFileSeq(name string) (uint32, bool) {
+	name = filepath.Base(name)
+	if !strings.HasPrefix(name, recordFilePrefix) ||
+		!strings.HasSuffix(name, recordFileSuffix) {
+		return 0, false
+	}
+	var seq uint32
+	s := name[len(recordFilePrefix) : len(name)-len(recordFileSuffix)]
+	if _, err := fmt.Sscanf(s, "%d", &seq); err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// RecordFiles returns the record files in the passed directory, oldest first.
+func RecordFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type seqFile struct {
+		seq  uint32
+		name string
+	}
+	var found []seqFile
+	for _, e := range entries {
+		if seq, ok := recordFileSeq(e.Name()); ok && !e.IsDir() {
+			found = append(found, seqFile{seq, e.Name()})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seq < found[j].seq
+	})
+
+	files := make([]string, 0, len(found))
+	for _, f := range found {
+		files = append(files, filepath.Join(dir, f.name))
+	}
+	return files, nil
+}
+
+// rotate closes the current file, starts the next one and removes the files
+// exceeding maxFiles.
+//
+// This function MUST be called with the recorder lock held (for writes).
+func (r *Recorder) rotate() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	r.seq++
+	name := filepath.Join(r.dir, fmt.Sprintf("%s%08d%s", recordFilePrefix,
+		r.seq, recordFileSuffix))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var hdr [5]byte
+	copy(hdr[:], recordFileMagic[:])
+	hdr[4] = recordFileVersion
+	if _, err := f.Write(hdr[:]); err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = int64(len(hdr))
+
+	if r.maxFiles <= 0 {
+		return nil
+	}
+	files, err := RecordFiles(r.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > r.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// record writes a message to the log.  raw is the message as it went over the
+// wire, including the message header.  Errors are logged once and disable the
+// recorder, since a broken log must not disrupt the peers.
+func (r *Recorder) record(inbound bool, peerID int32, pver uint32,
+	btcnet common.OmegaNet, enc wire.MessageEncoding, raw []byte) {
+
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(raw))
+	binary.LittleEndian.PutUint64(buf[0:], uint64(time.Now().UnixNano()))
+	if inbound {
+		buf[8] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[9:], uint32(peerID))
+	binary.LittleEndian.PutUint32(buf[13:], pver)
+	binary.LittleEndian.PutUint32(buf[17:], uint32(btcnet))
+	binary.LittleEndian.PutUint32(buf[21:], uint32(enc))
+	binary.LittleEndian.PutUint32(buf[25:], uint32(len(raw)))
+	buf = append(buf, raw...)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	if r.maxSize > 0 && r.size > int64(len(recordFileMagic)+1) &&
+		r.size+int64(len(buf)) > r.maxSize {
+		r.err = r.rotate()
+	}
+	if r.err == nil {
+		_, r.err = r.file.Write(buf)
+		r.size += int64(len(buf))
+	}
+	if r.err != nil {
+		log.Errorf("Unable to record message, recording stopped: %v",
+			r.err)
+	}
+}
+
+// Close closes the current file of the recorder.  Nothing is recorded after
+// it has been closed.
+func (r *Recorder) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err == nil {
+		r.err = errors.New("recorder closed")
+	}
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// RecordReader reads the records of a file written by a Recorder.
+type RecordReader struct {
+	r io.Reader
+}
+
+// NewRecordReader returns a RecordReader reading from r.  It returns
+// ErrBadRecordFile when r does not start with a record log header.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadRecordFile
+		}
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:4], recordFileMagic[:]) ||
+		hdr[4] != recordFileVersion {
+		return nil, ErrBadRecordFile
+	}
+	return &RecordReader{r: r}, nil
+}
+
+// Next returns the next record.  It returns io.EOF at the end of the file, and
+// io.ErrUnexpectedEOF when the last record is truncated, which happens when
+// the file was being written to.
+func (rr *RecordReader) Next() (*Record, error) {
+	var hdr [recordHeaderSize]byte
+	if _, err := io.ReadFull(rr.r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(hdr[25:])
+	if length > maxRecordLen {
+		return nil, fmt.Errorf("record length %d exceeds max %d",
+			length, maxRecordLen)
+	}
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(rr.r, raw); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return &Record{
+		Time:            time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[0:]))),
+		Inbound:         hdr[8] == 1,
+		PeerID:          int32(binary.LittleEndian.Uint32(hdr[9:])),
+		ProtocolVersion: binary.LittleEndian.Uint32(hdr[13:]),
+		Net:             common.OmegaNet(binary.LittleEndian.Uint32(hdr[17:])),
+		Encoding:        wire.MessageEncoding(binary.LittleEndian.Uint32(hdr[21:])),
+		Raw:             raw,
+	}, nil
+}
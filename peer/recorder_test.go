@@ -0,0 +1,125 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg"
+	"github.com/zeusyf/btcd/wire"
+)
+
+// TestRecorder ensures recorded messages are read back as recorded and that
+// the log is rotated.
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(dir, 200, 2)
+	if err != nil {
+		t.Fatalf("NewRecorder: unexpected error %v", err)
+	}
+
+	p := NewInboundPeer(&Config{ChainParams: &chaincfg.SimNetParams})
+	pver := p.ProtocolVersion()
+	net := chaincfg.SimNetParams.Net
+
+	// Record a sent ping and a received pong for each nonce.
+	for nonce := uint64(1); nonce <= 4; nonce++ {
+		var buf bytes.Buffer
+		_, err := wire.WriteMessageWithEncodingN(&buf,
+			wire.NewMsgPing(nonce, 1), pver, net, wire.BaseEncoding)
+		if err != nil {
+			t.Fatalf("WriteMessageWithEncodingN: unexpected error %v", err)
+		}
+		r.record(false, 7, pver, net, wire.BaseEncoding, buf.Bytes())
+
+		buf.Reset()
+		_, err = wire.WriteMessageWithEncodingN(&buf,
+			wire.NewMsgPong(nonce), pver, net, wire.BaseEncoding)
+		if err != nil {
+			t.Fatalf("WriteMessageWithEncodingN: unexpected error %v", err)
+		}
+		r.record(true, 8, pver, net, wire.BaseEncoding, buf.Bytes())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: unexpected error %v", err)
+	}
+
+	files, err := RecordFiles(dir)
+	if err != nil {
+		t.Fatalf("RecordFiles: unexpected error %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("RecordFiles: got %d files, want 2", len(files))
+	}
+
+	// Only the newest files are left, so the messages read back must be
+	// the tail of the recorded ones.
+	var msgs []wire.Message
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Open: unexpected error %v", err)
+		}
+		rr, err := NewRecordReader(f)
+		if err != nil {
+			t.Fatalf("NewRecordReader: unexpected error %v", err)
+		}
+		for {
+			rec, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next: unexpected error %v", err)
+			}
+			msg, err := rec.Message()
+			if err != nil {
+				t.Fatalf("Message: unexpected error %v", err)
+			}
+			wantInbound := msg.Command() == wire.CmdPong
+			if rec.Inbound != wantInbound {
+				t.Errorf("Next: got inbound %v for %s", rec.Inbound,
+					msg.Command())
+			}
+			wantID := int32(7)
+			if rec.Inbound {
+				wantID = 8
+			}
+			if rec.PeerID != wantID {
+				t.Errorf("Next: got peer id %d, want %d",
+					rec.PeerID, wantID)
+			}
+			msgs = append(msgs, msg)
+		}
+		f.Close()
+	}
+
+	want := []wire.Message{wire.NewMsgPing(4, 1), wire.NewMsgPong(4)}
+	if len(msgs) < len(want) {
+		t.Fatalf("got %d messages, want at least %d", len(msgs), len(want))
+	}
+	if got := msgs[len(msgs)-2:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %v, want %v", got, want)
+	}
+
+	// A new recorder continues after the existing files.
+	r, err = NewRecorder(dir, 200, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: unexpected error %v", err)
+	}
+	r.Close()
+	newFiles, _ := RecordFiles(dir)
+	if len(newFiles) != 3 || newFiles[1] != files[1] {
+		t.Errorf("RecordFiles: got %v after reopening", newFiles)
+	}
+
+	if _, err := NewRecordReader(bytes.NewReader([]byte("junk!"))); err != ErrBadRecordFile {
+		t.Errorf("NewRecordReader: got %v, want ErrBadRecordFile", err)
+	}
+}
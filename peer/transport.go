@@ -0,0 +1,340 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/zeusyf/btcd/btcec"
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// The v2 transport replaces the plaintext byte stream between two peers with
+// a stream of authenticated and encrypted packets once both sides have
+// advertised common.SFNodeV2Transport in their version messages.
+//
+// The upgrade happens right after the version messages have been exchanged:
+//
+//  1. The outbound side sends a 33-byte compressed ephemeral secp256k1 public
+//     key, the inbound side answers with its own.
+//  2. Both sides compute the ECDH shared secret and derive, with HKDF-SHA256,
+//     one key per direction plus a session id.  The ephemeral public keys and
+//     the network magic are bound into the derivation.
+//  3. Each side sends an encrypted packet carrying the session id, which lets
+//     the other side confirm that both derived the same keys.
+//
+// Every packet on the wire is a 4-byte little-endian ciphertext length
+// followed by the AES-256-GCM sealed payload.  The length is authenticated as
+// additional data.  The key of each direction is rolled forward after
+// v2RekeyInterval packets so a compromised key can only decrypt a bounded
+// amount of traffic.
+//
+// The key exchange is not authenticated, so it protects against passive
+// observers but not against an active man-in-the-middle.  Committee members
+// still authenticate each other through the signatures on consensus messages.
+const (
+	// v2PubKeyLen is the length of the ephemeral public key sent by each
+	// side during the v2 handshake.
+	v2PubKeyLen = btcec.PubKeyBytesLenCompressed
+
+	// v2KeyLen is the length of the symmetric key for each direction.
+	v2KeyLen = 32
+
+	// v2SessionIDLen is the length of the session id derived during the
+	// handshake.
+	v2SessionIDLen = 32
+
+	// v2LengthLen is the length of the packet length prefix.
+	v2LengthLen = 4
+
+	// v2RekeyInterval is the number of packets sent with a key before it
+	// is rolled forward.
+	v2RekeyInterval = 224
+
+	// v2MaxPacketSize is the maximum size of the ciphertext of a single
+	// packet.  A packet never carries more than one complete message.
+	v2MaxPacketSize = wire.MaxMessagePayload + wire.MessageHeaderSize + 16
+)
+
+var (
+	// v2Salt is the HKDF salt used for the v2 key derivation.  The network
+	// magic is appended to it so keys never match across networks.
+	v2Salt = []byte("omega_v2_transport")
+
+	// v2RekeyInfo is the HKDF info used when rolling a key forward.
+	v2RekeyInfo = []byte("omega_v2_rekey")
+
+	// errV2SessionMismatch is returned when the remote peer did not derive
+	// the same session keys during the v2 handshake.
+	errV2SessionMismatch = errors.New("v2 transport session id mismatch")
+)
+
+// hkdfExtract implements the extract step of HKDF (RFC 5869) with SHA256.
+func hkdfExtract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand implements the expand step of HKDF (RFC 5869) with SHA256.
+func hkdfExpand(prk, info []byte, length int) []byte {
+	var out, prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// cipherState houses the AEAD state for a single direction of a v2 session.
+type cipherState struct {
+	key   [v2KeyLen]byte
+	aead  cipher.AEAD
+	seq   uint64
+	nonce [12]byte
+}
+
+// newCipherState returns a cipher state for the passed key.
+func newCipherState(key []byte) (*cipherState, error) {
+	c := &cipherState{}
+	copy(c.key[:], key)
+	if err := c.setKey(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setKey initializes the AEAD from the current key.
+func (c *cipherState) setKey() error {
+	block, err := aes.NewCipher(c.key[:])
+	if err != nil {
+		return err
+	}
+	c.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// advance bumps the packet sequence number and rolls the key forward once
+// v2RekeyInterval packets have been processed with it.
+func (c *cipherState) advance() error {
+	c.seq++
+	if c.seq%v2RekeyInterval != 0 {
+		return nil
+	}
+	copy(c.key[:], hkdfExpand(c.key[:], v2RekeyInfo, v2KeyLen))
+	return c.setKey()
+}
+
+// nextNonce returns the nonce for the current packet sequence number.
+func (c *cipherState) nextNonce() []byte {
+	binary.LittleEndian.PutUint64(c.nonce[4:], c.seq)
+	return c.nonce[:]
+}
+
+// seal encrypts and authenticates plaintext, appending the result to dst.
+func (c *cipherState) seal(dst, plaintext, ad []byte) ([]byte, error) {
+	out := c.aead.Seal(dst, c.nextNonce(), plaintext, ad)
+	return out, c.advance()
+}
+
+// open authenticates and decrypts ciphertext.
+func (c *cipherState) open(ciphertext, ad []byte) ([]byte, error) {
+	out, err := c.aead.Open(ciphertext[:0], c.nextNonce(), ciphertext, ad)
+	if err != nil {
+		return nil, err
+	}
+	return out, c.advance()
+}
+
+// v2Conn wraps a net.Conn and transparently encrypts everything written to
+// it and decrypts everything read from it using the v2 transport.  Since it
+// is a net.Conn itself, the message reading and writing code of the peer does
+// not need to know whether the transport is encrypted.
+type v2Conn struct {
+	net.Conn
+
+	readMtx sync.Mutex
+	recv    *cipherState
+	pending []byte
+
+	writeMtx sync.Mutex
+	send     *cipherState
+
+	sessionID [v2SessionIDLen]byte
+}
+
+// Read reads decrypted data from the connection.  This is part of the
+// net.Conn interface implementation.
+func (c *v2Conn) Read(b []byte) (int, error) {
+	c.readMtx.Lock()
+	defer c.readMtx.Unlock()
+
+	for len(c.pending) == 0 {
+		payload, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = payload
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readPacket reads and decrypts the next packet from the underlying
+// connection.  The caller must hold readMtx.
+func (c *v2Conn) readPacket() ([]byte, error) {
+	var lenBytes [v2LengthLen]byte
+	if _, err := io.ReadFull(c.Conn, lenBytes[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(lenBytes[:])
+	if length > v2MaxPacketSize {
+		return nil, fmt.Errorf("v2 transport packet too large - "+
+			"%d bytes, but maximum is %d bytes", length,
+			v2MaxPacketSize)
+	}
+
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, ciphertext); err != nil {
+		return nil, err
+	}
+	return c.recv.open(ciphertext, lenBytes[:])
+}
+
+// Write encrypts b into a single packet and writes it to the connection.
+// This is part of the net.Conn interface implementation.
+func (c *v2Conn) Write(b []byte) (int, error) {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	if err := c.writePacket(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writePacket encrypts payload and writes it to the underlying connection.
+// The caller must hold writeMtx.
+func (c *v2Conn) writePacket(payload []byte) error {
+	length := uint32(len(payload) + c.send.aead.Overhead())
+	if length > v2MaxPacketSize {
+		return fmt.Errorf("v2 transport packet too large - "+
+			"%d bytes, but maximum is %d bytes", length,
+			v2MaxPacketSize)
+	}
+
+	buf := make([]byte, v2LengthLen, v2LengthLen+int(length))
+	binary.LittleEndian.PutUint32(buf, length)
+	buf, err := c.send.seal(buf, payload, buf[:v2LengthLen])
+	if err != nil {
+		return err
+	}
+	_, err = c.Conn.Write(buf)
+	return err
+}
+
+// v2Handshake performs the v2 key exchange over conn and returns the
+// encrypted connection.  The initiator is the side which opened the
+// connection.  The passed network is bound into the derived keys.
+func v2Handshake(conn net.Conn, initiator bool, btcnet common.OmegaNet) (*v2Conn, error) {
+	ephemeral, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	ourPub := ephemeral.PubKey().SerializeCompressed()
+
+	// The initiator speaks first so the exchange works over synchronous
+	// connections as well.
+	var theirPub [v2PubKeyLen]byte
+	if initiator {
+		if _, err := conn.Write(ourPub); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.ReadFull(conn, theirPub[:]); err != nil {
+		return nil, err
+	}
+	if !initiator {
+		if _, err := conn.Write(ourPub); err != nil {
+			return nil, err
+		}
+	}
+
+	remote, err := btcec.ParsePubKey(theirPub[:], btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("invalid v2 transport public key: %v", err)
+	}
+	if bytes.Equal(ourPub, theirPub[:]) {
+		return nil, errors.New("v2 transport public key reflected")
+	}
+
+	// Derive the keys.  The info commits to both ephemeral keys in
+	// initiator, responder order so both sides agree on it.
+	info := make([]byte, 0, 2*v2PubKeyLen)
+	if initiator {
+		info = append(append(info, ourPub...), theirPub[:]...)
+	} else {
+		info = append(append(info, theirPub[:]...), ourPub...)
+	}
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(btcnet))
+	salt := append(append([]byte{}, v2Salt...), magic[:]...)
+	prk := hkdfExtract(salt, btcec.GenerateSharedSecret(ephemeral, remote))
+	okm := hkdfExpand(prk, info, 2*v2KeyLen+v2SessionIDLen)
+
+	initKey, respKey := okm[:v2KeyLen], okm[v2KeyLen:2*v2KeyLen]
+	if !initiator {
+		initKey, respKey = respKey, initKey
+	}
+	send, err := newCipherState(initKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newCipherState(respKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &v2Conn{Conn: conn, send: send, recv: recv}
+	copy(c.sessionID[:], okm[2*v2KeyLen:])
+
+	// Confirm both sides derived the same keys by exchanging the session
+	// id, again with the initiator first.
+	if initiator {
+		if err := c.writePacket(c.sessionID[:]); err != nil {
+			return nil, err
+		}
+	}
+	theirID, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(theirID, c.sessionID[:]) {
+		return nil, errV2SessionMismatch
+	}
+	if !initiator {
+		if err := c.writePacket(c.sessionID[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/zeusyf/btcd/wire"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// v2Pair runs the v2 handshake over an in-memory connection and returns the
+// initiator and responder ends.
+func v2Pair(initNet, respNet common.OmegaNet) (*v2Conn, *v2Conn, error) {
+	inConn, outConn := net.Pipe()
+
+	type result struct {
+		conn *v2Conn
+		err  error
+	}
+	respChan := make(chan result, 1)
+	go func() {
+		c, err := v2Handshake(inConn, false, respNet)
+		if err != nil {
+			inConn.Close()
+		}
+		respChan <- result{c, err}
+	}()
+
+	initConn, err := v2Handshake(outConn, true, initNet)
+	if err != nil {
+		outConn.Close()
+	}
+	resp := <-respChan
+	if err != nil {
+		return nil, nil, err
+	}
+	return initConn, resp.conn, resp.err
+}
+
+// TestV2Handshake ensures both sides of the v2 handshake derive the same
+// session and that messages written on one end are read on the other.
+func TestV2Handshake(t *testing.T) {
+	initConn, respConn, err := v2Pair(common.MainNet, common.MainNet)
+	if err != nil {
+		t.Fatalf("v2Handshake: unexpected error %v", err)
+	}
+	defer initConn.Close()
+	defer respConn.Close()
+
+	if initConn.sessionID != respConn.sessionID {
+		t.Fatalf("session id mismatch - got %x, want %x",
+			respConn.sessionID, initConn.sessionID)
+	}
+
+	pver := MaxProtocolVersion
+	for i, c := range []struct {
+		from *v2Conn
+		to   *v2Conn
+	}{
+		{initConn, respConn},
+		{respConn, initConn},
+	} {
+		msg := wire.NewMsgPing(uint64(i+1), int32(i))
+		var buf bytes.Buffer
+		_, err := wire.WriteMessageWithEncodingN(&buf, msg, pver,
+			common.MainNet, wire.BaseEncoding)
+		if err != nil {
+			t.Fatalf("#%d WriteMessage: unexpected error %v", i, err)
+		}
+
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := c.from.Write(buf.Bytes())
+			errChan <- err
+		}()
+
+		got, _, err := wire.ReadMessage(c.to, pver, common.MainNet)
+		if err != nil {
+			t.Fatalf("#%d ReadMessage: unexpected error %v", i, err)
+		}
+		if err := <-errChan; err != nil {
+			t.Fatalf("#%d Write: unexpected error %v", i, err)
+		}
+		ping, ok := got.(*wire.MsgPing)
+		if !ok || ping.Nonce != msg.Nonce {
+			t.Fatalf("#%d got %v, want %v", i, got, msg)
+		}
+	}
+}
+
+// TestV2Rekey ensures both sides keep decrypting correctly across several
+// key rotations.
+func TestV2Rekey(t *testing.T) {
+	initConn, respConn, err := v2Pair(common.MainNet, common.MainNet)
+	if err != nil {
+		t.Fatalf("v2Handshake: unexpected error %v", err)
+	}
+	defer initConn.Close()
+	defer respConn.Close()
+
+	const numPackets = v2RekeyInterval*3 + 5
+	errChan := make(chan error, 1)
+	go func() {
+		for i := 0; i < numPackets; i++ {
+			if _, err := initConn.Write([]byte{byte(i), byte(i >> 8)}); err != nil {
+				errChan <- err
+				return
+			}
+		}
+		errChan <- nil
+	}()
+
+	var got [2]byte
+	for i := 0; i < numPackets; i++ {
+		if _, err := io.ReadFull(respConn, got[:]); err != nil {
+			t.Fatalf("packet %d: unexpected read error %v", i, err)
+		}
+		if got != [2]byte{byte(i), byte(i >> 8)} {
+			t.Fatalf("packet %d: got %x", i, got)
+		}
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("unexpected write error %v", err)
+	}
+	if initConn.send.key != respConn.recv.key {
+		t.Fatalf("keys diverged after %d packets", numPackets)
+	}
+}
+
+// TestV2Tamper ensures a modified packet is rejected.
+func TestV2Tamper(t *testing.T) {
+	initConn, respConn, err := v2Pair(common.MainNet, common.MainNet)
+	if err != nil {
+		t.Fatalf("v2Handshake: unexpected error %v", err)
+	}
+	defer initConn.Close()
+	defer respConn.Close()
+
+	// Seal a packet by hand and flip a bit of the ciphertext before
+	// handing it to the raw connection.
+	payload := []byte("committee traffic")
+	buf := make([]byte, v2LengthLen, 64)
+	buf[0] = byte(len(payload) + initConn.send.aead.Overhead())
+	buf, err = initConn.send.seal(buf, payload, buf[:v2LengthLen])
+	if err != nil {
+		t.Fatalf("seal: unexpected error %v", err)
+	}
+	buf[v2LengthLen] ^= 0x01
+	go initConn.Conn.Write(buf)
+
+	if _, err := respConn.Read(make([]byte, len(payload))); err == nil {
+		t.Fatal("Read: expected error for tampered packet")
+	}
+}
+
+// TestV2NetworkMismatch ensures peers on different networks fail the key
+// confirmation.
+func TestV2NetworkMismatch(t *testing.T) {
+	_, _, err := v2Pair(common.MainNet, common.TestNet)
+	if err == nil {
+		t.Fatal("v2Handshake: expected error for mismatched networks")
+	}
+}
@@ -457,6 +457,48 @@ func (c *Client) GetDifficulty() (float64, error) {
 	return c.GetDifficultyAsync().Receive()
 }
 
+// FutureGetDeploymentInfoResult is a promise to deliver the result of a
+// GetDeploymentInfoAsync RPC invocation (or an applicable error).
+type FutureGetDeploymentInfoResult chan *Response
+
+// Receive waits for the response promised by the future and returns the state
+// of the deployments provided by the server.
+func (r FutureGetDeploymentInfoResult) Receive() (*btcjson.GetDeploymentInfoResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a getdeploymentinfo result object.
+	var info btcjson.GetDeploymentInfoResult
+	if err := json.Unmarshal(res, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetDeploymentInfoAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function
+// on the returned instance.
+//
+// See GetDeploymentInfo for the blocking version and more details.
+func (c *Client) GetDeploymentInfoAsync(blockHash *chainhash.Hash) FutureGetDeploymentInfoResult {
+	var hash *string
+	if blockHash != nil {
+		hash = btcjson.String(blockHash.String())
+	}
+
+	cmd := btcjson.NewGetDeploymentInfoCmd(hash)
+	return c.sendCmd(cmd)
+}
+
+// GetDeploymentInfo returns the state of the version bits deployments for the
+// block after the block with the passed hash, or after the best block when the
+// hash is nil.
+func (c *Client) GetDeploymentInfo(blockHash *chainhash.Hash) (*btcjson.GetDeploymentInfoResult, error) {
+	return c.GetDeploymentInfoAsync(blockHash).Receive()
+}
+
 // FutureGetBlockChainInfoResult is a promise to deliver the result of a
 // GetBlockChainInfoAsync RPC invocation (or an applicable error).
 type FutureGetBlockChainInfoResult chan *Response
@@ -897,6 +939,44 @@ func (c *Client) GetTxOut(txHash *chainhash.Hash, index uint32, mempool bool, lo
 	return c.GetTxOutAsync(txHash, index, mempool, locked).Receive()
 }
 
+// FutureGetTxOutSetInfoResult is a future promise to deliver the result of a
+// GetTxOutSetInfoAsync RPC invocation (or an applicable error).
+type FutureGetTxOutSetInfoResult chan *Response
+
+// Receive waits for the response promised by the future and returns the
+// information about the utxo set.
+func (r FutureGetTxOutSetInfoResult) Receive() (*btcjson.GetTxOutSetInfoResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a gettxoutsetinfo result object.
+	var info btcjson.GetTxOutSetInfoResult
+	err = json.Unmarshal(res, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// GetTxOutSetInfoAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See GetTxOutSetInfo for the blocking version and more details.
+func (c *Client) GetTxOutSetInfoAsync() FutureGetTxOutSetInfoResult {
+	cmd := btcjson.NewGetTxOutSetInfoCmd()
+	return c.sendCmd(cmd)
+}
+
+// GetTxOutSetInfo returns information about the utxo set at the best block,
+// including its MuHash commitment.
+func (c *Client) GetTxOutSetInfo() (*btcjson.GetTxOutSetInfoResult, error) {
+	return c.GetTxOutSetInfoAsync().Receive()
+}
+
 // FutureGetTxOutResult is a future promise to deliver the result of a
 // GetTxOutAsync RPC invocation (or an applicable error).
 type FutureGetDefineResult chan *Response
@@ -1033,6 +1113,72 @@ func (c *Client) InvalidateBlock(blockHash *chainhash.Hash) error {
 	return c.InvalidateBlockAsync(blockHash).Receive()
 }
 
+// FutureReconsiderBlockResult is a future promise to deliver the result of a
+// ReconsiderBlockAsync RPC invocation (or an applicable error).
+type FutureReconsiderBlockResult chan *Response
+
+// Receive waits for the response promised by the future and returns an error
+// if the block could not be reconsidered.
+func (r FutureReconsiderBlockResult) Receive() error {
+	_, err := receiveFuture(r)
+
+	return err
+}
+
+// ReconsiderBlockAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function
+// on the returned instance.
+//
+// See ReconsiderBlock for the blocking version and more details.
+func (c *Client) ReconsiderBlockAsync(blockHash *chainhash.Hash) FutureReconsiderBlockResult {
+	hash := ""
+	if blockHash != nil {
+		hash = blockHash.String()
+	}
+
+	cmd := btcjson.NewReconsiderBlockCmd(hash)
+	return c.sendCmd(cmd)
+}
+
+// ReconsiderBlock removes the invalid status of a specific block, previously
+// invalidated with InvalidateBlock, and of its descendants.
+func (c *Client) ReconsiderBlock(blockHash *chainhash.Hash) error {
+	return c.ReconsiderBlockAsync(blockHash).Receive()
+}
+
+// FuturePreciousBlockResult is a future promise to deliver the result of a
+// PreciousBlockAsync RPC invocation (or an applicable error).
+type FuturePreciousBlockResult chan *Response
+
+// Receive waits for the response promised by the future and returns an error
+// if the block could not be made precious.
+func (r FuturePreciousBlockResult) Receive() error {
+	_, err := receiveFuture(r)
+
+	return err
+}
+
+// PreciousBlockAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See PreciousBlock for the blocking version and more details.
+func (c *Client) PreciousBlockAsync(blockHash *chainhash.Hash) FuturePreciousBlockResult {
+	hash := ""
+	if blockHash != nil {
+		hash = blockHash.String()
+	}
+
+	cmd := btcjson.NewPreciousBlockCmd(hash)
+	return c.sendCmd(cmd)
+}
+
+// PreciousBlock treats a specific block as if it was received before the other
+// blocks at the same height, so it becomes the end of the main chain.
+func (c *Client) PreciousBlock(blockHash *chainhash.Hash) error {
+	return c.PreciousBlockAsync(blockHash).Receive()
+}
+
 // FutureGetCFilterResult is a future promise to deliver the result of a
 // GetCFilterAsync RPC invocation (or an applicable error).
 type FutureGetCFilterResult chan *Response
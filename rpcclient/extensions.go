@@ -477,3 +477,46 @@ func (c *Client) VersionAsync() FutureVersionResult {
 func (c *Client) Version() (map[string]btcjson.VersionResult, error) {
 	return c.VersionAsync().Receive()
 }
+
+// FutureSimulateForfeitureResult is a future promise to deliver the result of
+// a SimulateForfeitureAsync RPC invocation (or an applicable error).
+type FutureSimulateForfeitureResult chan *Response
+
+// Receive waits for the response promised by the future and returns the
+// simulated forfeiture.
+func (r FutureSimulateForfeitureResult) Receive() (*btcjson.SimulateForfeitureResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a simulateforfeiture result object.
+	var result btcjson.SimulateForfeitureResult
+	err = json.Unmarshal(res, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SimulateForfeitureAsync returns an instance of a type that can be used to
+// get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See SimulateForfeiture for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) SimulateForfeitureAsync(height int32) FutureSimulateForfeitureResult {
+	cmd := btcjson.NewSimulateForfeitureCmd(height)
+	return c.sendCmd(cmd)
+}
+
+// SimulateForfeiture returns the forfeiture of the collateral of the miner
+// block at the passed height, as it would be done with the violation reports
+// made so far, along with the compensation transactions.  Nothing is changed
+// on the node.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) SimulateForfeiture(height int32) (*btcjson.SimulateForfeitureResult, error) {
+	return c.SimulateForfeitureAsync(height).Receive()
+}
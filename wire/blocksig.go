@@ -0,0 +1,194 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// The committee signatures of a block are the coinbase signature scripts
+// following the witness commitment.  Originally every signer adds one script
+// made of its 33-byte compressed public key and an ECDSA signature.  Since
+// compressed public keys start with 0x02 or 0x03, a script starting with any
+// other byte is a versioned block signature:
+//
+//	version (1 byte) | payload
+//
+// The only version defined is BlockSigAggregate, a single MuSig2 Schnorr
+// signature by a set of signers:
+//
+//	varint bitmap length | signer bitmap | 33-byte public key per signer |
+//	64-byte signature
+//
+// Bit i of the bitmap (least significant bit first) is set when the i-th
+// member of the committee signed.  Committee members are only known by the
+// hash of their key, so the keys are still carried, in bitmap order.  The
+// signature is valid under the MuSig2 aggregate of the keys in that order.
+//
+// Coinbases carrying a versioned block signature can only be encoded with
+// AggregateSignatureEncoding, which is only used with peers supporting
+// protocol version AggregateSigVersion.
+
+const (
+	// BlockSigAggregate is the version of an aggregate block signature.
+	BlockSigAggregate byte = 0x01
+
+	// blockSigPubKeyLen is the length of a compressed public key.
+	blockSigPubKeyLen = 33
+
+	// blockSigSchnorrLen is the length of a Schnorr signature.
+	blockSigSchnorrLen = 64
+
+	// maxBlockSigBitmap is the maximum length of a signer bitmap.
+	maxBlockSigBitmap = 64
+)
+
+// AggregateBlockSig is a single aggregate signature of a block by several
+// committee members.  See the format described above.
+type AggregateBlockSig struct {
+	Signers   []byte
+	PubKeys   [][]byte
+	Signature [blockSigSchnorrLen]byte
+}
+
+// SetSigner marks the committee member at the passed position as a signer.
+// Public keys must be added in position order.
+func (s *AggregateBlockSig) SetSigner(i int, pubKey []byte) {
+	for len(s.Signers) <= i/8 {
+		s.Signers = append(s.Signers, 0)
+	}
+	s.Signers[i/8] |= 1 << uint(i%8)
+	s.PubKeys = append(s.PubKeys, pubKey)
+}
+
+// HasSigner returns whether the committee member at the passed position
+// signed.
+func (s *AggregateBlockSig) HasSigner(i int) bool {
+	return i/8 < len(s.Signers) && s.Signers[i/8]&(1<<uint(i%8)) != 0
+}
+
+// NumSigners returns the number of signers according to the bitmap.
+func (s *AggregateBlockSig) NumSigners() int {
+	n := 0
+	for _, b := range s.Signers {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Bytes returns the signature script of the aggregate signature.
+func (s *AggregateBlockSig) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(BlockSigAggregate)
+	common.WriteVarInt(&buf, 0, uint64(len(s.Signers)))
+	buf.Write(s.Signers)
+	for _, k := range s.PubKeys {
+		buf.Write(k)
+	}
+	buf.Write(s.Signature[:])
+	return buf.Bytes()
+}
+
+// IsVersionedBlockSig returns whether the passed coinbase signature script is
+// a versioned block signature rather than a single signer's one.
+func IsVersionedBlockSig(script []byte) bool {
+	return len(script) > 0 && script[0] != 0x02 && script[0] != 0x03
+}
+
+// ParseAggregateBlockSig parses an aggregate block signature script.
+func ParseAggregateBlockSig(script []byte) (*AggregateBlockSig, error) {
+	if len(script) == 0 || script[0] != BlockSigAggregate {
+		return nil, messageError("ParseAggregateBlockSig",
+			"not an aggregate block signature")
+	}
+
+	r := bytes.NewReader(script[1:])
+	n, err := common.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || n > maxBlockSigBitmap {
+		str := fmt.Sprintf("invalid signer bitmap length %d", n)
+		return nil, messageError("ParseAggregateBlockSig", str)
+	}
+
+	s := &AggregateBlockSig{Signers: make([]byte, n)}
+	if _, err := r.Read(s.Signers); err != nil {
+		return nil, err
+	}
+	signers := s.NumSigners()
+	if r.Len() != signers*blockSigPubKeyLen+blockSigSchnorrLen {
+		str := fmt.Sprintf("wrong length for %d signers", signers)
+		return nil, messageError("ParseAggregateBlockSig", str)
+	}
+	s.PubKeys = make([][]byte, signers)
+	for i := range s.PubKeys {
+		s.PubKeys[i] = make([]byte, blockSigPubKeyLen)
+		r.Read(s.PubKeys[i])
+	}
+	r.Read(s.Signature[:])
+
+	return s, nil
+}
+
+// BlockSignerKeys returns the compressed public keys of the committee members
+// who signed the block with the passed coinbase, whatever the signature
+// version.  Malformed signatures are skipped.
+func BlockSignerKeys(coinbase *MsgTx) [][]byte {
+	if len(coinbase.SignatureScripts) < 2 {
+		return nil
+	}
+
+	keys := make([][]byte, 0, len(coinbase.SignatureScripts)-1)
+	for _, script := range coinbase.SignatureScripts[1:] {
+		if !IsVersionedBlockSig(script) {
+			if len(script) >= blockSigPubKeyLen {
+				keys = append(keys, script[:blockSigPubKeyLen])
+			}
+			continue
+		}
+		if agg, err := ParseAggregateBlockSig(script); err == nil {
+			keys = append(keys, agg.PubKeys...)
+		}
+	}
+	return keys
+}
+
+// NumBlockSigners returns the number of committee members who signed the block
+// with the passed coinbase.
+func NumBlockSigners(coinbase *MsgTx) int {
+	return len(BlockSignerKeys(coinbase))
+}
+
+// checkBlockSigEncoding ensures a coinbase carrying a versioned block
+// signature is only encoded or decoded with AggregateSignatureEncoding.
+func checkBlockSigEncoding(coinbase *MsgTx, enc MessageEncoding, op string) error {
+	if enc&AggregateSignatureEncoding != 0 || len(coinbase.SignatureScripts) < 2 {
+		return nil
+	}
+	for _, script := range coinbase.SignatureScripts[1:] {
+		if IsVersionedBlockSig(script) {
+			return messageError(op, "versioned block signature "+
+				"requires AggregateSignatureEncoding")
+		}
+	}
+	return nil
+}
+
+// messageEncoding returns the encoding to use for msg.
+// AggregateSignatureEncoding only applies to messages carrying blocks, so it
+// is removed for the others.
+func messageEncoding(msg Message, enc MessageEncoding) MessageEncoding {
+	switch msg.(type) {
+	case *MsgBlock, *MsgCmpctBlock:
+		return enc
+	}
+	return enc &^ AggregateSignatureEncoding
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestAggregateBlockSig tests the encoding of aggregate block signatures and
+// the extraction of the signer keys from a coinbase.
+func TestAggregateBlockSig(t *testing.T) {
+	key := func(b byte) []byte {
+		k := bytes.Repeat([]byte{b}, blockSigPubKeyLen)
+		k[0] = 0x02
+		return k
+	}
+
+	agg := &AggregateBlockSig{}
+	agg.SetSigner(0, key(1))
+	agg.SetSigner(2, key(2))
+	agg.SetSigner(9, key(3))
+	agg.Signature[0] = 0xaa
+	if agg.NumSigners() != 3 || !agg.HasSigner(9) || agg.HasSigner(1) {
+		t.Fatalf("unexpected signer bitmap %x", agg.Signers)
+	}
+
+	script := agg.Bytes()
+	if !IsVersionedBlockSig(script) {
+		t.Fatalf("IsVersionedBlockSig: aggregate signature not detected")
+	}
+	got, err := ParseAggregateBlockSig(script)
+	if err != nil {
+		t.Fatalf("ParseAggregateBlockSig: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, agg) {
+		t.Fatalf("ParseAggregateBlockSig: got %v, want %v", got, agg)
+	}
+	if _, err := ParseAggregateBlockSig(script[:len(script)-1]); err == nil {
+		t.Errorf("ParseAggregateBlockSig: truncated signature accepted")
+	}
+
+	// A legacy signature followed by an aggregate one.
+	coinbase := NewMsgTx(1)
+	coinbase.SignatureScripts = [][]byte{
+		make([]byte, 32),
+		append(key(4), 0x30, 0x01),
+		script,
+	}
+	keys := BlockSignerKeys(coinbase)
+	want := [][]byte{key(4), key(1), key(2), key(3)}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("BlockSignerKeys: got %x, want %x", keys, want)
+	}
+}
+
+// TestAggregateSignatureEncoding ensures blocks carrying a versioned block
+// signature are only encoded and decoded with AggregateSignatureEncoding.
+func TestAggregateSignatureEncoding(t *testing.T) {
+	agg := &AggregateBlockSig{}
+	agg.SetSigner(0, append([]byte{0x03}, make([]byte, 32)...))
+
+	block := cmpctTestBlock(1)
+	block.Transactions[0].SignatureScripts = [][]byte{make([]byte, 32),
+		agg.Bytes()}
+
+	var buf bytes.Buffer
+	err := block.OmcEncode(&buf, ProtocolVersion, SignatureEncoding)
+	if err == nil {
+		t.Fatalf("OmcEncode: expected error without " +
+			"AggregateSignatureEncoding")
+	}
+
+	buf.Reset()
+	enc := SignatureEncoding | AggregateSignatureEncoding
+	if err := block.OmcEncode(&buf, ProtocolVersion, enc); err != nil {
+		t.Fatalf("OmcEncode: unexpected error %v", err)
+	}
+	raw := buf.Bytes()
+
+	var got MsgBlock
+	err = got.OmcDecode(bytes.NewReader(raw), ProtocolVersion,
+		SignatureEncoding)
+	if err == nil {
+		t.Errorf("OmcDecode: expected error without " +
+			"AggregateSignatureEncoding")
+	}
+	err = got.OmcDecode(bytes.NewReader(raw), ProtocolVersion, enc)
+	if err != nil {
+		t.Fatalf("OmcDecode: unexpected error %v", err)
+	}
+	if got.Transactions[0].SignatureHash() != block.Transactions[0].SignatureHash() {
+		t.Errorf("OmcDecode: coinbase mismatch")
+	}
+
+	// Other messages are not affected by the encoding.
+	buf.Reset()
+	_, err = WriteMessageWithEncodingN(&buf, NewMsgTx(1), ProtocolVersion,
+		0, enc)
+	if err != nil {
+		t.Errorf("WriteMessageWithEncodingN: unexpected error %v", err)
+	}
+}
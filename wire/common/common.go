@@ -858,17 +858,22 @@ const (
 	// SFNode2X is a flag used to indicate a peer is running the Segwit2X
 	// software.
 	SFNode2X
+
+	// SFNodeV2Transport is a flag used to indicate a peer supports the
+	// encrypted and authenticated v2 peer transport.
+	SFNodeV2Transport
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
-	SFNodeNetwork: "SFNodeNetwork",
-	SFNodeGetUTXO: "SFNodeGetUTXO",
-	SFNodeBloom:   "SFNodeBloom",
-	SFNodeXthin:   "SFNodeXthin",
-	SFNodeBit5:    "SFNodeBit5",
-	SFNodeCF:      "SFNodeCF",
-	SFNode2X:      "SFNode2X",
+	SFNodeNetwork:     "SFNodeNetwork",
+	SFNodeGetUTXO:     "SFNodeGetUTXO",
+	SFNodeBloom:       "SFNodeBloom",
+	SFNodeXthin:       "SFNodeXthin",
+	SFNodeBit5:        "SFNodeBit5",
+	SFNodeCF:          "SFNodeCF",
+	SFNode2X:          "SFNode2X",
+	SFNodeV2Transport: "SFNodeV2Transport",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -881,6 +886,7 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeBit5,
 	SFNodeCF,
 	SFNode2X,
+	SFNodeV2Transport,
 }
 
 // String returns the ServiceFlag in human-readable form.
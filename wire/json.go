@@ -0,0 +1,516 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/omega/token"
+)
+
+// This file implements the canonical JSON representation of the wire types,
+// which is meant for inspecting messages while debugging.  It is derived from
+// the types themselves:
+//
+//   - structs are objects keyed by the exported field names in declaration
+//     order, with the fields of embedded structs inlined
+//   - hashes are strings in the usual byte-reversed hex form
+//   - byte slices and byte arrays, such as scripts and pubkey hashes, are hex
+//     strings
+//   - times are RFC3339 strings in UTC and IP addresses are strings
+//   - nil pointers and interfaces are null
+//   - token definitions are objects holding their type and hash, and their
+//     serialization as hex, since their encoding belongs to the token package
+//
+// The encoding of a value decodes back to a value with the same wire
+// serialization.
+
+var (
+	jsonHashType       = reflect.TypeOf(chainhash.Hash{})
+	jsonTimeType       = reflect.TypeOf(time.Time{})
+	jsonIPType         = reflect.TypeOf(net.IP{})
+	jsonMsgTxType      = reflect.TypeOf(MsgTx{})
+	jsonDefinitionType = reflect.TypeOf((*token.Definition)(nil)).Elem()
+	jsonTokenValueType = reflect.TypeOf((*token.TokenValue)(nil)).Elem()
+)
+
+// jsonDefinition is the JSON representation of a token definition.  Hash is
+// informational and ignored when decoding.
+type jsonDefinition struct {
+	Type uint8
+	Hash chainhash.Hash
+	Data []byte
+}
+
+// jsonMessage is the JSON representation of a message along with its command.
+type jsonMessage struct {
+	Command string
+	Message json.RawMessage
+}
+
+// jsonEncoder holds the state of the encoding of a value.
+type jsonEncoder struct {
+	buf bytes.Buffer
+
+	// txVersion is the version of the transaction being encoded, needed
+	// to serialize its definitions.
+	txVersion int32
+}
+
+// inlined returns whether the fields of an embedded struct field are inlined.
+func jsonInlined(f reflect.StructField) bool {
+	return f.Anonymous && f.Type.Kind() == reflect.Struct &&
+		f.Type != jsonHashType && f.Type != jsonTimeType
+}
+
+// writeString writes s as a JSON string.
+func (e *jsonEncoder) writeString(s string) {
+	b, _ := json.Marshal(s)
+	e.buf.Write(b)
+}
+
+// encode writes the JSON representation of v.
+func (e *jsonEncoder) encode(v reflect.Value) error {
+	t := v.Type()
+	switch t {
+	case jsonHashType:
+		e.writeString(v.Interface().(chainhash.Hash).String())
+		return nil
+
+	case jsonTimeType:
+		e.writeString(v.Interface().(time.Time).UTC().Format(time.RFC3339Nano))
+		return nil
+
+	case jsonIPType:
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		e.writeString(v.Interface().(net.IP).String())
+		return nil
+
+	case jsonDefinitionType:
+		return e.encodeDefinition(v)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		e.buf.WriteString(strconv.FormatBool(v.Bool()))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		e.buf.WriteString(strconv.FormatInt(v.Int(), 10))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		e.buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+
+	case reflect.String:
+		e.writeString(v.String())
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		return e.encode(v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			for i := range b {
+				b[i] = byte(v.Index(i).Uint())
+			}
+			e.writeString(hex.EncodeToString(b))
+			return nil
+		}
+		e.buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(']')
+
+	case reflect.Struct:
+		if t == jsonMsgTxType {
+			e.txVersion = int32(v.FieldByName("Version").Int())
+		}
+		e.buf.WriteByte('{')
+		first := true
+		if err := e.encodeFields(v, &first); err != nil {
+			return err
+		}
+		e.buf.WriteByte('}')
+
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+	return nil
+}
+
+// encodeFields writes the exported fields of the struct v as members of a JSON
+// object.  first tells whether no member has been written yet.
+func (e *jsonEncoder) encodeFields(v reflect.Value, first *bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if jsonInlined(f) {
+			if err := e.encodeFields(v.Field(i), first); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if !*first {
+			e.buf.WriteByte(',')
+		}
+		*first = false
+		e.writeString(f.Name)
+		e.buf.WriteByte(':')
+		if err := e.encode(v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+// encodeDefinition writes the JSON representation of a token definition.
+func (e *jsonEncoder) encodeDefinition(v reflect.Value) error {
+	if v.IsNil() {
+		e.buf.WriteString("null")
+		return nil
+	}
+	d := v.Interface().(token.Definition)
+
+	var w bytes.Buffer
+	if err := token.WriteDefinition(&w, ProtocolVersion, e.txVersion, d); err != nil {
+		return err
+	}
+	return e.encode(reflect.ValueOf(jsonDefinition{
+		Type: d.DefType(),
+		Hash: d.Hash(),
+		Data: w.Bytes(),
+	}))
+}
+
+// jsonDecoder holds the state of the decoding of a value.
+type jsonDecoder struct {
+	// txVersion is the version of the transaction being decoded, needed
+	// to deserialize its definitions.
+	txVersion int32
+}
+
+// decode sets v, which must be settable, from its JSON representation j as
+// returned by a json.Decoder using numbers.
+func (d *jsonDecoder) decode(j interface{}, v reflect.Value) error {
+	t := v.Type()
+	if j == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return fmt.Errorf("null %v", t)
+	}
+
+	switch t {
+	case jsonHashType:
+		s, ok := j.(string)
+		if !ok {
+			return fmt.Errorf("hash is not a string")
+		}
+		var h chainhash.Hash
+		if err := chainhash.Decode(&h, s); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(h))
+		return nil
+
+	case jsonTimeType:
+		s, ok := j.(string)
+		if !ok {
+			return fmt.Errorf("time is not a string")
+		}
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+
+	case jsonIPType:
+		s, ok := j.(string)
+		if !ok {
+			return fmt.Errorf("IP address is not a string")
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", s)
+		}
+		v.Set(reflect.ValueOf(ip))
+		return nil
+
+	case jsonDefinitionType:
+		return d.decodeDefinition(j, v)
+
+	case jsonTokenValueType:
+		m, ok := j.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("token value is not an object")
+		}
+		var tv token.TokenValue
+		if _, ok := m["Val"]; ok {
+			tv = &token.NumToken{}
+		} else {
+			tv = &token.HashToken{}
+		}
+		pv := reflect.ValueOf(tv)
+		if err := d.decode(j, pv.Elem()); err != nil {
+			return err
+		}
+		v.Set(pv)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := j.(bool)
+		if !ok {
+			return fmt.Errorf("%v is not a boolean", j)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n, ok := j.(json.Number)
+		if !ok {
+			return fmt.Errorf("%v is not a number", j)
+		}
+		i, err := strconv.ParseInt(string(n), 10, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		n, ok := j.(json.Number)
+		if !ok {
+			return fmt.Errorf("%v is not a number", j)
+		}
+		i, err := strconv.ParseUint(string(n), 10, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+
+	case reflect.String:
+		s, ok := j.(string)
+		if !ok {
+			return fmt.Errorf("%v is not a string", j)
+		}
+		v.SetString(s)
+
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := d.decode(j, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return d.decodeBytes(j, v)
+		}
+		a, ok := j.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an array", t)
+		}
+		if t.Kind() == reflect.Array {
+			if len(a) != t.Len() {
+				return fmt.Errorf("%v has %d elements", t, len(a))
+			}
+		} else {
+			v.Set(reflect.MakeSlice(t, len(a), len(a)))
+		}
+		for i := range a {
+			if err := d.decode(a[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		m, ok := j.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an object", t)
+		}
+		used := make(map[string]struct{}, len(m))
+		if err := d.decodeFields(m, v, used); err != nil {
+			return err
+		}
+		for k := range m {
+			if _, ok := used[k]; !ok {
+				return fmt.Errorf("unknown field %s in %v", k, t)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+	return nil
+}
+
+// decodeFields sets the fields of the struct v from the members of a JSON
+// object, and records the members used.
+func (d *jsonDecoder) decodeFields(m map[string]interface{}, v reflect.Value, used map[string]struct{}) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if jsonInlined(f) {
+			if err := d.decodeFields(m, v.Field(i), used); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		j, ok := m[f.Name]
+		if !ok {
+			continue
+		}
+		used[f.Name] = struct{}{}
+		if err := d.decode(j, v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+		}
+		if t == jsonMsgTxType && f.Name == "Version" {
+			d.txVersion = int32(v.Field(i).Int())
+		}
+	}
+	return nil
+}
+
+// decodeBytes sets the byte slice or byte array v from a hex string.
+func (d *jsonDecoder) decodeBytes(j interface{}, v reflect.Value) error {
+	s, ok := j.(string)
+	if !ok {
+		return fmt.Errorf("%v is not a hex string", v.Type())
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	if v.Kind() == reflect.Array {
+		if len(b) != v.Len() {
+			return fmt.Errorf("%v has %d bytes", v.Type(), len(b))
+		}
+	} else {
+		v.Set(reflect.MakeSlice(v.Type(), len(b), len(b)))
+	}
+	for i := range b {
+		v.Index(i).SetUint(uint64(b[i]))
+	}
+	return nil
+}
+
+// decodeDefinition sets the token definition v from its JSON representation.
+func (d *jsonDecoder) decodeDefinition(j interface{}, v reflect.Value) error {
+	var jd jsonDefinition
+	if err := d.decode(j, reflect.ValueOf(&jd).Elem()); err != nil {
+		return err
+	}
+	def, err := token.ReadDefinition(bytes.NewReader(jd.Data),
+		ProtocolVersion, d.txVersion)
+	if err != nil {
+		return err
+	}
+	if def.DefType() != jd.Type {
+		return fmt.Errorf("definition of type %d has data of type %d",
+			jd.Type, def.DefType())
+	}
+	v.Set(reflect.ValueOf(def))
+	return nil
+}
+
+// EncodeJSON returns the canonical JSON representation of v, which is one of
+// the wire types such as a message, a block header or a transaction, or a
+// pointer to one.
+func EncodeJSON(v interface{}) ([]byte, error) {
+	var e jsonEncoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// DecodeJSON sets the value pointed to by v, one of the wire types, from its
+// canonical JSON representation as returned by EncodeJSON.
+func DecodeJSON(data []byte, v interface{}) error {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() {
+		return fmt.Errorf("DecodeJSON: non-pointer %T", v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var j interface{}
+	if err := dec.Decode(&j); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("DecodeJSON: trailing data")
+	}
+
+	var d jsonDecoder
+	return d.decode(j, pv.Elem())
+}
+
+// MarshalMessageJSON returns the canonical JSON representation of a message
+// along with its command, which UnmarshalMessageJSON turns back into the
+// message.
+func MarshalMessageJSON(msg Message) ([]byte, error) {
+	payload, err := EncodeJSON(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMessage{
+		Command: msg.Command(),
+		Message: payload,
+	})
+}
+
+// UnmarshalMessageJSON returns the message from its JSON representation as
+// returned by MarshalMessageJSON.
+func UnmarshalMessageJSON(data []byte) (Message, error) {
+	var jm jsonMessage
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return nil, err
+	}
+	msg, err := makeEmptyMessage(jm.Command)
+	if err != nil {
+		return nil, err
+	}
+	if err := DecodeJSON(jm.Message, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,409 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+	"github.com/zeusyf/omega/token"
+)
+
+// emptyMessageCommands returns the commands makeEmptyMessage creates messages
+// for.  They are read from its source, so that no message is left out of the
+// tests as new ones are added.
+func emptyMessageCommands(t *testing.T) []string {
+	fset := gotoken.NewFileSet()
+	f, err := parser.ParseFile(fset, "message.go", nil, 0)
+	if err != nil {
+		t.Fatalf("ParseFile: unexpected error %v", err)
+	}
+
+	// Collect the values of the command constants.
+	values := make(map[string]string)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != gotoken.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if i >= len(vs.Values) {
+					break
+				}
+				lit, ok := vs.Values[i].(*ast.BasicLit)
+				if !ok || lit.Kind != gotoken.STRING {
+					continue
+				}
+				values[name.Name], _ = strconv.Unquote(lit.Value)
+			}
+		}
+	}
+
+	var cmds []string
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != "makeEmptyMessage" {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			cc, ok := n.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, e := range cc.List {
+				id, ok := e.(*ast.Ident)
+				if !ok {
+					t.Fatalf("makeEmptyMessage: unexpected case %T", e)
+				}
+				cmd, ok := values[id.Name]
+				if !ok {
+					t.Fatalf("makeEmptyMessage: unknown command %s",
+						id.Name)
+				}
+				cmds = append(cmds, cmd)
+			}
+			return true
+		})
+	}
+	if len(cmds) == 0 {
+		t.Fatalf("makeEmptyMessage: no commands found")
+	}
+	return cmds
+}
+
+// TestMessageJSON ensures the JSON representation of messages decodes back to
+// messages with the same wire encoding.
+func TestMessageJSON(t *testing.T) {
+	hash := chainhash.Hash{0x01, 0x02, 0x03}
+	pkh := [20]byte{0xaa, 0xbb}
+	ts := time.Unix(0x495fab29, 0)
+
+	me := NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 8333, common.SFNodeNetwork)
+	me.Timestamp = ts
+	you := NewNetAddressIPPort(net.ParseIP("2001:db8::1"), 8334, 0)
+	you.Timestamp = ts
+	version := NewMsgVersion(me, you, 0x1234, 100, 10)
+	version.Timestamp = ts
+
+	addr := NewMsgAddr()
+	addr.AddAddress(me)
+	addrV2 := NewMsgAddrV2()
+	addrV2.AddAddress(NewNetAddressV2(NetTorV3, bytes.Repeat([]byte{7}, 32),
+		9050, 0))
+
+	inv := NewMsgInv()
+	inv.AddInvVect(NewInvVect(common.InvTypeTx, &hash))
+
+	getBlocks := NewMsgGetBlocks(&hash, &chainhash.Hash{})
+	getBlocks.AddBlockLocatorHash(&hash)
+
+	headers := NewMsgHeaders()
+	headers.AddBlockHeader(NewBlockHeader(1, &hash, &hash, 0, 7))
+
+	block := cmpctTestBlock(3)
+	block.Header.Timestamp = ts
+
+	minerBlock := &MingingRightBlock{
+		Version:    1,
+		PrevBlock:  hash,
+		BestBlock:  hash,
+		Timestamp:  ts,
+		Bits:       0x1d00ffff,
+		Nonce:      5,
+		Miner:      pkh,
+		Connection: []byte("10.0.0.1:8383"),
+		Utxos:      NewOutPoint(&hash, 1),
+		ViolationReport: []*Violations{{
+			Height:  10,
+			MRBlock: hash,
+			Blocks:  []chainhash.Hash{hash, {0x04}},
+		}},
+		TphReports: []uint32{1, 2},
+	}
+
+	knowledge := NewMsgKnowledge()
+	knowledge.Height = 12
+	knowledge.K = []int32{1, 3}
+	knowledge.M = hash
+	knowledge.Finder = pkh
+	knowledge.From = pkh
+	knowledge.Signatures = [][]byte{{0x30, 0x01}, {0x30, 0x02}}
+
+	candidate := NewMsgCandidate(12, pkh, hash)
+	candidate.Signature = []byte{0x30, 0x44}
+
+	release := NewMsgRelease()
+	release.Height = 12
+	release.From = pkh
+	release.M = hash
+	release.Signature = []byte{0x30, 0x45}
+
+	invitation := NewMsgInvitation()
+	invitation.Expire = 20
+	invitation.To = pkh
+	invitation.Sig = []byte{0x30, 0x46}
+	invitation.Msg = []byte{0x01, 0x02}
+
+	ackInvitation := NewMsgAckInvitation()
+	ackInvitation.Height = 21
+	ackInvitation.Pubkey = [33]byte{0x02, 0x03}
+	ackInvitation.IP = []byte("10.0.0.1:8383")
+	ackInvitation.Sig = []byte{0x30, 0x47}
+
+	// A transaction with definitions, which are encoded by the token
+	// package.
+	defTx := NewMsgTx(1)
+	defTx.AddDef(&token.RightDef{
+		Father: hash,
+		Desc:   []byte("right"),
+		Attrib: 1,
+	})
+	border := &token.BorderDef{Father: hash}
+	border.Begin.SetLat(100)
+	border.Begin.SetLng(200)
+	border.End.SetLat(300)
+	border.End.SetLng(400)
+	defTx.AddDef(border)
+	defTx.AddTxIn(NewTxIn(NewOutPoint(&hash, 2), 0))
+	defTx.AddTxOut(NewTxOut(0, &token.NumToken{Val: 1000}, nil,
+		[]byte{0x41, 0x42}))
+	defTx.SignatureScripts = [][]byte{{0x03}}
+
+	getMinerBlocks := NewMsgGetMinerBlocks(&hash)
+	getMinerBlocks.AddBlockLocatorHash(&hash)
+
+	getData := NewMsgGetData()
+	getData.AddInvVect(NewInvVect(common.InvTypeBlock, &hash))
+	notFound := NewMsgNotFound()
+	notFound.AddInvVect(NewInvVect(common.InvTypeMinerBlock, &hash))
+
+	getHeaders := NewMsgGetHeaders()
+	getHeaders.AddBlockLocatorHash(&hash)
+	getHeaders.HashStop = chainhash.Hash{0x04}
+
+	cfHeaders := NewMsgCFHeaders()
+	cfHeaders.StopHash = hash
+	cfHeaders.PrevFilterHeader = chainhash.Hash{0x05}
+	cfHeaders.AddCFHash(&hash)
+	cfCheckpt := NewMsgCFCheckpt(GCSFilterRegular, &hash, 1)
+	cfCheckpt.AddCFHeader(&hash)
+
+	merkleBlock := NewMsgMerkleBlock(&block.Header)
+	merkleBlock.Transactions = 3
+	merkleBlock.AddTxHash(&hash)
+	merkleBlock.Flags = []byte{0x1d}
+
+	signatures := NewMsgSignatures()
+	signatures.Hash = hash
+	signatures.Signatures = [][]byte{{0x30, 0x03}, {0x30, 0x04}}
+
+	blockTxn := NewMsgBlockTxn(&hash, 1)
+	blockTxn.AddTransaction(block.Transactions[1])
+
+	getMinerHeaders := NewMsgGetMinerHeaders()
+	getMinerHeaders.AddBlockLocatorHash(&hash)
+	getMinerHeaders.HashStop = chainhash.Hash{0x06}
+	minerHeaders := NewMsgMinerHeaders()
+	minerHash := minerBlock.BlockHash()
+	minerHeaders.AddMinerHeader(NewMinerHeader(minerBlock, &minerHash))
+
+	pkg := NewMsgPackage(2)
+	pkg.AddTransaction(block.Transactions[1])
+	pkg.AddTransaction(defTx)
+
+	consensus := NewMsgConsensus()
+	consensus.Height = 12
+	consensus.From = pkh
+	consensus.M = hash
+	consensus.Signature = []byte{0x30, 0x48}
+
+	candidateReply := NewMsgCandidateResp()
+	candidateReply.Height = 12
+	candidateReply.Reply = "cnst"
+	candidateReply.K = []int64{1, 2}
+	candidateReply.Better = 3
+	candidateReply.From = pkh
+	candidateReply.M = hash
+	candidateReply.Signature = []byte{0x30, 0x49}
+
+	signature := NewMsgSignature()
+	signature.MsgConsensus = *consensus
+	signature.For = [20]byte{0xcc}
+
+	pull := NewMsgPull()
+	pull.Height = 12
+	pull.M = hash
+
+	msgs := []Message{
+		version,
+		addr,
+		addrV2,
+		inv,
+		getBlocks,
+		headers,
+		block,
+		minerBlock,
+		defTx,
+		NewMsgCmpctBlock(block, 0x0123456789abcdef),
+		NewMsgPing(0xffffffffffffffff, 3),
+		NewMsgPong(0xfffffffffffffffe),
+		NewMsgReject(CmdBlock, common.RejectInvalid, "bad block"),
+		NewMsgFeeFilter(1000),
+		knowledge,
+		candidate,
+		release,
+		invitation,
+		ackInvitation,
+		getMinerBlocks,
+		getData,
+		notFound,
+		getHeaders,
+		NewMsgAlert([]byte{0x01, 0x02}, []byte{0x30, 0x4a}),
+		NewMsgFilterAdd([]byte{0x01, 0x02}),
+		NewMsgFilterLoad([]byte{0x03, 0x04}, 10, 5, BloomUpdateAll),
+		NewMsgGetCFilters(GCSFilterRegular, 7, &hash),
+		NewMsgGetCFHeaders(GCSFilterRegular, 7, &hash),
+		NewMsgGetCFCheckpt(GCSFilterRegular, &hash),
+		NewMsgCFilter(GCSFilterRegular, &hash, []byte{0x05, 0x06}),
+		cfHeaders,
+		cfCheckpt,
+		merkleBlock,
+		signatures,
+		NewMsgSendCmpct(true),
+		NewMsgGetBlockTxn(&hash, []uint32{0, 2}),
+		blockTxn,
+		getMinerHeaders,
+		minerHeaders,
+		pkg,
+		consensus,
+		candidateReply,
+		signature,
+		pull,
+	}
+
+	// Every message makeEmptyMessage knows is round tripped, the ones
+	// without any content as they are created by it.
+	samples := make(map[string]Message, len(msgs))
+	for _, msg := range msgs {
+		samples[msg.Command()] = msg
+	}
+	for _, cmd := range emptyMessageCommands(t) {
+		msg, ok := samples[cmd]
+		if !ok {
+			var err error
+			msg, err = makeEmptyMessage(cmd)
+			if err != nil {
+				t.Errorf("%s: makeEmptyMessage: unexpected error %v",
+					cmd, err)
+				continue
+			}
+		}
+		var want bytes.Buffer
+		if err := msg.OmcEncode(&want, ProtocolVersion, SignatureEncoding); err != nil {
+			t.Errorf("%s: OmcEncode: unexpected error %v", msg.Command(), err)
+			continue
+		}
+
+		j, err := MarshalMessageJSON(msg)
+		if err != nil {
+			t.Errorf("%s: MarshalMessageJSON: unexpected error %v",
+				msg.Command(), err)
+			continue
+		}
+		got, err := UnmarshalMessageJSON(j)
+		if err != nil {
+			t.Errorf("%s: UnmarshalMessageJSON: unexpected error %v\n%s",
+				msg.Command(), err, j)
+			continue
+		}
+		if got.Command() != msg.Command() {
+			t.Errorf("%s: UnmarshalMessageJSON: got command %s",
+				msg.Command(), got.Command())
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := got.OmcEncode(&buf, ProtocolVersion, SignatureEncoding); err != nil {
+			t.Errorf("%s: OmcEncode: unexpected error %v", msg.Command(), err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+			t.Errorf("%s: round trip mismatch\ngot  %x\nwant %x\njson %s",
+				msg.Command(), buf.Bytes(), want.Bytes(), j)
+		}
+
+		// The encoding is canonical.
+		j2, err := MarshalMessageJSON(got)
+		if err != nil || !bytes.Equal(j2, j) {
+			t.Errorf("%s: encoding is not stable\ngot  %s\nwant %s",
+				msg.Command(), j2, j)
+		}
+	}
+}
+
+// TestEncodeJSON ensures the JSON representation of the wire types has the
+// expected form.
+func TestEncodeJSON(t *testing.T) {
+	hash := chainhash.Hash{0x01}
+	inv := NewMsgInv()
+	inv.AddInvVect(NewInvVect(common.InvTypeTx, &hash))
+	candidate := NewMsgCandidate(12, [20]byte{0xaa}, hash)
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			NewMsgPing(0xffffffffffffffff, -1),
+			`{"Nonce":18446744073709551615,"Height":-1}`,
+		},
+		{
+			inv,
+			`{"InvList":[{"Type":1,"Hash":"` + hash.String() + `"}]}`,
+		},
+		{
+			candidate,
+			`{"Height":12,"F":"aa00000000000000000000000000000000000000",` +
+				`"M":"` + hash.String() + `","Signature":"","Seq":0}`,
+		},
+		{
+			NewNetAddressTimestamp(time.Unix(0, 0), 0,
+				net.ParseIP("10.0.0.1"), 8333),
+			`{"Timestamp":"1970-01-01T00:00:00Z","Services":0,` +
+				`"IP":"10.0.0.1","NetID":0,"Addr":"","Port":8333}`,
+		},
+	}
+
+	for i, test := range tests {
+		got, err := EncodeJSON(test.in)
+		if err != nil {
+			t.Errorf("EncodeJSON #%d: unexpected error %v", i, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("EncodeJSON #%d:\ngot  %s\nwant %s", i, got,
+				test.want)
+		}
+	}
+
+	var ping MsgPing
+	err := DecodeJSON([]byte(`{"Nonce":1,"Height":2,"Extra":3}`), &ping)
+	if err == nil {
+		t.Errorf("DecodeJSON: unknown field accepted")
+	}
+	if err := DecodeJSON([]byte(`{"Nonce":-1}`), &ping); err == nil {
+		t.Errorf("DecodeJSON: negative unsigned value accepted")
+	}
+}
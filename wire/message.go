@@ -63,6 +63,15 @@ const (
 	CmdCFCheckpt      = "cfcheckpt"
 	CmdMerkleBlock    = "merkleblock"
 	CmdSignatures     = "signatures"
+	CmdSendCmpct      = "sendcmpct"
+	CmdCmpctBlock     = "cmpctblock"
+	CmdGetBlockTxn    = "getblocktxn"
+	CmdBlockTxn       = "blocktxn"
+	CmdAddrV2         = "addrv2"
+	CmdSendAddrV2     = "sendaddrv2"
+	CmdGetMinerHeaders = "getminerhdrs"
+	CmdMinerHeaders   = "minerheaders"
+	CmdPackage        = "package"
 
 	// consensus protocol message
 	CmdKnowledge      = "knowledge"
@@ -91,6 +100,12 @@ const (
 	// BaseEncoding, SignatureEncoding will not encode separator and anything after it
 	// FullEncoding will do.
 	FullEncoding
+
+	// AggregateSignatureEncoding allows the coinbase of blocks to carry
+	// versioned block signatures, such as a single aggregate signature of
+	// the committee.  It only applies to block messages and is used along
+	// with SignatureEncoding.
+	AggregateSignatureEncoding
 )
 
 // LatestEncoding is the most recently specified encoding for the Bitcoin wire
@@ -219,6 +234,33 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdMerkleBlock:
 		msg = &MsgMerkleBlock{}
 
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
+	case CmdSendAddrV2:
+		msg = &MsgSendAddrV2{}
+
+	case CmdGetMinerHeaders:
+		msg = &MsgGetMinerHeaders{}
+
+	case CmdMinerHeaders:
+		msg = &MsgMinerHeaders{}
+
+	case CmdPackage:
+		msg = &MsgPackage{}
+
 	case CmdKnowledge:
 		msg = &MsgKnowledge{}
 
@@ -340,7 +382,7 @@ func WriteMessageWithEncodingN(w io.Writer, msg Message, pver uint32,
 
 	// Encode the message payload.
 	var bw bytes.Buffer
-	err := msg.OmcEncode(&bw, pver, encoding)
+	err := msg.OmcEncode(&bw, pver, messageEncoding(msg, encoding))
 	if err != nil {
 		return totalBytes, err
 	}
@@ -470,7 +512,7 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet common.OmegaNet,
 	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
 	// MsgVersion OmcDecode function requires it.
 	pr := bytes.NewBuffer(payload)
-	err = msg.OmcDecode(pr, pver, enc)
+	err = msg.OmcDecode(pr, pver, messageEncoding(msg, enc))
 	if err != nil {
 		return totalBytes, nil, []byte(command), err
 	}
@@ -0,0 +1,105 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"io"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MinerHeaderLen is the length of a serialized MinerHeader.
+const MinerHeaderLen = 4 + chainhash.HashSize*2 + 4 + 4 + 4 + 20 +
+	chainhash.HashSize
+
+// MinerHeader is the header form of a MingingRightBlock used in the
+// minerheaders message (MsgMinerHeaders).  It holds the fixed size fields of
+// the block, which are enough to follow the miner chain, and leaves out the
+// connection info, collateral, violation reports and TPH reports, which are
+// fetched with the block.
+//
+// The hash of a miner block covers all of its fields, so it can't be computed
+// from the header.  It is carried along instead, and checked against the block
+// once fetched, see Matches.
+type MinerHeader struct {
+	// Version of the block.  This is not the same as the protocol version.
+	Version uint32
+
+	// Hash of the previous MingingRightBlock block in the block chain.
+	PrevBlock chainhash.Hash
+
+	// The best main chain block known to the miner.
+	BestBlock chainhash.Hash
+
+	// Time the block was created.  This is encoded as a uint32 on the wire.
+	Timestamp time.Time
+
+	// Difficulty target of the block.
+	Bits uint32
+
+	// Nonce used to generate the block.
+	Nonce int32
+
+	// Address (pubkey hash) of the new committee member.
+	Miner [20]byte
+
+	// Hash of the block.
+	Hash chainhash.Hash
+}
+
+// NewMinerHeader returns the header of the passed miner block with the passed
+// hash.
+func NewMinerHeader(b *MingingRightBlock, hash *chainhash.Hash) *MinerHeader {
+	return &MinerHeader{
+		Version:   b.Version,
+		PrevBlock: b.PrevBlock,
+		BestBlock: b.BestBlock,
+		Timestamp: b.Timestamp,
+		Bits:      b.Bits,
+		Nonce:     b.Nonce,
+		Miner:     b.Miner,
+		Hash:      *hash,
+	}
+}
+
+// BlockHash returns the hash of the miner block of the header.
+func (h *MinerHeader) BlockHash() chainhash.Hash {
+	return h.Hash
+}
+
+// Matches returns whether the header is the header of the passed miner block.
+func (h *MinerHeader) Matches(b *MingingRightBlock) bool {
+	return h.Version == b.Version && h.PrevBlock == b.PrevBlock &&
+		h.BestBlock == b.BestBlock &&
+		h.Timestamp.Unix() == b.Timestamp.Unix() && h.Bits == b.Bits &&
+		h.Nonce == b.Nonce && h.Miner == b.Miner &&
+		h.Hash == b.BlockHash()
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+func (h *MinerHeader) OmcDecode(r io.Reader, pver uint32, _ MessageEncoding) error {
+	return readMinerHeader(r, pver, h)
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+func (h *MinerHeader) OmcEncode(w io.Writer, pver uint32, _ MessageEncoding) error {
+	return writeMinerHeader(w, pver, h)
+}
+
+// readMinerHeader reads a miner block header from r.
+func readMinerHeader(r io.Reader, pver uint32, h *MinerHeader) error {
+	return common.ReadElements(r, &h.Version, &h.PrevBlock, &h.BestBlock,
+		(*common.Uint32Time)(&h.Timestamp), &h.Bits, &h.Nonce, &h.Miner,
+		&h.Hash)
+}
+
+// writeMinerHeader writes a miner block header to w.
+func writeMinerHeader(w io.Writer, pver uint32, h *MinerHeader) error {
+	sec := uint32(h.Timestamp.Unix())
+	return common.WriteElements(w, h.Version, &h.PrevBlock, &h.BestBlock,
+		sec, h.Bits, h.Nonce, &h.Miner, &h.Hash)
+}
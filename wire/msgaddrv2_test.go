@@ -0,0 +1,97 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// TestAddrV2Wire tests the MsgAddrV2 wire encode and decode for addresses of
+// every supported network.
+func TestAddrV2Wire(t *testing.T) {
+	ts := time.Unix(0x495fab29, 0)
+	addrs := []*NetAddress{
+		NewNetAddressV2(NetIPv4, net.ParseIP("127.0.0.1").To4(), 8333,
+			common.SFNodeNetwork),
+		NewNetAddressV2(NetIPv6, net.ParseIP("2001:db8::1"), 8334, 0),
+		NewNetAddressV2(NetTorV3, bytes.Repeat([]byte{0xab}, 32), 9050, 0),
+		NewNetAddressV2(NetI2P, bytes.Repeat([]byte{0xcd}, 32), 0, 0),
+		NewNetAddressV2(NetCJDNS, net.ParseIP("fc00::1"), 8333, 0),
+	}
+	msg := NewMsgAddrV2()
+	for _, na := range addrs {
+		na.Timestamp = ts
+		if err := msg.AddAddress(na); err != nil {
+			t.Fatalf("AddAddress: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := msg.OmcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("OmcEncode: %v", err)
+	}
+	var got MsgAddrV2
+	err := got.OmcDecode(bytes.NewReader(buf.Bytes()), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: %v", err)
+	}
+	if !reflect.DeepEqual(got.AddrList, msg.AddrList) {
+		t.Errorf("OmcDecode: got %v, want %v", got.AddrList, msg.AddrList)
+	}
+	for i, na := range got.AddrList {
+		if na.IsAddrV1() != (i < 2) {
+			t.Errorf("IsAddrV1 #%d: wrong result for network %v", i,
+				na.Network())
+		}
+	}
+
+	// The message is invalid before AddrV2Version.
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, AddrV2Version-1, BaseEncoding); err == nil {
+		t.Errorf("OmcEncode: expected error for old protocol version")
+	}
+}
+
+// TestAddrV2WireSkip ensures addresses of unknown networks and Tor v2 are
+// skipped and malformed ones are rejected.
+func TestAddrV2WireSkip(t *testing.T) {
+	entry := func(netID byte, addr []byte) []byte {
+		var buf bytes.Buffer
+		buf.Write([]byte{0x29, 0xab, 0x5f, 0x49}) // Timestamp
+		buf.WriteByte(0x01)                       // Services
+		buf.WriteByte(netID)
+		common.WriteVarBytes(&buf, ProtocolVersion, addr)
+		buf.Write([]byte{0x20, 0x8d}) // Port 8333
+		return buf.Bytes()
+	}
+
+	raw := []byte{0x03}
+	raw = append(raw, entry(byte(NetTorV2), make([]byte, 10))...)
+	raw = append(raw, entry(0x42, make([]byte, 7))...)
+	raw = append(raw, entry(byte(NetIPv4), []byte{10, 0, 0, 1})...)
+
+	var msg MsgAddrV2
+	err := msg.OmcDecode(bytes.NewReader(raw), ProtocolVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: %v", err)
+	}
+	if len(msg.AddrList) != 1 || !msg.AddrList[0].IP.Equal(net.IP{10, 0, 0, 1}) ||
+		msg.AddrList[0].Port != 8333 {
+		t.Fatalf("OmcDecode: unexpected addresses %v", msg.AddrList)
+	}
+
+	raw = append([]byte{0x01}, entry(byte(NetTorV3), make([]byte, 31))...)
+	err = msg.OmcDecode(bytes.NewReader(raw), ProtocolVersion, BaseEncoding)
+	if err == nil {
+		t.Errorf("OmcDecode: wrong address length accepted")
+	}
+}
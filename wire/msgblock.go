@@ -84,13 +84,18 @@ func (msg *MsgBlock) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) er
 	msg.Transactions = make([]*MsgTx, 0, txCount)
 	for i := uint64(0); i < txCount; i++ {
 		tx := MsgTx{}
-		err := tx.OmcDecode(r, pver, enc)
+		err := tx.OmcDecode(r, pver, enc&^AggregateSignatureEncoding)
 		if err != nil {
 			return err
 		}
 		msg.Transactions = append(msg.Transactions, &tx)
 	}
 
+	if len(msg.Transactions) > 0 {
+		return checkBlockSigEncoding(msg.Transactions[0], enc,
+			"MsgBlock.OmcDecode")
+	}
+
 	return nil
 }
 
@@ -112,7 +117,7 @@ func (msg *MsgBlock) Deserialize(r io.Reader) error {
 	// MessageEncoding parameter indicates that the transactions within the
 	// block are expected to be serialized according to the new
 	// serialization structure defined in BIP0141.
-	err := msg.OmcDecode(r, 0, SignatureEncoding)
+	err := msg.OmcDecode(r, 0, SignatureEncoding|AggregateSignatureEncoding)
 	if err != nil {
 		return err
 	}
@@ -187,6 +192,15 @@ func (msg *MsgBlock) DeserializeTxLoc(r *bytes.Buffer) ([]TxLoc, error) {
 // See Serialize for encoding blocks to be stored to disk, such as in a
 // database, as opposed to encoding blocks for the wire.
 func (msg *MsgBlock) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if len(msg.Transactions) > 0 {
+		err := checkBlockSigEncoding(msg.Transactions[0], enc,
+			"MsgBlock.OmcEncode")
+		if err != nil {
+			return err
+		}
+	}
+	enc &^= AggregateSignatureEncoding
+
 	err := writeBlockHeader(w, pver, &msg.Header)
 	if err != nil {
 		return err
@@ -217,7 +231,7 @@ func (msg *MsgBlock) Serialize(w io.Writer) error {
 	// Passing WitnessEncoding as the encoding type here indicates that
 	// each of the transactions should be serialized using the witness
 	// serialization structure defined in BIP0141.
-	err := msg.OmcEncode(w, 0, SignatureEncoding | FullEncoding)
+	err := msg.OmcEncode(w, 0, SignatureEncoding | FullEncoding | AggregateSignatureEncoding)
 
 	return err
 }
@@ -226,7 +240,7 @@ func (msg *MsgBlock) SerializeFull(w io.Writer) error {
 	// Passing WitnessEncoding as the encoding type here indicates that
 	// each of the transactions should be serialized using the witness
 	// serialization structure defined in BIP0141.
-	err := msg.OmcEncode(w, 0, SignatureEncoding | FullEncoding)
+	err := msg.OmcEncode(w, 0, SignatureEncoding | FullEncoding | AggregateSignatureEncoding)
 
 	return err
 }
@@ -235,7 +249,7 @@ func (msg *MsgBlock) SerializeFull(w io.Writer) error {
 // Serialize, with all (if any) witness data stripped from all transactions.
 // This method is provided in additon to the regular Serialize for SVP nodes.
 func (msg *MsgBlock) SerializeNoSignature(w io.Writer) error {
-	return msg.OmcEncode(w, 0, BaseEncoding | FullEncoding)
+	return msg.OmcEncode(w, 0, BaseEncoding | FullEncoding | AggregateSignatureEncoding)
 }
 
 // SerializeSize returns the number of bytes it would take to serialize the
@@ -0,0 +1,111 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MsgBlockTxn implements the Message interface and represents the reply to a
+// getblocktxn message (MsgGetBlockTxn).  It carries the requested
+// transactions of a block in the order they were requested.
+//
+// This message was not added until protocol versions starting with
+// CmpctBlockVersion.
+type MsgBlockTxn struct {
+	BlockHash    chainhash.Hash
+	Transactions []*MsgTx
+}
+
+// AddTransaction adds a transaction to the message.
+func (msg *MsgBlockTxn) AddTransaction(tx *MsgTx) {
+	msg.Transactions = append(msg.Transactions, tx)
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.OmcDecode", str)
+	}
+
+	if err := readElement(r, &msg.BlockHash); err != nil {
+		return err
+	}
+
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count, MaxCmpctBlockTxns)
+		return messageError("MsgBlockTxn.OmcDecode", str)
+	}
+
+	msg.Transactions = make([]*MsgTx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tx := MsgTx{}
+		if err := tx.OmcDecode(r, pver, enc); err != nil {
+			return err
+		}
+		msg.Transactions = append(msg.Transactions, &tx)
+	}
+
+	return nil
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.OmcEncode", str)
+	}
+
+	if err := writeElement(w, &msg.BlockHash); err != nil {
+		return err
+	}
+
+	err := common.WriteVarInt(w, pver, uint64(len(msg.Transactions)))
+	if err != nil {
+		return err
+	}
+	for _, tx := range msg.Transactions {
+		if err := tx.OmcEncode(w, pver, enc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgBlockTxn) Command() string {
+	return CmdBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	return MaxBlockPayload
+}
+
+// NewMsgBlockTxn returns a new blocktxn message that conforms to the Message
+// interface.  See MsgBlockTxn for details.
+func NewMsgBlockTxn(blockHash *chainhash.Hash, sizeHint int) *MsgBlockTxn {
+	return &MsgBlockTxn{
+		BlockHash:    *blockHash,
+		Transactions: make([]*MsgTx, 0, sizeHint),
+	}
+}
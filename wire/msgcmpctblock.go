@@ -0,0 +1,255 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// ShortIDSize is the number of bytes of a short transaction id in a compact
+// block.
+const ShortIDSize = 6
+
+// MaxCmpctBlockTxns is the maximum number of transactions, short ids and
+// prefilled transactions together, a compact block may announce.
+const MaxCmpctBlockTxns = MaxBlockPayload / minTxPayload
+
+// PrefilledTx is a transaction sent in full within a compact block together
+// with its index in the block.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a compact
+// block announcement.  It carries the header of a block, the transactions the
+// receiver is unlikely to have (prefilled) and a short id for each of the
+// others so the receiver may rebuild the block from its mempool.
+//
+// The coinbase is always prefilled since it carries the committee signatures.
+// Like in a block message, contract execution data is not sent, so every other
+// transaction is exactly what a peer would hold in its mempool.
+//
+// This message was not added until protocol versions starting with
+// CmpctBlockVersion.
+type MsgCmpctBlock struct {
+	Header        BlockHeader
+	Nonce         uint64
+	ShortIDs      []uint64
+	PrefilledTxns []PrefilledTx
+}
+
+// TxCount returns the number of transactions in the announced block.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxns)
+}
+
+// ShortIDKey returns the key used to compute the short ids of the
+// transactions of the compact block.  It commits to the block hash and the
+// nonce chosen by the sender so short id collisions can't be precomputed.
+func (msg *MsgCmpctBlock) ShortIDKey() []byte {
+	var buf bytes.Buffer
+	hash := msg.Header.BlockHash()
+	buf.Write(hash[:])
+	binary.Write(&buf, binary.LittleEndian, msg.Nonce)
+	return chainhash.HashB(buf.Bytes())
+}
+
+// CmpctShortID returns the short id of the transaction with the passed
+// signature hash under the passed key.  The signature hash is used rather than
+// the transaction hash so a match also implies identical signatures.
+func CmpctShortID(key []byte, sigHash *chainhash.Hash) uint64 {
+	buf := make([]byte, 0, len(key)+chainhash.HashSize)
+	buf = append(append(buf, key...), sigHash[:]...)
+	h := chainhash.HashB(buf)
+
+	var id uint64
+	for i := ShortIDSize - 1; i >= 0; i-- {
+		id = id<<8 | uint64(h[i])
+	}
+	return id
+}
+
+// readShortID reads a ShortIDSize bytes little endian short id from r.
+func readShortID(r io.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:ShortIDSize]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// writeShortID writes the ShortIDSize bytes little endian short id to w.
+func writeShortID(w io.Writer, id uint64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], id)
+	_, err := w.Write(b[:ShortIDSize])
+	return err
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.OmcDecode", str)
+	}
+
+	if err := readBlockHeader(r, pver, &msg.Header); err != nil {
+		return err
+	}
+	if err := readElement(r, &msg.Nonce); err != nil {
+		return err
+	}
+
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many short ids for message "+
+			"[count %v, max %v]", count, MaxCmpctBlockTxns)
+		return messageError("MsgCmpctBlock.OmcDecode", str)
+	}
+	msg.ShortIDs = make([]uint64, count)
+	for i := range msg.ShortIDs {
+		if msg.ShortIDs[i], err = readShortID(r); err != nil {
+			return err
+		}
+	}
+
+	count, err = common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count+uint64(len(msg.ShortIDs)) > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many prefilled transactions for "+
+			"message [count %v, max %v]", count,
+			MaxCmpctBlockTxns-len(msg.ShortIDs))
+		return messageError("MsgCmpctBlock.OmcDecode", str)
+	}
+	msg.PrefilledTxns = make([]PrefilledTx, count)
+	for i := range msg.PrefilledTxns {
+		pt := &msg.PrefilledTxns[i]
+		if err := readElement(r, &pt.Index); err != nil {
+			return err
+		}
+		pt.Tx = &MsgTx{}
+		if err := pt.Tx.OmcDecode(r, pver, enc&^AggregateSignatureEncoding); err != nil {
+			return err
+		}
+		if pt.Index == 0 {
+			err := checkBlockSigEncoding(pt.Tx, enc, "MsgCmpctBlock.OmcDecode")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.OmcEncode", str)
+	}
+
+	if msg.TxCount() > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", msg.TxCount(), MaxCmpctBlockTxns)
+		return messageError("MsgCmpctBlock.OmcEncode", str)
+	}
+
+	if err := writeBlockHeader(w, pver, &msg.Header); err != nil {
+		return err
+	}
+	if err := writeElement(w, msg.Nonce); err != nil {
+		return err
+	}
+
+	err := common.WriteVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	for _, id := range msg.ShortIDs {
+		if err := writeShortID(w, id); err != nil {
+			return err
+		}
+	}
+
+	err = common.WriteVarInt(w, pver, uint64(len(msg.PrefilledTxns)))
+	if err != nil {
+		return err
+	}
+	for _, pt := range msg.PrefilledTxns {
+		if pt.Index == 0 {
+			err := checkBlockSigEncoding(pt.Tx, enc, "MsgCmpctBlock.OmcEncode")
+			if err != nil {
+				return err
+			}
+		}
+		if err := writeElement(w, pt.Index); err != nil {
+			return err
+		}
+		if err := pt.Tx.OmcEncode(w, pver, enc&^AggregateSignatureEncoding); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	return MaxBlockPayload
+}
+
+// BlockHash computes the block identifier hash for the announced block.
+func (msg *MsgCmpctBlock) BlockHash() chainhash.Hash {
+	return msg.Header.BlockHash()
+}
+
+// NewMsgCmpctBlock returns a compact block announcement for the passed block
+// that conforms to the Message interface.  The coinbase is prefilled, every
+// other transaction is replaced by its short id.  See MsgCmpctBlock for
+// details.
+func NewMsgCmpctBlock(block *MsgBlock, nonce uint64) *MsgCmpctBlock {
+	msg := &MsgCmpctBlock{
+		Header:   block.Header,
+		Nonce:    nonce,
+		ShortIDs: make([]uint64, 0, len(block.Transactions)),
+	}
+
+	key := msg.ShortIDKey()
+	for i, tx := range block.Transactions {
+		if i == 0 {
+			msg.PrefilledTxns = append(msg.PrefilledTxns,
+				PrefilledTx{Index: uint32(i), Tx: tx})
+			continue
+		}
+		sigHash := tx.SignatureHash()
+		msg.ShortIDs = append(msg.ShortIDs, CmpctShortID(key, &sigHash))
+	}
+
+	return msg
+}
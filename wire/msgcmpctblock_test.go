@@ -0,0 +1,131 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// cmpctTestBlock returns a block with a coinbase and numTxns other
+// transactions for the compact block tests.
+func cmpctTestBlock(numTxns int) *MsgBlock {
+	block := NewMsgBlock(NewBlockHeader(1, &chainhash.Hash{},
+		&chainhash.Hash{}, 0, 0))
+	coinbase := NewMsgTx(1)
+	coinbase.AddTxIn(NewTxIn(NewOutPoint(&chainhash.Hash{}, 0xffffffff), 0))
+	coinbase.SignatureScripts = [][]byte{{0x01, 0x02, 0x03}}
+	block.AddTransaction(coinbase)
+	for i := 0; i < numTxns; i++ {
+		tx := NewMsgTx(1)
+		prev := chainhash.Hash{byte(i + 1)}
+		tx.AddTxIn(NewTxIn(NewOutPoint(&prev, uint32(i)), 0))
+		tx.SignatureScripts = [][]byte{{byte(i)}}
+		block.AddTransaction(tx)
+	}
+	return block
+}
+
+// TestCmpctBlockWire tests the MsgCmpctBlock wire encode and decode.
+func TestCmpctBlockWire(t *testing.T) {
+	block := cmpctTestBlock(5)
+	msg := NewMsgCmpctBlock(block, 0x0123456789abcdef)
+	if msg.TxCount() != len(block.Transactions) {
+		t.Fatalf("TxCount: got %d, want %d", msg.TxCount(),
+			len(block.Transactions))
+	}
+	if len(msg.PrefilledTxns) != 1 || msg.PrefilledTxns[0].Index != 0 {
+		t.Fatalf("NewMsgCmpctBlock: coinbase not prefilled - %v",
+			msg.PrefilledTxns)
+	}
+
+	key := msg.ShortIDKey()
+	for i, tx := range block.Transactions[1:] {
+		sigHash := tx.SignatureHash()
+		id := CmpctShortID(key, &sigHash)
+		if id != msg.ShortIDs[i] {
+			t.Errorf("short id %d: got %x, want %x", i, msg.ShortIDs[i],
+				id)
+		}
+		if id>>(ShortIDSize*8) != 0 {
+			t.Errorf("short id %d: %x exceeds %d bytes", i, id,
+				ShortIDSize)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := msg.OmcEncode(&buf, CmpctBlockVersion, SignatureEncoding); err != nil {
+		t.Fatalf("OmcEncode: unexpected error %v", err)
+	}
+	var got MsgCmpctBlock
+	err := got.OmcDecode(bytes.NewReader(buf.Bytes()), CmpctBlockVersion,
+		SignatureEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: unexpected error %v", err)
+	}
+	if got.BlockHash() != msg.BlockHash() || got.Nonce != msg.Nonce {
+		t.Errorf("OmcDecode: header or nonce mismatch")
+	}
+	if !reflect.DeepEqual(got.ShortIDs, msg.ShortIDs) {
+		t.Errorf("OmcDecode: got short ids %x, want %x", got.ShortIDs,
+			msg.ShortIDs)
+	}
+	if len(got.PrefilledTxns) != 1 ||
+		got.PrefilledTxns[0].Tx.SignatureHash() != block.Transactions[0].SignatureHash() {
+		t.Errorf("OmcDecode: prefilled coinbase mismatch")
+	}
+
+	// Older protocol versions must be rejected.
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, CmpctBlockVersion-1, SignatureEncoding); err == nil {
+		t.Errorf("OmcEncode: expected error for protocol version %d",
+			CmpctBlockVersion-1)
+	}
+}
+
+// TestBlockTxnWire tests the MsgGetBlockTxn and MsgBlockTxn wire encode and
+// decode.
+func TestBlockTxnWire(t *testing.T) {
+	block := cmpctTestBlock(3)
+	hash := block.BlockHash()
+
+	getMsg := NewMsgGetBlockTxn(&hash, []uint32{1, 3})
+	var buf bytes.Buffer
+	if err := getMsg.OmcEncode(&buf, CmpctBlockVersion, BaseEncoding); err != nil {
+		t.Fatalf("MsgGetBlockTxn.OmcEncode: unexpected error %v", err)
+	}
+	var gotGet MsgGetBlockTxn
+	err := gotGet.OmcDecode(&buf, CmpctBlockVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("MsgGetBlockTxn.OmcDecode: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(&gotGet, getMsg) {
+		t.Errorf("MsgGetBlockTxn: got %v, want %v", gotGet, getMsg)
+	}
+
+	txMsg := NewMsgBlockTxn(&hash, 2)
+	txMsg.AddTransaction(block.Transactions[1])
+	txMsg.AddTransaction(block.Transactions[3])
+	buf.Reset()
+	if err := txMsg.OmcEncode(&buf, CmpctBlockVersion, SignatureEncoding); err != nil {
+		t.Fatalf("MsgBlockTxn.OmcEncode: unexpected error %v", err)
+	}
+	var gotTxn MsgBlockTxn
+	err = gotTxn.OmcDecode(&buf, CmpctBlockVersion, SignatureEncoding)
+	if err != nil {
+		t.Fatalf("MsgBlockTxn.OmcDecode: unexpected error %v", err)
+	}
+	if gotTxn.BlockHash != hash || len(gotTxn.Transactions) != 2 {
+		t.Fatalf("MsgBlockTxn: got %v, want %v", gotTxn, txMsg)
+	}
+	for i, tx := range gotTxn.Transactions {
+		if tx.SignatureHash() != txMsg.Transactions[i].SignatureHash() {
+			t.Errorf("MsgBlockTxn: transaction %d mismatch", i)
+		}
+	}
+}
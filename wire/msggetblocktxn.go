@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MsgGetBlockTxn implements the Message interface and represents a request
+// for the transactions of a compact block (MsgCmpctBlock) the requester could
+// not find in its mempool.  The transactions are identified by their index in
+// the block and are returned in a blocktxn message (MsgBlockTxn).
+//
+// This message was not added until protocol versions starting with
+// CmpctBlockVersion.
+type MsgGetBlockTxn struct {
+	BlockHash chainhash.Hash
+	Indexes   []uint32
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.OmcDecode", str)
+	}
+
+	if err := readElement(r, &msg.BlockHash); err != nil {
+		return err
+	}
+
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %v, max %v]", count, MaxCmpctBlockTxns)
+		return messageError("MsgGetBlockTxn.OmcDecode", str)
+	}
+
+	msg.Indexes = make([]uint32, count)
+	for i := range msg.Indexes {
+		if err := readElement(r, &msg.Indexes[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.OmcEncode", str)
+	}
+
+	count := len(msg.Indexes)
+	if count > MaxCmpctBlockTxns {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %v, max %v]", count, MaxCmpctBlockTxns)
+		return messageError("MsgGetBlockTxn.OmcEncode", str)
+	}
+
+	if err := writeElement(w, &msg.BlockHash); err != nil {
+		return err
+	}
+
+	err := common.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+	for _, index := range msg.Indexes {
+		if err := writeElement(w, index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetBlockTxn) Command() string {
+	return CmdGetBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + num indexes (varInt) + max allowed indexes.
+	return chainhash.HashSize + common.MaxVarIntPayload +
+		MaxCmpctBlockTxns*4
+}
+
+// NewMsgGetBlockTxn returns a new getblocktxn message that conforms to the
+// Message interface.  See MsgGetBlockTxn for details.
+func NewMsgGetBlockTxn(blockHash *chainhash.Hash, indexes []uint32) *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{
+		BlockHash: *blockHash,
+		Indexes:   indexes,
+	}
+}
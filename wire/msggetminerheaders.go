@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MsgGetMinerHeaders implements the Message interface and represents a
+// getminerheaders message.  It is the miner chain counterpart of getheaders
+// (MsgGetHeaders): it requests the headers of the miner blocks
+// starting after the last known hash in the slice of block locator hashes.
+// The list is returned via a minerheaders message (MsgMinerHeaders) and is
+// limited by a specific hash to stop at or the maximum number of headers per
+// message, which is currently 2000.
+//
+// The command is shortened to getminerhdrs to fit the 12 byte command field.
+//
+// This message was not added until protocol versions starting with
+// MinerHeadersVersion.
+type MsgGetMinerHeaders struct {
+	ProtocolVersion    uint32
+	BlockLocatorHashes []*chainhash.Hash
+	HashStop           chainhash.Hash
+}
+
+// AddBlockLocatorHash adds a new block locator hash to the message.
+func (msg *MsgGetMinerHeaders) AddBlockLocatorHash(hash *chainhash.Hash) error {
+	if len(msg.BlockLocatorHashes)+1 > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message [max %v]",
+			MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetMinerHeaders.AddBlockLocatorHash", str)
+	}
+
+	msg.BlockLocatorHashes = append(msg.BlockLocatorHashes, hash)
+	return nil
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetMinerHeaders) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < MinerHeadersVersion {
+		str := fmt.Sprintf("getminerheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetMinerHeaders.OmcDecode", str)
+	}
+
+	err := readElement(r, &msg.ProtocolVersion)
+	if err != nil {
+		return err
+	}
+
+	// Read num block locator hashes and limit to max.
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message "+
+			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetMinerHeaders.OmcDecode", str)
+	}
+
+	// Create a contiguous slice of hashes to deserialize into in order to
+	// reduce the number of allocations.
+	locatorHashes := make([]chainhash.Hash, count)
+	msg.BlockLocatorHashes = make([]*chainhash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		hash := &locatorHashes[i]
+		err := readElement(r, hash)
+		if err != nil {
+			return err
+		}
+		msg.AddBlockLocatorHash(hash)
+	}
+
+	return readElement(r, &msg.HashStop)
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetMinerHeaders) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < MinerHeadersVersion {
+		str := fmt.Sprintf("getminerheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetMinerHeaders.OmcEncode", str)
+	}
+
+	// Limit to max block locator hashes per message.
+	count := len(msg.BlockLocatorHashes)
+	if count > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message "+
+			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetMinerHeaders.OmcEncode", str)
+	}
+
+	err := writeElement(w, msg.ProtocolVersion)
+	if err != nil {
+		return err
+	}
+
+	err = common.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range msg.BlockLocatorHashes {
+		err := writeElement(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeElement(w, &msg.HashStop)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetMinerHeaders) Command() string {
+	return CmdGetMinerHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetMinerHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Version 4 bytes + num block locator hashes (varInt) + max allowed block
+	// locators + hash stop.
+	return 4 + common.MaxVarIntPayload + (MaxBlockLocatorsPerMsg *
+		chainhash.HashSize) + chainhash.HashSize
+}
+
+// NewMsgGetMinerHeaders returns a new getminerheaders message that conforms
+// to the Message interface.  See MsgGetMinerHeaders for details.
+func NewMsgGetMinerHeaders() *MsgGetMinerHeaders {
+	return &MsgGetMinerHeaders{
+		ProtocolVersion: ProtocolVersion,
+		BlockLocatorHashes: make([]*chainhash.Hash, 0,
+			MaxBlockLocatorsPerMsg),
+	}
+}
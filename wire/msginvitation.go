@@ -6,6 +6,7 @@ package wire
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/zeusyf/btcd/chaincfg/chainhash"
 	"github.com/zeusyf/btcd/wire/common"
 	"io"
@@ -53,17 +54,52 @@ func (m * Invitation) Deserialize(r io.Reader) error {
 	return nil
 }
 
+// The formats of the invitation in MsgInvitation.  The format is carried in
+// the byte which used to be the Encrypt flag, so legacy invitations decode as
+// InvitationPlain or InvitationRSA.
+const (
+	// InvitationPlain is an invitation which is not encrypted.
+	InvitationPlain uint8 = 0
+
+	// InvitationRSA is an invitation encrypted with the RSA key the
+	// recipient advertised in the Connection of its miner block.  It is
+	// only accepted for migration.
+	InvitationRSA uint8 = 1
+
+	// InvitationECIES is an invitation encrypted with ECIES to the
+	// secp256k1 miner key of the recipient.  The signature of the sender
+	// is encrypted along with the invitation instead of being sent in Sig.
+	InvitationECIES uint8 = 2
+)
+
 type MsgInvitation struct {
 	Expire uint32 // expiration height. anything more than Height + committee size
 	To [20]byte	// receipient identified by PKH address
 	Encrypt bool	// whether Msg is encrypted Invitation
+	Version uint8	// format of the invitation in Msg, InvitationPlain etc.
 	Sig []byte	// my signature (w/o pubkey) on invitation to prove I am the one
-	Msg []byte	// RSA encrypted invitation message using the receipient's RSA pubkey
+	Msg []byte	// encrypted invitation message, as specified by Version
+}
+
+// SignatureHash returns the hash signed by the sender of the invitation.  For
+// InvitationECIES, the hash commits to the format, expiration and recipient
+// of the message as well, so the invitation can't be replayed with another
+// expiration or to another recipient.
+func (msg *MsgInvitation) SignatureHash(inv *Invitation) []byte {
+	var w bytes.Buffer
+	if msg.Version >= InvitationECIES {
+		w.WriteByte(msg.Version)
+		common.WriteElement(&w, msg.Expire)
+		w.Write(msg.To[:])
+	}
+	inv.Serialize(&w)
+
+	return chainhash.DoubleHashB(w.Bytes())
 }
 
 func (msg *MsgInvitation) Hash() chainhash.Hash {
 	var w bytes.Buffer
-	msg.OmcEncode(&w, 0, 0)
+	msg.OmcEncode(&w, ProtocolVersion, 0)
 
 	return chainhash.DoubleHashH(w.Bytes())
 }
@@ -77,7 +113,7 @@ func (msg *MsgInvitation) OmcDecode(r io.Reader, pver uint32, enc MessageEncodin
 	}
 	msg.Expire = uint32(x)
 
-	err = common.ReadElement(r, msg.To)
+	err = common.ReadElement(r, &msg.To)
 	if err != nil {
 		return err
 	}
@@ -87,11 +123,19 @@ func (msg *MsgInvitation) OmcDecode(r io.Reader, pver uint32, enc MessageEncodin
 	if err != nil {
 		return err
 	}
-	if b == 0 {
-		msg.Encrypt = false
-	} else {
-		msg.Encrypt = true
+	switch {
+	case b == InvitationPlain || b == InvitationRSA:
+		msg.Version = b
+
+	case b == InvitationECIES && pver >= ECIESInvitationVersion:
+		msg.Version = b
+
+	default:
+		str := fmt.Sprintf("unsupported invitation format %d for "+
+			"protocol version %d", b, pver)
+		return messageError("MsgInvitation.OmcDecode", str)
 	}
+	msg.Encrypt = msg.Version != InvitationPlain
 
 	t, err := common.ReadVarBytes(r, 0, 1024, "Sig")
 	if err != nil {
@@ -123,11 +167,17 @@ func (msg *MsgInvitation) OmcEncode(w io.Writer, pver uint32, enc MessageEncodin
 		return err
 	}
 
-	if msg.Encrypt {
-		err = common.WriteElement(w, byte(1))
-	} else {
-		err = common.WriteElement(w, byte(0))
+	// Legacy invitations only set Encrypt.
+	version := msg.Version
+	if version == InvitationPlain && msg.Encrypt {
+		version = InvitationRSA
+	}
+	if version == InvitationECIES && pver < ECIESInvitationVersion {
+		str := fmt.Sprintf("ECIES invitation invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgInvitation.OmcEncode", str)
 	}
+	err = common.WriteElement(w, version)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// TestInvitationWire tests the MsgInvitation wire encode and decode of the
+// invitation formats.
+func TestInvitationWire(t *testing.T) {
+	tests := []struct {
+		version uint8
+		encrypt bool
+		pver    uint32
+	}{
+		{InvitationPlain, false, MinerHeadersVersion},
+		{InvitationRSA, true, MinerHeadersVersion},
+		{InvitationECIES, true, ECIESInvitationVersion},
+	}
+
+	for _, test := range tests {
+		msg := NewMsgInvitation()
+		msg.Expire = 300
+		msg.To = [20]byte{0x01, 0x02}
+		msg.Version = test.version
+		msg.Encrypt = test.encrypt
+		msg.Sig = []byte{0x30, 0x44}
+		msg.Msg = []byte{0xaa, 0xbb, 0xcc}
+
+		var buf bytes.Buffer
+		if err := msg.OmcEncode(&buf, test.pver, BaseEncoding); err != nil {
+			t.Fatalf("OmcEncode #%d: unexpected error %v", test.version,
+				err)
+		}
+		var got MsgInvitation
+		err := got.OmcDecode(bytes.NewReader(buf.Bytes()), test.pver,
+			BaseEncoding)
+		if err != nil {
+			t.Fatalf("OmcDecode #%d: unexpected error %v", test.version,
+				err)
+		}
+		if !reflect.DeepEqual(&got, msg) {
+			t.Errorf("OmcDecode #%d: got %v, want %v", test.version,
+				&got, msg)
+		}
+	}
+
+	// Legacy invitations only set Encrypt.
+	msg := NewMsgInvitation()
+	msg.Encrypt = true
+	var buf bytes.Buffer
+	if err := msg.OmcEncode(&buf, MinerHeadersVersion, BaseEncoding); err != nil {
+		t.Fatalf("OmcEncode: unexpected error %v", err)
+	}
+	var got MsgInvitation
+	err := got.OmcDecode(bytes.NewReader(buf.Bytes()), MinerHeadersVersion,
+		BaseEncoding)
+	if err != nil || got.Version != InvitationRSA {
+		t.Errorf("OmcDecode: got version %d, error %v for legacy "+
+			"encrypted invitation", got.Version, err)
+	}
+
+	// ECIES invitations are not valid before ECIESInvitationVersion.
+	msg.Version = InvitationECIES
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, MinerHeadersVersion, BaseEncoding); err == nil {
+		t.Errorf("OmcEncode: ECIES invitation accepted for old protocol " +
+			"version")
+	}
+	buf.Reset()
+	msg.OmcEncode(&buf, ECIESInvitationVersion, BaseEncoding)
+	err = got.OmcDecode(bytes.NewReader(buf.Bytes()), MinerHeadersVersion,
+		BaseEncoding)
+	if err == nil {
+		t.Errorf("OmcDecode: ECIES invitation accepted for old protocol " +
+			"version")
+	}
+}
+
+// TestInvitationSignatureHash ensures the signature hash of ECIES invitations
+// commits to the expiration and recipient.
+func TestInvitationSignatureHash(t *testing.T) {
+	inv := &Invitation{Height: 10, IP: []byte("10.0.0.1:8383")}
+	msg := &MsgInvitation{Expire: 20, Version: InvitationECIES}
+	hash := msg.SignatureHash(inv)
+
+	msg.Expire++
+	if bytes.Equal(msg.SignatureHash(inv), hash) {
+		t.Errorf("SignatureHash: expiration not committed to")
+	}
+	msg.Expire--
+	msg.To[0] = 1
+	if bytes.Equal(msg.SignatureHash(inv), hash) {
+		t.Errorf("SignatureHash: recipient not committed to")
+	}
+
+	// Legacy invitations only sign the invitation.
+	legacy := &MsgInvitation{Expire: 20, Version: InvitationRSA}
+	var w bytes.Buffer
+	inv.Serialize(&w)
+	if !bytes.Equal(legacy.SignatureHash(inv), chainhash.DoubleHashB(w.Bytes())) {
+		t.Errorf("SignatureHash: unexpected legacy signature hash")
+	}
+}
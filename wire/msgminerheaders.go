@@ -0,0 +1,124 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MsgMinerHeaders implements the Message interface and represents a
+// minerheaders message.  It is used to deliver miner block headers
+// (MinerHeader) in response to a getminerheaders message
+// (MsgGetMinerHeaders).  The maximum number of headers per message is
+// currently 2000.
+//
+// This message was not added until protocol versions starting with
+// MinerHeadersVersion.
+type MsgMinerHeaders struct {
+	Headers []*MinerHeader
+}
+
+// AddMinerHeader adds a new miner block header to the message.
+func (msg *MsgMinerHeaders) AddMinerHeader(h *MinerHeader) error {
+	if len(msg.Headers)+1 > MaxBlockHeadersPerMsg {
+		str := fmt.Sprintf("too many miner headers in message [max %v]",
+			MaxBlockHeadersPerMsg)
+		return messageError("MsgMinerHeaders.AddMinerHeader", str)
+	}
+
+	msg.Headers = append(msg.Headers, h)
+	return nil
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgMinerHeaders) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < MinerHeadersVersion {
+		str := fmt.Sprintf("minerheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgMinerHeaders.OmcDecode", str)
+	}
+
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max headers per message.
+	if count > MaxBlockHeadersPerMsg {
+		str := fmt.Sprintf("too many miner headers for message "+
+			"[count %v, max %v]", count, MaxBlockHeadersPerMsg)
+		return messageError("MsgMinerHeaders.OmcDecode", str)
+	}
+
+	// Create a contiguous slice of headers to deserialize into in order to
+	// reduce the number of allocations.
+	headers := make([]MinerHeader, count)
+	msg.Headers = make([]*MinerHeader, 0, count)
+	for i := uint64(0); i < count; i++ {
+		h := &headers[i]
+		if err := readMinerHeader(r, pver, h); err != nil {
+			return err
+		}
+		msg.AddMinerHeader(h)
+	}
+
+	return nil
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgMinerHeaders) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < MinerHeadersVersion {
+		str := fmt.Sprintf("minerheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgMinerHeaders.OmcEncode", str)
+	}
+
+	// Limit to max headers per message.
+	count := len(msg.Headers)
+	if count > MaxBlockHeadersPerMsg {
+		str := fmt.Sprintf("too many miner headers for message "+
+			"[count %v, max %v]", count, MaxBlockHeadersPerMsg)
+		return messageError("MsgMinerHeaders.OmcEncode", str)
+	}
+
+	err := common.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, h := range msg.Headers {
+		if err := writeMinerHeader(w, pver, h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgMinerHeaders) Command() string {
+	return CmdMinerHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgMinerHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Num headers (varInt) + max allowed headers.
+	return common.MaxVarIntPayload + MinerHeaderLen*MaxBlockHeadersPerMsg
+}
+
+// NewMsgMinerHeaders returns a new minerheaders message that conforms to the
+// Message interface.  See MsgMinerHeaders for details.
+func NewMsgMinerHeaders() *MsgMinerHeaders {
+	return &MsgMinerHeaders{
+		Headers: make([]*MinerHeader, 0, MaxBlockHeadersPerMsg),
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// TestMinerHeadersWire tests the MsgGetMinerHeaders and MsgMinerHeaders wire
+// encode and decode and the matching of headers against blocks.
+func TestMinerHeadersWire(t *testing.T) {
+	block := &MingingRightBlock{
+		Version:    Version2,
+		PrevBlock:  chainhash.Hash{0x01},
+		BestBlock:  chainhash.Hash{0x02},
+		Timestamp:  time.Unix(0x495fab29, 0),
+		Bits:       0x1d00ffff,
+		Nonce:      42,
+		Miner:      [20]byte{0x03},
+		Connection: []byte("127.0.0.1:8383"),
+		TphReports: []uint32{},
+	}
+	hash := block.BlockHash()
+	h := NewMinerHeader(block, &hash)
+	if !h.Matches(block) {
+		t.Fatalf("Matches: header does not match its block")
+	}
+
+	msg := NewMsgMinerHeaders()
+	msg.AddMinerHeader(h)
+	var buf bytes.Buffer
+	if err := msg.OmcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("OmcEncode: %v", err)
+	}
+	if buf.Len() != 1+MinerHeaderLen {
+		t.Errorf("OmcEncode: got %d bytes, want %d", buf.Len(),
+			1+MinerHeaderLen)
+	}
+
+	// The reports of the block are left out of the header.
+	block.ViolationReport = []*Violations{{}}
+	block.TphReports = []uint32{1, 2, 3}
+	hash = block.BlockHash()
+	h = NewMinerHeader(block, &hash)
+	msg = NewMsgMinerHeaders()
+	msg.AddMinerHeader(h)
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("OmcEncode: %v", err)
+	}
+	if buf.Len() != 1+MinerHeaderLen {
+		t.Errorf("OmcEncode: got %d bytes for a block with reports, "+
+			"want %d", buf.Len(), 1+MinerHeaderLen)
+	}
+	var got MsgMinerHeaders
+	err := got.OmcDecode(bytes.NewReader(buf.Bytes()), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: %v", err)
+	}
+	if !reflect.DeepEqual(got.Headers, msg.Headers) {
+		t.Errorf("OmcDecode: got %v, want %v", got.Headers, msg.Headers)
+	}
+	if !got.Headers[0].Matches(block) {
+		t.Errorf("Matches: decoded header does not match its block")
+	}
+
+	// Any change to the fields left out of the header changes the hash, so
+	// the block no longer matches the header.
+	block.Connection = []byte("127.0.0.1:8384")
+	if h.Matches(block) {
+		t.Errorf("Matches: header matches a different block")
+	}
+
+	getMsg := NewMsgGetMinerHeaders()
+	getMsg.AddBlockLocatorHash(&chainhash.Hash{0x04})
+	getMsg.HashStop = chainhash.Hash{0x05}
+	buf.Reset()
+	if err := getMsg.OmcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("OmcEncode: %v", err)
+	}
+	var gotGet MsgGetMinerHeaders
+	err = gotGet.OmcDecode(bytes.NewReader(buf.Bytes()), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: %v", err)
+	}
+	if !reflect.DeepEqual(&gotGet, getMsg) {
+		t.Errorf("OmcDecode: got %v, want %v", &gotGet, getMsg)
+	}
+
+	// The messages are invalid before MinerHeadersVersion.
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, AddrV2Version, BaseEncoding); err == nil {
+		t.Errorf("OmcEncode: expected error for old protocol version")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// MaxPackageTxns is the maximum number of transactions a package message can
+// carry.
+const MaxPackageTxns = 25
+
+// MsgPackage implements the Message interface and represents a package
+// message.  It is used to relay a set of dependent transactions which are only
+// worth accepting together, such as a parent paying no fees and a child paying
+// for both.  The transactions are sorted topologically, so each one comes
+// after the transactions it spends outputs of.
+//
+// This message was not added until protocol versions starting with
+// PackageRelayVersion.
+type MsgPackage struct {
+	Transactions []*MsgTx
+}
+
+// AddTransaction adds a transaction to the message.
+func (msg *MsgPackage) AddTransaction(tx *MsgTx) error {
+	if len(msg.Transactions)+1 > MaxPackageTxns {
+		str := fmt.Sprintf("too many transactions in message [max %v]",
+			MaxPackageTxns)
+		return messageError("MsgPackage.AddTransaction", str)
+	}
+
+	msg.Transactions = append(msg.Transactions, tx)
+	return nil
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgPackage) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("package message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgPackage.OmcDecode", str)
+	}
+
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxPackageTxns {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count, MaxPackageTxns)
+		return messageError("MsgPackage.OmcDecode", str)
+	}
+
+	msg.Transactions = make([]*MsgTx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tx := MsgTx{}
+		if err := tx.OmcDecode(r, pver, enc); err != nil {
+			return err
+		}
+		msg.Transactions = append(msg.Transactions, &tx)
+	}
+
+	return nil
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgPackage) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("package message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgPackage.OmcEncode", str)
+	}
+
+	count := len(msg.Transactions)
+	if count > MaxPackageTxns {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count, MaxPackageTxns)
+		return messageError("MsgPackage.OmcEncode", str)
+	}
+
+	err := common.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+	for _, tx := range msg.Transactions {
+		if err := tx.OmcEncode(w, pver, enc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgPackage) Command() string {
+	return CmdPackage
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgPackage) MaxPayloadLength(pver uint32) uint32 {
+	return MaxBlockPayload
+}
+
+// NewMsgPackage returns a new package message that conforms to the Message
+// interface.  See MsgPackage for details.
+func NewMsgPackage(sizeHint int) *MsgPackage {
+	if sizeHint > MaxPackageTxns {
+		sizeHint = MaxPackageTxns
+	}
+
+	return &MsgPackage{
+		Transactions: make([]*MsgTx, 0, sizeHint),
+	}
+}
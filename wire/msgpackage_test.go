@@ -0,0 +1,75 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zeusyf/btcd/chaincfg/chainhash"
+)
+
+// TestPackageWire tests the MsgPackage wire encode and decode.
+func TestPackageWire(t *testing.T) {
+	parent := NewMsgTx(1)
+	parent.AddTxIn(NewTxIn(NewOutPoint(&chainhash.Hash{0x01}, 0), 0))
+	parent.SignatureScripts = [][]byte{{0x01}}
+	parentHash := parent.TxHash()
+	child := NewMsgTx(1)
+	child.AddTxIn(NewTxIn(NewOutPoint(&parentHash, 0), 0))
+	child.SignatureScripts = [][]byte{{0x02}}
+
+	msg := NewMsgPackage(2)
+	if err := msg.AddTransaction(parent); err != nil {
+		t.Fatalf("AddTransaction: unexpected error %v", err)
+	}
+	if err := msg.AddTransaction(child); err != nil {
+		t.Fatalf("AddTransaction: unexpected error %v", err)
+	}
+
+	var buf bytes.Buffer
+	err := msg.OmcEncode(&buf, PackageRelayVersion, SignatureEncoding)
+	if err != nil {
+		t.Fatalf("OmcEncode: unexpected error %v", err)
+	}
+	var got MsgPackage
+	err = got.OmcDecode(&buf, PackageRelayVersion, SignatureEncoding)
+	if err != nil {
+		t.Fatalf("OmcDecode: unexpected error %v", err)
+	}
+	if len(got.Transactions) != len(msg.Transactions) {
+		t.Fatalf("OmcDecode: got %d transactions, want %d",
+			len(got.Transactions), len(msg.Transactions))
+	}
+	for i, tx := range got.Transactions {
+		if tx.SignatureHash() != msg.Transactions[i].SignatureHash() {
+			t.Errorf("OmcDecode: transaction %d mismatch", i)
+		}
+	}
+
+	// The message is not valid before PackageRelayVersion.
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, PackageRelayVersion-1, SignatureEncoding); err == nil {
+		t.Errorf("OmcEncode: expected error for protocol version %d",
+			PackageRelayVersion-1)
+	}
+
+	// A package can't carry more than MaxPackageTxns transactions.
+	for i := len(msg.Transactions); i < MaxPackageTxns; i++ {
+		if err := msg.AddTransaction(child); err != nil {
+			t.Fatalf("AddTransaction: unexpected error %v", err)
+		}
+	}
+	if err := msg.AddTransaction(child); err == nil {
+		t.Errorf("AddTransaction: expected error adding more than %d "+
+			"transactions", MaxPackageTxns)
+	}
+	msg.Transactions = append(msg.Transactions, child)
+	buf.Reset()
+	if err := msg.OmcEncode(&buf, PackageRelayVersion, SignatureEncoding); err == nil {
+		t.Errorf("OmcEncode: expected error encoding more than %d "+
+			"transactions", MaxPackageTxns)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgSendCmpct implements the Message interface and represents a sendcmpct
+// message.  It is used to request the peer announce new blocks with compact
+// block messages (MsgCmpctBlock) rather than inventory vectors or headers.
+//
+// This message was not added until protocol versions starting with
+// CmpctBlockVersion.
+type MsgSendCmpct struct {
+	// Announce indicates whether the peer should announce new blocks with
+	// compact blocks.
+	Announce bool
+}
+
+// OmcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) OmcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.OmcDecode", str)
+	}
+
+	return readElement(r, &msg.Announce)
+}
+
+// OmcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) OmcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CmpctBlockVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.OmcEncode", str)
+	}
+
+	return writeElement(w, msg.Announce)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgSendCmpct) Command() string {
+	return CmdSendCmpct
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) MaxPayloadLength(pver uint32) uint32 {
+	return 1
+}
+
+// NewMsgSendCmpct returns a new sendcmpct message that conforms to the
+// Message interface.  See MsgSendCmpct for details.
+func NewMsgSendCmpct(announce bool) *MsgSendCmpct {
+	return &MsgSendCmpct{
+		Announce: announce,
+	}
+}
@@ -40,9 +40,18 @@ type NetAddress struct {
 	// Bitfield which identifies the services supported by the address.
 	Services common.ServiceFlag
 
-	// IP address of the peer.
+	// IP address of the peer.  It is nil for networks whose addresses are
+	// not IP addresses, see NetID.
 	IP net.IP
 
+	// NetID is the network of the address.  The zero value means the
+	// network is derived from IP.  See NetworkID.
+	NetID NetworkID
+
+	// Addr is the address of the peer on networks whose addresses are not
+	// IP addresses, such as the public key of a Tor v3 hidden service.
+	Addr []byte
+
 	// Port the peer is using.  This is encoded in big endian on the wire
 	// which differs from most everything else.
 	Port uint16
@@ -0,0 +1,208 @@
+// Copyright (c) 2018-2021 The Omegasuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/zeusyf/btcd/wire/common"
+)
+
+// NetworkID identifies the network of an address in addrv2 messages
+// (MsgAddrV2).  The values are those of BIP0155.
+type NetworkID uint8
+
+const (
+	// NetIPv4 is an IPv4 address.
+	NetIPv4 NetworkID = 1
+
+	// NetIPv6 is an IPv6 address.
+	NetIPv6 NetworkID = 2
+
+	// NetTorV2 is a Tor v2 hidden service.  They are no longer supported
+	// by Tor and are skipped when decoded.
+	NetTorV2 NetworkID = 3
+
+	// NetTorV3 is a Tor v3 hidden service, addressed by its 32-byte
+	// ed25519 public key.
+	NetTorV3 NetworkID = 4
+
+	// NetI2P is an I2P destination, addressed by its 32-byte SHA256 hash.
+	NetI2P NetworkID = 5
+
+	// NetCJDNS is a CJDNS address, an IPv6 address in fc00::/8.
+	NetCJDNS NetworkID = 6
+)
+
+// maxAddrV2Len is the maximum length of an address in an addrv2 message.
+// Longer addresses are rejected even for unknown networks.
+const maxAddrV2Len = 512
+
+// addrV2Lens maps the known networks to the length of their addresses.
+var addrV2Lens = map[NetworkID]int{
+	NetIPv4:  4,
+	NetIPv6:  16,
+	NetTorV2: 10,
+	NetTorV3: 32,
+	NetI2P:   32,
+	NetCJDNS: 16,
+}
+
+// String returns the NetworkID in human-readable form.
+func (n NetworkID) String() string {
+	switch n {
+	case NetIPv4:
+		return "ipv4"
+	case NetIPv6:
+		return "ipv6"
+	case NetTorV2:
+		return "torv2"
+	case NetTorV3:
+		return "torv3"
+	case NetI2P:
+		return "i2p"
+	case NetCJDNS:
+		return "cjdns"
+	}
+	return fmt.Sprintf("Unknown NetworkID (%d)", uint8(n))
+}
+
+// Network returns the network of the address.
+func (na *NetAddress) Network() NetworkID {
+	if na.NetID != 0 {
+		return na.NetID
+	}
+	if na.IP.To4() != nil {
+		return NetIPv4
+	}
+	return NetIPv6
+}
+
+// IsAddrV1 returns whether the address can be relayed in a legacy addr
+// message (MsgAddr), which only carries IP addresses.
+func (na *NetAddress) IsAddrV1() bool {
+	switch na.Network() {
+	case NetIPv4, NetIPv6:
+		return true
+	}
+	return false
+}
+
+// NewNetAddressV2 returns a new NetAddress on the passed network using the
+// provided address, port, and supported services with defaults for the
+// remaining fields.  Addresses of the IP based networks are stored in IP, and
+// NetID is only set for networks which can't be derived from IP.
+func NewNetAddressV2(netID NetworkID, addr []byte, port uint16, services common.ServiceFlag) *NetAddress {
+	na := NewNetAddressIPPort(nil, port, services)
+	switch netID {
+	case NetIPv4, NetIPv6:
+		na.IP = net.IP(addr).To16()
+	case NetCJDNS:
+		na.IP = net.IP(addr).To16()
+		na.NetID = netID
+	default:
+		na.Addr = addr
+		na.NetID = netID
+	}
+	return na
+}
+
+// maxNetAddressV2Payload returns the max payload size for a NetAddress in an
+// addrv2 message.
+func maxNetAddressV2Payload() uint32 {
+	// Timestamp 4 bytes + services varint + network id 1 byte + address
+	// length varint + address + port 2 bytes.
+	return 4 + common.MaxVarIntPayload + 1 + common.MaxVarIntPayload +
+		maxAddrV2Len + 2
+}
+
+// readNetAddressV2 reads an addrv2 encoded NetAddress from r.  It returns
+// false without error for addresses of networks which are unknown or no
+// longer supported, which must be skipped.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddress) (bool, error) {
+	err := common.ReadElement(r, (*common.Uint32Time)(&na.Timestamp))
+	if err != nil {
+		return false, err
+	}
+	services, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	var netID NetworkID
+	if err := common.ReadElement(r, (*uint8)(&netID)); err != nil {
+		return false, err
+	}
+	count, err := common.ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	if count > maxAddrV2Len {
+		str := fmt.Sprintf("address too long [len %v, max %v]", count,
+			maxAddrV2Len)
+		return false, messageError("readNetAddressV2", str)
+	}
+	addr := make([]byte, count)
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return false, err
+	}
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	port, err := common.BinarySerializer.Uint16(r, common.BigEndian)
+	if err != nil {
+		return false, err
+	}
+
+	l, ok := addrV2Lens[netID]
+	if !ok || netID == NetTorV2 {
+		return false, nil
+	}
+	if int(count) != l {
+		str := fmt.Sprintf("wrong address length for network %v "+
+			"[len %v, want %v]", netID, count, l)
+		return false, messageError("readNetAddressV2", str)
+	}
+
+	timestamp := na.Timestamp
+	*na = *NewNetAddressV2(netID, addr, port, common.ServiceFlag(services))
+	na.Timestamp = timestamp
+	return true, nil
+}
+
+// writeNetAddressV2 serializes a NetAddress to w using the addrv2 encoding.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddress) error {
+	netID := na.Network()
+	var addr []byte
+	switch netID {
+	case NetIPv4:
+		addr = na.IP.To4()
+	case NetIPv6, NetCJDNS:
+		addr = na.IP.To16()
+	default:
+		addr = na.Addr
+	}
+	if l, ok := addrV2Lens[netID]; !ok || len(addr) != l {
+		str := fmt.Sprintf("invalid address for network %v", netID)
+		return messageError("writeNetAddressV2", str)
+	}
+
+	err := common.WriteElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+	if err := common.WriteVarInt(w, pver, uint64(na.Services)); err != nil {
+		return err
+	}
+	if err := common.WriteElement(w, uint8(netID)); err != nil {
+		return err
+	}
+	if err := common.WriteVarBytes(w, pver, addr); err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	return binary.Write(w, common.BigEndian, na.Port)
+}
@@ -12,7 +12,7 @@ import (
 // XXX pedro: we will probably need to bump this.
 const (
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 70013
+	ProtocolVersion uint32 = 70019
 
 	// MultipleAddressVersion is the protocol version which added multiple
 	// addresses per message (pver >= MultipleAddressVersion).
@@ -50,5 +50,30 @@ const (
 	// FeeFilterVersion is the protocol version which added a new
 	// feefilter message.
 	FeeFilterVersion uint32 = 70013
+
+	// CmpctBlockVersion is the protocol version which added the sendcmpct,
+	// cmpctblock, getblocktxn and blocktxn messages.
+	CmpctBlockVersion uint32 = 70014
+
+	// AggregateSigVersion is the protocol version which allowed blocks to
+	// carry versioned block signatures, encoded with
+	// AggregateSignatureEncoding.
+	AggregateSigVersion uint32 = 70015
+
+	// AddrV2Version is the protocol version which added the sendaddrv2 and
+	// addrv2 messages.
+	AddrV2Version uint32 = 70016
+
+	// MinerHeadersVersion is the protocol version which added the
+	// getminerheaders and minerheaders messages.
+	MinerHeadersVersion uint32 = 70017
+
+	// ECIESInvitationVersion is the protocol version which added
+	// invitations encrypted to the secp256k1 key of the recipient.
+	ECIESInvitationVersion uint32 = 70018
+
+	// PackageRelayVersion is the protocol version which added the package
+	// message.
+	PackageRelayVersion uint32 = 70019
 )
 